@@ -0,0 +1,133 @@
+// bookkeeper-app/account_archive.go
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// setupAccountArchive 为账户增加 archived_at 列：非空表示已归档。
+// 归档账户默认不出现在账户列表中，也不能用于新的流水，但历史流水和统计中仍按账户名显示。
+func setupAccountArchive(tx *sql.Tx) error {
+	return addColumnIfMissing(tx, "accounts", "archived_at", `"archived_at" TEXT`)
+}
+
+// ensureAccountsActive 检查流水使用的账户都未归档。账户不存在的情况由归属校验处理，这里跳过。
+func ensureAccountsActive(tx *sql.Tx, accountIDs ...*int64) error {
+	for _, id := range accountIDs {
+		if id == nil {
+			continue
+		}
+		var name string
+		var archivedAt sql.NullString
+		err := tx.QueryRow("SELECT name, archived_at FROM accounts WHERE id = ?", *id).Scan(&name, &archivedAt)
+		if err == sql.ErrNoRows {
+			continue
+		}
+		if err != nil {
+			return &ledgerError{Status: http.StatusInternalServerError, Message: "查询账户状态失败", Err: err}
+		}
+		if archivedAt.Valid {
+			return &ledgerError{Status: http.StatusConflict, Message: fmt.Sprintf("账户「%s」已归档，不能用于新的流水，请先取消归档", name)}
+		}
+	}
+	return nil
+}
+
+func sameAccount(a, b *int64) bool {
+	return a != nil && b != nil && *a == *b
+}
+
+// accountHasHistory 账户是否被任何流水引用 (包括回收站中的流水)
+func accountHasHistory(q interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}, accountID interface{}) (bool, error) {
+	var count int
+	err := q.QueryRow("SELECT COUNT(*) FROM transactions WHERE from_account_id = ? OR to_account_id = ?", accountID, accountID).Scan(&count)
+	return count > 0, err
+}
+
+// setAccountArchived 归档或取消归档账户，并记录审计
+func (h *DBHandler) setAccountArchived(c *gin.Context, archive bool) {
+	userID, _ := c.Get("userID")
+	id := c.Param("id")
+	logger := h.Logger.With(slog.Int64("userID", userID.(int64)), "accountID", id)
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		logger.Error("开启事务失败", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "开启事务失败"})
+		return
+	}
+	defer tx.Rollback()
+
+	var isPrimaryInt int
+	var archivedAt sql.NullString
+	err = tx.QueryRow("SELECT is_primary, archived_at FROM accounts WHERE id = ? AND user_id = ?", id, userID).Scan(&isPrimaryInt, &archivedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "未找到指定ID的账户"})
+		} else {
+			logger.Error("查询账户失败", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "查询账户失败"})
+		}
+		return
+	}
+	var newArchivedAt interface{}
+	if archive {
+		if archivedAt.Valid {
+			c.JSON(http.StatusConflict, gin.H{"error": "账户已归档"})
+			return
+		}
+		if isPrimaryInt == 1 {
+			c.JSON(http.StatusConflict, gin.H{"error": "无法归档主账户。请先设置其他账户为主账户。"})
+			return
+		}
+		newArchivedAt = time.Now().Format(time.RFC3339)
+	} else if !archivedAt.Valid {
+		c.JSON(http.StatusConflict, gin.H{"error": "账户未归档"})
+		return
+	}
+
+	before, err := auditSnapshot(tx, "account", userID.(int64), id)
+	if err != nil {
+		writeLedgerError(c, logger, err)
+		return
+	}
+	if _, err := tx.Exec("UPDATE accounts SET archived_at = ? WHERE id = ? AND user_id = ?", newArchivedAt, id, userID); err != nil {
+		logger.Error("更新账户归档状态失败", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "更新账户归档状态失败"})
+		return
+	}
+	if err := writeAudit(tx, auditActorFromContext(c), "account", "update", id, before); err != nil {
+		writeLedgerError(c, logger, err)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		logger.Error("提交事务失败", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "提交事务失败"})
+		return
+	}
+	if archive {
+		c.JSON(http.StatusOK, gin.H{"message": "账户已归档"})
+	} else {
+		c.JSON(http.StatusOK, gin.H{"message": "账户已取消归档"})
+	}
+}
+
+// ArchiveAccount 归档账户：主账户不能归档；余额不为零的账户也可以归档 (如冻结或停用的账户)，余额照常计入资产
+// POST /accounts/:id/archive
+func (h *DBHandler) ArchiveAccount(c *gin.Context) {
+	h.setAccountArchived(c, true)
+}
+
+// UnarchiveAccount 取消归档，账户重新出现在列表中并可用于新的流水
+// POST /accounts/:id/unarchive
+func (h *DBHandler) UnarchiveAccount(c *gin.Context) {
+	h.setAccountArchived(c, false)
+}
@@ -0,0 +1,105 @@
+// bookkeeper-app/account_archive_test.go
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// 测试账户归档：有流水的账户只能归档不能删除，归档后从列表隐藏、不能用于新流水，历史流水仍显示账户名
+func TestAccountArchive(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	handler := &DBHandler{DB: db, Logger: slog.New(slog.NewJSONHandler(io.Discard, nil))}
+	router := setupRouter(handler)
+
+	userID := createTestUser(t, db, "testuser", "password")
+	token := getTestAuthToken(t, userID, "testuser", false)
+	mainAccount := createTestAccount(t, db, userID, "Main", 1000.0)
+	oldCard := createTestAccount(t, db, userID, "Old Card", 0)
+	unused := createTestAccount(t, db, userID, "Unused", 0)
+	frozen := createTestAccount(t, db, userID, "Frozen", 50.0)
+	db.Exec("UPDATE accounts SET is_primary = 1 WHERE id = ?", mainAccount)
+
+	create := func(req CreateTransactionRequest) (int, int64) {
+		body, _ := json.Marshal(req)
+		w := performRequest(router, "POST", "/api/v1/transactions", bytes.NewBuffer(body), token)
+		var resp struct {
+			ID int64 `json:"id"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		return w.Code, resp.ID
+	}
+	code, _ := create(CreateTransactionRequest{Type: "transfer", Amount: yuan(100), TransactionDate: "2024-05-01", FromAccountID: &mainAccount, ToAccountID: &oldCard})
+	assert.Equal(t, http.StatusCreated, code)
+	code, expenseID := create(CreateTransactionRequest{Type: "expense", Amount: yuan(100), TransactionDate: "2024-05-02", FromAccountID: &oldCard})
+	assert.Equal(t, http.StatusCreated, code)
+
+	post := func(path string) int {
+		return performRequest(router, "POST", path, nil, token).Code
+	}
+	accountNames := func(query string) []string {
+		w := performRequest(router, "GET", "/api/v1/accounts"+query, nil, token)
+		assert.Equal(t, http.StatusOK, w.Code)
+		var accounts []Account
+		json.Unmarshal(w.Body.Bytes(), &accounts)
+		names := []string{}
+		for _, acc := range accounts {
+			names = append(names, acc.Name)
+		}
+		return names
+	}
+
+	// 有流水的账户余额为零也不能删除；没有流水的账户可以删除
+	w := performRequest(router, "DELETE", fmt.Sprintf("/api/v1/accounts/%d", oldCard), nil, token)
+	assert.Equal(t, http.StatusConflict, w.Code)
+	w = performRequest(router, "DELETE", fmt.Sprintf("/api/v1/accounts/%d", unused), nil, token)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// 主账户不能归档；余额不为零的账户可以归档，余额保持不变
+	assert.Equal(t, http.StatusConflict, post(fmt.Sprintf("/api/v1/accounts/%d/archive", mainAccount)))
+	assert.Equal(t, http.StatusOK, post(fmt.Sprintf("/api/v1/accounts/%d/archive", frozen)))
+	var frozenBalance Money
+	db.QueryRow("SELECT balance FROM accounts WHERE id = ?", frozen).Scan(&frozenBalance)
+	assert.Equal(t, yuan(50), frozenBalance)
+	assert.Equal(t, http.StatusOK, post(fmt.Sprintf("/api/v1/accounts/%d/archive", oldCard)))
+	assert.Equal(t, http.StatusConflict, post(fmt.Sprintf("/api/v1/accounts/%d/archive", oldCard)))
+	assert.Equal(t, http.StatusConflict, post(fmt.Sprintf("/api/v1/accounts/%d/set_primary", oldCard)))
+
+	assert.Equal(t, []string{"Main"}, accountNames(""))
+	assert.ElementsMatch(t, []string{"Main", "Old Card", "Frozen"}, accountNames("?include_archived=true"))
+
+	// 归档账户不能用于新流水，修改原有流水时可以保留
+	code, _ = create(CreateTransactionRequest{Type: "income", Amount: yuan(10), TransactionDate: "2024-05-03", ToAccountID: &oldCard})
+	assert.Equal(t, http.StatusConflict, code)
+	body, _ := json.Marshal(CreateTransactionRequest{Type: "expense", Amount: yuan(100), TransactionDate: "2024-05-02", Description: "旧卡消费", FromAccountID: &oldCard})
+	w = performRequest(router, "PUT", fmt.Sprintf("/api/v1/transactions/%d", expenseID), bytes.NewBuffer(body), token)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	// 历史流水仍显示账户名
+	w = performRequest(router, "GET", "/api/v1/transactions?year=2024", nil, token)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "Old Card")
+
+	// 删除后恢复也会动用归档账户，需先取消归档
+	w = performRequest(router, "DELETE", fmt.Sprintf("/api/v1/transactions/%d", expenseID), nil, token)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, http.StatusConflict, post(fmt.Sprintf("/api/v1/trash/%d/restore", expenseID)))
+
+	assert.Equal(t, http.StatusOK, post(fmt.Sprintf("/api/v1/accounts/%d/unarchive", oldCard)))
+	assert.Equal(t, http.StatusConflict, post(fmt.Sprintf("/api/v1/accounts/%d/unarchive", oldCard)))
+	assert.Equal(t, http.StatusOK, post(fmt.Sprintf("/api/v1/trash/%d/restore", expenseID)))
+	assert.Equal(t, []string{"Main", "Old Card"}, accountNames(""))
+
+	// 其他用户的账户
+	otherID := createTestUser(t, db, "other", "password")
+	otherAccount := createTestAccount(t, db, otherID, "Other", 0)
+	assert.Equal(t, http.StatusNotFound, post(fmt.Sprintf("/api/v1/accounts/%d/archive", otherAccount)))
+}
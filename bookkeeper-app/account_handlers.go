@@ -2,8 +2,11 @@
 package main
 
 import (
+	"database/sql"
+	"encoding/json"
 	"log/slog"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
@@ -11,10 +14,14 @@ import (
 	// "github.com/mattn/go-sqlite3" //不再需要
 )
 
-// GetAccounts (无修改)
+// GetAccounts 默认不返回已归档的账户，include_archived=true 时一并返回
 func (h *DBHandler) GetAccounts(c *gin.Context) {
 	userID, _ := c.Get("userID")
-	rows, err := h.DB.Query("SELECT id, name, type, balance, icon, is_primary, created_at FROM accounts WHERE user_id = ? ORDER BY is_primary DESC, created_at ASC", userID)
+	query := "SELECT id, name, type, balance, icon, is_primary, currency, created_at, credit_limit, statement_day, payment_due_day, archived_at FROM accounts WHERE user_id = ?"
+	if c.Query("include_archived") != "true" {
+		query += " AND archived_at IS NULL"
+	}
+	rows, err := h.DB.Query(query+" ORDER BY is_primary DESC, created_at ASC", userID)
 	if err != nil {
 		h.Logger.Error("获取账户列表失败", "error", err, slog.Int64("userID", userID.(int64)))
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "获取账户列表失败"})
@@ -25,12 +32,19 @@ func (h *DBHandler) GetAccounts(c *gin.Context) {
 	for rows.Next() {
 		var acc Account
 		var isPrimaryInt int
-		if err := rows.Scan(&acc.ID, &acc.Name, &acc.Type, &acc.Balance, &acc.Icon, &isPrimaryInt, &acc.CreatedAt); err != nil {
+		var statementDay, paymentDueDay sql.NullInt64
+		if err := rows.Scan(&acc.ID, &acc.Name, &acc.Type, &acc.Balance, &acc.Icon, &isPrimaryInt, &acc.Currency, &acc.CreatedAt, &acc.CreditLimit, &statementDay, &paymentDueDay, &acc.ArchivedAt); err != nil {
 			h.Logger.Error("扫描账户数据失败", "error", err)
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "扫描账户数据失败"})
 			return
 		}
 		acc.IsPrimary = isPrimaryInt == 1
+		if acc.Type == creditCardAccountType {
+			available := acc.CreditLimit + acc.Balance
+			acc.AvailableCredit = &available
+			acc.StatementDay = nullableInt(statementDay)
+			acc.PaymentDueDay = nullableInt(paymentDueDay)
+		}
 		accounts = append(accounts, acc)
 	}
 	c.JSON(http.StatusOK, accounts)
@@ -44,8 +58,32 @@ func (h *DBHandler) CreateAccount(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的请求数据: " + err.Error()})
 		return
 	}
+	if err := validateCreditSettings(req.Type, req.Balance, req.CreditLimit, req.StatementDay, req.PaymentDueDay); err != nil {
+		writeLedgerError(c, h.Logger, err)
+		return
+	}
+	// 未指定币种时使用用户本位币
+	currency := req.Currency
+	if currency == "" {
+		base, err := userBaseCurrency(h.DB, userID.(int64))
+		if err != nil {
+			h.Logger.Error("查询本位币失败", "error", err, slog.Int64("userID", userID.(int64)))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "创建账户失败"})
+			return
+		}
+		currency = base
+	}
+	tx, err := h.DB.Begin()
+	if err != nil {
+		h.Logger.Error("开启事务失败", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "开启事务失败"})
+		return
+	}
+	defer tx.Rollback()
+
 	createdAt := time.Now().Format(time.RFC3339)
-	_, err := h.DB.Exec("INSERT INTO accounts (user_id, name, type, balance, icon, created_at) VALUES (?, ?, ?, ?, ?, ?)", userID, req.Name, req.Type, req.Balance, req.Icon, createdAt)
+	res, err := tx.Exec("INSERT INTO accounts (user_id, name, type, balance, opening_balance, icon, currency, created_at, credit_limit, statement_day, payment_due_day) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		userID, req.Name, req.Type, req.Balance, req.Balance, req.Icon, currency, createdAt, req.CreditLimit, req.StatementDay, req.PaymentDueDay)
 	if err != nil {
 		h.Logger.Error("创建账户失败", "error", err, slog.Int64("userID", userID.(int64)))
 		if strings.Contains(err.Error(), "UNIQUE constraint failed") {
@@ -55,6 +93,16 @@ func (h *DBHandler) CreateAccount(c *gin.Context) {
 		}
 		return
 	}
+	id, _ := res.LastInsertId()
+	if err := writeAudit(tx, auditActorFromContext(c), "account", "create", id, nil); err != nil {
+		writeLedgerError(c, h.Logger, err)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		h.Logger.Error("提交事务失败", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "提交事务失败"})
+		return
+	}
 	c.JSON(http.StatusCreated, gin.H{"message": "账户创建成功"})
 }
 
@@ -67,7 +115,51 @@ func (h *DBHandler) UpdateAccount(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的请求数据: " + err.Error()})
 		return
 	}
-	res, err := h.DB.Exec("UPDATE accounts SET name = ?, icon = ? WHERE id = ? AND user_id = ?", req.Name, req.Icon, id, userID)
+	tx, err := h.DB.Begin()
+	if err != nil {
+		h.Logger.Error("开启事务失败", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "开启事务失败"})
+		return
+	}
+	defer tx.Rollback()
+
+	// 信用卡设置未填的字段保持原值，合并后整体校验 (额度不能低于当前欠款)
+	var accountType string
+	var balance, creditLimit Money
+	var statementDay, paymentDueDay sql.NullInt64
+	err = tx.QueryRow("SELECT type, balance, credit_limit, statement_day, payment_due_day FROM accounts WHERE id = ? AND user_id = ?", id, userID).
+		Scan(&accountType, &balance, &creditLimit, &statementDay, &paymentDueDay)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "未找到指定ID的账户"})
+		} else {
+			h.Logger.Error("查询账户失败", "error", err, "accountID", id, slog.Int64("userID", userID.(int64)))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "更新账户失败"})
+		}
+		return
+	}
+	newStatementDay, newPaymentDueDay := nullableInt(statementDay), nullableInt(paymentDueDay)
+	if req.CreditLimit != nil {
+		creditLimit = *req.CreditLimit
+	}
+	if req.StatementDay != nil {
+		newStatementDay = req.StatementDay
+	}
+	if req.PaymentDueDay != nil {
+		newPaymentDueDay = req.PaymentDueDay
+	}
+	if err := validateCreditSettings(accountType, balance, creditLimit, newStatementDay, newPaymentDueDay); err != nil {
+		writeLedgerError(c, h.Logger, err)
+		return
+	}
+
+	before, err := auditSnapshot(tx, "account", userID.(int64), id)
+	if err != nil {
+		writeLedgerError(c, h.Logger, err)
+		return
+	}
+	res, err := tx.Exec("UPDATE accounts SET name = ?, icon = ?, credit_limit = ?, statement_day = ?, payment_due_day = ? WHERE id = ? AND user_id = ?",
+		req.Name, req.Icon, creditLimit, newStatementDay, newPaymentDueDay, id, userID)
 	if err != nil {
 		h.Logger.Error("更新账户失败", "error", err, "accountID", id, slog.Int64("userID", userID.(int64)))
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "更新账户失败"})
@@ -78,18 +170,43 @@ func (h *DBHandler) UpdateAccount(c *gin.Context) {
 		c.JSON(http.StatusNotFound, gin.H{"error": "未找到指定ID的账户"})
 		return
 	}
+	if err := writeAudit(tx, auditActorFromContext(c), "account", "update", id, before); err != nil {
+		writeLedgerError(c, h.Logger, err)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		h.Logger.Error("提交事务失败", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "提交事务失败"})
+		return
+	}
 	c.JSON(http.StatusOK, gin.H{"message": "账户更新成功"})
 }
 
-// DeleteAccount (无修改)
+// DeleteAccount 只能删除余额为零且没有任何流水的非主账户，其余情况请归档。
+// 检查与删除在同一事务中完成，避免检查之后新记入的流水失去账户引用。
 func (h *DBHandler) DeleteAccount(c *gin.Context) {
 	userID, _ := c.Get("userID")
 	id := c.Param("id")
-	var balance float64
+	logger := h.Logger.With(slog.Int64("userID", userID.(int64)), "accountID", id)
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		logger.Error("开启事务失败", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "开启事务失败"})
+		return
+	}
+	defer tx.Rollback()
+
+	var balance Money
 	var isPrimaryInt int
-	err := h.DB.QueryRow("SELECT balance, is_primary FROM accounts WHERE id = ? AND user_id = ?", id, userID).Scan(&balance, &isPrimaryInt)
+	err = tx.QueryRow("SELECT balance, is_primary FROM accounts WHERE id = ? AND user_id = ?", id, userID).Scan(&balance, &isPrimaryInt)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "未找到指定ID的账户"})
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "未找到指定ID的账户"})
+		} else {
+			logger.Error("查询账户失败", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "删除账户失败"})
+		}
 		return
 	}
 	if balance != 0 {
@@ -100,15 +217,34 @@ func (h *DBHandler) DeleteAccount(c *gin.Context) {
 		c.JSON(http.StatusConflict, gin.H{"error": "无法删除主账户。请先设置其他账户为主账户。"})
 		return
 	}
-	res, err := h.DB.Exec("DELETE FROM accounts WHERE id = ? AND user_id = ?", id, userID)
+	// 删除账户会使历史流水失去账户引用，有流水的账户只能归档
+	hasHistory, err := accountHasHistory(tx, id)
 	if err != nil {
-		h.Logger.Error("删除账户失败", "error", err, "accountID", id, slog.Int64("userID", userID.(int64)))
+		logger.Error("查询账户流水失败", "error", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "删除账户失败"})
 		return
 	}
-	rowsAffected, _ := res.RowsAffected()
-	if rowsAffected == 0 {
-		c.JSON(http.StatusNotFound, gin.H{"error": "未找到指定ID的账户"})
+	if hasHistory {
+		c.JSON(http.StatusConflict, gin.H{"error": "无法删除：账户已有流水记录 (含回收站中的流水)。如不再使用请归档该账户。"})
+		return
+	}
+	before, err := auditSnapshot(tx, "account", userID.(int64), id)
+	if err != nil {
+		writeLedgerError(c, logger, err)
+		return
+	}
+	if _, err := tx.Exec("DELETE FROM accounts WHERE id = ? AND user_id = ?", id, userID); err != nil {
+		logger.Error("删除账户失败", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "删除账户失败"})
+		return
+	}
+	if err := writeAudit(tx, auditActorFromContext(c), "account", "delete", id, before); err != nil {
+		writeLedgerError(c, logger, err)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		logger.Error("提交事务失败", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "提交事务失败"})
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{"message": "账户删除成功"})
@@ -126,6 +262,26 @@ func (h *DBHandler) SetPrimaryAccount(c *gin.Context) {
 	}
 	defer tx.Rollback()
 
+	var archivedAt sql.NullString
+	if err := tx.QueryRow("SELECT archived_at FROM accounts WHERE id = ? AND user_id = ?", id, userID).Scan(&archivedAt); err == nil && archivedAt.Valid {
+		c.JSON(http.StatusConflict, gin.H{"error": "无法将已归档的账户设为主账户"})
+		return
+	}
+
+	// 原主账户和新主账户都会发生变化，各记一条审计记录
+	var previousID int64
+	changed := []string{id}
+	if err := tx.QueryRow("SELECT id FROM accounts WHERE user_id = ? AND is_primary = 1", userID).Scan(&previousID); err == nil && strconv.FormatInt(previousID, 10) != id {
+		changed = append(changed, strconv.FormatInt(previousID, 10))
+	}
+	befores := make([]json.RawMessage, len(changed))
+	for i, accountID := range changed {
+		if befores[i], err = auditSnapshot(tx, "account", userID.(int64), accountID); err != nil {
+			writeLedgerError(c, h.Logger, err)
+			return
+		}
+	}
+
 	if _, err := tx.Exec("UPDATE accounts SET is_primary = 0 WHERE user_id = ?", userID); err != nil {
 		h.Logger.Error("重置主账户失败", "error", err, slog.Int64("userID", userID.(int64)))
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "重置主账户失败"})
@@ -142,6 +298,13 @@ func (h *DBHandler) SetPrimaryAccount(c *gin.Context) {
 		c.JSON(http.StatusNotFound, gin.H{"error": "未找到指定ID的账户"})
 		return
 	}
+	actor := auditActorFromContext(c)
+	for i, accountID := range changed {
+		if err := writeAudit(tx, actor, "account", "update", accountID, befores[i]); err != nil {
+			writeLedgerError(c, h.Logger, err)
+			return
+		}
+	}
 	if err := tx.Commit(); err != nil {
 		h.Logger.Error("提交事务失败", "error", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "提交事务失败"})
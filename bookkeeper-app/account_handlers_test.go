@@ -29,10 +29,10 @@ func TestGetAccounts_DataIsolation(t *testing.T) {
 	user2ID := createTestUser(t, db, "user2", "password123")
 
 	// 为 user1 创建2个账户
-	db.Exec("INSERT INTO accounts (user_id, name, type, balance, icon, created_at) VALUES (?, ?, ?, ?, ?, ?)", user1ID, "User1-Checking", "card", 1000, "CreditCard", time.Now().Format(time.RFC3339))
-	db.Exec("INSERT INTO accounts (user_id, name, type, balance, icon, created_at) VALUES (?, ?, ?, ?, ?, ?)", user1ID, "User1-Savings", "card", 5000, "PiggyBank", time.Now().Format(time.RFC3339))
+	db.Exec("INSERT INTO accounts (user_id, name, type, balance, icon, created_at) VALUES (?, ?, ?, ?, ?, ?)", user1ID, "User1-Checking", "card", yuan(1000), "CreditCard", time.Now().Format(time.RFC3339))
+	db.Exec("INSERT INTO accounts (user_id, name, type, balance, icon, created_at) VALUES (?, ?, ?, ?, ?, ?)", user1ID, "User1-Savings", "card", yuan(5000), "PiggyBank", time.Now().Format(time.RFC3339))
 	// 为 user2 创建1个账户
-	db.Exec("INSERT INTO accounts (user_id, name, type, balance, icon, created_at) VALUES (?, ?, ?, ?, ?, ?)", user2ID, "User2-Wallet", "wechat", 200, "Wallet", time.Now().Format(time.RFC3339))
+	db.Exec("INSERT INTO accounts (user_id, name, type, balance, icon, created_at) VALUES (?, ?, ?, ?, ?, ?)", user2ID, "User2-Wallet", "wechat", yuan(200), "Wallet", time.Now().Format(time.RFC3339))
 
 	// 获取 user1 的token
 	user1Token := getTestAuthToken(t, user1ID, "user1", false)
@@ -66,7 +66,7 @@ func TestCreateAccount_Success(t *testing.T) {
 	accountPayload := CreateAccountRequest{
 		Name:    "My New Test Account",
 		Type:    "alipay",
-		Balance: 150.50,
+		Balance: yuan(150.50),
 		Icon:    "Briefcase",
 	}
 	body, _ := json.Marshal(accountPayload)
@@ -127,7 +127,7 @@ func TestTransferFunds_InsufficientBalance(t *testing.T) {
 	transferPayload := TransferRequest{
 		FromAccountID: fromAccountID,
 		ToAccountID:   toAccountID,
-		Amount:        100.01,
+		Amount:        yuan(100.01),
 		Date:          time.Now().Format("2006-01-02"),
 		Description:   "Test transfer",
 	}
@@ -142,18 +142,18 @@ func TestTransferFunds_InsufficientBalance(t *testing.T) {
 	assert.Contains(t, errResp["error"], "账户余额不足")
 
 	// 验证数据库中的余额没有发生变化
-	var fromBalance, toBalance float64
+	var fromBalance, toBalance Money
 	db.QueryRow("SELECT balance FROM accounts WHERE id = ?", fromAccountID).Scan(&fromBalance)
 	db.QueryRow("SELECT balance FROM accounts WHERE id = ?", toAccountID).Scan(&toBalance)
-	assert.Equal(t, 100.0, fromBalance)
-	assert.Equal(t, 0.0, toBalance)
+	assert.Equal(t, yuan(100), fromBalance)
+	assert.Equal(t, yuan(0), toBalance)
 }
 
-// 辅助函数，用于在测试中快速创建账户
+// 辅助函数，用于在测试中快速创建账户 (余额以元为单位)
 func createTestAccount(t *testing.T, db *sql.DB, userID int64, name string, balance float64) int64 {
 	res, err := db.Exec(
-		"INSERT INTO accounts (user_id, name, type, balance, icon, is_primary, created_at) VALUES (?, ?, ?, ?, ?, ?, ?)",
-		userID, name, "card", balance, "Wallet", 0, time.Now().Format(time.RFC3339),
+		"INSERT INTO accounts (user_id, name, type, balance, opening_balance, icon, is_primary, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+		userID, name, "card", yuan(balance), yuan(balance), "Wallet", 0, time.Now().Format(time.RFC3339),
 	)
 	if err != nil {
 		t.Fatalf("创建测试账户 '%s' 失败: %v", name, err)
@@ -176,7 +176,7 @@ func TestDeleteTransaction_RevertsBalance(t *testing.T) {
 	// 创建一笔50元的支出流水
 	createReq := CreateTransactionRequest{
 		Type:            "expense",
-		Amount:          50.0,
+		Amount:          yuan(50),
 		TransactionDate: time.Now().Format("2006-01-02"),
 		CategoryID:      func() *string { s := "food_dining"; return &s }(),
 		FromAccountID:   &accountID,
@@ -186,9 +186,9 @@ func TestDeleteTransaction_RevertsBalance(t *testing.T) {
 	assert.Equal(t, http.StatusCreated, w.Code)
 
 	// 确认账户余额被扣减
-	var balanceAfterCreate float64
+	var balanceAfterCreate Money
 	db.QueryRow("SELECT balance FROM accounts WHERE id = ?", accountID).Scan(&balanceAfterCreate)
-	assert.Equal(t, 950.0, balanceAfterCreate)
+	assert.Equal(t, yuan(950), balanceAfterCreate)
 
 	// 获取这笔流水的ID
 	var transactionID int64
@@ -199,7 +199,7 @@ func TestDeleteTransaction_RevertsBalance(t *testing.T) {
 	assert.Equal(t, http.StatusOK, wDelete.Code)
 
 	// 确认账户余额已恢复
-	var balanceAfterDelete float64
+	var balanceAfterDelete Money
 	db.QueryRow("SELECT balance FROM accounts WHERE id = ?", accountID).Scan(&balanceAfterDelete)
-	assert.Equal(t, 1000.0, balanceAfterDelete, "删除流水后，账户余额应该恢复到原始值")
+	assert.Equal(t, yuan(1000), balanceAfterDelete, "删除流水后，账户余额应该恢复到原始值")
 }
@@ -0,0 +1,293 @@
+// bookkeeper-app/attachment_handlers.go
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxAttachmentSize 单个附件的大小上限
+const maxAttachmentSize = 10 << 20
+
+// allowedAttachmentTypes 允许上传的文件类型 (按文件内容识别，不信任客户端声明的类型)
+var allowedAttachmentTypes = map[string]bool{
+	"image/jpeg":      true,
+	"image/png":       true,
+	"image/gif":       true,
+	"image/webp":      true,
+	"application/pdf": true,
+}
+
+// getAttachmentsDir 附件存储目录，默认为数据库文件同级的 attachments 目录
+func getAttachmentsDir() string {
+	if dir := os.Getenv("ATTACHMENTS_DIR"); dir != "" {
+		return dir
+	}
+	return filepath.Join(filepath.Dir(getDBPath()), "attachments")
+}
+
+// setupAttachments 创建附件元数据表。
+// 附件记录随流水或用户级联删除时，触发器把文件路径记入 attachment_orphans，
+// 由 purgeOrphanAttachments 在事务提交后清理磁盘文件 (事务回滚时这些记录也会一起回滚)。
+func setupAttachments(tx *sql.Tx) error {
+	if _, err := tx.Exec(`
+    CREATE TABLE IF NOT EXISTS attachments (
+        "id" INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+        "user_id" INTEGER NOT NULL,
+        "transaction_id" INTEGER NOT NULL,
+        "file_name" TEXT NOT NULL,
+        "stored_path" TEXT NOT NULL UNIQUE,
+        "mime_type" TEXT NOT NULL,
+        "size" INTEGER NOT NULL,
+        "sha256" TEXT NOT NULL,
+        "created_at" TEXT NOT NULL,
+        FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE,
+        FOREIGN KEY(transaction_id) REFERENCES transactions(id) ON DELETE CASCADE
+    );`); err != nil {
+		return fmt.Errorf("创建 attachments 表失败: %w", err)
+	}
+	if _, err := tx.Exec(`CREATE INDEX IF NOT EXISTS idx_attachments_transaction ON attachments (transaction_id);`); err != nil {
+		return fmt.Errorf("为 attachments 创建索引失败: %w", err)
+	}
+	if _, err := tx.Exec(`
+    CREATE TABLE IF NOT EXISTS attachment_orphans (
+        "stored_path" TEXT NOT NULL PRIMARY KEY
+    );`); err != nil {
+		return fmt.Errorf("创建 attachment_orphans 表失败: %w", err)
+	}
+	if _, err := tx.Exec(`
+    CREATE TRIGGER IF NOT EXISTS attachments_ad AFTER DELETE ON attachments BEGIN
+        INSERT OR IGNORE INTO attachment_orphans(stored_path) VALUES (old.stored_path);
+    END;`); err != nil {
+		return fmt.Errorf("创建附件清理触发器失败: %w", err)
+	}
+	return nil
+}
+
+// purgeOrphanAttachments 删除已无对应记录的附件文件。删除流水、用户或附件后调用，启动时也会执行一次以清理遗留文件。
+func (h *DBHandler) purgeOrphanAttachments() {
+	rows, err := h.DB.Query("SELECT stored_path FROM attachment_orphans")
+	if err != nil {
+		h.Logger.Error("查询待清理附件失败", "error", err)
+		return
+	}
+	var paths []string
+	for rows.Next() {
+		var p string
+		if err := rows.Scan(&p); err == nil {
+			paths = append(paths, p)
+		}
+	}
+	rows.Close()
+
+	dir := getAttachmentsDir()
+	for _, p := range paths {
+		if err := os.Remove(filepath.Join(dir, p)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			h.Logger.Warn("删除附件文件失败，将在下次清理时重试", "path", p, "error", err)
+			continue
+		}
+		h.DB.Exec("DELETE FROM attachment_orphans WHERE stored_path = ?", p)
+	}
+}
+
+func scanAttachment(row rowScanner) (Attachment, error) {
+	var a Attachment
+	err := row.Scan(&a.ID, &a.TransactionID, &a.FileName, &a.MimeType, &a.Size, &a.SHA256, &a.CreatedAt)
+	return a, err
+}
+
+const attachmentColumns = "id, transaction_id, file_name, mime_type, size, sha256, created_at"
+
+// UploadAttachment 为流水上传一个附件 (multipart 表单字段 file)
+func (h *DBHandler) UploadAttachment(c *gin.Context) {
+	userID, _ := c.Get("userID")
+	transactionID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的流水ID"})
+		return
+	}
+	logger := h.Logger.With(slog.Int64("userID", userID.(int64)), "transactionID", transactionID)
+
+	var count int
+	if err := h.DB.QueryRow("SELECT COUNT(*) FROM transactions WHERE id = ? AND user_id = ? AND deleted_at IS NULL", transactionID, userID).Scan(&count); err != nil {
+		logger.Error("查询流水失败", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "服务器内部错误"})
+		return
+	}
+	if count == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "未找到指定ID的流水"})
+		return
+	}
+
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxAttachmentSize+1<<20)
+	file, err := c.FormFile("file")
+	if err != nil {
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("附件不能超过 %d MB", maxAttachmentSize>>20)})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": "文件上传失败: " + err.Error()})
+		return
+	}
+	if file.Size > maxAttachmentSize {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("附件不能超过 %d MB", maxAttachmentSize>>20)})
+		return
+	}
+
+	src, err := file.Open()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "读取上传文件失败"})
+		return
+	}
+	defer src.Close()
+
+	// 按文件内容识别类型
+	head := make([]byte, 512)
+	n, _ := io.ReadFull(src, head)
+	mimeType := http.DetectContentType(head[:n])
+	if !allowedAttachmentTypes[mimeType] {
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": "不支持的文件类型: " + mimeType + " (仅支持 JPEG/PNG/GIF/WebP 图片和 PDF)"})
+		return
+	}
+	if _, err := src.Seek(0, io.SeekStart); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "读取上传文件失败"})
+		return
+	}
+
+	// 以随机文件名存储在用户子目录下，同时计算 SHA-256
+	randomName := make([]byte, 16)
+	rand.Read(randomName)
+	storedPath := filepath.Join(strconv.FormatInt(userID.(int64), 10), hex.EncodeToString(randomName))
+	fullPath := filepath.Join(getAttachmentsDir(), storedPath)
+	if err := os.MkdirAll(filepath.Dir(fullPath), 0o755); err != nil {
+		logger.Error("创建附件目录失败", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "保存附件失败"})
+		return
+	}
+	dst, err := os.Create(fullPath)
+	if err != nil {
+		logger.Error("创建附件文件失败", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "保存附件失败"})
+		return
+	}
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(dst, hash), src)
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(fullPath)
+		logger.Error("写入附件文件失败", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "保存附件失败"})
+		return
+	}
+
+	attachment := Attachment{
+		TransactionID: transactionID,
+		FileName:      filepath.Base(file.Filename),
+		MimeType:      mimeType,
+		Size:          size,
+		SHA256:        hex.EncodeToString(hash.Sum(nil)),
+		CreatedAt:     time.Now().Format(time.RFC3339),
+	}
+	res, err := h.DB.Exec(
+		"INSERT INTO attachments (user_id, transaction_id, file_name, stored_path, mime_type, size, sha256, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+		userID, transactionID, attachment.FileName, storedPath, attachment.MimeType, attachment.Size, attachment.SHA256, attachment.CreatedAt,
+	)
+	if err != nil {
+		os.Remove(fullPath)
+		logger.Error("保存附件记录失败", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "保存附件失败"})
+		return
+	}
+	attachment.ID, _ = res.LastInsertId()
+	c.JSON(http.StatusCreated, attachment)
+}
+
+// GetAttachments 列出流水的全部附件
+func (h *DBHandler) GetAttachments(c *gin.Context) {
+	userID, _ := c.Get("userID")
+	transactionID := c.Param("id")
+	logger := h.Logger.With(slog.Int64("userID", userID.(int64)), "transactionID", transactionID)
+
+	rows, err := h.DB.Query("SELECT "+attachmentColumns+" FROM attachments WHERE transaction_id = ? AND user_id = ? ORDER BY id", transactionID, userID)
+	if err != nil {
+		logger.Error("查询附件失败", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "获取附件列表失败"})
+		return
+	}
+	defer rows.Close()
+
+	attachments := []Attachment{}
+	for rows.Next() {
+		a, err := scanAttachment(rows)
+		if err != nil {
+			logger.Error("扫描附件数据失败", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "获取附件列表失败"})
+			return
+		}
+		attachments = append(attachments, a)
+	}
+	c.JSON(http.StatusOK, attachments)
+}
+
+// DownloadAttachment 下载附件原文件
+func (h *DBHandler) DownloadAttachment(c *gin.Context) {
+	userID, _ := c.Get("userID")
+	id := c.Param("id")
+
+	var fileName, storedPath, mimeType string
+	err := h.DB.QueryRow("SELECT file_name, stored_path, mime_type FROM attachments WHERE id = ? AND user_id = ?", id, userID).
+		Scan(&fileName, &storedPath, &mimeType)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "未找到指定ID的附件"})
+		} else {
+			h.Logger.Error("查询附件失败", "error", err, "attachmentID", id)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "服务器内部错误"})
+		}
+		return
+	}
+
+	fullPath := filepath.Join(getAttachmentsDir(), storedPath)
+	if _, err := os.Stat(fullPath); err != nil {
+		h.Logger.Error("附件文件缺失", "error", err, "attachmentID", id, "path", storedPath)
+		c.JSON(http.StatusNotFound, gin.H{"error": "附件文件不存在"})
+		return
+	}
+	c.Header("Content-Type", mimeType)
+	c.FileAttachment(fullPath, fileName)
+}
+
+// DeleteAttachment 删除附件记录及其文件
+func (h *DBHandler) DeleteAttachment(c *gin.Context) {
+	userID, _ := c.Get("userID")
+	id := c.Param("id")
+	res, err := h.DB.Exec("DELETE FROM attachments WHERE id = ? AND user_id = ?", id, userID)
+	if err != nil {
+		h.Logger.Error("删除附件失败", "error", err, "attachmentID", id, slog.Int64("userID", userID.(int64)))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "删除附件失败"})
+		return
+	}
+	rowsAffected, _ := res.RowsAffected()
+	if rowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "未找到指定ID的附件"})
+		return
+	}
+	h.purgeOrphanAttachments()
+	c.JSON(http.StatusOK, gin.H{"message": "附件删除成功"})
+}
@@ -0,0 +1,128 @@
+// bookkeeper-app/attachment_handlers_test.go
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// uploadTestAttachment 以 multipart 表单上传一个附件
+func uploadTestAttachment(r http.Handler, transactionID int64, fileName string, content []byte, token string) *httptest.ResponseRecorder {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	part, _ := mw.CreateFormFile("file", fileName)
+	part.Write(content)
+	mw.Close()
+
+	req, _ := http.NewRequest("POST", fmt.Sprintf("/api/v1/transactions/%d/attachments", transactionID), &body)
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+// 测试附件的上传、类型限制、下载，以及删除流水后文件被清理
+func TestAttachments_Lifecycle(t *testing.T) {
+	attachmentsDir := t.TempDir()
+	t.Setenv("ATTACHMENTS_DIR", attachmentsDir)
+
+	db := setupTestDB(t)
+	defer db.Close()
+	handler := &DBHandler{DB: db, Logger: slog.New(slog.NewJSONHandler(io.Discard, nil))}
+	router := setupRouter(handler)
+
+	userID := createTestUser(t, db, "testuser", "password")
+	token := getTestAuthToken(t, userID, "testuser", false)
+	accountID := createTestAccount(t, db, userID, "Test Account", 100.0)
+
+	body, _ := json.Marshal(CreateTransactionRequest{Type: "expense", Amount: yuan(10), TransactionDate: "2024-01-01", FromAccountID: &accountID})
+	w := performRequest(router, "POST", "/api/v1/transactions", bytes.NewBuffer(body), token)
+	var created struct {
+		ID int64 `json:"id"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &created)
+
+	png := append([]byte("\x89PNG\r\n\x1a\n"), bytes.Repeat([]byte{0}, 64)...)
+	w = uploadTestAttachment(router, created.ID, "receipt.png", png, token)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	var attachment Attachment
+	json.Unmarshal(w.Body.Bytes(), &attachment)
+	sum := sha256.Sum256(png)
+	assert.Equal(t, "image/png", attachment.MimeType)
+	assert.Equal(t, int64(len(png)), attachment.Size)
+	assert.Equal(t, hex.EncodeToString(sum[:]), attachment.SHA256)
+
+	// 不支持的类型
+	w = uploadTestAttachment(router, created.ID, "notes.txt", []byte("hello"), token)
+	assert.Equal(t, http.StatusUnsupportedMediaType, w.Code)
+
+	w = performRequest(router, "GET", fmt.Sprintf("/api/v1/transactions/%d/attachments", created.ID), nil, token)
+	var list []Attachment
+	json.Unmarshal(w.Body.Bytes(), &list)
+	assert.Len(t, list, 1)
+
+	w = performRequest(router, "GET", fmt.Sprintf("/api/v1/attachments/%d", attachment.ID), nil, token)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, png, w.Body.Bytes())
+
+	// 其他用户无法下载
+	otherID := createTestUser(t, db, "other", "password")
+	otherToken := getTestAuthToken(t, otherID, "other", false)
+	w = performRequest(router, "GET", fmt.Sprintf("/api/v1/attachments/%d", attachment.ID), nil, otherToken)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	countFiles := func() int {
+		n := 0
+		filepath.WalkDir(attachmentsDir, func(_ string, d os.DirEntry, _ error) error {
+			if d != nil && !d.IsDir() {
+				n++
+			}
+			return nil
+		})
+		return n
+	}
+	assert.Equal(t, 1, countFiles())
+
+	// 流水移入回收站时保留附件，超过保留期限被彻底删除后才清理文件
+	w = performRequest(router, "DELETE", fmt.Sprintf("/api/v1/transactions/%d", created.ID), nil, token)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, 1, countFiles())
+	handler.purgeExpiredTrash(time.Now().AddDate(0, 0, trashRetentionDays+1))
+	assert.Equal(t, 0, countFiles())
+}
+
+// 测试备份压缩包包含数据库和附件，并能解压还原
+func TestBackupArchive_RoundTrip(t *testing.T) {
+	srcDir := t.TempDir()
+	dbPath := filepath.Join(srcDir, "ledger.db")
+	os.WriteFile(dbPath, []byte("db"), 0o644)
+	os.MkdirAll(filepath.Join(srcDir, "attachments", "1"), 0o755)
+	os.WriteFile(filepath.Join(srcDir, "attachments", "1", "abc"), []byte("file"), 0o644)
+
+	var buf bytes.Buffer
+	assert.NoError(t, writeBackupArchive(&buf, dbPath, filepath.Join(srcDir, "attachments")))
+	archivePath := filepath.Join(srcDir, "backup.zip")
+	os.WriteFile(archivePath, buf.Bytes(), 0o644)
+
+	dstDir := t.TempDir()
+	assert.NoError(t, extractBackupArchive(archivePath, filepath.Join(dstDir, "restored.db"), filepath.Join(dstDir, "attachments")))
+	restoredDB, _ := os.ReadFile(filepath.Join(dstDir, "restored.db"))
+	restoredFile, _ := os.ReadFile(filepath.Join(dstDir, "attachments", "1", "abc"))
+	assert.Equal(t, "db", string(restoredDB))
+	assert.Equal(t, "file", string(restoredFile))
+}
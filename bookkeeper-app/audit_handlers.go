@@ -0,0 +1,285 @@
+// bookkeeper-app/audit_handlers.go
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultAuditPageLimit = 50
+	maxAuditPageLimit     = 500
+)
+
+// auditEntityTables 可审计的实体及其对应的数据表
+var auditEntityTables = map[string]string{
+	"transaction": "transactions",
+	"account":     "accounts",
+	"loan":        "loans",
+	"budget":      "budgets",
+	"category":    "categories",
+}
+
+// auditQuerier 同时兼容 *sql.DB 和 *sql.Tx：在事务中写入时审计记录与数据变更一起提交或回滚
+type auditQuerier interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// setupAudit 创建审计日志表。审计记录不随用户删除而级联删除，以便事后追溯。
+// user_id 为操作者，owner_id 为被操作数据的所有者 (管理员代为操作时两者不同)。
+func setupAudit(tx *sql.Tx) error {
+	if _, err := tx.Exec(`
+    CREATE TABLE IF NOT EXISTS audit_log (
+        "id" INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+        "user_id" INTEGER NOT NULL,
+        "owner_id" INTEGER,
+        "entity" TEXT NOT NULL,
+        "entity_id" TEXT NOT NULL,
+        "action" TEXT NOT NULL,
+        "before_json" TEXT,
+        "after_json" TEXT,
+        "ip_address" TEXT,
+        "user_agent" TEXT,
+        "created_at" TEXT NOT NULL
+    );`); err != nil {
+		return fmt.Errorf("创建 audit_log 表失败: %w", err)
+	}
+	if err := addColumnIfMissing(tx, "audit_log", "owner_id", `"owner_id" INTEGER`); err != nil {
+		return err
+	}
+	// 旧记录都是用户操作自己的数据
+	if _, err := tx.Exec(`UPDATE audit_log SET owner_id = user_id WHERE owner_id IS NULL;`); err != nil {
+		return fmt.Errorf("回填 audit_log.owner_id 失败: %w", err)
+	}
+	if _, err := tx.Exec(`CREATE INDEX IF NOT EXISTS idx_audit_log_user ON audit_log (user_id, id);`); err != nil {
+		return fmt.Errorf("为 audit_log 创建索引失败: %w", err)
+	}
+	if _, err := tx.Exec(`CREATE INDEX IF NOT EXISTS idx_audit_log_owner ON audit_log (owner_id, id);`); err != nil {
+		return fmt.Errorf("为 audit_log 创建索引失败: %w", err)
+	}
+	if _, err := tx.Exec(`CREATE INDEX IF NOT EXISTS idx_audit_log_entity ON audit_log (entity, entity_id);`); err != nil {
+		return fmt.Errorf("为 audit_log 创建索引失败: %w", err)
+	}
+	return nil
+}
+
+// auditActor 执行变更的用户及其请求来源。后台任务 (如周期记账) 没有请求上下文，IP 和 User-Agent 为空。
+type auditActor struct {
+	UserID    int64
+	IPAddress string
+	UserAgent string
+}
+
+// auditActorFromContext 从请求中取出当前用户、客户端 IP 和 User-Agent (与登录记录的取法一致)
+func auditActorFromContext(c *gin.Context) auditActor {
+	userID, _ := c.Get("userID")
+	return auditActor{UserID: userID.(int64), IPAddress: c.ClientIP(), UserAgent: c.Request.UserAgent()}
+}
+
+// auditSnapshot 读取实体当前的整行数据并编码为 JSON，行不存在时返回 nil。
+// 错误包装为 ledgerError，事务中的处理器可以直接交给 writeLedgerError。
+func auditSnapshot(q auditQuerier, entity string, userID int64, entityID interface{}) (json.RawMessage, error) {
+	table, ok := auditEntityTables[entity]
+	if !ok {
+		return nil, fmt.Errorf("未知的审计实体: %s", entity)
+	}
+	wrap := func(err error) error {
+		return &ledgerError{Status: http.StatusInternalServerError, Message: "读取审计快照失败", Err: err}
+	}
+	rows, err := q.Query("SELECT * FROM "+table+" WHERE id = ? AND user_id = ?", entityID, userID)
+	if err != nil {
+		return nil, wrap(err)
+	}
+	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, wrap(err)
+	}
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return nil, wrap(err)
+		}
+		return nil, nil
+	}
+	values := make([]interface{}, len(columns))
+	dest := make([]interface{}, len(columns))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	if err := rows.Scan(dest...); err != nil {
+		return nil, wrap(err)
+	}
+	snapshot := make(map[string]interface{}, len(columns))
+	for i, column := range columns {
+		if b, ok := values[i].([]byte); ok {
+			snapshot[column] = string(b)
+		} else {
+			snapshot[column] = values[i]
+		}
+	}
+	snapshotJSON, err := json.Marshal(snapshot)
+	if err != nil {
+		return nil, wrap(err)
+	}
+	return snapshotJSON, nil
+}
+
+// writeAudit 写入一条审计记录：before 为变更前的快照 (新建时为 nil)，变更后的快照在此读取 (硬删除后为 nil)
+func writeAudit(q auditQuerier, actor auditActor, entity, action string, entityID interface{}, before json.RawMessage) error {
+	return writeAuditForOwner(q, actor, actor.UserID, entity, action, entityID, before)
+}
+
+// writeAuditForOwner 用于操作者不是数据所有者的场景 (如管理员修复余额)，快照按 ownerID 读取
+func writeAuditForOwner(q auditQuerier, actor auditActor, ownerID int64, entity, action string, entityID interface{}, before json.RawMessage) error {
+	after, err := auditSnapshot(q, entity, ownerID, entityID)
+	if err != nil {
+		return err
+	}
+	_, err = q.Exec(
+		"INSERT INTO audit_log (user_id, owner_id, entity, entity_id, action, before_json, after_json, ip_address, user_agent, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		actor.UserID, ownerID, entity, fmt.Sprint(entityID), action, nullableJSON(before), nullableJSON(after), actor.IPAddress, actor.UserAgent, time.Now().Format(time.RFC3339),
+	)
+	if err != nil {
+		return &ledgerError{Status: http.StatusInternalServerError, Message: "写入审计日志失败", Err: err}
+	}
+	return nil
+}
+
+func nullableJSON(raw json.RawMessage) interface{} {
+	if raw == nil {
+		return nil
+	}
+	return string(raw)
+}
+
+// GetAuditLog 查询与当前用户数据相关的审计记录 (包括管理员代为进行的操作)
+// GET /audit?entity=&entity_id=&action=&from=&to=&limit=&before_id=
+func (h *DBHandler) GetAuditLog(c *gin.Context) {
+	userID, _ := c.Get("userID")
+	h.queryAuditLog(c, []string{"owner_id = ?"}, []interface{}{userID})
+}
+
+// GetAllAuditLog 管理员查询所有用户的审计记录，可用 user_id 按操作者过滤、owner_id 按数据所有者过滤
+// GET /admin/audit?user_id=&owner_id=&entity=&entity_id=&action=&from=&to=&limit=&before_id=
+func (h *DBHandler) GetAllAuditLog(c *gin.Context) {
+	var conditions []string
+	var args []interface{}
+	for _, column := range []string{"user_id", "owner_id"} {
+		v := c.Query(column)
+		if v == "" {
+			continue
+		}
+		id, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("无效的 %s 参数", column)})
+			return
+		}
+		conditions = append(conditions, column+" = ?")
+		args = append(args, id)
+	}
+	h.queryAuditLog(c, conditions, args)
+}
+
+// queryAuditLog 按查询参数过滤审计记录，按 ID 倒序返回；响应中的 next_before_id 用于获取下一页
+func (h *DBHandler) queryAuditLog(c *gin.Context, conditions []string, args []interface{}) {
+	if entity := c.Query("entity"); entity != "" {
+		if _, ok := auditEntityTables[entity]; !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "无效的 entity 参数"})
+			return
+		}
+		conditions = append(conditions, "entity = ?")
+		args = append(args, entity)
+	}
+	if v := c.Query("entity_id"); v != "" {
+		conditions = append(conditions, "entity_id = ?")
+		args = append(args, v)
+	}
+	if v := c.Query("action"); v != "" {
+		conditions = append(conditions, "action = ?")
+		args = append(args, v)
+	}
+	for _, bound := range []struct{ param, op string }{{"from", ">="}, {"to", "<="}} {
+		v := c.Query(bound.param)
+		if v == "" {
+			continue
+		}
+		if _, err := time.Parse(dateLayout, v); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("无效的 %s 参数，应为 YYYY-MM-DD", bound.param)})
+			return
+		}
+		conditions = append(conditions, "substr(created_at, 1, 10) "+bound.op+" ?")
+		args = append(args, v)
+	}
+	if v := c.Query("before_id"); v != "" {
+		id, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "无效的 before_id 参数"})
+			return
+		}
+		conditions = append(conditions, "id < ?")
+		args = append(args, id)
+	}
+	limit := defaultAuditPageLimit
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "无效的 limit 参数"})
+			return
+		}
+		limit = min(n, maxAuditPageLimit)
+	}
+
+	query := "SELECT id, user_id, owner_id, entity, entity_id, action, before_json, after_json, ip_address, user_agent, created_at FROM audit_log"
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	query += " ORDER BY id DESC LIMIT ?"
+	args = append(args, limit+1)
+
+	rows, err := h.DB.Query(query, args...)
+	if err != nil {
+		h.Logger.Error("查询审计日志失败", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询审计日志失败"})
+		return
+	}
+	defer rows.Close()
+
+	response := AuditLogResponse{Entries: []AuditLog{}}
+	for rows.Next() {
+		var entry AuditLog
+		var before, after, ip, userAgent sql.NullString
+		if err := rows.Scan(&entry.ID, &entry.UserID, &entry.OwnerID, &entry.Entity, &entry.EntityID, &entry.Action, &before, &after, &ip, &userAgent, &entry.CreatedAt); err != nil {
+			h.Logger.Error("扫描审计日志失败", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "查询审计日志失败"})
+			return
+		}
+		if before.Valid {
+			entry.Before = json.RawMessage(before.String)
+		}
+		if after.Valid {
+			entry.After = json.RawMessage(after.String)
+		}
+		entry.IPAddress = ip.String
+		entry.UserAgent = userAgent.String
+		response.Entries = append(response.Entries, entry)
+	}
+	if err := rows.Err(); err != nil {
+		h.Logger.Error("遍历审计日志结果集时出错", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询审计日志失败"})
+		return
+	}
+	if len(response.Entries) > limit {
+		response.Entries = response.Entries[:limit]
+		next := response.Entries[limit-1].ID
+		response.NextBeforeID = &next
+	}
+	c.JSON(http.StatusOK, response)
+}
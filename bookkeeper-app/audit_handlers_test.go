@@ -0,0 +1,145 @@
+// bookkeeper-app/audit_handlers_test.go
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// 测试审计日志：增删改都会记录前后快照和请求来源，失败的操作不留记录，用户只能看到自己的记录，管理员可查看全部
+func TestAuditLog(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	handler := &DBHandler{DB: db, Logger: slog.New(slog.NewJSONHandler(io.Discard, nil))}
+	router := setupRouter(handler)
+
+	userID := createTestUser(t, db, "testuser", "password")
+	token := getTestAuthToken(t, userID, "testuser", false)
+	otherID := createTestUser(t, db, "otheruser", "password")
+	otherToken := getTestAuthToken(t, otherID, "otheruser", false)
+	adminToken := getTestAuthToken(t, 1, "admin", true)
+	accountID := createTestAccount(t, db, userID, "Test Account", 1000.0)
+
+	send := func(method, path string, payload interface{}) *httptest.ResponseRecorder {
+		var body io.Reader
+		if payload != nil {
+			b, _ := json.Marshal(payload)
+			body = bytes.NewBuffer(b)
+		}
+		return performRequest(router, method, path, body, token)
+	}
+	query := func(path, token string) AuditLogResponse {
+		w := performRequest(router, "GET", path, nil, token)
+		assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		var resp AuditLogResponse
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		return resp
+	}
+	snapshot := func(raw json.RawMessage) map[string]interface{} {
+		var m map[string]interface{}
+		json.Unmarshal(raw, &m)
+		return m
+	}
+
+	// 创建流水时记录请求的 User-Agent
+	body, _ := json.Marshal(CreateTransactionRequest{Type: "expense", Amount: yuan(100), TransactionDate: "2024-05-03", FromAccountID: &accountID})
+	req, _ := http.NewRequest("POST", "/api/v1/transactions", bytes.NewBuffer(body))
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "audit-test/1.0")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	var created struct {
+		ID int64 `json:"id"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &created)
+	txPath := fmt.Sprintf("/api/v1/transactions/%d", created.ID)
+
+	w = send("PUT", txPath, CreateTransactionRequest{Type: "expense", Amount: yuan(80), TransactionDate: "2024-05-03", FromAccountID: &accountID})
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	// 余额不足的修改被回滚，不留审计记录
+	w = send("PUT", txPath, CreateTransactionRequest{Type: "expense", Amount: yuan(5000), TransactionDate: "2024-05-03", FromAccountID: &accountID})
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Equal(t, http.StatusOK, send("DELETE", txPath, nil).Code)
+	assert.Equal(t, http.StatusOK, send("POST", fmt.Sprintf("/api/v1/trash/%d/restore", created.ID), nil).Code)
+
+	resp := query(fmt.Sprintf("/api/v1/audit?entity=transaction&entity_id=%d", created.ID), token)
+	if assert.Len(t, resp.Entries, 4) {
+		restore, del, update, create := resp.Entries[0], resp.Entries[1], resp.Entries[2], resp.Entries[3]
+		assert.Equal(t, "create", create.Action)
+		assert.Nil(t, create.Before)
+		assert.Equal(t, float64(yuan(100)), snapshot(create.After)["amount"])
+		assert.Equal(t, "audit-test/1.0", create.UserAgent)
+		assert.Equal(t, userID, create.UserID)
+
+		assert.Equal(t, "update", update.Action)
+		assert.Equal(t, float64(yuan(100)), snapshot(update.Before)["amount"])
+		assert.Equal(t, float64(yuan(80)), snapshot(update.After)["amount"])
+
+		assert.Equal(t, "delete", del.Action)
+		assert.Nil(t, snapshot(del.Before)["deleted_at"])
+		assert.NotNil(t, snapshot(del.After)["deleted_at"])
+
+		assert.Equal(t, "restore", restore.Action)
+		assert.Nil(t, snapshot(restore.After)["deleted_at"])
+	}
+
+	// 其余实体：账户、分类、预算 (覆盖记为修改)、贷款 (删除后无变更后快照)
+	assert.Equal(t, http.StatusOK, send("PUT", fmt.Sprintf("/api/v1/accounts/%d", accountID), UpdateAccountRequest{Name: "Renamed"}).Code)
+	assert.Equal(t, http.StatusCreated, send("POST", "/api/v1/categories", CreateCategoryRequest{ID: "pets", Name: "宠物", Type: "expense", Icon: "Dog"}).Code)
+	budget := CreateOrUpdateBudgetRequest{Amount: yuan(500), Period: "monthly", Year: 2024, Month: 5}
+	assert.Equal(t, http.StatusOK, send("POST", "/api/v1/budgets", budget).Code)
+	budget.Amount = yuan(600)
+	assert.Equal(t, http.StatusOK, send("POST", "/api/v1/budgets", budget).Code)
+	interest := 0.0
+	assert.Equal(t, http.StatusCreated, send("POST", "/api/v1/loans", UpdateLoanRequest{Principal: yuan(100), InterestRate: &interest, LoanDate: "2024-05-01"}).Code)
+	var loanID int64
+	db.QueryRow("SELECT id FROM loans WHERE user_id = ?", userID).Scan(&loanID)
+	assert.Equal(t, http.StatusOK, send("DELETE", fmt.Sprintf("/api/v1/loans/%d", loanID), nil).Code)
+
+	resp = query("/api/v1/audit?entity=account", token)
+	if assert.Len(t, resp.Entries, 1) {
+		assert.Equal(t, "Test Account", snapshot(resp.Entries[0].Before)["name"])
+		assert.Equal(t, "Renamed", snapshot(resp.Entries[0].After)["name"])
+	}
+	resp = query("/api/v1/audit?entity=category&entity_id=pets", token)
+	assert.Len(t, resp.Entries, 1)
+	resp = query("/api/v1/audit?entity=budget", token)
+	if assert.Len(t, resp.Entries, 2) {
+		assert.Equal(t, "update", resp.Entries[0].Action)
+		assert.Equal(t, float64(yuan(500)), snapshot(resp.Entries[0].Before)["amount"])
+		assert.Equal(t, float64(yuan(600)), snapshot(resp.Entries[0].After)["amount"])
+		assert.Equal(t, "create", resp.Entries[1].Action)
+	}
+	resp = query("/api/v1/audit?entity=loan&action=delete", token)
+	if assert.Len(t, resp.Entries, 1) {
+		assert.NotNil(t, resp.Entries[0].Before)
+		assert.Nil(t, resp.Entries[0].After)
+	}
+
+	// 分页
+	resp = query("/api/v1/audit?limit=3", token)
+	assert.Len(t, resp.Entries, 3)
+	if assert.NotNil(t, resp.NextBeforeID) {
+		next := query(fmt.Sprintf("/api/v1/audit?limit=100&before_id=%d", *resp.NextBeforeID), token)
+		assert.Len(t, next.Entries, 10-3)
+	}
+	w = performRequest(router, "GET", "/api/v1/audit?entity=users", nil, token)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	// 其他用户看不到，管理员可按用户查看全部
+	assert.Empty(t, query("/api/v1/audit", otherToken).Entries)
+	w = performRequest(router, "GET", "/api/v1/admin/audit", nil, token)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Len(t, query(fmt.Sprintf("/api/v1/admin/audit?user_id=%d&limit=100", userID), adminToken).Entries, 10)
+	assert.Empty(t, query(fmt.Sprintf("/api/v1/admin/audit?user_id=%d", otherID), adminToken).Entries)
+}
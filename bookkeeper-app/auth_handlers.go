@@ -355,5 +355,6 @@ func (h *DBHandler) DeleteUser(c *gin.Context) {
 		return
 	}
 
+	h.purgeOrphanAttachments()
 	c.JSON(http.StatusOK, gin.H{"message": "用户及其所有数据已成功删除"})
 }
@@ -53,8 +53,7 @@ func AuthMiddleware() gin.HandlerFunc {
 		// 将解析出的用户信息存储在 context 中，方便后续的 handler 使用
 		c.Set("claims", claims)
 		c.Set("userID", claims.UserID) // 特别设置 userID，使用更方便
-
-		c.Next()
+		// 不在这里调用 c.Next()：AdminMiddleware 会内联调用本中间件，提前执行后续处理器会绕过管理员校验
 	}
 }
 
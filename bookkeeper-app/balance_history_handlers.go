@@ -0,0 +1,216 @@
+// bookkeeper-app/balance_history_handlers.go
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxBalanceHistoryPoints 一次余额历史查询最多返回的时间段数
+const maxBalanceHistoryPoints = 1000
+
+// accountBalanceAt 账户在 date (YYYY-MM-DD) 当天结束时的余额：当前余额减去此后所有流水的影响，
+// 流水的影响取自其记账分录，已删除的流水没有分录，不计入。
+// 同时返回账户币种；账户不存在或不属于该用户时返回 404。
+func accountBalanceAt(q interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}, userID, accountID int64, date string) (Money, string, error) {
+	var balance, later Money
+	var currency string
+	if err := q.QueryRow("SELECT balance, currency FROM accounts WHERE id = ? AND user_id = ?", accountID, userID).Scan(&balance, &currency); err != nil {
+		if err == sql.ErrNoRows {
+			return 0, "", &ledgerError{Status: http.StatusNotFound, Message: "未找到指定ID的账户"}
+		}
+		return 0, "", &ledgerError{Status: http.StatusInternalServerError, Message: "查询账户余额失败", Err: err}
+	}
+	err := q.QueryRow(`
+        SELECT COALESCE(SUM(`+accountEffectSQL("?")+`), 0)
+        FROM transactions t
+        WHERE t.user_id = ? AND (t.from_account_id = ? OR t.to_account_id = ?) AND t.deleted_at IS NULL
+          AND date(t.transaction_date) > ?`,
+		accountID, accountID, userID, accountID, accountID, date,
+	).Scan(&later)
+	if err != nil {
+		return 0, "", &ledgerError{Status: http.StatusInternalServerError, Message: "计算历史余额失败", Err: err}
+	}
+	return balance - later, currency, nil
+}
+
+// historyPeriodStart / historyNextPeriod 余额历史的时间段划分：按日、按周 (周一开始) 或按自然月
+func historyPeriodStart(d time.Time, interval string) time.Time {
+	switch interval {
+	case "week":
+		return d.AddDate(0, 0, -((int(d.Weekday()) + 6) % 7))
+	case "month":
+		return time.Date(d.Year(), d.Month(), 1, 0, 0, 0, 0, d.Location())
+	}
+	return d
+}
+
+func historyNextPeriod(start time.Time, interval string) time.Time {
+	switch interval {
+	case "week":
+		return start.AddDate(0, 0, 7)
+	case "month":
+		return start.AddDate(0, 1, 0)
+	}
+	return start.AddDate(0, 0, 1)
+}
+
+// buildBalanceHistory 从 to 当天的余额出发，按日汇总 [from, to] 内的流入流出，倒推期初余额后逐段累加
+func buildBalanceHistory(tx *sql.Tx, userID, accountID int64, from, to time.Time, interval string) (AccountBalanceHistory, error) {
+	fromStr, toStr := from.Format(dateLayout), to.Format(dateLayout)
+	history := AccountBalanceHistory{AccountID: accountID, From: fromStr, To: toStr, Interval: interval, Points: []BalanceHistoryPoint{}}
+
+	closing, currency, err := accountBalanceAt(tx, userID, accountID, toStr)
+	if err != nil {
+		return history, err
+	}
+	history.Currency = currency
+
+	type dayFlow struct{ inflow, outflow Money }
+	flows := make(map[string]dayFlow)
+	rows, err := tx.Query(`
+        SELECT date(t.transaction_date), SUM(`+accountInflowSQL("?")+`), SUM(`+accountOutflowSQL("?")+`)
+        FROM transactions t
+        WHERE t.user_id = ? AND (t.from_account_id = ? OR t.to_account_id = ?) AND t.deleted_at IS NULL
+          AND date(t.transaction_date) BETWEEN ? AND ?
+        GROUP BY date(t.transaction_date)`,
+		accountID, accountID, userID, accountID, accountID, fromStr, toStr,
+	)
+	if err != nil {
+		return history, &ledgerError{Status: http.StatusInternalServerError, Message: "查询账户流水失败", Err: err}
+	}
+	defer rows.Close()
+	opening := closing
+	for rows.Next() {
+		var day string
+		var f dayFlow
+		if err := rows.Scan(&day, &f.inflow, &f.outflow); err != nil {
+			return history, &ledgerError{Status: http.StatusInternalServerError, Message: "扫描账户流水失败", Err: err}
+		}
+		flows[day] = f
+		opening -= f.inflow - f.outflow
+	}
+	if err := rows.Err(); err != nil {
+		return history, &ledgerError{Status: http.StatusInternalServerError, Message: "查询账户流水失败", Err: err}
+	}
+
+	balance := opening
+	for start := historyPeriodStart(from, interval); !start.After(to); start = historyNextPeriod(start, interval) {
+		periodStart, periodEnd := start, historyNextPeriod(start, interval).AddDate(0, 0, -1)
+		if periodStart.Before(from) {
+			periodStart = from
+		}
+		if periodEnd.After(to) {
+			periodEnd = to
+		}
+		point := BalanceHistoryPoint{PeriodStart: periodStart.Format(dateLayout), PeriodEnd: periodEnd.Format(dateLayout), OpeningBalance: balance}
+		for d := periodStart; !d.After(periodEnd); d = d.AddDate(0, 0, 1) {
+			f := flows[d.Format(dateLayout)]
+			point.Inflow += f.inflow
+			point.Outflow += f.outflow
+		}
+		balance += point.Inflow - point.Outflow
+		point.ClosingBalance = balance
+		history.Points = append(history.Points, point)
+	}
+	return history, nil
+}
+
+// parseAccountID 解析路径中的账户ID，无效时直接返回 400
+func parseAccountID(c *gin.Context) (int64, bool) {
+	accountID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的账户ID"})
+		return 0, false
+	}
+	return accountID, true
+}
+
+// GetAccountBalanceAt 查询账户在某日结束时的余额，不填日期时为今天
+// GET /accounts/:id/balance?at=2024-01-01
+func (h *DBHandler) GetAccountBalanceAt(c *gin.Context) {
+	userID, _ := c.Get("userID")
+	logger := h.Logger.With(slog.Int64("userID", userID.(int64)), "accountID", c.Param("id"))
+
+	accountID, ok := parseAccountID(c)
+	if !ok {
+		return
+	}
+	at := c.DefaultQuery("at", time.Now().Format(dateLayout))
+	if _, err := time.Parse(dateLayout, at); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "at 格式应为 YYYY-MM-DD"})
+		return
+	}
+
+	balance, currency, err := accountBalanceAt(h.DB, userID.(int64), accountID, at)
+	if err != nil {
+		writeLedgerError(c, logger, err)
+		return
+	}
+	c.JSON(http.StatusOK, AccountBalance{AccountID: accountID, Date: at, Currency: currency, Balance: balance})
+}
+
+// GetAccountBalanceHistory 按日/周/月列出账户在一段时间内的余额变化。
+// to 默认为今天，from 默认为 to 之前 30 天，interval 默认为 day。
+// GET /accounts/:id/history?from=2024-01-01&to=2024-06-30&interval=month
+func (h *DBHandler) GetAccountBalanceHistory(c *gin.Context) {
+	userID, _ := c.Get("userID")
+	logger := h.Logger.With(slog.Int64("userID", userID.(int64)), "accountID", c.Param("id"))
+
+	accountID, ok := parseAccountID(c)
+	if !ok {
+		return
+	}
+	interval := c.DefaultQuery("interval", "day")
+	if interval != "day" && interval != "week" && interval != "month" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "interval 只能是 day、week 或 month"})
+		return
+	}
+	to, err := time.ParseInLocation(dateLayout, c.DefaultQuery("to", time.Now().Format(dateLayout)), time.Local)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "to 格式应为 YYYY-MM-DD"})
+		return
+	}
+	from := to.AddDate(0, 0, -30)
+	if v := c.Query("from"); v != "" {
+		if from, err = time.ParseInLocation(dateLayout, v, time.Local); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "from 格式应为 YYYY-MM-DD"})
+			return
+		}
+	}
+	if from.After(to) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "from 不能晚于 to"})
+		return
+	}
+	points := 0
+	for start := historyPeriodStart(from, interval); !start.After(to); start = historyNextPeriod(start, interval) {
+		if points++; points > maxBalanceHistoryPoints {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("时间范围过大，最多返回 %d 个时间段，请缩小范围或使用更大的 interval", maxBalanceHistoryPoints)})
+			return
+		}
+	}
+
+	// 只读事务，保证余额与流水来自同一快照
+	tx, err := h.DB.Begin()
+	if err != nil {
+		logger.Error("开启事务失败", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "开启事务失败"})
+		return
+	}
+	defer tx.Rollback()
+
+	history, err := buildBalanceHistory(tx, userID.(int64), accountID, from, to, interval)
+	if err != nil {
+		writeLedgerError(c, logger, err)
+		return
+	}
+	c.JSON(http.StatusOK, history)
+}
@@ -0,0 +1,91 @@
+// bookkeeper-app/balance_history_handlers_test.go
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// 测试由当前余额倒推历史余额：收入、支出和转账双方的方向，已删除的流水不计入
+func TestAccountBalanceHistory(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	handler := &DBHandler{DB: db, Logger: slog.New(slog.NewJSONHandler(io.Discard, nil))}
+	router := setupRouter(handler)
+
+	userID := createTestUser(t, db, "testuser", "password")
+	token := getTestAuthToken(t, userID, "testuser", false)
+	accountA := createTestAccount(t, db, userID, "Account A", 1000.0)
+	accountB := createTestAccount(t, db, userID, "Account B", 0)
+
+	create := func(req CreateTransactionRequest) int64 {
+		body, _ := json.Marshal(req)
+		w := performRequest(router, "POST", "/api/v1/transactions", bytes.NewBuffer(body), token)
+		assert.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+		var resp struct {
+			ID int64 `json:"id"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		return resp.ID
+	}
+	create(CreateTransactionRequest{Type: "income", Amount: yuan(500), TransactionDate: "2024-01-10", ToAccountID: &accountA})
+	create(CreateTransactionRequest{Type: "expense", Amount: yuan(200), TransactionDate: "2024-01-20", FromAccountID: &accountA})
+	create(CreateTransactionRequest{Type: "transfer", Amount: yuan(300), TransactionDate: "2024-02-05", FromAccountID: &accountA, ToAccountID: &accountB})
+	deleted := create(CreateTransactionRequest{Type: "expense", Amount: yuan(100), TransactionDate: "2024-01-15", FromAccountID: &accountA})
+	w := performRequest(router, "DELETE", fmt.Sprintf("/api/v1/transactions/%d", deleted), nil, token)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	balanceAt := func(accountID int64, at string) Money {
+		w := performRequest(router, "GET", fmt.Sprintf("/api/v1/accounts/%d/balance?at=%s", accountID, at), nil, token)
+		assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		var resp AccountBalance
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		return resp.Balance
+	}
+	assert.Equal(t, yuan(1000), balanceAt(accountA, "2024-01-09"))
+	assert.Equal(t, yuan(1500), balanceAt(accountA, "2024-01-15"))
+	assert.Equal(t, yuan(1300), balanceAt(accountA, "2024-01-31"))
+	assert.Equal(t, yuan(1000), balanceAt(accountA, "2024-02-05"))
+	assert.Equal(t, yuan(0), balanceAt(accountB, "2024-02-04"))
+	assert.Equal(t, yuan(300), balanceAt(accountB, "2024-02-05"))
+
+	history := func(query string) (int, AccountBalanceHistory) {
+		w := performRequest(router, "GET", fmt.Sprintf("/api/v1/accounts/%d/history?%s", accountA, query), nil, token)
+		var resp AccountBalanceHistory
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		return w.Code, resp
+	}
+	code, resp := history("from=2024-01-05&to=2024-02-29&interval=month")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, []BalanceHistoryPoint{
+		{PeriodStart: "2024-01-05", PeriodEnd: "2024-01-31", OpeningBalance: yuan(1000), Inflow: yuan(500), Outflow: yuan(200), ClosingBalance: yuan(1300)},
+		{PeriodStart: "2024-02-01", PeriodEnd: "2024-02-29", OpeningBalance: yuan(1300), Outflow: yuan(300), ClosingBalance: yuan(1000)},
+	}, resp.Points)
+
+	// 按周划分时每周从周一开始，首段按 from 截断
+	code, resp = history("from=2024-01-10&to=2024-01-21&interval=week")
+	assert.Equal(t, http.StatusOK, code)
+	if assert.Len(t, resp.Points, 2) {
+		assert.Equal(t, "2024-01-14", resp.Points[0].PeriodEnd)
+		assert.Equal(t, "2024-01-15", resp.Points[1].PeriodStart)
+		assert.Equal(t, yuan(1300), resp.Points[1].ClosingBalance)
+	}
+
+	code, _ = history("from=2024-01-10&to=2024-01-21&interval=year")
+	assert.Equal(t, http.StatusBadRequest, code)
+	code, _ = history("from=2024-02-10&to=2024-01-21")
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	// 其他用户的账户
+	otherID := createTestUser(t, db, "other", "password")
+	otherAccount := createTestAccount(t, db, otherID, "Other", 10)
+	w = performRequest(router, "GET", fmt.Sprintf("/api/v1/accounts/%d/balance?at=2024-01-01", otherAccount), nil, token)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
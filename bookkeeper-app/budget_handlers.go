@@ -3,6 +3,7 @@ package main
 
 import (
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"log/slog"
@@ -43,25 +44,39 @@ func (h *DBHandler) CreateOrUpdateBudget(c *gin.Context) {
 	defer tx.Rollback()
 
 	// 先尝试删除可能存在的旧预算记录，因为 ON CONFLICT 对 NULL 的处理在某些 SQLite 版本中有问题
-	var deleteQuery string
+	var whereClause string
 	var deleteArgs []interface{}
 
-	deleteQuery = "DELETE FROM budgets WHERE user_id = ? AND period = ? AND year = ?"
+	whereClause = " WHERE user_id = ? AND period = ? AND year = ?"
 	deleteArgs = append(deleteArgs, userID, req.Period, req.Year)
 
 	if req.Period == "monthly" {
-		deleteQuery += " AND month = ?"
+		whereClause += " AND month = ?"
 		deleteArgs = append(deleteArgs, req.Month)
 	}
 
 	if req.CategoryID == nil || *req.CategoryID == "" {
-		deleteQuery += " AND category_id IS NULL"
+		whereClause += " AND category_id IS NULL"
 	} else {
-		deleteQuery += " AND category_id = ?"
+		whereClause += " AND category_id = ?"
 		deleteArgs = append(deleteArgs, *req.CategoryID)
 	}
 
-	if _, err := tx.Exec(deleteQuery, deleteArgs...); err != nil {
+	// 覆盖已有预算时记为一次修改，变更前快照取自被替换的旧记录
+	var before json.RawMessage
+	var oldID int64
+	if err := tx.QueryRow("SELECT id FROM budgets"+whereClause, deleteArgs...).Scan(&oldID); err == nil {
+		if before, err = auditSnapshot(tx, "budget", userID.(int64), oldID); err != nil {
+			writeLedgerError(c, logger, err)
+			return
+		}
+	} else if err != sql.ErrNoRows {
+		logger.Error("查询旧预算失败", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "保存预算失败"})
+		return
+	}
+
+	if _, err := tx.Exec("DELETE FROM budgets"+whereClause, deleteArgs...); err != nil {
 		logger.Error("删除旧预算失败", "error", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "保存预算失败"})
 		return
@@ -86,7 +101,7 @@ func (h *DBHandler) CreateOrUpdateBudget(c *gin.Context) {
 		insertArgs = append(insertArgs, userID, req.Period, req.Year, nil, categoryIDForInsert, req.Amount, createdAt)
 	}
 
-	_, err = tx.Exec(insertQuery, insertArgs...)
+	res, err := tx.Exec(insertQuery, insertArgs...)
 	if err != nil {
 		logger.Error("创建或更新预算失败", "error", err)
 		var sqliteErr sqlite3.Error
@@ -97,6 +112,15 @@ func (h *DBHandler) CreateOrUpdateBudget(c *gin.Context) {
 		}
 		return
 	}
+	budgetID, _ := res.LastInsertId()
+	action := "create"
+	if before != nil {
+		action = "update"
+	}
+	if err := writeAudit(tx, auditActorFromContext(c), "budget", action, budgetID, before); err != nil {
+		writeLedgerError(c, logger, err)
+		return
+	}
 
 	if err := tx.Commit(); err != nil {
 		logger.Error("提交预算事务失败", "error", err)
@@ -167,9 +191,10 @@ func (h *DBHandler) GetBudgets(c *gin.Context) {
 		}
 
 		// 2. 为每个预算单独计算其已用金额
-		var spent float64
+		var spent Money
 		var spentQueryBuilder strings.Builder
-		spentQueryBuilder.WriteString("SELECT COALESCE(SUM(amount), 0) FROM transactions WHERE user_id = ? AND type IN ('expense', 'repayment')")
+		// 按明细行统计，拆分流水的每一行计入各自的分类；外币流水按当日汇率换算为本位币
+		spentQueryBuilder.WriteString("SELECT COALESCE(SUM(base_amount), 0) FROM transaction_lines WHERE user_id = ? AND type IN ('expense', 'repayment')")
 
 		args := []interface{}{userID}
 
@@ -194,7 +219,7 @@ func (h *DBHandler) GetBudgets(c *gin.Context) {
 		b.Spent = spent
 		b.Remaining = b.Amount - spent
 		if b.Amount > 0 {
-			b.Progress = spent / b.Amount
+			b.Progress = spent.Float64() / b.Amount.Float64()
 		} else {
 			b.Progress = 0
 		}
@@ -215,7 +240,19 @@ func (h *DBHandler) GetBudgets(c *gin.Context) {
 func (h *DBHandler) DeleteBudget(c *gin.Context) {
 	userID, _ := c.Get("userID")
 	id := c.Param("id")
-	res, err := h.DB.Exec("DELETE FROM budgets WHERE id = ? AND user_id = ?", id, userID)
+	tx, err := h.DB.Begin()
+	if err != nil {
+		h.Logger.Error("开启事务失败", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "开启事务失败"})
+		return
+	}
+	defer tx.Rollback()
+	before, err := auditSnapshot(tx, "budget", userID.(int64), id)
+	if err != nil {
+		writeLedgerError(c, h.Logger, err)
+		return
+	}
+	res, err := tx.Exec("DELETE FROM budgets WHERE id = ? AND user_id = ?", id, userID)
 	if err != nil {
 		h.Logger.Error("删除预算失败", "error", err, "budgetID", id, slog.Int64("userID", userID.(int64)))
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "删除预算失败"})
@@ -226,5 +263,14 @@ func (h *DBHandler) DeleteBudget(c *gin.Context) {
 		c.JSON(http.StatusNotFound, gin.H{"error": "未找到指定ID的预算"})
 		return
 	}
+	if err := writeAudit(tx, auditActorFromContext(c), "budget", "delete", id, before); err != nil {
+		writeLedgerError(c, h.Logger, err)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		h.Logger.Error("提交事务失败", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "提交事务失败"})
+		return
+	}
 	c.JSON(http.StatusOK, gin.H{"message": "预算删除成功"})
 }
@@ -0,0 +1,159 @@
+// bookkeeper-app/bulk_handlers.go
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+)
+
+// maxBulkItems 单次批量操作允许的最大条目数
+const maxBulkItems = 500
+
+// runBulkItems 在同一事务中依次执行各条目。每个条目使用独立的保存点，失败的条目会回滚到保存点，
+// 以便继续校验后续条目并一次性报告所有错误；只要有任何条目失败，调用方就不应提交事务。
+func runBulkItems(tx *sql.Tx, count int, apply func(i int) error) ([]BulkItemError, error) {
+	itemErrors := []BulkItemError{}
+	for i := 0; i < count; i++ {
+		if _, err := tx.Exec("SAVEPOINT bulk_item"); err != nil {
+			return nil, err
+		}
+		if err := apply(i); err != nil {
+			if _, rbErr := tx.Exec("ROLLBACK TO bulk_item"); rbErr != nil {
+				return nil, rbErr
+			}
+			// 服务器内部错误不是条目本身的问题，直接中止整个批量操作
+			var le *ledgerError
+			if !errors.As(err, &le) || le.Status == http.StatusInternalServerError {
+				return nil, err
+			}
+			itemErrors = append(itemErrors, BulkItemError{Index: i, Status: le.Status, Error: le.Message})
+		}
+		if _, err := tx.Exec("RELEASE bulk_item"); err != nil {
+			return nil, err
+		}
+	}
+	return itemErrors, nil
+}
+
+// BulkCreateTransactions 批量创建流水：全部成功才提交，任何一条失败则整体回滚并按下标报告错误
+func (h *DBHandler) BulkCreateTransactions(c *gin.Context) {
+	userID, _ := c.Get("userID")
+	logger := h.Logger.With(slog.Int64("userID", userID.(int64)))
+
+	var req BulkCreateTransactionsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的请求数据: " + err.Error()})
+		return
+	}
+	if len(req.Items) > maxBulkItems {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("单次最多提交 %d 条流水", maxBulkItems)})
+		return
+	}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		logger.Error("开启事务失败", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "开启事务失败"})
+		return
+	}
+	defer tx.Rollback()
+
+	actor := auditActorFromContext(c)
+	ids := make([]int64, 0, len(req.Items))
+	itemErrors, err := runBulkItems(tx, len(req.Items), func(i int) error {
+		item := &req.Items[i]
+		// 条目级别的字段校验 (与 POST /transactions 的绑定规则相同)
+		if err := binding.Validator.ValidateStruct(item); err != nil {
+			return &ledgerError{Status: http.StatusBadRequest, Message: "无效的请求数据: " + err.Error()}
+		}
+		id, err := createTransactionInTx(tx, userID.(int64), item)
+		if err != nil {
+			return err
+		}
+		if err := writeAudit(tx, actor, "transaction", "create", id, nil); err != nil {
+			return err
+		}
+		ids = append(ids, id)
+		return nil
+	})
+	if err != nil {
+		writeLedgerError(c, logger, err)
+		return
+	}
+	if len(itemErrors) > 0 {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": fmt.Sprintf("%d 条流水校验失败，未做任何修改", len(itemErrors)), "errors": itemErrors})
+		return
+	}
+	if req.DryRun {
+		c.JSON(http.StatusOK, gin.H{"message": "校验通过 (dry run，未提交)", "dry_run": true, "count": len(req.Items)})
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		logger.Error("提交事务失败", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "提交事务失败"})
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"message": fmt.Sprintf("成功创建 %d 条流水", len(ids)), "ids": ids})
+}
+
+// BulkDeleteTransactions 批量删除流水并恢复账户余额：全部成功才提交，任何一条失败则整体回滚
+func (h *DBHandler) BulkDeleteTransactions(c *gin.Context) {
+	userID, _ := c.Get("userID")
+	logger := h.Logger.With(slog.Int64("userID", userID.(int64)))
+
+	var req BulkDeleteTransactionsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的请求数据: " + err.Error()})
+		return
+	}
+	if len(req.IDs) > maxBulkItems {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("单次最多删除 %d 条流水", maxBulkItems)})
+		return
+	}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		logger.Error("开启事务失败", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "开启事务失败"})
+		return
+	}
+	defer tx.Rollback()
+
+	actor := auditActorFromContext(c)
+	itemErrors, err := runBulkItems(tx, len(req.IDs), func(i int) error {
+		before, err := auditSnapshot(tx, "transaction", userID.(int64), req.IDs[i])
+		if err != nil {
+			return err
+		}
+		if err := deleteTransactionInTx(tx, userID.(int64), req.IDs[i]); err != nil {
+			return err
+		}
+		return writeAudit(tx, actor, "transaction", "delete", req.IDs[i], before)
+	})
+	if err != nil {
+		writeLedgerError(c, logger, err)
+		return
+	}
+	if len(itemErrors) > 0 {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": fmt.Sprintf("%d 条流水无法删除，未做任何修改", len(itemErrors)), "errors": itemErrors})
+		return
+	}
+	if req.DryRun {
+		c.JSON(http.StatusOK, gin.H{"message": "校验通过 (dry run，未提交)", "dry_run": true, "count": len(req.IDs)})
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		logger.Error("提交事务失败", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "提交事务失败"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": fmt.Sprintf("已将 %d 条流水移入回收站，相关账户余额已恢复", len(req.IDs))})
+}
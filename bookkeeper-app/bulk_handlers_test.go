@@ -0,0 +1,88 @@
+// bookkeeper-app/bulk_handlers_test.go
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// 测试批量创建的全有或全无语义：任何一条失败都不应留下部分数据
+func TestBulkCreateTransactions_AllOrNothing(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	handler := &DBHandler{DB: db, Logger: slog.New(slog.NewJSONHandler(io.Discard, nil))}
+	router := setupRouter(handler)
+
+	userID := createTestUser(t, db, "testuser", "password")
+	token := getTestAuthToken(t, userID, "testuser", false)
+	accountID := createTestAccount(t, db, userID, "Test Account", 100.0)
+
+	items := []CreateTransactionRequest{
+		{Type: "expense", Amount: yuan(60), TransactionDate: "2024-01-01", FromAccountID: &accountID},
+		{Type: "expense", Amount: yuan(0), TransactionDate: "2024-01-02", FromAccountID: &accountID},
+		{Type: "expense", Amount: yuan(50), TransactionDate: "2024-01-03", FromAccountID: &accountID},
+	}
+	body, _ := json.Marshal(BulkCreateTransactionsRequest{Items: items})
+	w := performRequest(router, "POST", "/api/v1/transactions/bulk", bytes.NewBuffer(body), token)
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+
+	var errResp struct {
+		Errors []BulkItemError `json:"errors"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &errResp)
+	if assert.Len(t, errResp.Errors, 2) {
+		assert.Equal(t, 1, errResp.Errors[0].Index)
+		assert.Equal(t, http.StatusBadRequest, errResp.Errors[0].Status)
+		// 第一条扣款 60 后余额只剩 40，第三条余额不足
+		assert.Equal(t, 2, errResp.Errors[1].Index)
+		assert.Equal(t, http.StatusConflict, errResp.Errors[1].Status)
+	}
+
+	var count int
+	var balance Money
+	db.QueryRow("SELECT COUNT(*) FROM transactions WHERE user_id = ?", userID).Scan(&count)
+	db.QueryRow("SELECT balance FROM accounts WHERE id = ?", accountID).Scan(&balance)
+	assert.Equal(t, 0, count)
+	assert.Equal(t, yuan(100), balance)
+
+	// dry run 校验通过但不提交
+	items = []CreateTransactionRequest{items[0], {Type: "expense", Amount: yuan(40), TransactionDate: "2024-01-02", FromAccountID: &accountID}}
+	body, _ = json.Marshal(BulkCreateTransactionsRequest{Items: items, DryRun: true})
+	w = performRequest(router, "POST", "/api/v1/transactions/bulk", bytes.NewBuffer(body), token)
+	assert.Equal(t, http.StatusOK, w.Code)
+	db.QueryRow("SELECT COUNT(*) FROM transactions WHERE user_id = ?", userID).Scan(&count)
+	assert.Equal(t, 0, count)
+
+	body, _ = json.Marshal(BulkCreateTransactionsRequest{Items: items})
+	w = performRequest(router, "POST", "/api/v1/transactions/bulk", bytes.NewBuffer(body), token)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	db.QueryRow("SELECT balance FROM accounts WHERE id = ?", accountID).Scan(&balance)
+	assert.Equal(t, yuan(0), balance)
+
+	// 批量删除：包含一个不存在的ID时整体回滚
+	var ids []int64
+	rows, _ := db.Query("SELECT id FROM transactions WHERE user_id = ?", userID)
+	for rows.Next() {
+		var id int64
+		rows.Scan(&id)
+		ids = append(ids, id)
+	}
+	rows.Close()
+	body, _ = json.Marshal(BulkDeleteTransactionsRequest{IDs: append(ids, 9999)})
+	w = performRequest(router, "DELETE", "/api/v1/transactions/bulk", bytes.NewBuffer(body), token)
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	db.QueryRow("SELECT COUNT(*) FROM transactions WHERE user_id = ?", userID).Scan(&count)
+	assert.Equal(t, 2, count)
+
+	body, _ = json.Marshal(BulkDeleteTransactionsRequest{IDs: ids})
+	w = performRequest(router, "DELETE", "/api/v1/transactions/bulk", bytes.NewBuffer(body), token)
+	assert.Equal(t, http.StatusOK, w.Code)
+	db.QueryRow("SELECT balance FROM accounts WHERE id = ?", accountID).Scan(&balance)
+	assert.Equal(t, yuan(100), balance)
+}
@@ -75,9 +75,17 @@ func (h *DBHandler) CreateCategory(c *gin.Context) {
 		return
 	}
 
+	tx, err := h.DB.Begin()
+	if err != nil {
+		h.Logger.Error("开启事务失败", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "开启事务失败"})
+		return
+	}
+	defer tx.Rollback()
+
 	// 检查ID和名称是否与任何现有分类（共享或私有）冲突
 	var count int
-	err := h.DB.QueryRow(`
+	err = tx.QueryRow(`
 		SELECT COUNT(*) FROM (
 			SELECT id, name FROM shared_categories
 			UNION ALL
@@ -96,7 +104,7 @@ func (h *DBHandler) CreateCategory(c *gin.Context) {
 
 	createdAt := time.Now().Format(time.RFC3339)
 	// 只在用户的私有 categories 表中插入
-	_, err = h.DB.Exec("INSERT INTO categories(id, user_id, name, type, icon, created_at) VALUES(?, ?, ?, ?, ?, ?)",
+	_, err = tx.Exec("INSERT INTO categories(id, user_id, name, type, icon, created_at) VALUES(?, ?, ?, ?, ?, ?)",
 		req.ID, userID, req.Name, req.Type, req.Icon, createdAt)
 
 	if err != nil {
@@ -110,6 +118,15 @@ func (h *DBHandler) CreateCategory(c *gin.Context) {
 		}
 		return
 	}
+	if err := writeAudit(tx, auditActorFromContext(c), "category", "create", req.ID, nil); err != nil {
+		writeLedgerError(c, h.Logger, err)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		h.Logger.Error("提交事务失败", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "提交事务失败"})
+		return
+	}
 
 	newCategory := Category{
 		ID:         req.ID,
@@ -134,9 +151,17 @@ func (h *DBHandler) UpdateCategory(c *gin.Context) {
 		return
 	}
 
+	tx, err := h.DB.Begin()
+	if err != nil {
+		h.Logger.Error("开启事务失败", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "开启事务失败"})
+		return
+	}
+	defer tx.Rollback()
+
 	// 检查新名称是否与其他分类冲突
 	var count int
-	err := h.DB.QueryRow(`
+	err = tx.QueryRow(`
 		SELECT COUNT(*) FROM (
 			SELECT name FROM shared_categories
 			UNION ALL
@@ -146,7 +171,7 @@ func (h *DBHandler) UpdateCategory(c *gin.Context) {
 	if err == nil && count > 0 {
 		// 检查这个冲突是不是自己
 		var selfName string
-		h.DB.QueryRow("SELECT name FROM categories WHERE id = ? AND user_id = ?", id, userID).Scan(&selfName)
+		tx.QueryRow("SELECT name FROM categories WHERE id = ? AND user_id = ?", id, userID).Scan(&selfName)
 		if selfName != req.Name {
 			c.JSON(http.StatusConflict, gin.H{"error": "更新分类失败，该名称已被其他分类使用"})
 			return
@@ -154,7 +179,12 @@ func (h *DBHandler) UpdateCategory(c *gin.Context) {
 	}
 
 	// 只允许更新私有分类
-	result, err := h.DB.Exec("UPDATE categories SET name = ?, icon = ? WHERE id = ? AND user_id = ?", req.Name, req.Icon, id, userID)
+	before, err := auditSnapshot(tx, "category", userID.(int64), id)
+	if err != nil {
+		writeLedgerError(c, h.Logger, err)
+		return
+	}
+	result, err := tx.Exec("UPDATE categories SET name = ?, icon = ? WHERE id = ? AND user_id = ?", req.Name, req.Icon, id, userID)
 	if err != nil {
 		h.Logger.Error("更新私有分类失败", "error", err, "categoryID", id, slog.Int64("userID", userID.(int64)))
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "更新分类失败"})
@@ -166,6 +196,15 @@ func (h *DBHandler) UpdateCategory(c *gin.Context) {
 		c.JSON(http.StatusNotFound, gin.H{"error": "未找到指定的私有分类，或该分类为不可编辑的共享分类"})
 		return
 	}
+	if err := writeAudit(tx, auditActorFromContext(c), "category", "update", id, before); err != nil {
+		writeLedgerError(c, h.Logger, err)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		h.Logger.Error("提交事务失败", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "提交事务失败"})
+		return
+	}
 
 	c.JSON(http.StatusOK, gin.H{"message": "分类更新成功"})
 }
@@ -175,9 +214,21 @@ func (h *DBHandler) DeleteCategory(c *gin.Context) {
 	userID, _ := c.Get("userID")
 	id := c.Param("id")
 
-	// 检查是否有流水正在使用此分类
+	tx, err := h.DB.Begin()
+	if err != nil {
+		h.Logger.Error("开启事务失败", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "开启事务失败"})
+		return
+	}
+	defer tx.Rollback()
+
+	// 检查是否有流水 (包括拆分明细) 正在使用此分类
 	var count int
-	err := h.DB.QueryRow("SELECT COUNT(*) FROM transactions WHERE category_id = ? AND user_id = ?", id, userID).Scan(&count)
+	err = tx.QueryRow(`
+		SELECT
+			(SELECT COUNT(*) FROM transactions WHERE category_id = ? AND user_id = ?) +
+			(SELECT COUNT(*) FROM transaction_splits s JOIN transactions t ON t.id = s.transaction_id WHERE s.category_id = ? AND t.user_id = ?)
+	`, id, userID, id, userID).Scan(&count)
 	if err != nil {
 		h.Logger.Error("检查分类使用情况失败", "error", err, "categoryID", id, slog.Int64("userID", userID.(int64)))
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "检查分类使用情况失败"})
@@ -189,7 +240,12 @@ func (h *DBHandler) DeleteCategory(c *gin.Context) {
 	}
 
 	// 只允许删除私有分类
-	result, err := h.DB.Exec("DELETE FROM categories WHERE id = ? AND user_id = ?", id, userID)
+	before, err := auditSnapshot(tx, "category", userID.(int64), id)
+	if err != nil {
+		writeLedgerError(c, h.Logger, err)
+		return
+	}
+	result, err := tx.Exec("DELETE FROM categories WHERE id = ? AND user_id = ?", id, userID)
 	if err != nil {
 		h.Logger.Error("删除私有分类失败", "error", err, "categoryID", id, slog.Int64("userID", userID.(int64)))
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "删除分类失败"})
@@ -201,6 +257,15 @@ func (h *DBHandler) DeleteCategory(c *gin.Context) {
 		c.JSON(http.StatusNotFound, gin.H{"error": "未找到指定的私有分类，或该分类为不可删除的共享分类"})
 		return
 	}
+	if err := writeAudit(tx, auditActorFromContext(c), "category", "delete", id, before); err != nil {
+		writeLedgerError(c, h.Logger, err)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		h.Logger.Error("提交事务失败", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "提交事务失败"})
+		return
+	}
 
 	c.JSON(http.StatusOK, gin.H{"message": "分类删除成功"})
 }
@@ -0,0 +1,275 @@
+// bookkeeper-app/credit_card_handlers.go
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	creditCardAccountType  = "credit_card"
+	defaultStatementCycles = 6
+	maxStatementCycles     = 24
+)
+
+// setupCreditCards 为账户表补充信用卡设置：信用额度、每月账单日和还款日 (非信用卡账户为空)
+func setupCreditCards(tx *sql.Tx) error {
+	columns := []struct{ name, definition string }{
+		{"credit_limit", `"credit_limit" INTEGER NOT NULL DEFAULT 0`},
+		{"statement_day", `"statement_day" INTEGER`},
+		{"payment_due_day", `"payment_due_day" INTEGER`},
+	}
+	for _, col := range columns {
+		if err := addColumnIfMissing(tx, "accounts", col.name, col.definition); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkAvailableFunds 检查账户是否足以支出 amount：普通账户不能透支，信用卡最多透支到信用额度
+func checkAvailableFunds(tx *sql.Tx, accountID int64, amount Money, label string) error {
+	var balance, creditLimit Money
+	var accountType string
+	if err := tx.QueryRow("SELECT balance, credit_limit, type FROM accounts WHERE id = ?", accountID).Scan(&balance, &creditLimit, &accountType); err != nil {
+		return &ledgerError{Status: http.StatusInternalServerError, Message: "查询" + label + "余额失败", Err: err}
+	}
+	if balance+creditLimit >= amount {
+		return nil
+	}
+	if accountType == creditCardAccountType {
+		return &ledgerError{Status: http.StatusConflict, Message: fmt.Sprintf("信用卡可用额度不足 (可用: %s, 需要: %s)", balance+creditLimit, amount), Retryable: true}
+	}
+	return &ledgerError{Status: http.StatusConflict, Message: fmt.Sprintf("%s余额不足 (当前: %s, 需要: %s)", label, balance, amount), Retryable: true}
+}
+
+// validateCreditSettings 校验账户的信用卡设置：信用卡必须有正的额度及账单日、还款日，余额不能低于额度的负值；
+// 其他类型的账户不能设置这些字段，余额也不能为负
+func validateCreditSettings(accountType string, balance, creditLimit Money, statementDay, paymentDueDay *int) error {
+	if accountType != creditCardAccountType {
+		if creditLimit != 0 || statementDay != nil || paymentDueDay != nil {
+			return &ledgerError{Status: http.StatusBadRequest, Message: "只有信用卡账户可以设置信用额度、账单日和还款日"}
+		}
+		if balance < 0 {
+			return &ledgerError{Status: http.StatusBadRequest, Message: "账户余额不能为负数"}
+		}
+		return nil
+	}
+	if creditLimit <= 0 {
+		return &ledgerError{Status: http.StatusBadRequest, Message: "信用卡必须设置大于零的信用额度 (credit_limit)"}
+	}
+	if statementDay == nil || paymentDueDay == nil {
+		return &ledgerError{Status: http.StatusBadRequest, Message: "信用卡必须设置账单日 (statement_day) 和还款日 (payment_due_day)"}
+	}
+	if balance < -creditLimit {
+		return &ledgerError{Status: http.StatusConflict, Message: fmt.Sprintf("信用卡欠款 %s 超过信用额度 %s", -balance, creditLimit)}
+	}
+	return nil
+}
+
+// nullableInt 将可空的整数列转换为指针，NULL 为 nil
+func nullableInt(v sql.NullInt64) *int {
+	if !v.Valid {
+		return nil
+	}
+	n := int(v.Int64)
+	return &n
+}
+
+// billingDate 返回某年某月的第 day 日；该月没有这一天时 (如 31 日) 取当月最后一天
+func billingDate(year int, month time.Month, day int) time.Time {
+	lastDay := time.Date(year, month+1, 0, 0, 0, 0, 0, time.Local).Day()
+	return time.Date(year, month, min(day, lastDay), 0, 0, 0, 0, time.Local)
+}
+
+// statementCycle 一个账单周期：(上一账单日, 本账单日]，还款日为本账单日之后的第一个还款日
+type statementCycle struct {
+	start, end, due time.Time
+}
+
+// statementCycles 返回包含 today 的当前周期及之前共 count 个账单周期，按时间从新到旧排列
+func statementCycles(today time.Time, statementDay, paymentDueDay, count int) []statementCycle {
+	end := billingDate(today.Year(), today.Month(), statementDay)
+	if end.Before(today) {
+		end = billingDate(today.Year(), today.Month()+1, statementDay)
+	}
+	cycles := make([]statementCycle, 0, count)
+	for i := 0; i < count; i++ {
+		previousEnd := billingDate(end.Year(), end.Month()-1, statementDay)
+		due := billingDate(end.Year(), end.Month(), paymentDueDay)
+		if !due.After(end) {
+			due = billingDate(end.Year(), end.Month()+1, paymentDueDay)
+		}
+		cycles = append(cycles, statementCycle{start: previousEnd.AddDate(0, 0, 1), end: end, due: due})
+		end = previousEnd
+	}
+	return cycles
+}
+
+// creditCardFlow 一条流水对信用卡账户余额的流入和流出
+type creditCardFlow struct{ inflow, outflow Money }
+
+// creditCardFlows 由记账分录得出 since 之后每条流水对信用卡账户的流入和流出，按流水 ID 索引
+func creditCardFlows(tx *sql.Tx, userID, accountID int64, since string) (map[int64]creditCardFlow, error) {
+	rows, err := tx.Query(`
+        SELECT t.id, `+accountInflowSQL("?")+`, `+accountOutflowSQL("?")+`
+        FROM transactions t
+        WHERE t.user_id = ? AND (t.from_account_id = ? OR t.to_account_id = ?) AND t.deleted_at IS NULL
+          AND date(t.transaction_date) >= ?`,
+		accountID, accountID, userID, accountID, accountID, since,
+	)
+	if err != nil {
+		return nil, &ledgerError{Status: http.StatusInternalServerError, Message: "查询信用卡分录失败", Err: err}
+	}
+	defer rows.Close()
+	flows := make(map[int64]creditCardFlow)
+	for rows.Next() {
+		var id int64
+		var f creditCardFlow
+		if err := rows.Scan(&id, &f.inflow, &f.outflow); err != nil {
+			return nil, &ledgerError{Status: http.StatusInternalServerError, Message: "扫描信用卡分录失败", Err: err}
+		}
+		flows[id] = f
+	}
+	if err := rows.Err(); err != nil {
+		return nil, &ledgerError{Status: http.StatusInternalServerError, Message: "查询信用卡分录失败", Err: err}
+	}
+	return flows, nil
+}
+
+// buildCreditCardStatements 按账单周期汇总信用卡流水。每期的期末欠款即应还金额，
+// 账单日之后到还款日 (含) 之间的还款 (流入) 计为已还金额。
+func buildCreditCardStatements(tx *sql.Tx, userID, accountID int64, today time.Time, count int) ([]CreditCardStatement, error) {
+	var accountType string
+	var balance Money
+	var statementDay, paymentDueDay sql.NullInt64
+	err := tx.QueryRow("SELECT type, balance, statement_day, payment_due_day FROM accounts WHERE id = ? AND user_id = ?", accountID, userID).
+		Scan(&accountType, &balance, &statementDay, &paymentDueDay)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, &ledgerError{Status: http.StatusNotFound, Message: "未找到指定ID的账户"}
+		}
+		return nil, &ledgerError{Status: http.StatusInternalServerError, Message: "查询账户失败", Err: err}
+	}
+	if accountType != creditCardAccountType || !statementDay.Valid || !paymentDueDay.Valid {
+		return nil, &ledgerError{Status: http.StatusBadRequest, Message: "只有信用卡账户才有账单"}
+	}
+
+	cycles := statementCycles(today, int(statementDay.Int64), int(paymentDueDay.Int64), count)
+	earliest := cycles[len(cycles)-1].start.Format(dateLayout)
+
+	rows, err := tx.Query(userCategoriesCTE+" SELECT "+transactionColumns+" FROM transactions t "+transactionJoins+`
+        WHERE t.user_id = ? AND (t.from_account_id = ? OR t.to_account_id = ?) AND t.deleted_at IS NULL
+          AND date(t.transaction_date) >= ?
+        ORDER BY t.transaction_date, t.id`,
+		userID, userID, accountID, accountID, earliest,
+	)
+	if err != nil {
+		return nil, &ledgerError{Status: http.StatusInternalServerError, Message: "查询信用卡流水失败", Err: err}
+	}
+	defer rows.Close()
+	var transactions []Transaction
+	for rows.Next() {
+		t, err := scanTransaction(rows)
+		if err != nil {
+			return nil, &ledgerError{Status: http.StatusInternalServerError, Message: "扫描信用卡流水失败", Err: err}
+		}
+		transactions = append(transactions, t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, &ledgerError{Status: http.StatusInternalServerError, Message: "查询信用卡流水失败", Err: err}
+	}
+	flows, err := creditCardFlows(tx, userID, accountID, earliest)
+	if err != nil {
+		return nil, err
+	}
+
+	// 从当前余额倒推最早一期的期初余额
+	opening := balance
+	for _, t := range transactions {
+		f := flows[t.ID]
+		opening -= f.inflow - f.outflow
+	}
+
+	todayStr := today.Format(dateLayout)
+	statements := make([]CreditCardStatement, len(cycles))
+	for i := len(cycles) - 1; i >= 0; i-- {
+		cycle := cycles[i]
+		start, end, due := cycle.start.Format(dateLayout), cycle.end.Format(dateLayout), cycle.due.Format(dateLayout)
+		s := CreditCardStatement{PeriodStart: start, PeriodEnd: end, DueDate: due, OpeningBalance: opening, Transactions: []Transaction{}}
+		for _, t := range transactions {
+			date := t.TransactionDate[:min(len(t.TransactionDate), len(dateLayout))]
+			f := flows[t.ID]
+			if date >= start && date <= end {
+				s.Charges += f.outflow
+				s.Credits += f.inflow
+				s.Transactions = append(s.Transactions, t)
+			} else if date > end && date <= due {
+				s.PaidAmount += f.inflow
+			}
+		}
+		s.ClosingBalance = opening + s.Credits - s.Charges
+		if s.ClosingBalance < 0 {
+			s.AmountDue = -s.ClosingBalance
+		}
+		switch {
+		case todayStr <= end:
+			s.Status = "open"
+		case s.PaidAmount >= s.AmountDue:
+			s.Status = "paid"
+		case todayStr > due:
+			s.Status = "overdue"
+		default:
+			s.Status = "unpaid"
+		}
+		statements[i] = s
+		opening = s.ClosingBalance
+	}
+	return statements, nil
+}
+
+// GetCreditCardStatements 按账单周期列出信用卡账单 (从当前未出账的周期开始，由新到旧)
+// GET /accounts/:id/statements?limit=6
+func (h *DBHandler) GetCreditCardStatements(c *gin.Context) {
+	userID, _ := c.Get("userID")
+	logger := h.Logger.With(slog.Int64("userID", userID.(int64)), "accountID", c.Param("id"))
+
+	accountID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的账户ID"})
+		return
+	}
+	count := defaultStatementCycles
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxStatementCycles {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("limit 应为 1 到 %d 之间的整数", maxStatementCycles)})
+			return
+		}
+		count = n
+	}
+
+	// 只读事务，保证余额与流水来自同一快照
+	tx, err := h.DB.Begin()
+	if err != nil {
+		logger.Error("开启事务失败", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "开启事务失败"})
+		return
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.Local)
+	statements, err := buildCreditCardStatements(tx, userID.(int64), accountID, today, count)
+	if err != nil {
+		writeLedgerError(c, logger, err)
+		return
+	}
+	c.JSON(http.StatusOK, statements)
+}
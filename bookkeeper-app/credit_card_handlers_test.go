@@ -0,0 +1,149 @@
+// bookkeeper-app/credit_card_handlers_test.go
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// 测试信用卡账户：创建时的校验、在额度内透支、额度不能低于当前欠款
+func TestCreditCardAccount(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	handler := &DBHandler{DB: db, Logger: slog.New(slog.NewJSONHandler(io.Discard, nil))}
+	router := setupRouter(handler)
+
+	userID := createTestUser(t, db, "testuser", "password")
+	token := getTestAuthToken(t, userID, "testuser", false)
+	send := func(method, path string, body interface{}) int {
+		b, _ := json.Marshal(body)
+		return performRequest(router, method, path, bytes.NewBuffer(b), token).Code
+	}
+	day := func(v int) *int { return &v }
+
+	// 普通账户不能设置额度或负余额；信用卡必须设置额度和账单日、还款日，欠款不能超过额度
+	assert.Equal(t, http.StatusBadRequest, send("POST", "/api/v1/accounts", CreateAccountRequest{Name: "储蓄卡", Type: "card", CreditLimit: yuan(100)}))
+	assert.Equal(t, http.StatusBadRequest, send("POST", "/api/v1/accounts", CreateAccountRequest{Name: "储蓄卡", Type: "card", Balance: yuan(-1)}))
+	assert.Equal(t, http.StatusBadRequest, send("POST", "/api/v1/accounts", CreateAccountRequest{Name: "信用卡", Type: "credit_card", CreditLimit: yuan(1000)}))
+	assert.Equal(t, http.StatusConflict, send("POST", "/api/v1/accounts", CreateAccountRequest{Name: "信用卡", Type: "credit_card", Balance: yuan(-1001), CreditLimit: yuan(1000), StatementDay: day(5), PaymentDueDay: day(25)}))
+	assert.Equal(t, http.StatusCreated, send("POST", "/api/v1/accounts", CreateAccountRequest{Name: "信用卡", Type: "credit_card", Balance: yuan(-100), CreditLimit: yuan(1000), StatementDay: day(5), PaymentDueDay: day(25)}))
+	var cardID int64
+	db.QueryRow("SELECT id FROM accounts WHERE name = '信用卡'").Scan(&cardID)
+
+	// 可以透支到额度为止
+	expense := func(amount float64) int {
+		return send("POST", "/api/v1/transactions", CreateTransactionRequest{Type: "expense", Amount: yuan(amount), TransactionDate: "2024-05-10", FromAccountID: &cardID})
+	}
+	assert.Equal(t, http.StatusCreated, expense(800))
+	assert.Equal(t, http.StatusConflict, expense(100.01))
+	assert.Equal(t, http.StatusCreated, expense(100))
+
+	w := performRequest(router, "GET", "/api/v1/accounts", nil, token)
+	var accounts []Account
+	json.Unmarshal(w.Body.Bytes(), &accounts)
+	if assert.Len(t, accounts, 1) {
+		assert.Equal(t, yuan(-1000), accounts[0].Balance)
+		if assert.NotNil(t, accounts[0].AvailableCredit) {
+			assert.Equal(t, Money(0), *accounts[0].AvailableCredit)
+		}
+		assert.Equal(t, day(5), accounts[0].StatementDay)
+	}
+
+	// 额度不能调到当前欠款以下；其他字段不填时保持不变
+	path := fmt.Sprintf("/api/v1/accounts/%d", cardID)
+	lower, higher := yuan(500), yuan(2000)
+	assert.Equal(t, http.StatusConflict, send("PUT", path, UpdateAccountRequest{Name: "信用卡", CreditLimit: &lower}))
+	assert.Equal(t, http.StatusOK, send("PUT", path, UpdateAccountRequest{Name: "信用卡", CreditLimit: &higher, PaymentDueDay: day(20)}))
+	var creditLimit Money
+	var statementDay, paymentDueDay int
+	db.QueryRow("SELECT credit_limit, statement_day, payment_due_day FROM accounts WHERE id = ?", cardID).Scan(&creditLimit, &statementDay, &paymentDueDay)
+	assert.Equal(t, yuan(2000), creditLimit)
+	assert.Equal(t, 5, statementDay)
+	assert.Equal(t, 20, paymentDueDay)
+}
+
+// 测试账单周期的划分：账单日超出当月天数时取月末，还款日不晚于账单日时落在下个月
+func TestStatementCycles(t *testing.T) {
+	date := func(s string) time.Time {
+		d, _ := time.ParseInLocation(dateLayout, s, time.Local)
+		return d
+	}
+	cycles := statementCycles(date("2024-02-10"), 31, 10, 3)
+	want := [][3]string{
+		{"2024-02-01", "2024-02-29", "2024-03-10"},
+		{"2024-01-01", "2024-01-31", "2024-02-10"},
+		{"2023-12-01", "2023-12-31", "2024-01-10"},
+	}
+	if assert.Len(t, cycles, 3) {
+		for i, c := range cycles {
+			assert.Equal(t, want[i], [3]string{c.start.Format(dateLayout), c.end.Format(dateLayout), c.due.Format(dateLayout)})
+		}
+	}
+}
+
+// 测试信用卡账单：按周期汇总消费和还款，账单日后到还款日之间的还款计入已还金额
+func TestCreditCardStatements(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	handler := &DBHandler{DB: db, Logger: slog.New(slog.NewJSONHandler(io.Discard, nil))}
+	router := setupRouter(handler)
+
+	userID := createTestUser(t, db, "testuser", "password")
+	token := getTestAuthToken(t, userID, "testuser", false)
+	savingsID := createTestAccount(t, db, userID, "Savings", 1000.0)
+	res, err := db.Exec("INSERT INTO accounts (user_id, name, type, balance, icon, created_at, credit_limit, statement_day, payment_due_day) VALUES (?, '信用卡', 'credit_card', 0, '', ?, ?, 5, 25)",
+		userID, time.Now().Format(time.RFC3339), yuan(1000))
+	assert.NoError(t, err)
+	cardID, _ := res.LastInsertId()
+
+	for _, req := range []CreateTransactionRequest{
+		{Type: "expense", Amount: yuan(200), TransactionDate: "2024-05-10", FromAccountID: &cardID},
+		{Type: "expense", Amount: yuan(100), TransactionDate: "2024-06-01", FromAccountID: &cardID},
+		{Type: "transfer", Amount: yuan(300), TransactionDate: "2024-06-15", FromAccountID: &savingsID, ToAccountID: &cardID},
+		{Type: "expense", Amount: yuan(50), TransactionDate: "2024-06-18", FromAccountID: &cardID},
+	} {
+		body, _ := json.Marshal(req)
+		w := performRequest(router, "POST", "/api/v1/transactions", bytes.NewBuffer(body), token)
+		assert.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	}
+
+	tx, err := db.Begin()
+	assert.NoError(t, err)
+	defer tx.Rollback()
+	statements, err := buildCreditCardStatements(tx, userID, cardID, time.Date(2024, 6, 20, 0, 0, 0, 0, time.Local), 3)
+	if assert.NoError(t, err) && assert.Len(t, statements, 3) {
+		current, previous, oldest := statements[0], statements[1], statements[2]
+		assert.Equal(t, "2024-06-06", current.PeriodStart)
+		assert.Equal(t, "2024-07-05", current.PeriodEnd)
+		assert.Equal(t, "open", current.Status)
+		assert.Equal(t, yuan(-300), current.OpeningBalance)
+		assert.Equal(t, yuan(-50), current.ClosingBalance)
+		assert.Len(t, current.Transactions, 2)
+
+		assert.Equal(t, "2024-06-25", previous.DueDate)
+		assert.Equal(t, yuan(300), previous.Charges)
+		assert.Equal(t, yuan(300), previous.AmountDue)
+		assert.Equal(t, yuan(300), previous.PaidAmount)
+		assert.Equal(t, "paid", previous.Status)
+
+		assert.Equal(t, "2024-04-06", oldest.PeriodStart)
+		assert.Equal(t, Money(0), oldest.ClosingBalance)
+	}
+
+	// 接口：非信用卡账户返回 400，limit 控制返回的期数
+	w := performRequest(router, "GET", fmt.Sprintf("/api/v1/accounts/%d/statements", savingsID), nil, token)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = performRequest(router, "GET", fmt.Sprintf("/api/v1/accounts/%d/statements?limit=2", cardID), nil, token)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var resp []CreditCardStatement
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	assert.Len(t, resp, 2)
+}
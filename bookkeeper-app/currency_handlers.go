@@ -0,0 +1,382 @@
+// bookkeeper-app/currency_handlers.go
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+)
+
+// maxRateFileSize 汇率 CSV 文件的大小上限
+const maxRateFileSize = 2 << 20
+
+// setupCurrencies 为用户、账户和流水增加币种字段，并创建按日期生效的汇率表。
+// 旧数据统一视为人民币；必须在 setupPostings 之前执行，因为分录补写和 transaction_lines 视图依赖这些列。
+func setupCurrencies(tx *sql.Tx) error {
+	columns := []struct{ table, column, definition string }{
+		{"users", "base_currency", `"base_currency" TEXT NOT NULL DEFAULT 'CNY'`},
+		{"accounts", "currency", `"currency" TEXT NOT NULL DEFAULT 'CNY'`},
+		{"transactions", "currency", `"currency" TEXT NOT NULL DEFAULT 'CNY'`},
+		// 跨币种转账时转入账户实际入账的金额 (以转入账户币种计)，其它流水为 NULL
+		{"transactions", "to_amount", `"to_amount" INTEGER`},
+	}
+	for _, col := range columns {
+		if err := addColumnIfMissing(tx, col.table, col.column, col.definition); err != nil {
+			return err
+		}
+	}
+
+	if _, err := tx.Exec(`
+    CREATE TABLE IF NOT EXISTS exchange_rates (
+        "id" INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+        "user_id" INTEGER NOT NULL,
+        "from_currency" TEXT NOT NULL,
+        "to_currency" TEXT NOT NULL,
+        "rate" REAL NOT NULL,
+        "rate_date" TEXT NOT NULL,
+        "created_at" TEXT NOT NULL,
+        FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE,
+        UNIQUE(user_id, from_currency, to_currency, rate_date)
+    );`); err != nil {
+		return fmt.Errorf("创建 exchange_rates 表失败: %w", err)
+	}
+	return nil
+}
+
+// exchangeRateSQL 生成一个 SQL 标量表达式：在 dateExpr 当天有效的 from→to 汇率。
+// 依次尝试：当天或之前最近的正向汇率、反向汇率的倒数、之后最早的正向汇率、之后最早的反向汇率的倒数；
+// 完全没有汇率时按 1 换算，避免统计结果整体变为 NULL。参数均为 SQL 表达式 (列名或子查询)，不是用户输入。
+func exchangeRateSQL(fromExpr, toExpr, dateExpr, userExpr string) string {
+	lookup := func(from, to, op, order string) string {
+		return fmt.Sprintf(`(SELECT r.rate FROM exchange_rates r WHERE r.user_id = %s AND r.from_currency = %s AND r.to_currency = %s AND r.rate_date %s date(%s) ORDER BY r.rate_date %s LIMIT 1)`,
+			userExpr, from, to, op, dateExpr, order)
+	}
+	return fmt.Sprintf(`(CASE WHEN %s = %s THEN 1.0 ELSE COALESCE(%s, 1.0 / %s, %s, 1.0 / %s, 1.0) END)`,
+		fromExpr, toExpr,
+		lookup(fromExpr, toExpr, "<=", "DESC"),
+		lookup(toExpr, fromExpr, "<=", "DESC"),
+		lookup(fromExpr, toExpr, ">", "ASC"),
+		lookup(toExpr, fromExpr, ">", "ASC"),
+	)
+}
+
+// baseCurrencySQL 用户本位币的 SQL 子查询
+func baseCurrencySQL(userExpr string) string {
+	return fmt.Sprintf("(SELECT bu.base_currency FROM users bu WHERE bu.id = %s)", userExpr)
+}
+
+// baseAmountSQL 将某币种的金额 (分) 按指定日期的汇率换算为用户本位币的 SQL 表达式，结果四舍五入到分
+func baseAmountSQL(amountExpr, currencyExpr, dateExpr, userExpr string) string {
+	return fmt.Sprintf("CAST(ROUND(%s * %s) AS INTEGER)", amountExpr, exchangeRateSQL(currencyExpr, baseCurrencySQL(userExpr), dateExpr, userExpr))
+}
+
+// transactionBaseAmount 针对 transactions 表 (别名 t) 的本位币金额表达式
+var transactionBaseAmount = baseAmountSQL("t.amount", "t.currency", "t.transaction_date", "t.user_id")
+
+// userBaseCurrency 查询用户的本位币
+func userBaseCurrency(q interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}, userID int64) (string, error) {
+	var currency string
+	err := q.QueryRow("SELECT base_currency FROM users WHERE id = ?", userID).Scan(&currency)
+	return currency, err
+}
+
+// accountCurrency 查询账户币种
+func accountCurrency(tx *sql.Tx, accountID int64) (string, error) {
+	var currency string
+	err := tx.QueryRow("SELECT currency FROM accounts WHERE id = ?", accountID).Scan(&currency)
+	return currency, err
+}
+
+// transactionCurrency 确定流水金额所用的币种：支出、还款和转账以转出账户为准，收入以收款账户为准，其余为本位币。
+// 调用前账户归属权必须已经校验过。
+func transactionCurrency(tx *sql.Tx, userID int64, req *CreateTransactionRequest) (string, error) {
+	var currency string
+	var err error
+	switch {
+	case (req.Type == "income" || req.Type == "refund" || req.Type == "adjustment") && req.ToAccountID != nil:
+		currency, err = accountCurrency(tx, *req.ToAccountID)
+	case req.Type != "settlement" && req.FromAccountID != nil:
+		currency, err = accountCurrency(tx, *req.FromAccountID)
+	default:
+		currency, err = userBaseCurrency(tx, userID)
+	}
+	if err != nil {
+		return "", &ledgerError{Status: http.StatusInternalServerError, Message: "查询流水币种失败", Err: err}
+	}
+	return currency, nil
+}
+
+// requireBaseCurrencyAccount 校验账户币种与用户本位币一致 (贷款以本位币记账，还款只能使用本位币账户)
+func requireBaseCurrencyAccount(tx *sql.Tx, userID, accountID int64) error {
+	var currency, base string
+	err := tx.QueryRow("SELECT a.currency, u.base_currency FROM accounts a JOIN users u ON u.id = a.user_id WHERE a.id = ? AND a.user_id = ?", accountID, userID).Scan(&currency, &base)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return &ledgerError{Status: http.StatusNotFound, Message: "找不到指定的账户或无权操作"}
+		}
+		return &ledgerError{Status: http.StatusInternalServerError, Message: "查询账户币种失败", Err: err}
+	}
+	if currency != base {
+		return &ledgerError{Status: http.StatusBadRequest, Message: fmt.Sprintf("贷款以本位币 (%s) 记账，不能使用 %s 账户还款", base, currency)}
+	}
+	return nil
+}
+
+// normalizeCurrency 统一币种代码为大写
+func normalizeCurrency(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// upsertExchangeRate 写入一条汇率，同一天同一币种对的汇率以最后一次为准
+func upsertExchangeRate(tx *sql.Tx, userID int64, req ExchangeRateRequest) error {
+	_, err := tx.Exec(`
+        INSERT INTO exchange_rates (user_id, from_currency, to_currency, rate, rate_date, created_at)
+        VALUES (?, ?, ?, ?, ?, ?)
+        ON CONFLICT(user_id, from_currency, to_currency, rate_date) DO UPDATE SET rate = excluded.rate`,
+		userID, normalizeCurrency(req.FromCurrency), normalizeCurrency(req.ToCurrency), req.Rate, req.RateDate, time.Now().Format(time.RFC3339))
+	return err
+}
+
+// validateExchangeRate 校验币种代码 (ISO 4217)、汇率和日期，CSV 导入的行也走这一校验
+func validateExchangeRate(req *ExchangeRateRequest) error {
+	req.FromCurrency = normalizeCurrency(req.FromCurrency)
+	req.ToCurrency = normalizeCurrency(req.ToCurrency)
+	if err := binding.Validator.ValidateStruct(req); err != nil {
+		return fmt.Errorf("无效的汇率数据: %w", err)
+	}
+	if req.FromCurrency == req.ToCurrency {
+		return errors.New("源币种与目标币种不能相同")
+	}
+	if _, err := time.Parse(dateLayout, req.RateDate); err != nil {
+		return fmt.Errorf("日期格式应为 YYYY-MM-DD: %s", req.RateDate)
+	}
+	return nil
+}
+
+// GetExchangeRates 获取汇率列表，可用 currency 参数筛选涉及某币种的汇率
+func (h *DBHandler) GetExchangeRates(c *gin.Context) {
+	userID, _ := c.Get("userID")
+	logger := h.Logger.With(slog.Int64("userID", userID.(int64)))
+
+	query := "SELECT id, from_currency, to_currency, rate, rate_date, created_at FROM exchange_rates WHERE user_id = ?"
+	args := []interface{}{userID}
+	if currency := normalizeCurrency(c.Query("currency")); currency != "" {
+		query += " AND (from_currency = ? OR to_currency = ?)"
+		args = append(args, currency, currency)
+	}
+	query += " ORDER BY rate_date DESC, from_currency, to_currency"
+
+	rows, err := h.DB.Query(query, args...)
+	if err != nil {
+		logger.Error("查询汇率失败", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "获取汇率失败"})
+		return
+	}
+	defer rows.Close()
+
+	rates := []ExchangeRate{}
+	for rows.Next() {
+		var r ExchangeRate
+		if err := rows.Scan(&r.ID, &r.FromCurrency, &r.ToCurrency, &r.Rate, &r.RateDate, &r.CreatedAt); err != nil {
+			logger.Error("扫描汇率数据失败", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "获取汇率失败"})
+			return
+		}
+		rates = append(rates, r)
+	}
+	c.JSON(http.StatusOK, rates)
+}
+
+// CreateExchangeRate 录入一条汇率 (同一天同一币种对已有汇率时覆盖)
+func (h *DBHandler) CreateExchangeRate(c *gin.Context) {
+	userID, _ := c.Get("userID")
+	logger := h.Logger.With(slog.Int64("userID", userID.(int64)))
+
+	var req ExchangeRateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的请求数据: " + err.Error()})
+		return
+	}
+	if err := validateExchangeRate(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		logger.Error("开启事务失败", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "开启事务失败"})
+		return
+	}
+	defer tx.Rollback()
+
+	if err := upsertExchangeRate(tx, userID.(int64), req); err != nil {
+		logger.Error("保存汇率失败", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "保存汇率失败"})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		logger.Error("提交事务失败", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "提交事务失败"})
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"message": "汇率保存成功"})
+}
+
+// UploadExchangeRates 通过 CSV 批量导入汇率，每行为 日期,源币种,目标币种,汇率 (可带表头)。
+// 任何一行有误则整个文件不导入，并按行号报告错误。
+func (h *DBHandler) UploadExchangeRates(c *gin.Context) {
+	userID, _ := c.Get("userID")
+	logger := h.Logger.With(slog.Int64("userID", userID.(int64)))
+
+	file, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "文件上传失败: " + err.Error()})
+		return
+	}
+	if file.Size > maxRateFileSize {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("汇率文件不能超过 %d MB", maxRateFileSize>>20)})
+		return
+	}
+	src, err := file.Open()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "读取上传文件失败"})
+		return
+	}
+	defer src.Close()
+	data, err := io.ReadAll(src)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "读取上传文件失败"})
+		return
+	}
+
+	reader := csv.NewReader(bytes.NewReader(bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))))
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	records, err := reader.ReadAll()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "解析 CSV 失败: " + err.Error()})
+		return
+	}
+
+	var rates []ExchangeRateRequest
+	var lineErrors []string
+	for i, record := range records {
+		if len(record) == 1 && strings.TrimSpace(record[0]) == "" {
+			continue
+		}
+		if len(record) < 4 {
+			lineErrors = append(lineErrors, fmt.Sprintf("第 %d 行: 应包含 日期,源币种,目标币种,汇率 四列", i+1))
+			continue
+		}
+		rate, parseErr := strconv.ParseFloat(strings.TrimSpace(record[3]), 64)
+		if parseErr != nil && i == 0 {
+			continue // 表头
+		}
+		req := ExchangeRateRequest{
+			RateDate:     strings.TrimSpace(record[0]),
+			FromCurrency: record[1],
+			ToCurrency:   record[2],
+			Rate:         rate,
+		}
+		if parseErr != nil {
+			lineErrors = append(lineErrors, fmt.Sprintf("第 %d 行: 无效的汇率 %q", i+1, record[3]))
+			continue
+		}
+		if err := validateExchangeRate(&req); err != nil {
+			lineErrors = append(lineErrors, fmt.Sprintf("第 %d 行: %s", i+1, err.Error()))
+			continue
+		}
+		rates = append(rates, req)
+	}
+	if len(lineErrors) > 0 {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": fmt.Sprintf("%d 行汇率有误，未做任何导入", len(lineErrors)), "errors": lineErrors})
+		return
+	}
+	if len(rates) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "文件中没有汇率数据"})
+		return
+	}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		logger.Error("开启事务失败", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "开启事务失败"})
+		return
+	}
+	defer tx.Rollback()
+
+	for _, r := range rates {
+		if err := upsertExchangeRate(tx, userID.(int64), r); err != nil {
+			logger.Error("导入汇率失败", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "导入汇率失败"})
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		logger.Error("提交事务失败", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "提交事务失败"})
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"message": fmt.Sprintf("成功导入 %d 条汇率", len(rates)), "count": len(rates)})
+}
+
+// DeleteExchangeRate 删除一条汇率
+func (h *DBHandler) DeleteExchangeRate(c *gin.Context) {
+	userID, _ := c.Get("userID")
+	id := c.Param("id")
+	res, err := h.DB.Exec("DELETE FROM exchange_rates WHERE id = ? AND user_id = ?", id, userID)
+	if err != nil {
+		h.Logger.Error("删除汇率失败", "error", err, "rateID", id, slog.Int64("userID", userID.(int64)))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "删除汇率失败"})
+		return
+	}
+	rowsAffected, _ := res.RowsAffected()
+	if rowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "未找到指定ID的汇率"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "汇率删除成功"})
+}
+
+// GetBaseCurrency 获取用户的本位币
+func (h *DBHandler) GetBaseCurrency(c *gin.Context) {
+	userID, _ := c.Get("userID")
+	currency, err := userBaseCurrency(h.DB, userID.(int64))
+	if err != nil {
+		h.Logger.Error("查询本位币失败", "error", err, slog.Int64("userID", userID.(int64)))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "获取本位币失败"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"base_currency": currency})
+}
+
+// UpdateBaseCurrency 修改用户的本位币，之后所有统计按新的本位币换算
+func (h *DBHandler) UpdateBaseCurrency(c *gin.Context) {
+	userID, _ := c.Get("userID")
+	var req BaseCurrencyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的请求数据: " + err.Error()})
+		return
+	}
+	currency := normalizeCurrency(req.BaseCurrency)
+	if _, err := h.DB.Exec("UPDATE users SET base_currency = ? WHERE id = ?", currency, userID); err != nil {
+		h.Logger.Error("更新本位币失败", "error", err, slog.Int64("userID", userID.(int64)))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "更新本位币失败"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "本位币已更新", "base_currency": currency})
+}
@@ -0,0 +1,122 @@
+// bookkeeper-app/currency_handlers_test.go
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// uploadTestRates 以 multipart 表单上传汇率 CSV
+func uploadTestRates(r http.Handler, content string, token string) *httptest.ResponseRecorder {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	part, _ := mw.CreateFormFile("file", "rates.csv")
+	part.Write([]byte(content))
+	mw.Close()
+
+	req, _ := http.NewRequest("POST", "/api/v1/exchange_rates/upload", &body)
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+// 测试外币账户、汇率导入、跨币种转账以及按流水日期汇率换算的统计
+func TestMultiCurrency(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	handler := &DBHandler{DB: db, Logger: slog.New(slog.NewJSONHandler(io.Discard, nil))}
+	router := setupRouter(handler)
+
+	userID := createTestUser(t, db, "testuser", "password")
+	token := getTestAuthToken(t, userID, "testuser", false)
+	cnyID := createTestAccount(t, db, userID, "人民币卡", 10000.0)
+
+	w := performRequest(router, "POST", "/api/v1/accounts", bytes.NewBufferString(`{"name":"美元卡","type":"card","currency":"USD"}`), token)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	var usdID int64
+	db.QueryRow("SELECT id FROM accounts WHERE user_id = ? AND currency = 'USD'", userID).Scan(&usdID)
+	assert.NotZero(t, usdID)
+
+	// 汇率 CSV：有错误的行导致整个文件不导入
+	w = uploadTestRates(router, "date,from,to,rate\n2024-01-01,USD,CNY,7.0\n2024-02-30,USD,CNY,abc\n", token)
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	w = uploadTestRates(router, "date,from,to,rate\n2024-01-01,usd,cny,7.0\n2024-06-01,USD,CNY,7.2\n", token)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	w = performRequest(router, "GET", "/api/v1/exchange_rates?currency=USD", nil, token)
+	var rates []ExchangeRate
+	json.Unmarshal(w.Body.Bytes(), &rates)
+	assert.Len(t, rates, 2)
+
+	// 跨币种转账必须提供转入金额
+	transfer := CreateTransactionRequest{Type: "transfer", Amount: yuan(710), TransactionDate: "2024-03-01", FromAccountID: &cnyID, ToAccountID: &usdID}
+	body, _ := json.Marshal(transfer)
+	w = performRequest(router, "POST", "/api/v1/transactions", bytes.NewBuffer(body), token)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	toAmount := yuan(100)
+	transfer.ToAmount = &toAmount
+	body, _ = json.Marshal(transfer)
+	w = performRequest(router, "POST", "/api/v1/transactions", bytes.NewBuffer(body), token)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	var created struct {
+		ID int64 `json:"id"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &created)
+
+	// 美元支出分别按 5 月 (7.0) 和 7 月 (7.2) 的汇率换算
+	for _, date := range []string{"2024-05-01", "2024-07-01"} {
+		req := CreateTransactionRequest{Type: "expense", Amount: yuan(10), TransactionDate: date, FromAccountID: &usdID}
+		body, _ := json.Marshal(req)
+		w = performRequest(router, "POST", "/api/v1/transactions", bytes.NewBuffer(body), token)
+		assert.Equal(t, http.StatusCreated, w.Code)
+	}
+
+	var balance Money
+	db.QueryRow("SELECT balance FROM accounts WHERE id = ?", usdID).Scan(&balance)
+	assert.Equal(t, yuan(80), balance)
+
+	w = performRequest(router, "GET", "/api/v1/transactions?year=2024&type=expense", nil, token)
+	var list GetTransactionsResponse
+	json.Unmarshal(w.Body.Bytes(), &list)
+	assert.Equal(t, "CNY", list.Summary.Currency)
+	assert.Equal(t, yuan(142), list.Summary.TotalExpense)
+	if assert.Len(t, list.Transactions, 2) {
+		assert.Equal(t, "USD", list.Transactions[0].Currency)
+	}
+
+	// 总存款：9290 CNY + 80 USD × 7.2
+	w = performRequest(router, "GET", "/api/v1/dashboard/cards", nil, token)
+	var cards []DashboardCard
+	json.Unmarshal(w.Body.Bytes(), &cards)
+	if assert.Len(t, cards, 4) {
+		assert.Equal(t, yuan(9290+80*7.2), cards[3].Value)
+	}
+
+	// 删除转账时两边分别按各自金额恢复
+	w = performRequest(router, "DELETE", fmt.Sprintf("/api/v1/transactions/%d", created.ID), nil, token)
+	assert.Equal(t, http.StatusOK, w.Code)
+	db.QueryRow("SELECT balance FROM accounts WHERE id = ?", cnyID).Scan(&balance)
+	assert.Equal(t, yuan(10000), balance)
+	db.QueryRow("SELECT balance FROM accounts WHERE id = ?", usdID).Scan(&balance)
+	assert.Equal(t, yuan(-20), balance)
+
+	// 修改本位币后统计按新本位币换算 (CNY→USD 使用反向汇率的倒数)
+	w = performRequest(router, "PUT", "/api/v1/settings/base_currency", bytes.NewBufferString(`{"base_currency":"USD"}`), token)
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = performRequest(router, "GET", "/api/v1/transactions?year=2024&type=expense", nil, token)
+	list = GetTransactionsResponse{}
+	json.Unmarshal(w.Body.Bytes(), &list)
+	assert.Equal(t, "USD", list.Summary.Currency)
+	assert.Equal(t, yuan(20), list.Summary.TotalExpense)
+}
@@ -14,41 +14,39 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
-// getTotalsForPeriod (无修改)
-func getTotalsForPeriod(db *sql.DB, userID int64, year, month string) (float64, float64, error) {
-	var income, expense sql.NullFloat64
+// getTotalsForPeriod 统计期间内的收入和支出，金额按流水日期的汇率换算为本位币。
+// 收支均按 transaction_lines (收支分类的记账分录) 统计，退款冲减原支出所在期间的支出。
+func getTotalsForPeriod(db interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}, userID int64, year, month string) (Money, Money, error) {
+	var income, expense Money
 	var conditions []string
 	var args []interface{}
 
-	conditions = append(conditions, "user_id = ?")
+	conditions = append(conditions, "t.user_id = ?")
 	args = append(args, userID)
-	conditions = append(conditions, "type IN ('income', 'expense', 'repayment')")
 
 	if year != "" {
-		conditions = append(conditions, "strftime('%Y', transaction_date) = ?")
+		conditions = append(conditions, "strftime('%Y', t.transaction_date) = ?")
 		args = append(args, year)
 	}
 	if month != "" {
 		monthFormatted := fmt.Sprintf("%02s", month)
-		conditions = append(conditions, "strftime('%m', transaction_date) = ?")
+		conditions = append(conditions, "strftime('%m', t.transaction_date) = ?")
 		args = append(args, monthFormatted)
 	}
 
+	where := strings.Join(conditions, " AND ")
 	query := `
-        SELECT 
-            COALESCE(SUM(CASE WHEN type = 'income' THEN amount ELSE 0 END), 0),
-            COALESCE(SUM(CASE WHEN type IN ('expense', 'repayment') THEN amount ELSE 0 END), 0)
-        FROM transactions
+        SELECT
+            (SELECT COALESCE(SUM(t.base_amount), 0) FROM transaction_lines t WHERE t.type = 'income' AND ` + where + `),
+            (SELECT COALESCE(SUM(t.base_amount), 0) FROM transaction_lines t WHERE t.type IN ('expense', 'repayment') AND ` + where + `)
     `
-	if len(conditions) > 0 {
-		query += " WHERE " + strings.Join(conditions, " AND ")
-	}
-
-	err := db.QueryRow(query, args...).Scan(&income, &expense)
+	err := db.QueryRow(query, append(args, args...)...).Scan(&income, &expense)
 	if err != nil {
 		return 0, 0, err
 	}
-	return income.Float64, expense.Float64, nil
+	return income, expense, nil
 }
 
 // GetDashboardCards (无修改)
@@ -79,7 +77,7 @@ func (h *DBHandler) GetDashboardCards(c *gin.Context) {
 			}
 		}
 	}
-	var prevIncome, prevExpense float64
+	var prevIncome, prevExpense Money
 	if prevYear != "" || prevMonth != "" {
 		prevIncome, prevExpense, err = getTotalsForPeriod(h.DB, userID.(int64), prevYear, prevMonth)
 		if err != nil {
@@ -87,16 +85,18 @@ func (h *DBHandler) GetDashboardCards(c *gin.Context) {
 		}
 	}
 
-	var totalDeposits float64
+	// 总存款：各账户余额按今日汇率换算为本位币后求和
+	var totalDeposits Money
 	var accountCount int
-	err = h.DB.QueryRow("SELECT COALESCE(SUM(balance), 0), COUNT(id) FROM accounts WHERE user_id = ?", userID).Scan(&totalDeposits, &accountCount)
+	depositQuery := "SELECT COALESCE(SUM(" + baseAmountSQL("a.balance", "a.currency", "'now'", "a.user_id") + "), 0), COUNT(a.id) FROM accounts a WHERE a.user_id = ?"
+	err = h.DB.QueryRow(depositQuery, userID).Scan(&totalDeposits, &accountCount)
 	if err != nil {
 		logger.Error("获取总存款数据失败", "error", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "获取总存款数据失败"})
 		return
 	}
 
-	var totalLoan float64
+	var totalLoan Money
 	h.DB.QueryRow("SELECT COALESCE(SUM(principal), 0) FROM loans WHERE user_id = ? AND status = 'active'", userID).Scan(&totalLoan)
 
 	cards := []DashboardCard{
@@ -108,7 +108,7 @@ func (h *DBHandler) GetDashboardCards(c *gin.Context) {
 	c.JSON(http.StatusOK, cards)
 }
 
-// GetAnalyticsCharts (无修改)
+// GetAnalyticsCharts 返回支出趋势、分类支出和标签支出
 func (h *DBHandler) GetAnalyticsCharts(c *gin.Context) {
 	userID, _ := c.Get("userID")
 	logger := h.Logger.With(slog.Int64("userID", userID.(int64)))
@@ -119,27 +119,30 @@ func (h *DBHandler) GetAnalyticsCharts(c *gin.Context) {
 	var response AnalyticsChartsResponse
 	response.ExpenseTrend = []ChartDataPoint{}
 	response.CategoryExpense = []ChartDataPoint{}
+	response.TagExpense = []ChartDataPoint{}
 
+	// 所有金额均按流水日期的汇率换算为本位币；支出均按 transaction_lines 统计，退款冲减原支出的期间、分类和标签
 	var trendQuery strings.Builder
 	var trendArgs []interface{}
 	trendQuery.WriteString("SELECT ")
 	if year != "" && month == "" {
-		trendQuery.WriteString("strftime('%Y-%m', transaction_date) as period, COALESCE(SUM(amount), 0)")
+		trendQuery.WriteString("strftime('%Y-%m', t.transaction_date) as period")
 	} else if year != "" && month != "" {
-		trendQuery.WriteString("strftime('%d', transaction_date) as period, COALESCE(SUM(amount), 0)")
+		trendQuery.WriteString("strftime('%d', t.transaction_date) as period")
 	} else {
-		trendQuery.WriteString("strftime('%Y', transaction_date) as period, COALESCE(SUM(amount), 0)")
+		trendQuery.WriteString("strftime('%Y', t.transaction_date) as period")
 	}
-	trendQuery.WriteString(" FROM transactions WHERE user_id = ? AND type IN ('expense', 'repayment')")
+	trendQuery.WriteString(", COALESCE(SUM(t.base_amount), 0)")
+	trendQuery.WriteString(" FROM transaction_lines t WHERE t.user_id = ? AND t.type IN ('expense', 'repayment')")
 	trendArgs = append(trendArgs, userID)
 
 	if year != "" {
-		trendQuery.WriteString(" AND strftime('%Y', transaction_date) = ?")
+		trendQuery.WriteString(" AND strftime('%Y', t.transaction_date) = ?")
 		trendArgs = append(trendArgs, year)
 	}
 	if month != "" {
 		monthFormatted := fmt.Sprintf("%02s", month)
-		trendQuery.WriteString(" AND strftime('%m', transaction_date) = ?")
+		trendQuery.WriteString(" AND strftime('%m', t.transaction_date) = ?")
 		trendArgs = append(trendArgs, monthFormatted)
 	}
 	trendQuery.WriteString(" GROUP BY period ORDER BY period ASC")
@@ -169,8 +172,8 @@ func (h *DBHandler) GetAnalyticsCharts(c *gin.Context) {
             UNION ALL
             SELECT id, name FROM categories WHERE user_id = ?
         )
-        SELECT COALESCE(uc.name, '未分类') as category_name, COALESCE(SUM(t.amount), 0)
-        FROM transactions t
+        SELECT COALESCE(uc.name, '未分类') as category_name, COALESCE(SUM(t.base_amount), 0)
+        FROM transaction_lines t
         LEFT JOIN UserCategories uc ON t.category_id = uc.id
         WHERE t.user_id = ? AND t.type IN ('expense', 'repayment')
     `)
@@ -186,7 +189,7 @@ func (h *DBHandler) GetAnalyticsCharts(c *gin.Context) {
 		catQueryBuilder.WriteString(" AND strftime('%m', t.transaction_date) = ?")
 		catArgs = append(catArgs, monthFormatted)
 	}
-	catQueryBuilder.WriteString(" GROUP BY category_name HAVING SUM(t.amount) > 0 ORDER BY SUM(t.amount) DESC")
+	catQueryBuilder.WriteString(" GROUP BY category_name HAVING SUM(t.base_amount) > 0 ORDER BY SUM(t.base_amount) DESC")
 
 	catRows, err := h.DB.Query(catQueryBuilder.String(), catArgs...)
 	if err != nil {
@@ -204,6 +207,44 @@ func (h *DBHandler) GetAnalyticsCharts(c *gin.Context) {
 		}
 		response.CategoryExpense = append(response.CategoryExpense, point)
 	}
+	catRows.Close()
+
+	// 按标签统计支出：标签挂在流水上，因此按流水金额计 (带多个标签的流水会计入每个标签)，退款计入原支出的标签
+	var tagQueryBuilder strings.Builder
+	tagQueryBuilder.WriteString(`
+        SELECT g.name, COALESCE(SUM(t.base_amount), 0) AS total
+        FROM transaction_tags tt
+        JOIN tags g ON g.id = tt.tag_id
+        JOIN transaction_lines t ON t.origin_id = tt.transaction_id
+        WHERE t.user_id = ? AND t.type IN ('expense', 'repayment')
+    `)
+	tagArgs := []interface{}{userID}
+	if year != "" {
+		tagQueryBuilder.WriteString(" AND strftime('%Y', t.transaction_date) = ?")
+		tagArgs = append(tagArgs, year)
+	}
+	if month != "" {
+		tagQueryBuilder.WriteString(" AND strftime('%m', t.transaction_date) = ?")
+		tagArgs = append(tagArgs, fmt.Sprintf("%02s", month))
+	}
+	tagQueryBuilder.WriteString(" GROUP BY g.id HAVING total > 0 ORDER BY total DESC")
+
+	tagRows, err := h.DB.Query(tagQueryBuilder.String(), tagArgs...)
+	if err != nil {
+		logger.Error("查询标签支出失败", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "获取标签支出数据失败"})
+		return
+	}
+	defer tagRows.Close()
+
+	for tagRows.Next() {
+		var point ChartDataPoint
+		if err := tagRows.Scan(&point.Name, &point.Value); err != nil {
+			logger.Warn("扫描标签支出数据失败", "error", err)
+			continue
+		}
+		response.TagExpense = append(response.TagExpense, point)
+	}
 
 	c.JSON(http.StatusOK, response)
 }
@@ -214,7 +255,7 @@ func (h *DBHandler) GetSystemStats(c *gin.Context) {
 	var dbSize int64
 
 	h.DB.QueryRow("SELECT COUNT(*) FROM users").Scan(&userCount)
-	h.DB.QueryRow("SELECT COUNT(*) FROM transactions").Scan(&transactionCount)
+	h.DB.QueryRow("SELECT COUNT(*) FROM transactions WHERE deleted_at IS NULL").Scan(&transactionCount)
 	h.DB.QueryRow("SELECT COUNT(*) FROM accounts").Scan(&accountCount)
 
 	dbPath := getDBPath()
@@ -276,9 +317,9 @@ func (h *DBHandler) GetDashboardWidgets(c *gin.Context) {
 		}
 
 		// 2. 计算总支出
-		var spent float64
+		var spent Money
 		var spentQueryBuilder strings.Builder
-		spentQueryBuilder.WriteString("SELECT COALESCE(SUM(amount), 0) FROM transactions WHERE user_id = ? AND type IN ('expense', 'repayment')")
+		spentQueryBuilder.WriteString("SELECT COALESCE(SUM(base_amount), 0) FROM transaction_lines WHERE user_id = ? AND type IN ('expense', 'repayment')")
 		spentArgs := []interface{}{userID}
 
 		if period == "monthly" {
@@ -293,7 +334,7 @@ func (h *DBHandler) GetDashboardWidgets(c *gin.Context) {
 		summary.Spent = spent
 
 		if summary.Amount > 0 {
-			summary.Progress = spent / summary.Amount
+			summary.Progress = spent.Float64() / summary.Amount.Float64()
 		}
 		response.Budgets = append(response.Budgets, summary)
 	}
@@ -310,11 +351,11 @@ func (h *DBHandler) GetDashboardWidgets(c *gin.Context) {
 			if repaymentDate.Valid {
 				loanInfo.RepaymentDate = &repaymentDate.String
 			}
-			var totalRepaid float64
-			h.DB.QueryRow("SELECT COALESCE(SUM(amount), 0) FROM transactions WHERE user_id = ? AND type = 'repayment' AND related_loan_id = ?", userID, loanInfo.ID).Scan(&totalRepaid)
+			var totalRepaid Money
+			h.DB.QueryRow("SELECT COALESCE(SUM(amount), 0) FROM postings WHERE user_id = ? AND account_type = 'expense' AND loan_id = ?", userID, loanInfo.ID).Scan(&totalRepaid)
 			loanInfo.OutstandingBalance = loanInfo.Principal - totalRepaid
 			if loanInfo.Principal > 0 {
-				loanInfo.RepaymentAmountProgress = totalRepaid / loanInfo.Principal
+				loanInfo.RepaymentAmountProgress = totalRepaid.Float64() / loanInfo.Principal.Float64()
 			}
 			response.Loans = append(response.Loans, loanInfo)
 		}
@@ -2,17 +2,28 @@
 package main
 
 import (
+	"archive/zip"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
 	"log/slog"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
-// ExportData (【全新备份功能】) - 导出整个数据库文件
+// backupDBEntry 备份压缩包中数据库文件的名称，附件位于 attachments/ 目录下
+const (
+	backupDBEntry          = "simple_ledger.db"
+	backupAttachmentsEntry = "attachments/"
+)
+
+// ExportData (【全新备份功能】) - 导出整个数据库文件及附件目录 (zip 格式)
 func (h *DBHandler) ExportData(c *gin.Context) {
 	// 从中间件获取用户信息
 	userIDValue, _ := c.Get("userID")
@@ -36,15 +47,114 @@ func (h *DBHandler) ExportData(c *gin.Context) {
 		return
 	}
 
-	filename := fmt.Sprintf("bookkeeper_backup_%s_%s.db", username, time.Now().Format("20060102_150405"))
+	filename := fmt.Sprintf("bookkeeper_backup_%s_%s.zip", username, time.Now().Format("20060102_150405"))
 	c.Header("Content-Description", "File Transfer")
 	c.Header("Content-Disposition", "attachment; filename="+filename)
-	c.Header("Content-Type", "application/octet-stream")
+	c.Header("Content-Type", "application/zip")
+
+	if err := writeBackupArchive(c.Writer, dbPath, getAttachmentsDir()); err != nil {
+		// 响应头已经发出，只能记录日志
+		logger.Error("写入备份压缩包失败", "error", err)
+	}
+}
+
+// writeBackupArchive 将数据库文件和附件目录写入 zip 压缩包
+func writeBackupArchive(w io.Writer, dbPath, attachmentsDir string) error {
+	zw := zip.NewWriter(w)
+	addFile := func(name, path string) error {
+		src, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer src.Close()
+		dst, err := zw.Create(name)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(dst, src)
+		return err
+	}
+
+	if err := addFile(backupDBEntry, dbPath); err != nil {
+		return err
+	}
+	err := filepath.WalkDir(attachmentsDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil // 尚未上传过附件
+			}
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(attachmentsDir, path)
+		if err != nil {
+			return err
+		}
+		return addFile(backupAttachmentsEntry+filepath.ToSlash(rel), path)
+	})
+	if err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// extractBackupArchive 将备份压缩包解压到暂存位置：数据库写入 dbDest，附件写入 attachmentsDest 目录
+func extractBackupArchive(archivePath, dbDest, attachmentsDest string) error {
+	zr, err := zip.OpenReader(archivePath)
+	if err != nil {
+		return fmt.Errorf("无法读取压缩包: %w", err)
+	}
+	defer zr.Close()
+
+	extract := func(f *zip.File, dest string) error {
+		if err := os.MkdirAll(filepath.Dir(dest), 0o755); err != nil {
+			return err
+		}
+		src, err := f.Open()
+		if err != nil {
+			return err
+		}
+		defer src.Close()
+		dst, err := os.Create(dest)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(dst, src); err != nil {
+			dst.Close()
+			return err
+		}
+		return dst.Close()
+	}
 
-	c.File(dbPath)
+	foundDB := false
+	for _, f := range zr.File {
+		switch {
+		case f.Name == backupDBEntry:
+			if err := extract(f, dbDest); err != nil {
+				return err
+			}
+			foundDB = true
+		case strings.HasPrefix(f.Name, backupAttachmentsEntry) && !f.FileInfo().IsDir():
+			rel := strings.TrimPrefix(f.Name, backupAttachmentsEntry)
+			// 防止路径穿越 (zip slip)
+			if !filepath.IsLocal(rel) {
+				return fmt.Errorf("压缩包中包含非法路径: %s", f.Name)
+			}
+			if err := extract(f, filepath.Join(attachmentsDest, filepath.FromSlash(rel))); err != nil {
+				return err
+			}
+		}
+	}
+	if !foundDB {
+		return fmt.Errorf("压缩包中缺少 %s", backupDBEntry)
+	}
+	return nil
 }
 
-// ImportData (【全新恢复功能】) - 恢复整个数据库文件
+// ImportData (【全新恢复功能】) - 恢复整个数据库文件。
+// 支持 ExportData 导出的 zip 压缩包 (同时恢复附件)，以及旧版导出的 .db 文件 (仅恢复数据库，保留现有附件)。
 func (h *DBHandler) ImportData(c *gin.Context) {
 	userIDValue, _ := c.Get("userID")
 	userID, _ := userIDValue.(int64)
@@ -57,8 +167,36 @@ func (h *DBHandler) ImportData(c *gin.Context) {
 	}
 
 	// 验证文件扩展名
-	if filepath.Ext(file.Filename) != ".db" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "请上传 .db 格式的数据库备份文件"})
+	ext := filepath.Ext(file.Filename)
+	if ext != ".db" && ext != ".zip" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "请上传 .zip 格式的备份文件 (或旧版的 .db 数据库文件)"})
+		return
+	}
+
+	dbPath := getDBPath()
+	attachmentsDir := getAttachmentsDir()
+
+	// 先把上传内容放到暂存位置，确认可用后再替换现有数据
+	stagedDB := dbPath + ".restore"
+	stagedAttachments := attachmentsDir + ".restore"
+	defer os.Remove(stagedDB)
+	defer os.RemoveAll(stagedAttachments)
+	if ext == ".zip" {
+		stagedArchive := dbPath + ".restore.zip"
+		defer os.Remove(stagedArchive)
+		if err := c.SaveUploadedFile(file, stagedArchive); err != nil {
+			logger.Error("保存上传的备份文件失败", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "恢复失败：无法保存上传文件"})
+			return
+		}
+		if err := extractBackupArchive(stagedArchive, stagedDB, stagedAttachments); err != nil {
+			logger.Warn("解压备份文件失败", "error", err)
+			c.JSON(http.StatusBadRequest, gin.H{"error": "恢复失败：" + err.Error()})
+			return
+		}
+	} else if err := c.SaveUploadedFile(file, stagedDB); err != nil {
+		logger.Error("保存上传的备份文件失败", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "恢复失败：无法保存上传文件"})
 		return
 	}
 
@@ -69,10 +207,9 @@ func (h *DBHandler) ImportData(c *gin.Context) {
 	}
 	logger.Info("数据库连接已关闭，准备进行文件替换")
 
-	dbPath := getDBPath()
-
 	// 备份当前数据库，以防恢复失败
-	backupPath := dbPath + ".bak-" + time.Now().Format("20060102150405")
+	suffix := ".bak-" + time.Now().Format("20060102150405")
+	backupPath := dbPath + suffix
 	if _, err := os.Stat(dbPath); err == nil {
 		err := os.Rename(dbPath, backupPath)
 		if err != nil {
@@ -85,8 +222,8 @@ func (h *DBHandler) ImportData(c *gin.Context) {
 		logger.Info("当前数据库已备份", "path", backupPath)
 	}
 
-	// 使用上传的文件覆盖当前数据库文件
-	err = c.SaveUploadedFile(file, dbPath)
+	// 使用暂存的文件替换当前数据库文件
+	err = os.Rename(stagedDB, dbPath)
 	if err != nil {
 		logger.Error("用上传文件覆盖数据库失败", "error", err)
 		// 恢复失败，尝试将备份文件还原
@@ -96,6 +233,31 @@ func (h *DBHandler) ImportData(c *gin.Context) {
 		return
 	}
 
+	// 压缩包备份同时替换附件目录，原目录同样保留一份备份
+	attachmentsBackup := attachmentsDir + suffix
+	restoreAttachments := func() {}
+	if ext == ".zip" {
+		if err := os.Rename(attachmentsDir, attachmentsBackup); err != nil && !os.IsNotExist(err) {
+			logger.Error("备份当前附件目录失败", "error", err)
+			os.Rename(backupPath, dbPath)
+			h.DB, _ = initializeDB(h.Logger)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "恢复失败：无法备份现有附件"})
+			return
+		}
+		if err := os.Rename(stagedAttachments, attachmentsDir); err != nil && !os.IsNotExist(err) {
+			logger.Error("替换附件目录失败", "error", err)
+			os.Rename(attachmentsBackup, attachmentsDir)
+			os.Rename(backupPath, dbPath)
+			h.DB, _ = initializeDB(h.Logger)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "恢复失败：无法写入附件"})
+			return
+		}
+		restoreAttachments = func() {
+			os.RemoveAll(attachmentsDir)
+			os.Rename(attachmentsBackup, attachmentsDir)
+		}
+	}
+
 	logger.Info("数据库文件已成功被上传的文件覆盖")
 
 	// 恢复成功后，重新初始化数据库连接
@@ -104,6 +266,7 @@ func (h *DBHandler) ImportData(c *gin.Context) {
 		logger.Error("恢复后重新初始化数据库连接失败", "error", err)
 		// 恢复失败，这是一个严重问题，可能上传的文件是坏的
 		os.Rename(backupPath, dbPath) // 再次尝试还原
+		restoreAttachments()
 		h.DB, _ = initializeDB(h.Logger)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "恢复失败：上传的数据库文件可能已损坏或格式不兼容"})
 		return
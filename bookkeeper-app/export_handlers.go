@@ -0,0 +1,344 @@
+// bookkeeper-app/export_handlers.go
+package main
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/xuri/excelize/v2"
+)
+
+// transactionTypeLabels 导出文件中流水类型的中文名称
+var transactionTypeLabels = map[string]string{
+	"income": "收入", "expense": "支出", "repayment": "还款", "transfer": "转账", "settlement": "结算", "refund": "退款",
+	"adjustment": "余额调整",
+}
+
+var exportHeader = []string{"ID", "日期", "类型", "金额", "币种", "分类", "转出账户", "转入账户", "描述", "标签"}
+
+// exportExtraColumns 追加在 transactionColumns 之后：以逗号拼接的流水标签、换算为本位币的金额
+var exportExtraColumns = `,
+            (SELECT group_concat(g.name, ',') FROM transaction_tags tt JOIN tags g ON g.id = tt.tag_id WHERE tt.transaction_id = t.id),
+            ` + transactionBaseAmount
+
+// exportRow 导出的一行：流水、标签及本位币金额
+type exportRow struct {
+	Transaction
+	TagList    string
+	BaseAmount Money
+}
+
+func (r *exportRow) record() []string {
+	deref := func(s *string) string {
+		if s == nil {
+			return ""
+		}
+		return *s
+	}
+	return []string{
+		strconv.FormatInt(r.ID, 10), r.TransactionDate, transactionTypeLabels[r.Type],
+		r.Amount.String(), r.Currency, deref(r.CategoryName),
+		deref(r.FromAccountName), deref(r.ToAccountName), r.Description, r.TagList,
+	}
+}
+
+// queryExportRows 按筛选条件逐行读取流水 (按日期升序)，每行回调一次，避免把全部结果载入内存
+func (h *DBHandler) queryExportRows(userID int64, conditions []string, args []interface{}, fn func(*exportRow) error) error {
+	rows, err := h.DB.Query(userCategoriesCTE+`
+        SELECT `+transactionColumns+exportExtraColumns+`
+        FROM transactions t
+        `+transactionJoins+`
+        WHERE `+strings.Join(conditions, " AND ")+`
+        ORDER BY t.transaction_date, t.created_at, t.id`,
+		append([]interface{}{userID}, args...)...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var tags sql.NullString
+		var baseAmount Money
+		t, err := scanTransaction(rows, &tags, &baseAmount)
+		if err != nil {
+			return err
+		}
+		if err := fn(&exportRow{Transaction: t, TagList: tags.String, BaseAmount: baseAmount}); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// ExportTransactions 按 GetTransactions 相同的筛选条件导出流水，format 为 csv、xlsx 或 ofx
+func (h *DBHandler) ExportTransactions(c *gin.Context) {
+	userID, _ := c.Get("userID")
+	logger := h.Logger.With(slog.Int64("userID", userID.(int64)))
+
+	filter, err := parseTransactionFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	format := c.DefaultQuery("format", "csv")
+	filename := fmt.Sprintf("transactions_%s.%s", time.Now().Format("20060102_150405"), format)
+
+	var export func(*gin.Context, int64, TransactionFilter) error
+	switch format {
+	case "csv":
+		c.Header("Content-Type", "text/csv; charset=utf-8")
+		export = h.exportCSV
+	case "xlsx":
+		c.Header("Content-Type", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
+		export = h.exportXLSX
+	case "ofx":
+		c.Header("Content-Type", "application/x-ofx")
+		export = h.exportOFX
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format 只能为 csv、xlsx 或 ofx"})
+		return
+	}
+	c.Header("Content-Disposition", "attachment; filename="+filename)
+
+	if err := export(c, userID.(int64), filter); err != nil {
+		logger.Error("导出流水失败", "format", format, "error", err)
+		if !c.Writer.Written() {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "导出流水失败"})
+		}
+	}
+}
+
+// exportCSV 直接写入响应流。带 UTF-8 BOM，以便 Excel 正确识别中文
+func (h *DBHandler) exportCSV(c *gin.Context, userID int64, filter TransactionFilter) error {
+	conditions, args := filter.conditions(userID)
+	c.Status(http.StatusOK)
+	io.WriteString(c.Writer, "\xef\xbb\xbf")
+	w := csv.NewWriter(c.Writer)
+	w.Write(exportHeader)
+	count := 0
+	err := h.queryExportRows(userID, conditions, args, func(r *exportRow) error {
+		if err := w.Write(r.record()); err != nil {
+			return err
+		}
+		if count++; count%500 == 0 {
+			w.Flush()
+		}
+		return w.Error()
+	})
+	w.Flush()
+	if err != nil {
+		return err
+	}
+	return w.Error()
+}
+
+// exportXLSX 生成两个工作表：「流水」逐行流式写入，「月度汇总」按月统计收入、支出和结余
+func (h *DBHandler) exportXLSX(c *gin.Context, userID int64, filter TransactionFilter) error {
+	conditions, args := filter.conditions(userID)
+
+	f := excelize.NewFile()
+	defer f.Close()
+	const detailSheet, summarySheet = "流水", "月度汇总"
+	if err := f.SetSheetName("Sheet1", detailSheet); err != nil {
+		return err
+	}
+	if _, err := f.NewSheet(summarySheet); err != nil {
+		return err
+	}
+
+	sw, err := f.NewStreamWriter(detailSheet)
+	if err != nil {
+		return err
+	}
+	sw.SetColWidth(2, 2, 12)
+	sw.SetColWidth(9, 9, 40)
+	header := make([]interface{}, len(exportHeader))
+	for i, v := range exportHeader {
+		header[i] = v
+	}
+	if err := sw.SetRow("A1", header); err != nil {
+		return err
+	}
+
+	type monthTotals struct{ income, expense Money }
+	months := map[string]*monthTotals{}
+	rowNum := 1
+	err = h.queryExportRows(userID, conditions, args, func(r *exportRow) error {
+		rowNum++
+		record := r.record()
+		values := make([]interface{}, len(record))
+		for i, v := range record {
+			values[i] = v
+		}
+		values[0], values[3] = r.ID, r.Amount.Float64()
+		cell, _ := excelize.CoordinatesToCellName(1, rowNum)
+		if err := sw.SetRow(cell, values); err != nil {
+			return err
+		}
+
+		month := r.TransactionDate
+		if len(month) >= 7 {
+			month = month[:7]
+		}
+		totals := months[month]
+		if totals == nil {
+			totals = &monthTotals{}
+			months[month] = totals
+		}
+		switch r.Type {
+		case "income":
+			totals.income += r.BaseAmount
+		case "expense", "repayment":
+			totals.expense += r.BaseAmount
+		case "refund":
+			totals.expense -= r.BaseAmount // 按退款所在月份冲减，与明细表逐行对应
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if err := sw.Flush(); err != nil {
+		return err
+	}
+
+	// 汇总表每月一行，数据量很小，直接写入
+	keys := make([]string, 0, len(months))
+	for k := range months {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	f.SetSheetRow(summarySheet, "A1", &[]interface{}{"月份", "收入", "支出", "结余"})
+	for i, k := range keys {
+		cell, _ := excelize.CoordinatesToCellName(1, i+2)
+		t := months[k]
+		f.SetSheetRow(summarySheet, cell, &[]interface{}{k, t.income.Float64(), t.expense.Float64(), (t.income - t.expense).Float64()})
+	}
+
+	c.Status(http.StatusOK)
+	_, err = f.WriteTo(c.Writer)
+	return err
+}
+
+// ofxDate 将 YYYY-MM-DD 转为 OFX 的 YYYYMMDD 格式
+func ofxDate(date string) string {
+	if len(date) >= 10 {
+		return strings.ReplaceAll(date[:10], "-", "")
+	}
+	return strings.ReplaceAll(date, "-", "")
+}
+
+func ofxEscape(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+// exportOFX 生成 OFX 2.2 对账单：每个账户一个 STMTRS，金额以该账户视角计正负 (转账会出现在两个账户中)。
+// 结算类流水不涉及账户，不会出现在 OFX 中。
+func (h *DBHandler) exportOFX(c *gin.Context, userID int64, filter TransactionFilter) error {
+	accountQuery := "SELECT id, name, type, balance, currency FROM accounts WHERE user_id = ?"
+	accountArgs := []interface{}{userID}
+	if len(filter.AccountIDs) > 0 {
+		accountQuery += " AND id IN (" + placeholders(len(filter.AccountIDs)) + ")"
+		for _, id := range filter.AccountIDs {
+			accountArgs = append(accountArgs, id)
+		}
+	}
+	rows, err := h.DB.Query(accountQuery+" ORDER BY id", accountArgs...)
+	if err != nil {
+		return err
+	}
+	var accounts []Account
+	for rows.Next() {
+		var a Account
+		if err := rows.Scan(&a.ID, &a.Name, &a.Type, &a.Balance, &a.Currency); err != nil {
+			rows.Close()
+			return err
+		}
+		accounts = append(accounts, a)
+	}
+	rows.Close()
+
+	// 账户筛选由外层按账户拆分处理，这里只保留其余条件
+	perAccount := filter
+	perAccount.AccountIDs = nil
+	baseConditions, baseArgs := perAccount.conditions(userID)
+
+	now := time.Now().Format("20060102150405")
+	w := c.Writer
+	c.Status(http.StatusOK)
+	fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8" standalone="no"?>
+<?OFX OFXHEADER="200" VERSION="220" SECURITY="NONE" OLDFILEUID="NONE" NEWFILEUID="NONE"?>
+<OFX>
+<SIGNONMSGSRSV1><SONRS><STATUS><CODE>0</CODE><SEVERITY>INFO</SEVERITY></STATUS><DTSERVER>%s</DTSERVER><LANGUAGE>CHI</LANGUAGE></SONRS></SIGNONMSGSRSV1>
+<BANKMSGSRSV1>
+`, now)
+
+	for i, account := range accounts {
+		conditions := append([]string{}, baseConditions...)
+		conditions = append(conditions, "(t.from_account_id = ? OR t.to_account_id = ?)", "t.type <> 'settlement'")
+		args := append(append([]interface{}{}, baseArgs...), account.ID, account.ID)
+
+		var start, end sql.NullString
+		dateRangeQuery := "SELECT MIN(t.transaction_date), MAX(t.transaction_date) FROM transactions t WHERE " + strings.Join(conditions, " AND ")
+		if err := h.DB.QueryRow(dateRangeQuery, args...).Scan(&start, &end); err != nil {
+			return err
+		}
+		if !start.Valid {
+			continue // 该账户在筛选范围内没有流水
+		}
+
+		fmt.Fprintf(w, `<STMTTRNRS><TRNUID>%d</TRNUID><STATUS><CODE>0</CODE><SEVERITY>INFO</SEVERITY></STATUS>
+<STMTRS><CURDEF>%s</CURDEF>
+<BANKACCTFROM><BANKID>simple-ledger</BANKID><ACCTID>%d</ACCTID><ACCTTYPE>CHECKING</ACCTTYPE></BANKACCTFROM>
+<BANKTRANLIST><DTSTART>%s</DTSTART><DTEND>%s</DTEND>
+`, i+1, account.Currency, account.ID, ofxDate(start.String), ofxDate(end.String))
+
+		err := h.queryExportRows(userID, conditions, args, func(r *exportRow) error {
+			trnType, amount := "CREDIT", r.Amount
+			if r.FromAccountID != nil && *r.FromAccountID == account.ID {
+				trnType, amount = "DEBIT", -r.Amount
+			} else if r.ToAmount != nil {
+				amount = *r.ToAmount // 跨币种转账的转入方按转入账户币种的金额入账
+			}
+			if r.Type == "transfer" {
+				trnType = "XFER"
+			}
+			name := r.Description
+			if name == "" {
+				name = transactionTypeLabels[r.Type]
+			}
+			if runes := []rune(name); len(runes) > 32 {
+				name = string(runes[:32])
+			}
+			memo := ""
+			if r.CategoryName != nil {
+				memo = *r.CategoryName
+			}
+			_, err := fmt.Fprintf(w, "<STMTTRN><TRNTYPE>%s</TRNTYPE><DTPOSTED>%s</DTPOSTED><TRNAMT>%s</TRNAMT><FITID>%d</FITID><NAME>%s</NAME><MEMO>%s</MEMO></STMTTRN>\n",
+				trnType, ofxDate(r.TransactionDate), amount, r.ID, ofxEscape(name), ofxEscape(memo))
+			return err
+		})
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintf(w, `</BANKTRANLIST>
+<LEDGERBAL><BALAMT>%s</BALAMT><DTASOF>%s</DTASOF></LEDGERBAL>
+</STMTRS></STMTTRNRS>
+`, account.Balance, now)
+	}
+
+	_, err = io.WriteString(w, "</BANKMSGSRSV1>\n</OFX>\n")
+	return err
+}
@@ -0,0 +1,78 @@
+// bookkeeper-app/export_handlers_test.go
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xuri/excelize/v2"
+)
+
+// 测试 CSV / XLSX / OFX 三种导出格式及筛选条件
+func TestExportTransactions(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	handler := &DBHandler{DB: db, Logger: slog.New(slog.NewJSONHandler(io.Discard, nil))}
+	router := setupRouter(handler)
+
+	userID := createTestUser(t, db, "testuser", "password")
+	token := getTestAuthToken(t, userID, "testuser", false)
+	cardID := createTestAccount(t, db, userID, "银行卡", 1000.0)
+	walletID := createTestAccount(t, db, userID, "钱包", 0)
+
+	category := "food_dining"
+	for _, req := range []CreateTransactionRequest{
+		{Type: "expense", Amount: yuan(30), TransactionDate: "2024-01-10", Description: "午餐 <外卖>", CategoryID: &category, FromAccountID: &cardID, Tags: []string{"工作日"}},
+		{Type: "income", Amount: yuan(500), TransactionDate: "2024-02-01", Description: "奖金", ToAccountID: &cardID},
+		{Type: "transfer", Amount: yuan(100), TransactionDate: "2024-02-05", FromAccountID: &cardID, ToAccountID: &walletID},
+	} {
+		body, _ := json.Marshal(req)
+		w := performRequest(router, "POST", "/api/v1/transactions", bytes.NewBuffer(body), token)
+		assert.Equal(t, http.StatusCreated, w.Code)
+	}
+
+	// CSV：带 BOM，包含分类名、账户名和标签
+	w := performRequest(router, "GET", "/api/v1/transactions/export?format=csv", nil, token)
+	assert.Equal(t, http.StatusOK, w.Code)
+	records, err := csv.NewReader(strings.NewReader(strings.TrimPrefix(w.Body.String(), "\xef\xbb\xbf"))).ReadAll()
+	assert.NoError(t, err)
+	if assert.Len(t, records, 4) {
+		assert.Equal(t, exportHeader, records[0])
+		assert.Equal(t, []string{"2024-01-10", "支出", "30.00", "CNY", "餐饮", "银行卡", "", "午餐 <外卖>", "工作日"}, records[1][1:])
+	}
+
+	w = performRequest(router, "GET", "/api/v1/transactions/export?format=csv&type=income", nil, token)
+	records, _ = csv.NewReader(strings.NewReader(w.Body.String())).ReadAll()
+	assert.Len(t, records, 2)
+
+	// XLSX：月度汇总表
+	w = performRequest(router, "GET", "/api/v1/transactions/export?format=xlsx", nil, token)
+	assert.Equal(t, http.StatusOK, w.Code)
+	f, err := excelize.OpenReader(bytes.NewReader(w.Body.Bytes()))
+	if assert.NoError(t, err) {
+		detail, _ := f.GetRows("流水")
+		assert.Len(t, detail, 4)
+		summary, _ := f.GetRows("月度汇总")
+		assert.Equal(t, [][]string{{"月份", "收入", "支出", "结余"}, {"2024-01", "0", "30", "-30"}, {"2024-02", "500", "0", "500"}}, summary)
+		f.Close()
+	}
+
+	// OFX：转账在两个账户中各出现一次，金额按账户视角计正负
+	w = performRequest(router, "GET", "/api/v1/transactions/export?format=ofx", nil, token)
+	assert.Equal(t, http.StatusOK, w.Code)
+	ofx := w.Body.String()
+	assert.Equal(t, 2, strings.Count(ofx, "<STMTRS>"))
+	assert.Equal(t, 4, strings.Count(ofx, "<STMTTRN>"))
+	assert.Contains(t, ofx, "<TRNTYPE>DEBIT</TRNTYPE><DTPOSTED>20240110</DTPOSTED><TRNAMT>-30.00</TRNAMT>")
+	assert.Contains(t, ofx, "<NAME>午餐 &lt;外卖&gt;</NAME>")
+
+	w = performRequest(router, "GET", "/api/v1/transactions/export?format=pdf", nil, token)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
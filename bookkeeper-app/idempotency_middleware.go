@@ -0,0 +1,224 @@
+// bookkeeper-app/idempotency_middleware.go
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// idempotencyKeyTTL 幂等键及其响应的保留时间，过期后同一个键会被当作新请求处理
+	idempotencyKeyTTL = 24 * time.Hour
+	// idempotencyLockTimeout 处理中的请求超过该时间仍未完成 (如进程崩溃) 时视为已放弃，允许重试
+	idempotencyLockTimeout  = time.Minute
+	maxIdempotencyKeyLength = 255
+	// maxIdempotentBodySize 带幂等键的 JSON 等请求体上限：请求体需要整体读入内存计算摘要
+	maxIdempotentBodySize = 2 << 20
+	// maxIdempotentUploadSize 带幂等键的文件上传 (multipart) 上限：上传内容边计算摘要边写入临时文件，不占用内存
+	maxIdempotentUploadSize = 100 << 20
+)
+
+// setupIdempotency 创建幂等键表：按用户保存键、请求摘要和首次处理的响应
+func setupIdempotency(tx *sql.Tx) error {
+	if _, err := tx.Exec(`
+    CREATE TABLE IF NOT EXISTS idempotency_keys (
+        "user_id" INTEGER NOT NULL,
+        "key" TEXT NOT NULL,
+        "request_hash" TEXT NOT NULL,
+        "status_code" INTEGER NOT NULL DEFAULT 0,
+        "content_type" TEXT,
+        "response_body" BLOB,
+        "created_at" TEXT NOT NULL,
+        "expires_at" TEXT NOT NULL,
+        PRIMARY KEY (user_id, key),
+        FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+    );`); err != nil {
+		return fmt.Errorf("创建 idempotency_keys 表失败: %w", err)
+	}
+	if _, err := tx.Exec(`CREATE INDEX IF NOT EXISTS idx_idempotency_keys_expires ON idempotency_keys (expires_at);`); err != nil {
+		return fmt.Errorf("为 idempotency_keys 创建索引失败: %w", err)
+	}
+	return nil
+}
+
+// idempotencyRecorder 在写出响应的同时保留一份响应体，供保存后重放
+type idempotencyRecorder struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *idempotencyRecorder) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *idempotencyRecorder) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
+
+// IdempotencyMiddleware 为带 Idempotency-Key 请求头的 POST/PUT 请求提供幂等保证 (需放在 AuthMiddleware 之后)：
+// 同一用户以相同的键重发相同的请求时直接返回首次处理的响应，不再重复执行；
+// 相同的键配上不同的请求 (方法、路径或请求体不同) 返回 422；首次请求仍在处理中时返回 409。
+// 服务器内部错误 (5xx) 的响应不会保存，客户端可以用同一个键重试。
+// 请求体超过上限时返回 413；文件上传 (multipart) 重试时必须发送与首次完全相同的请求体。
+func (h *DBHandler) IdempotencyMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader("Idempotency-Key")
+		if key == "" || (c.Request.Method != http.MethodPost && c.Request.Method != http.MethodPut) {
+			c.Next()
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Idempotency-Key 长度不能超过 %d", maxIdempotencyKeyLength)})
+			return
+		}
+		userID, _ := c.Get("userID")
+		logger := h.Logger.With(slog.Int64("userID", userID.(int64)), "idempotencyKey", key)
+
+		// 读取请求体计算摘要，再放回去供处理器使用
+		digest := sha256.New()
+		fmt.Fprintf(digest, "%s %s\n", c.Request.Method, c.Request.URL.RequestURI())
+		if c.ContentType() == "multipart/form-data" {
+			spool, err := spoolIdempotentUpload(c, digest)
+			if err != nil {
+				writeLedgerError(c, logger, err)
+				c.Abort()
+				return
+			}
+			defer func() {
+				spool.Close()
+				os.Remove(spool.Name())
+			}()
+		} else {
+			body, err := io.ReadAll(io.LimitReader(c.Request.Body, maxIdempotentBodySize+1))
+			if err != nil {
+				c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "读取请求数据失败"})
+				return
+			}
+			if len(body) > maxIdempotentBodySize {
+				c.AbortWithStatusJSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("带 Idempotency-Key 的请求体不能超过 %dMB", maxIdempotentBodySize>>20)})
+				return
+			}
+			c.Request.Body = io.NopCloser(bytes.NewReader(body))
+			digest.Write(body)
+		}
+		requestHash := hex.EncodeToString(digest.Sum(nil))
+
+		now := time.Now()
+		reserved, err := h.reserveIdempotencyKey(userID.(int64), key, requestHash, now)
+		if err != nil {
+			logger.Error("登记幂等键失败", "error", err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "服务器内部错误"})
+			return
+		}
+		if !reserved {
+			h.replayIdempotentResponse(c, userID.(int64), key, requestHash)
+			return
+		}
+
+		recorder := &idempotencyRecorder{ResponseWriter: c.Writer}
+		c.Writer = recorder
+		c.Next()
+
+		status := recorder.Status()
+		if status >= http.StatusInternalServerError {
+			if _, err := h.DB.Exec("DELETE FROM idempotency_keys WHERE user_id = ? AND key = ?", userID, key); err != nil {
+				logger.Error("释放幂等键失败", "error", err)
+			}
+			return
+		}
+		_, err = h.DB.Exec("UPDATE idempotency_keys SET status_code = ?, content_type = ?, response_body = ? WHERE user_id = ? AND key = ?",
+			status, recorder.Header().Get("Content-Type"), recorder.body.Bytes(), userID, key)
+		if err != nil {
+			logger.Error("保存幂等响应失败", "error", err)
+		}
+	}
+}
+
+// spoolIdempotentUpload 把上传的请求体写入临时文件，同时计入摘要，并让处理器改从临时文件读取。
+// 调用方负责关闭并删除返回的临时文件。
+func spoolIdempotentUpload(c *gin.Context, digest io.Writer) (*os.File, error) {
+	spool, err := os.CreateTemp("", "idempotent-upload-*")
+	if err != nil {
+		return nil, &ledgerError{Status: http.StatusInternalServerError, Message: "服务器内部错误", Err: err}
+	}
+	discard := func(err error) (*os.File, error) {
+		spool.Close()
+		os.Remove(spool.Name())
+		return nil, err
+	}
+	n, err := io.Copy(spool, io.TeeReader(io.LimitReader(c.Request.Body, maxIdempotentUploadSize+1), digest))
+	if err != nil {
+		return discard(&ledgerError{Status: http.StatusBadRequest, Message: "读取请求数据失败"})
+	}
+	if n > maxIdempotentUploadSize {
+		return discard(&ledgerError{Status: http.StatusRequestEntityTooLarge, Message: fmt.Sprintf("带 Idempotency-Key 的上传不能超过 %dMB", maxIdempotentUploadSize>>20)})
+	}
+	if _, err := spool.Seek(0, io.SeekStart); err != nil {
+		return discard(&ledgerError{Status: http.StatusInternalServerError, Message: "服务器内部错误", Err: err})
+	}
+	c.Request.Body = spool
+	return spool, nil
+}
+
+// reserveIdempotencyKey 登记一个新的幂等键 (状态为处理中)。键已存在且未过期时返回 false；
+// 已过期或处理超时被放弃的键会被替换。顺带清理所有已过期的键。
+func (h *DBHandler) reserveIdempotencyKey(userID int64, key, requestHash string, now time.Time) (bool, error) {
+	nowStr := now.Format(time.RFC3339)
+	if _, err := h.DB.Exec("DELETE FROM idempotency_keys WHERE expires_at < ?", nowStr); err != nil {
+		return false, err
+	}
+	staleBefore := now.Add(-idempotencyLockTimeout).Format(time.RFC3339)
+	if _, err := h.DB.Exec("DELETE FROM idempotency_keys WHERE user_id = ? AND key = ? AND status_code = 0 AND created_at < ?", userID, key, staleBefore); err != nil {
+		return false, err
+	}
+	res, err := h.DB.Exec("INSERT OR IGNORE INTO idempotency_keys (user_id, key, request_hash, created_at, expires_at) VALUES (?, ?, ?, ?, ?)",
+		userID, key, requestHash, nowStr, now.Add(idempotencyKeyTTL).Format(time.RFC3339))
+	if err != nil {
+		return false, err
+	}
+	inserted, _ := res.RowsAffected()
+	return inserted == 1, nil
+}
+
+// replayIdempotentResponse 处理重复使用的幂等键：请求一致时重放已保存的响应
+func (h *DBHandler) replayIdempotentResponse(c *gin.Context, userID int64, key, requestHash string) {
+	var storedHash string
+	var status int
+	var contentType sql.NullString
+	var body []byte
+	err := h.DB.QueryRow("SELECT request_hash, status_code, content_type, response_body FROM idempotency_keys WHERE user_id = ? AND key = ?", userID, key).
+		Scan(&storedHash, &status, &contentType, &body)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			// 首次请求恰好以服务器错误结束并释放了键
+			c.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": "该 Idempotency-Key 的请求刚刚失败，请重试"})
+		} else {
+			h.Logger.Error("查询幂等键失败", "error", err, slog.Int64("userID", userID))
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "服务器内部错误"})
+		}
+		return
+	}
+	if storedHash != requestHash {
+		c.AbortWithStatusJSON(http.StatusUnprocessableEntity, gin.H{"error": "该 Idempotency-Key 已用于内容不同的请求"})
+		return
+	}
+	if status == 0 {
+		c.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": "使用该 Idempotency-Key 的请求仍在处理中"})
+		return
+	}
+	c.Header("Idempotent-Replayed", "true")
+	c.Data(status, contentType.String, body)
+	c.Abort()
+}
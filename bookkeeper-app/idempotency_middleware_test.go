@@ -0,0 +1,135 @@
+// bookkeeper-app/idempotency_middleware_test.go
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// 测试幂等键：相同请求重放首次响应且不重复扣款，不同请求体返回 422，键按用户隔离，过期后重新处理
+func TestIdempotencyKeys(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	handler := &DBHandler{DB: db, Logger: slog.New(slog.NewJSONHandler(io.Discard, nil))}
+	router := setupRouter(handler)
+
+	userID := createTestUser(t, db, "testuser", "password")
+	token := getTestAuthToken(t, userID, "testuser", false)
+	otherID := createTestUser(t, db, "otheruser", "password")
+	otherToken := getTestAuthToken(t, otherID, "otheruser", false)
+	accountID := createTestAccount(t, db, userID, "Test Account", 1000.0)
+	otherAccountID := createTestAccount(t, db, otherID, "Other Account", 1000.0)
+
+	send := func(method, path, key, token string, payload interface{}) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(payload)
+		req, _ := http.NewRequest(method, path, bytes.NewBuffer(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("Content-Type", "application/json")
+		if key != "" {
+			req.Header.Set("Idempotency-Key", key)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	balance := func(id int64) Money {
+		var b Money
+		db.QueryRow("SELECT balance FROM accounts WHERE id = ?", id).Scan(&b)
+		return b
+	}
+	count := func() int {
+		var n int
+		db.QueryRow("SELECT COUNT(*) FROM transactions WHERE user_id = ?", userID).Scan(&n)
+		return n
+	}
+
+	expense := CreateTransactionRequest{Type: "expense", Amount: yuan(100), TransactionDate: "2024-05-03", FromAccountID: &accountID}
+	first := send("POST", "/api/v1/transactions", "key-1", token, expense)
+	assert.Equal(t, http.StatusCreated, first.Code)
+	retry := send("POST", "/api/v1/transactions", "key-1", token, expense)
+	assert.Equal(t, http.StatusCreated, retry.Code)
+	assert.Equal(t, "true", retry.Header().Get("Idempotent-Replayed"))
+	assert.Equal(t, first.Body.String(), retry.Body.String())
+	assert.Equal(t, 1, count())
+	assert.Equal(t, yuan(900), balance(accountID))
+
+	// 相同的键、不同的请求体
+	expense.Amount = yuan(200)
+	assert.Equal(t, http.StatusUnprocessableEntity, send("POST", "/api/v1/transactions", "key-1", token, expense).Code)
+	assert.Equal(t, 1, count())
+
+	// 不带键的请求不受影响；其他用户可以使用相同的键
+	assert.Equal(t, http.StatusCreated, send("POST", "/api/v1/transactions", "", token, expense).Code)
+	assert.Equal(t, http.StatusCreated, send("POST", "/api/v1/transactions", "", token, expense).Code)
+	assert.Equal(t, 3, count())
+	otherExpense := CreateTransactionRequest{Type: "expense", Amount: yuan(100), TransactionDate: "2024-05-03", FromAccountID: &otherAccountID}
+	assert.Equal(t, http.StatusCreated, send("POST", "/api/v1/transactions", "key-1", otherToken, otherExpense).Code)
+	assert.Equal(t, yuan(900), balance(otherAccountID))
+
+	// 还清贷款的重试不会重复扣款
+	interest := 0.0
+	assert.Equal(t, http.StatusCreated, send("POST", "/api/v1/loans", "", token, UpdateLoanRequest{Principal: yuan(100), InterestRate: &interest, LoanDate: "2024-05-01"}).Code)
+	var loanID int64
+	db.QueryRow("SELECT id FROM loans WHERE user_id = ?", userID).Scan(&loanID)
+	settle := SettleLoanRequest{FromAccountID: accountID, RepaymentDate: "2024-05-10"}
+	path := fmt.Sprintf("/api/v1/loans/%d/settle", loanID)
+	assert.Equal(t, http.StatusOK, send("POST", path, "settle-1", token, settle).Code)
+	assert.Equal(t, http.StatusOK, send("POST", path, "settle-1", token, settle).Code)
+	assert.Equal(t, yuan(400), balance(accountID))
+	// 同一个键用于不同的接口
+	assert.Equal(t, http.StatusUnprocessableEntity, send("POST", "/api/v1/transactions", "settle-1", token, settle).Code)
+
+	// 业务错误的响应同样会被保存并重放
+	big := CreateTransactionRequest{Type: "expense", Amount: yuan(5000), TransactionDate: "2024-05-03", FromAccountID: &accountID}
+	assert.Equal(t, http.StatusConflict, send("POST", "/api/v1/transactions", "key-2", token, big).Code)
+	w := send("POST", "/api/v1/transactions", "key-2", token, big)
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Equal(t, "true", w.Header().Get("Idempotent-Replayed"))
+
+	// 过期后同一个键按新请求处理
+	db.Exec("UPDATE idempotency_keys SET expires_at = ? WHERE key = 'key-1'", time.Now().Add(-time.Minute).Format(time.RFC3339))
+	w = send("POST", "/api/v1/transactions", "key-1", token, expense)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Empty(t, w.Header().Get("Idempotent-Replayed"))
+	assert.Equal(t, 5, count())
+
+	// 文件上传同样遵守幂等键：相同的上传重放首次响应，内容不同则拒绝
+	upload := func(content string) *httptest.ResponseRecorder {
+		var buf bytes.Buffer
+		mw := multipart.NewWriter(&buf)
+		mw.SetBoundary("idempotency-test-boundary")
+		part, _ := mw.CreateFormFile("file", "rates.csv")
+		part.Write([]byte(content))
+		mw.Close()
+		req, _ := http.NewRequest("POST", "/api/v1/exchange_rates/upload", &buf)
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("Content-Type", mw.FormDataContentType())
+		req.Header.Set("Idempotency-Key", "upload-1")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	rates := "date,currency,rate\n2024-05-01,USD,7.1\n"
+	firstUpload := upload(rates)
+	w = upload(rates)
+	assert.Equal(t, firstUpload.Code, w.Code)
+	assert.Equal(t, firstUpload.Body.String(), w.Body.String())
+	assert.Equal(t, "true", w.Header().Get("Idempotent-Replayed"))
+	assert.Equal(t, http.StatusUnprocessableEntity, upload("date,currency,rate\n2024-05-01,USD,7.2\n").Code)
+
+	// 超过上限的请求体直接拒绝
+	oversized := CreateTransactionRequest{Type: "expense", Amount: yuan(1), TransactionDate: "2024-05-03", FromAccountID: &accountID, Description: strings.Repeat("x", maxIdempotentBodySize)}
+	assert.Equal(t, http.StatusRequestEntityTooLarge, send("POST", "/api/v1/transactions", "key-3", token, oversized).Code)
+	assert.Equal(t, 5, count())
+}
@@ -0,0 +1,472 @@
+// bookkeeper-app/import_handlers.go
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"golang.org/x/text/encoding/simplifiedchinese"
+)
+
+// maxImportFileSize 导入账单文件的大小上限
+const maxImportFileSize = 5 << 20
+
+// billColumns 账单中各字段对应的候选表头名 (不同版本的导出文件表头略有差异)
+type billColumns struct {
+	Date         []string
+	Amount       []string
+	Direction    []string
+	Counterparty []string
+	Description  []string
+	ExternalID   []string
+	Status       []string
+	CategoryID   []string
+}
+
+// billFormat 一种账单格式：表头定义，以及状态中包含这些关键字的行不导入
+type billFormat struct {
+	Columns      billColumns
+	SkipStatuses []string
+}
+
+var billFormats = map[string]billFormat{
+	// 支付宝「交易明细」导出：GBK 编码，表头前有二十余行说明
+	"alipay": {
+		Columns: billColumns{
+			Date:         []string{"交易时间", "交易创建时间"},
+			Amount:       []string{"金额", "金额（元）"},
+			Direction:    []string{"收/支"},
+			Counterparty: []string{"交易对方"},
+			Description:  []string{"商品说明", "商品名称"},
+			ExternalID:   []string{"交易订单号", "交易号"},
+			Status:       []string{"交易状态"},
+		},
+		SkipStatuses: []string{"交易关闭", "失败"},
+	},
+	// 微信支付账单明细：UTF-8 编码，表头前有十余行说明
+	"wechat": {
+		Columns: billColumns{
+			Date:         []string{"交易时间"},
+			Amount:       []string{"金额(元)", "金额（元）"},
+			Direction:    []string{"收/支"},
+			Counterparty: []string{"交易对方"},
+			Description:  []string{"商品"},
+			ExternalID:   []string{"交易单号"},
+			Status:       []string{"当前状态"},
+		},
+		SkipStatuses: []string{"已全额退款", "失败"},
+	},
+}
+
+// genericBillFormat 由用户提供的列映射构造通用 CSV 格式
+func genericBillFormat(m CSVColumnMapping) billFormat {
+	optional := func(name string) []string {
+		if name == "" {
+			return nil
+		}
+		return []string{name}
+	}
+	return billFormat{Columns: billColumns{
+		Date:        []string{m.Date},
+		Amount:      []string{m.Amount},
+		Direction:   optional(m.Direction),
+		Description: optional(m.Description),
+		ExternalID:  optional(m.ExternalID),
+		CategoryID:  optional(m.CategoryID),
+	}}
+}
+
+// decodeBillText 将账单内容转换为 UTF-8：去掉 BOM，非合法 UTF-8 的内容按 GBK 解码
+func decodeBillText(data []byte) ([]byte, error) {
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+	if utf8.Valid(data) {
+		return data, nil
+	}
+	decoded, err := simplifiedchinese.GBK.NewDecoder().Bytes(data)
+	if err != nil {
+		return nil, fmt.Errorf("无法识别文件编码 (仅支持 UTF-8 和 GBK): %w", err)
+	}
+	return decoded, nil
+}
+
+var billDateLayouts = []string{
+	"2006-01-02 15:04:05", "2006-01-02 15:04", "2006-01-02",
+	"2006/1/2 15:04:05", "2006/1/2 15:04", "2006/1/2",
+	"2006年1月2日 15:04:05", "2006年1月2日", "20060102",
+}
+
+// parseBillDate 解析账单中的日期，返回 YYYY-MM-DD
+func parseBillDate(s string) (string, error) {
+	for _, layout := range billDateLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t.Format(dateLayout), nil
+		}
+	}
+	return "", fmt.Errorf("无法识别的日期: %s", s)
+}
+
+// parseBillAmount 解析金额，去掉货币符号和千分位
+func parseBillAmount(s string) (Money, error) {
+	cleaned := strings.NewReplacer("¥", "", "￥", "", ",", "", " ", "").Replace(s)
+	amount, err := ParseMoney(cleaned)
+	if err != nil {
+		return 0, fmt.Errorf("无法识别的金额: %s", s)
+	}
+	return amount, nil
+}
+
+// parseBillCSV 解析账单内容：跳过表头前的说明行，按列映射把每一行转换为针对 accountID 的流水请求。
+// 此处只做格式层面的转换，去重和入账校验由调用方在事务中完成。
+func parseBillCSV(data []byte, format billFormat, accountID int64) ([]CSVImportRow, error) {
+	text, err := decodeBillText(data)
+	if err != nil {
+		return nil, err
+	}
+	r := csv.NewReader(bytes.NewReader(text))
+	r.FieldsPerRecord = -1
+	r.LazyQuotes = true
+
+	// 1. 找到表头行：包含日期列和金额列的第一行
+	var index map[string]int
+	col := func(candidates []string) int {
+		for _, name := range candidates {
+			if i, ok := index[name]; ok {
+				return i
+			}
+		}
+		return -1
+	}
+	line := 0
+	headerLen := 0
+	for index == nil {
+		record, err := r.Read()
+		if err == io.EOF {
+			return nil, fmt.Errorf("未找到账单表头 (需要包含列: %s, %s)", format.Columns.Date[0], format.Columns.Amount[0])
+		}
+		line++
+		if err != nil {
+			continue // 说明行中可能有不规范的引号
+		}
+		candidate := make(map[string]int, len(record))
+		for i, name := range record {
+			candidate[strings.TrimSpace(name)] = i
+		}
+		index = candidate
+		if col(format.Columns.Date) < 0 || col(format.Columns.Amount) < 0 {
+			index = nil
+			continue
+		}
+		headerLen = len(record)
+	}
+	cols := map[string]int{
+		"date": col(format.Columns.Date), "amount": col(format.Columns.Amount),
+		"direction": col(format.Columns.Direction), "counterparty": col(format.Columns.Counterparty),
+		"description": col(format.Columns.Description), "external_id": col(format.Columns.ExternalID),
+		"status": col(format.Columns.Status), "category_id": col(format.Columns.CategoryID),
+	}
+
+	// 2. 逐行转换
+	rows := []CSVImportRow{}
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		line++
+		if err != nil {
+			rows = append(rows, CSVImportRow{Line: line, Status: "error", Reason: "CSV 格式错误: " + err.Error()})
+			continue
+		}
+		// 列数明显不足的是空行或文件末尾的统计说明，直接忽略
+		if len(record) < headerLen/2+1 {
+			continue
+		}
+		field := func(key string) string {
+			if i := cols[key]; i >= 0 && i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+
+		row := CSVImportRow{Line: line, ExternalID: field("external_id")}
+		status := field("status")
+		if skip := matchesAny(status, format.SkipStatuses); skip {
+			row.Status, row.Reason = "skipped", "交易状态为「"+status+"」"
+			rows = append(rows, row)
+			continue
+		}
+		date, err := parseBillDate(field("date"))
+		if err != nil {
+			row.Status, row.Reason = "error", err.Error()
+			rows = append(rows, row)
+			continue
+		}
+		amount, err := parseBillAmount(field("amount"))
+		if err != nil {
+			row.Status, row.Reason = "error", err.Error()
+			rows = append(rows, row)
+			continue
+		}
+
+		txType := ""
+		if cols["direction"] >= 0 {
+			switch direction := field("direction"); strings.ToLower(direction) {
+			case "支出", "expense":
+				txType = "expense"
+			case "收入", "income":
+				txType = "income"
+			default:
+				row.Status, row.Reason = "skipped", "不计收支 ("+direction+")"
+				rows = append(rows, row)
+				continue
+			}
+		} else if amount < 0 {
+			txType = "expense"
+		} else {
+			txType = "income"
+		}
+		if amount < 0 {
+			amount = -amount
+		}
+		if amount == 0 {
+			row.Status, row.Reason = "skipped", "金额为 0"
+			rows = append(rows, row)
+			continue
+		}
+
+		description := field("description")
+		if counterparty := field("counterparty"); counterparty != "" && counterparty != "/" {
+			row.Counterparty = counterparty
+			description = strings.TrimSpace(counterparty + " " + description)
+		}
+		req := &CreateTransactionRequest{Type: txType, Amount: amount, TransactionDate: date, Description: description}
+		if categoryID := field("category_id"); categoryID != "" {
+			req.CategoryID = &categoryID
+		}
+		if txType == "expense" {
+			req.FromAccountID = &accountID
+		} else {
+			req.ToAccountID = &accountID
+		}
+		row.Status, row.Transaction = "new", req
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+func matchesAny(s string, keywords []string) bool {
+	for _, k := range keywords {
+		if s != "" && strings.Contains(s, k) {
+			return true
+		}
+	}
+	return false
+}
+
+// setupImports 创建导入记录表，用于按外部交易号去重。流水被删除 (包括移入回收站) 后可以重新导入。
+func setupImports(tx *sql.Tx) error {
+	if _, err := tx.Exec(`
+    CREATE TABLE IF NOT EXISTS imported_transactions (
+        "id" INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+        "user_id" INTEGER NOT NULL,
+        "source" TEXT NOT NULL,
+        "external_id" TEXT NOT NULL,
+        "transaction_id" INTEGER NOT NULL,
+        "created_at" TEXT NOT NULL,
+        FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE,
+        FOREIGN KEY(transaction_id) REFERENCES transactions(id) ON DELETE CASCADE,
+        UNIQUE(user_id, source, external_id)
+    );`); err != nil {
+		return fmt.Errorf("创建 imported_transactions 表失败: %w", err)
+	}
+	return nil
+}
+
+// ImportCSV 导入支付宝/微信/通用 CSV 账单。
+// 表单字段: file, source (alipay|wechat|generic), account_id, mapping (generic 时必填, JSON), commit。
+// commit 不为 true 时只返回预览，不做任何修改；确认后以 commit=true 重新上传同一文件即可导入。
+// 导入是原子的：任何一行入账失败都不会写入任何数据。
+func (h *DBHandler) ImportCSV(c *gin.Context) {
+	userID, _ := c.Get("userID")
+	logger := h.Logger.With(slog.Int64("userID", userID.(int64)))
+
+	source := c.PostForm("source")
+	format, ok := billFormats[source]
+	if source == "generic" {
+		var mapping CSVColumnMapping
+		if err := json.Unmarshal([]byte(c.PostForm("mapping")), &mapping); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "通用 CSV 导入需要提供有效的列映射 (mapping)"})
+			return
+		}
+		if err := binding.Validator.ValidateStruct(&mapping); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "无效的列映射: " + err.Error()})
+			return
+		}
+		format, ok = genericBillFormat(mapping), true
+	}
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "source 只能为 alipay、wechat 或 generic"})
+		return
+	}
+	accountID, err := strconv.ParseInt(c.PostForm("account_id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "请指定导入到的账户 (account_id)"})
+		return
+	}
+	commit := c.PostForm("commit") == "true"
+
+	file, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "文件上传失败: " + err.Error()})
+		return
+	}
+	if file.Size > maxImportFileSize {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("账单文件不能超过 %d MB", maxImportFileSize>>20)})
+		return
+	}
+	src, err := file.Open()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "读取上传文件失败"})
+		return
+	}
+	data, err := io.ReadAll(src)
+	src.Close()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "读取上传文件失败"})
+		return
+	}
+
+	rows, err := parseBillCSV(data, format, accountID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		logger.Error("开启事务失败", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "开启事务失败"})
+		return
+	}
+	defer tx.Rollback()
+
+	if !isOwner(tx, userID.(int64), "accounts", accountID) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "未找到指定的账户"})
+		return
+	}
+
+	// 1. 按外部交易号去重 (已导入过的，以及同一文件中重复出现的)
+	seen := map[string]bool{}
+	var pending []int
+	for i := range rows {
+		row := &rows[i]
+		if row.Status != "new" {
+			continue
+		}
+		if row.ExternalID != "" {
+			var count int
+			err := tx.QueryRow(`
+                SELECT COUNT(*) FROM imported_transactions i
+                JOIN transactions t ON t.id = i.transaction_id
+                WHERE i.user_id = ? AND i.source = ? AND i.external_id = ? AND t.deleted_at IS NULL`,
+				userID, source, row.ExternalID).Scan(&count)
+			if err != nil {
+				logger.Error("检查重复导入失败", "error", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "服务器内部错误"})
+				return
+			}
+			if count > 0 || seen[row.ExternalID] {
+				row.Status, row.Reason, row.Transaction = "duplicate", "该交易已导入过", nil
+				continue
+			}
+			seen[row.ExternalID] = true
+		}
+		pending = append(pending, i)
+	}
+
+	// 2. 按日期先后入账 (账单通常按时间倒序排列)，逐行校验余额等规则
+	sort.SliceStable(pending, func(a, b int) bool {
+		return rows[pending[a]].Transaction.TransactionDate < rows[pending[b]].Transaction.TransactionDate
+	})
+	createdAt := time.Now().Format(time.RFC3339)
+	actor := auditActorFromContext(c)
+	itemErrors, err := runBulkItems(tx, len(pending), func(i int) error {
+		row := &rows[pending[i]]
+		if err := binding.Validator.ValidateStruct(row.Transaction); err != nil {
+			return &ledgerError{Status: http.StatusBadRequest, Message: "无效的流水数据: " + err.Error()}
+		}
+		// 交易对方只关联到已有商户 (按商户名或别名)，不为每个交易对方自动创建商户
+		if row.Counterparty != "" && row.Transaction.PayeeID == nil {
+			payeeID, ok, err := findPayee(tx, userID.(int64), row.Counterparty)
+			if err != nil {
+				return &ledgerError{Status: http.StatusInternalServerError, Message: "查询商户失败", Err: err}
+			}
+			if ok {
+				row.Transaction.PayeeID = &payeeID
+			}
+		}
+		id, err := createTransactionInTx(tx, userID.(int64), row.Transaction)
+		if err != nil {
+			return err
+		}
+		if err := writeAudit(tx, actor, "transaction", "create", id, nil); err != nil {
+			return err
+		}
+		if row.ExternalID != "" {
+			// 原流水在回收站中时改为指向新流水
+			_, err := tx.Exec("INSERT OR REPLACE INTO imported_transactions (user_id, source, external_id, transaction_id, created_at) VALUES (?, ?, ?, ?, ?)",
+				userID, source, row.ExternalID, id, createdAt)
+			if err != nil {
+				return &ledgerError{Status: http.StatusInternalServerError, Message: "保存导入记录失败", Err: err}
+			}
+		}
+		row.TransactionID = &id
+		return nil
+	})
+	if err != nil {
+		writeLedgerError(c, logger, err)
+		return
+	}
+	for _, itemErr := range itemErrors {
+		row := &rows[pending[itemErr.Index]]
+		row.Status, row.Reason, row.TransactionID = "error", itemErr.Error, nil
+	}
+
+	response := CSVImportResponse{Source: source, Committed: commit && len(itemErrors) == 0, Rows: rows, Summary: map[string]int{}}
+	for i := range rows {
+		if !response.Committed {
+			rows[i].TransactionID = nil // 未提交，ID 无效
+		} else if rows[i].Status == "new" {
+			rows[i].Status = "imported"
+		}
+		response.Summary[rows[i].Status]++
+	}
+
+	if !commit {
+		c.JSON(http.StatusOK, response)
+		return
+	}
+	if len(itemErrors) > 0 {
+		c.JSON(http.StatusUnprocessableEntity, response)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		logger.Error("提交事务失败", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "提交事务失败"})
+		return
+	}
+	c.JSON(http.StatusCreated, response)
+}
@@ -0,0 +1,140 @@
+// bookkeeper-app/import_handlers_test.go
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/text/encoding/simplifiedchinese"
+)
+
+const alipaySample = `------------------------------------------------------------------------------------
+导出信息：
+姓名：张三
+支付宝账户：zhangsan@example.com
+起始时间：[2024-01-01 00:00:00]    终止时间：[2024-01-31 23:59:59]
+------------------------支付宝（中国）网络技术有限公司  电子客户回单------------------------
+交易时间,交易分类,交易对方,对方账号,商品说明,收/支,金额,收/付款方式,交易状态,交易订单号,商家订单号,备注,
+2024-01-20 12:30:00,餐饮美食,某某餐厅,/,午餐,支出,35.50,余额宝,交易成功,2024012022001	,M001	,,
+2024-01-15 09:00:00,转账红包,李四,li***@example.com,转账,收入,200.00,,交易成功,2024011522002	,,,
+2024-01-10 18:00:00,投资理财,余额宝,/,收益发放,不计收支,0.12,,交易成功,2024011022003	,,,
+2024-01-05 08:00:00,日用百货,某超市,/,购物,支出,50.00,余额宝,交易关闭,2024010522004	,,,
+`
+
+const wechatSample = "\xef\xbb\xbf微信支付账单明细,,,,,,,,,,\n" +
+	"微信昵称：[张三],,,,,,,,,,\n" +
+	"----------------------微信支付账单明细列表--------------------,,,,,,,,,,\n" +
+	"交易时间,交易类型,交易对方,商品,收/支,金额(元),支付方式,当前状态,交易单号,商户单号,备注\n" +
+	"2024-02-01 10:00:00,商户消费,咖啡店,拿铁,支出,¥28.00,零钱,支付成功,4200001\t,10001\t,/\n" +
+	"2024-02-02 11:00:00,商户消费,书店,图书,支出,¥88.00,零钱,已全额退款,4200002\t,10002\t,/\n" +
+	"2024-02-03 12:00:00,微信红包,王五,/,收入,\"¥1,000.00\",/,已存入零钱,4200003\t,/,/\n"
+
+// 测试支付宝 (GBK) 和微信账单的解析
+func TestParseBillCSV(t *testing.T) {
+	gbk, err := simplifiedchinese.GBK.NewEncoder().Bytes([]byte(alipaySample))
+	assert.NoError(t, err)
+
+	rows, err := parseBillCSV(gbk, billFormats["alipay"], 1)
+	assert.NoError(t, err)
+	if assert.Len(t, rows, 4) {
+		assert.Equal(t, "new", rows[0].Status)
+		assert.Equal(t, "2024012022001", rows[0].ExternalID)
+		assert.Equal(t, "expense", rows[0].Transaction.Type)
+		assert.Equal(t, yuan(35.5), rows[0].Transaction.Amount)
+		assert.Equal(t, "2024-01-20", rows[0].Transaction.TransactionDate)
+		assert.Equal(t, "某某餐厅 午餐", rows[0].Transaction.Description)
+		assert.Equal(t, "income", rows[1].Transaction.Type)
+		assert.Equal(t, "skipped", rows[2].Status) // 不计收支
+		assert.Equal(t, "skipped", rows[3].Status) // 交易关闭
+	}
+
+	rows, err = parseBillCSV([]byte(wechatSample), billFormats["wechat"], 1)
+	assert.NoError(t, err)
+	if assert.Len(t, rows, 3) {
+		assert.Equal(t, yuan(28), rows[0].Transaction.Amount)
+		assert.Equal(t, "skipped", rows[1].Status)
+		assert.Equal(t, yuan(1000), rows[2].Transaction.Amount)
+		assert.Equal(t, "income", rows[2].Transaction.Type)
+	}
+
+	generic := "Date,Amount,Memo,Ref\n2024/3/1,-12.5,Bus,R1\n2024/3/2,100,Refund,R2\n"
+	rows, err = parseBillCSV([]byte(generic), genericBillFormat(CSVColumnMapping{Date: "Date", Amount: "Amount", Description: "Memo", ExternalID: "Ref"}), 1)
+	assert.NoError(t, err)
+	if assert.Len(t, rows, 2) {
+		assert.Equal(t, "expense", rows[0].Transaction.Type)
+		assert.Equal(t, yuan(12.5), rows[0].Transaction.Amount)
+		assert.Equal(t, "2024-03-01", rows[0].Transaction.TransactionDate)
+		assert.Equal(t, "income", rows[1].Transaction.Type)
+	}
+}
+
+// importTestCSV 以 multipart 表单上传账单
+func importTestCSV(r http.Handler, fields map[string]string, content []byte, token string) *httptest.ResponseRecorder {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	for k, v := range fields {
+		mw.WriteField(k, v)
+	}
+	part, _ := mw.CreateFormFile("file", "bill.csv")
+	part.Write(content)
+	mw.Close()
+
+	req, _ := http.NewRequest("POST", "/api/v1/import/csv", &body)
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+// 测试预览不写入数据、确认后导入，以及再次导入时按交易号去重
+func TestImportCSV_PreviewCommitAndDedupe(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	handler := &DBHandler{DB: db, Logger: slog.New(slog.NewJSONHandler(io.Discard, nil))}
+	router := setupRouter(handler)
+
+	userID := createTestUser(t, db, "testuser", "password")
+	token := getTestAuthToken(t, userID, "testuser", false)
+	accountID := createTestAccount(t, db, userID, "支付宝", 0)
+	fields := map[string]string{"source": "alipay", "account_id": strconv.FormatInt(accountID, 10)}
+
+	// 预览：收入 200 先于支出 35.5 入账，因此余额校验可以通过
+	w := importTestCSV(router, fields, []byte(alipaySample), token)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var resp CSVImportResponse
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	assert.False(t, resp.Committed)
+	assert.Equal(t, map[string]int{"new": 2, "skipped": 2}, resp.Summary)
+
+	var count int
+	db.QueryRow("SELECT COUNT(*) FROM transactions WHERE user_id = ?", userID).Scan(&count)
+	assert.Equal(t, 0, count)
+
+	fields["commit"] = "true"
+	w = importTestCSV(router, fields, []byte(alipaySample), token)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	resp = CSVImportResponse{}
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	assert.Equal(t, map[string]int{"imported": 2, "skipped": 2}, resp.Summary)
+
+	var balance Money
+	db.QueryRow("SELECT balance FROM accounts WHERE id = ?", accountID).Scan(&balance)
+	assert.Equal(t, yuan(164.5), balance)
+
+	w = importTestCSV(router, fields, []byte(alipaySample), token)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	resp = CSVImportResponse{}
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	assert.Equal(t, map[string]int{"duplicate": 2, "skipped": 2}, resp.Summary)
+	db.QueryRow("SELECT COUNT(*) FROM transactions WHERE user_id = ?", userID).Scan(&count)
+	assert.Equal(t, 2, count)
+}
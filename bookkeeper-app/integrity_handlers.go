@@ -0,0 +1,230 @@
+// bookkeeper-app/integrity_handlers.go
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// integrityIssues 孤立引用检查：每项为问题代码、说明及查询条件 (流水别名 t，转出/转入账户别名 fa/ta)
+var integrityIssues = []struct {
+	code, message, condition string
+}{
+	{"missing_from_account", "流水的转出/付款账户不存在 (账户可能已被删除)",
+		"t.type IN ('expense', 'repayment', 'transfer') AND fa.id IS NULL"},
+	{"missing_to_account", "流水的转入/收款账户不存在 (账户可能已被删除)",
+		"t.type IN ('income', 'refund', 'transfer') AND ta.id IS NULL"},
+	{"foreign_account", "流水引用了其他用户的账户",
+		"(fa.user_id <> t.user_id OR ta.user_id <> t.user_id)"},
+	{"missing_loan", "还款流水关联的贷款不存在",
+		"t.type = 'repayment' AND NOT EXISTS (SELECT 1 FROM loans l WHERE l.id = t.related_loan_id AND l.user_id = t.user_id)"},
+	{"missing_category", "流水的分类不存在",
+		"t.category_id IS NOT NULL AND NOT EXISTS (SELECT 1 FROM shared_categories sc WHERE sc.id = t.category_id) AND NOT EXISTS (SELECT 1 FROM categories c WHERE c.id = t.category_id AND c.user_id = t.user_id)"},
+	{"missing_postings", "流水没有记账分录",
+		"t.type <> 'settlement' AND NOT EXISTS (SELECT 1 FROM postings p WHERE p.transaction_id = t.id)"},
+	{"unbalanced_postings", "流水的记账分录借贷不平衡",
+		"EXISTS (SELECT 1 FROM postings p WHERE p.transaction_id = t.id GROUP BY p.currency HAVING SUM(p.amount) <> 0)"},
+}
+
+// setupIntegrity 为账户表补充期初余额 (创建账户时的初始余额)，账户余额应始终等于期初余额加上所有流水的影响。
+// 已有账户没有记录初始余额，只能以迁移时的余额为基准倒推，因此迁移之前已经存在的偏差无法被发现。
+func setupIntegrity(tx *sql.Tx) error {
+	if err := addColumnIfMissing(tx, "accounts", "opening_balance", `"opening_balance" INTEGER`); err != nil {
+		return err
+	}
+	_, err := tx.Exec(`
+        UPDATE accounts SET opening_balance = balance - COALESCE((
+            SELECT SUM(` + accountEffectSQL("accounts.id") + `) FROM transactions t
+            WHERE t.user_id = accounts.user_id AND t.deleted_at IS NULL
+              AND (t.from_account_id = accounts.id OR t.to_account_id = accounts.id)), 0)
+        WHERE opening_balance IS NULL`)
+	if err != nil {
+		return fmt.Errorf("补充账户期初余额失败: %w", err)
+	}
+	return nil
+}
+
+// checkIntegrity 重新计算账户余额 (期初余额 + 流水) 与账户余额比对，并检查流水的孤立引用。
+// userID 为 nil 时检查所有用户。
+func checkIntegrity(tx *sql.Tx, userID *int64) (IntegrityReport, error) {
+	report := IntegrityReport{CheckedAt: time.Now().Format(time.RFC3339), Discrepancies: []BalanceDiscrepancy{}, Issues: []IntegrityIssue{}}
+	filter, args := "", []interface{}{}
+	if userID != nil {
+		filter, args = "WHERE a.user_id = ?", append(args, *userID)
+	}
+
+	rows, err := tx.Query(`
+        SELECT a.id, a.user_id, a.name, a.currency, a.balance, COALESCE(a.opening_balance, 0),
+               COALESCE((SELECT SUM(`+accountEffectSQL("a.id")+`) FROM transactions t
+                         WHERE t.user_id = a.user_id AND t.deleted_at IS NULL
+                           AND (t.from_account_id = a.id OR t.to_account_id = a.id)), 0)
+        FROM accounts a `+filter+`
+        ORDER BY a.user_id, a.id`, args...)
+	if err != nil {
+		return report, &ledgerError{Status: http.StatusInternalServerError, Message: "重新计算账户余额失败", Err: err}
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var d BalanceDiscrepancy
+		if err := rows.Scan(&d.AccountID, &d.UserID, &d.AccountName, &d.Currency, &d.ActualBalance, &d.OpeningBalance, &d.TransactionTotal); err != nil {
+			return report, &ledgerError{Status: http.StatusInternalServerError, Message: "扫描账户数据失败", Err: err}
+		}
+		report.AccountsChecked++
+		d.ExpectedBalance = d.OpeningBalance + d.TransactionTotal
+		d.Difference = d.ActualBalance - d.ExpectedBalance
+		if d.Difference != 0 {
+			report.Discrepancies = append(report.Discrepancies, d)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return report, &ledgerError{Status: http.StatusInternalServerError, Message: "重新计算账户余额失败", Err: err}
+	}
+
+	transactionFilter := ""
+	if userID != nil {
+		transactionFilter = "AND t.user_id = ?"
+	}
+	for _, issue := range integrityIssues {
+		rows, err := tx.Query(`
+            SELECT t.id, t.user_id FROM transactions t
+            LEFT JOIN accounts fa ON fa.id = t.from_account_id
+            LEFT JOIN accounts ta ON ta.id = t.to_account_id
+            WHERE t.deleted_at IS NULL `+transactionFilter+` AND `+issue.condition+`
+            ORDER BY t.id`, args...)
+		if err != nil {
+			return report, &ledgerError{Status: http.StatusInternalServerError, Message: "检查孤立引用失败", Err: err}
+		}
+		for rows.Next() {
+			item := IntegrityIssue{Code: issue.code, Message: issue.message}
+			if err := rows.Scan(&item.TransactionID, &item.UserID); err != nil {
+				rows.Close()
+				return report, &ledgerError{Status: http.StatusInternalServerError, Message: "扫描孤立引用失败", Err: err}
+			}
+			report.Issues = append(report.Issues, item)
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return report, &ledgerError{Status: http.StatusInternalServerError, Message: "检查孤立引用失败", Err: err}
+		}
+	}
+	return report, nil
+}
+
+// repairBalances 为每个余额偏差生成一条今天的余额调整流水 (账户对权益，不计入收支统计)。
+// 偏差已经体现在账户余额中，调整流水只补记分录、不再改动余额：修复后账户余额保持不变，而分录与余额重新一致。
+// 某个账户无法修复时 (如当月已结算) 记录原因并跳过。
+// 孤立引用需要人工处理，不会自动修复。
+func repairBalances(tx *sql.Tx, report *IntegrityReport, c *gin.Context) error {
+	today := time.Now().Format(dateLayout)
+	itemErrors, err := runBulkItems(tx, len(report.Discrepancies), func(i int) error {
+		d := &report.Discrepancies[i]
+		id, err := insertAdjustment(tx, d.UserID, d.AccountID, d.Difference, today, "余额校正")
+		if err != nil {
+			return err
+		}
+		if _, err := recordPostings(tx, id); err != nil {
+			return err
+		}
+		// 操作者记为实际发起修复的用户 (可能是管理员)，数据所有者记为账户所有者，用户在自己的审计日志中也能看到
+		if err := writeAuditForOwner(tx, auditActorFromContext(c), d.UserID, "transaction", "create", id, nil); err != nil {
+			return err
+		}
+		d.AdjustmentTransactionID = &id
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, e := range itemErrors {
+		report.Discrepancies[e.Index].RepairError = e.Error
+	}
+	report.Repaired = true
+	return nil
+}
+
+// runIntegrityCheck 检查 (repair 为 true 时同时修复) 指定用户或所有用户的数据一致性
+func (h *DBHandler) runIntegrityCheck(c *gin.Context, userID *int64, repair bool) {
+	logger := h.Logger
+	if userID != nil {
+		logger = logger.With(slog.Int64("userID", *userID))
+	}
+	tx, err := h.DB.Begin()
+	if err != nil {
+		logger.Error("开启事务失败", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "开启事务失败"})
+		return
+	}
+	defer tx.Rollback()
+
+	report, err := checkIntegrity(tx, userID)
+	if err != nil {
+		writeLedgerError(c, logger, err)
+		return
+	}
+	if repair {
+		if err := repairBalances(tx, &report, c); err != nil {
+			writeLedgerError(c, logger, err)
+			return
+		}
+		if err := tx.Commit(); err != nil {
+			logger.Error("提交事务失败", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "提交事务失败"})
+			return
+		}
+		logger.Info("已修复账户余额偏差", "discrepancies", len(report.Discrepancies))
+	}
+	c.JSON(http.StatusOK, report)
+}
+
+// GetIntegrity 检查当前用户的账户余额与流水是否一致，以及流水中的孤立引用
+// GET /integrity
+func (h *DBHandler) GetIntegrity(c *gin.Context) {
+	userID, _ := c.Get("userID")
+	id := userID.(int64)
+	h.runIntegrityCheck(c, &id, false)
+}
+
+// RepairIntegrity 为当前用户的余额偏差生成调整流水
+// POST /integrity/repair
+func (h *DBHandler) RepairIntegrity(c *gin.Context) {
+	userID, _ := c.Get("userID")
+	id := userID.(int64)
+	h.runIntegrityCheck(c, &id, true)
+}
+
+// adminIntegrityUser 解析管理员接口的 user_id 参数，不填表示所有用户
+func adminIntegrityUser(c *gin.Context) (*int64, bool) {
+	v := c.Query("user_id")
+	if v == "" {
+		return nil, true
+	}
+	id, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的 user_id 参数"})
+		return nil, false
+	}
+	return &id, true
+}
+
+// GetAllIntegrity 管理员检查所有用户 (或 user_id 指定的用户) 的数据一致性
+// GET /admin/integrity?user_id=
+func (h *DBHandler) GetAllIntegrity(c *gin.Context) {
+	if userID, ok := adminIntegrityUser(c); ok {
+		h.runIntegrityCheck(c, userID, false)
+	}
+}
+
+// RepairAllIntegrity 管理员修复所有用户 (或 user_id 指定的用户) 的余额偏差
+// POST /admin/integrity/repair?user_id=
+func (h *DBHandler) RepairAllIntegrity(c *gin.Context) {
+	if userID, ok := adminIntegrityUser(c); ok {
+		h.runIntegrityCheck(c, userID, true)
+	}
+}
@@ -0,0 +1,145 @@
+// bookkeeper-app/integrity_handlers_test.go
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// 测试一致性检查：发现余额偏差和孤立引用，修复后余额不变且流水与余额重新一致
+func TestIntegrityCheck(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	handler := &DBHandler{DB: db, Logger: slog.New(slog.NewJSONHandler(io.Discard, nil))}
+	router := setupRouter(handler)
+
+	userID := createTestUser(t, db, "testuser", "password")
+	token := getTestAuthToken(t, userID, "testuser", false)
+	adminToken := getTestAuthToken(t, 1, "admin", true)
+	accountA := createTestAccount(t, db, userID, "Account A", 1000.0)
+	accountB := createTestAccount(t, db, userID, "Account B", 0)
+	accountC := createTestAccount(t, db, userID, "Account C", 0)
+
+	create := func(req CreateTransactionRequest) int64 {
+		body, _ := json.Marshal(req)
+		w := performRequest(router, "POST", "/api/v1/transactions", bytes.NewBuffer(body), token)
+		assert.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+		var resp struct {
+			ID int64 `json:"id"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		return resp.ID
+	}
+	create(CreateTransactionRequest{Type: "expense", Amount: yuan(100), TransactionDate: "2024-05-01", FromAccountID: &accountA})
+	create(CreateTransactionRequest{Type: "transfer", Amount: yuan(50), TransactionDate: "2024-05-02", FromAccountID: &accountA, ToAccountID: &accountB})
+	incomeID := create(CreateTransactionRequest{Type: "income", Amount: yuan(20), TransactionDate: "2024-05-03", ToAccountID: &accountC})
+
+	check := func(method, path, token string) (int, IntegrityReport) {
+		w := performRequest(router, method, path, nil, token)
+		var report IntegrityReport
+		json.Unmarshal(w.Body.Bytes(), &report)
+		return w.Code, report
+	}
+	code, report := check("GET", "/api/v1/integrity", token)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, 3, report.AccountsChecked)
+	assert.Empty(t, report.Discrepancies)
+	assert.Empty(t, report.Issues)
+
+	// 制造偏差：余额被直接改动 (其中一个账户已归档)、账户被删除后流水的引用被置空、分类不存在
+	db.Exec("UPDATE accounts SET balance = balance + ? WHERE id = ?", yuan(30), accountA)
+	db.Exec("UPDATE accounts SET balance = balance - ?, archived_at = '2024-06-01T00:00:00Z' WHERE id = ?", yuan(20), accountB)
+	db.Exec("DELETE FROM accounts WHERE id = ?", accountC)
+	db.Exec("UPDATE transactions SET category_id = 'no_such_category' WHERE id = ?", incomeID)
+
+	code, report = check("GET", "/api/v1/integrity", token)
+	assert.Equal(t, http.StatusOK, code)
+	if assert.Len(t, report.Discrepancies, 2) {
+		d := report.Discrepancies[0]
+		assert.Equal(t, accountA, d.AccountID)
+		assert.Equal(t, yuan(850), d.ExpectedBalance)
+		assert.Equal(t, yuan(880), d.ActualBalance)
+		assert.Equal(t, yuan(30), d.Difference)
+		assert.Equal(t, yuan(-20), report.Discrepancies[1].Difference)
+	}
+	codes := map[string]int64{}
+	for _, issue := range report.Issues {
+		codes[issue.Code] = issue.TransactionID
+	}
+	assert.Equal(t, map[string]int64{"missing_to_account": incomeID, "missing_category": incomeID}, codes)
+
+	// 管理员接口需要管理员权限，可按用户过滤
+	code, _ = check("GET", "/api/v1/admin/integrity", token)
+	assert.Equal(t, http.StatusForbidden, code)
+	code, report = check("GET", fmt.Sprintf("/api/v1/admin/integrity?user_id=%d", userID), adminToken)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Len(t, report.Discrepancies, 2)
+
+	// 修复：生成以权益为对方科目的调整流水，余额保持不变，归档账户也能修复
+	code, report = check("POST", "/api/v1/integrity/repair", token)
+	assert.Equal(t, http.StatusOK, code)
+	assert.True(t, report.Repaired)
+	if assert.Len(t, report.Discrepancies, 2) {
+		for _, d := range report.Discrepancies {
+			assert.Empty(t, d.RepairError)
+		}
+		if adjustmentID := report.Discrepancies[0].AdjustmentTransactionID; assert.NotNil(t, adjustmentID) {
+			var txType, description string
+			var amount Money
+			var categoryID *string
+			db.QueryRow("SELECT type, amount, description, category_id FROM transactions WHERE id = ?", *adjustmentID).Scan(&txType, &amount, &description, &categoryID)
+			assert.Equal(t, "adjustment", txType)
+			assert.Equal(t, yuan(30), amount)
+			assert.Equal(t, "余额校正", description)
+			assert.Nil(t, categoryID)
+			var accountTypes []string
+			rows, _ := db.Query("SELECT account_type FROM postings WHERE transaction_id = ? ORDER BY id", *adjustmentID)
+			for rows.Next() {
+				var accountType string
+				rows.Scan(&accountType)
+				accountTypes = append(accountTypes, accountType)
+			}
+			rows.Close()
+			assert.Equal(t, []string{postingAsset, postingEquity}, accountTypes)
+		}
+	}
+	var balance Money
+	db.QueryRow("SELECT balance FROM accounts WHERE id = ?", accountA).Scan(&balance)
+	assert.Equal(t, yuan(880), balance)
+	db.QueryRow("SELECT balance FROM accounts WHERE id = ?", accountB).Scan(&balance)
+	assert.Equal(t, yuan(30), balance)
+	// 调整不计入收支统计
+	var lines int
+	db.QueryRow("SELECT COUNT(*) FROM transaction_lines WHERE user_id = ? AND transaction_date >= '2024-06-01'", userID).Scan(&lines)
+	assert.Zero(t, lines)
+
+	code, report = check("GET", "/api/v1/integrity", token)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Empty(t, report.Discrepancies)
+	assert.Len(t, report.Issues, 2, "孤立引用需要人工处理")
+
+	// 管理员代为修复：审计记录的操作者为管理员，用户在自己的审计日志中也能看到
+	db.Exec("UPDATE accounts SET balance = balance + ? WHERE id = ?", yuan(5), accountA)
+	code, report = check("POST", fmt.Sprintf("/api/v1/admin/integrity/repair?user_id=%d", userID), adminToken)
+	assert.Equal(t, http.StatusOK, code)
+	if assert.Len(t, report.Discrepancies, 1) && assert.NotNil(t, report.Discrepancies[0].AdjustmentTransactionID) {
+		w := performRequest(router, "GET", "/api/v1/audit?entity=transaction&limit=1", nil, token)
+		assert.Equal(t, http.StatusOK, w.Code)
+		var audit AuditLogResponse
+		json.Unmarshal(w.Body.Bytes(), &audit)
+		if assert.Len(t, audit.Entries, 1) {
+			entry := audit.Entries[0]
+			assert.Equal(t, fmt.Sprint(*report.Discrepancies[0].AdjustmentTransactionID), entry.EntityID)
+			assert.Equal(t, int64(1), entry.UserID)
+			assert.Equal(t, userID, entry.OwnerID)
+			assert.NotNil(t, entry.After)
+		}
+	}
+}
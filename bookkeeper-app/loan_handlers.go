@@ -26,7 +26,14 @@ func (h *DBHandler) CreateLoan(c *gin.Context) {
 	status := "active"
 	createdAt := time.Now().Format(time.RFC3339)
 
-	_, err := h.DB.Exec(
+	tx, err := h.DB.Begin()
+	if err != nil {
+		h.Logger.Error("开启事务失败", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "开启事务失败"})
+		return
+	}
+	defer tx.Rollback()
+	res, err := tx.Exec(
 		"INSERT INTO loans(user_id, principal, interest_rate, loan_date, repayment_date, description, status, created_at) VALUES(?, ?, ?, ?, ?, ?, ?, ?)",
 		userID, req.Principal, *req.InterestRate, req.LoanDate, req.RepaymentDate, req.Description, status, createdAt,
 	)
@@ -35,6 +42,16 @@ func (h *DBHandler) CreateLoan(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "创建贷款失败"})
 		return
 	}
+	id, _ := res.LastInsertId()
+	if err := writeAudit(tx, auditActorFromContext(c), "loan", "create", id, nil); err != nil {
+		writeLedgerError(c, h.Logger, err)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		h.Logger.Error("提交事务失败", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "提交事务失败"})
+		return
+	}
 	c.JSON(http.StatusCreated, gin.H{"message": "贷款创建成功"})
 }
 
@@ -48,7 +65,19 @@ func (h *DBHandler) UpdateLoan(c *gin.Context) {
 		return
 	}
 
-	result, err := h.DB.Exec(
+	tx, err := h.DB.Begin()
+	if err != nil {
+		h.Logger.Error("开启事务失败", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "开启事务失败"})
+		return
+	}
+	defer tx.Rollback()
+	before, err := auditSnapshot(tx, "loan", userID.(int64), id)
+	if err != nil {
+		writeLedgerError(c, h.Logger, err)
+		return
+	}
+	result, err := tx.Exec(
 		"UPDATE loans SET principal=?, interest_rate=?, loan_date=?, repayment_date=?, description=? WHERE id=? AND user_id=?",
 		req.Principal, *req.InterestRate, req.LoanDate, req.RepaymentDate, req.Description, id, userID,
 	)
@@ -63,6 +92,15 @@ func (h *DBHandler) UpdateLoan(c *gin.Context) {
 		c.JSON(http.StatusNotFound, gin.H{"error": "未找到指定ID的贷款"})
 		return
 	}
+	if err := writeAudit(tx, auditActorFromContext(c), "loan", "update", id, before); err != nil {
+		writeLedgerError(c, h.Logger, err)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		h.Logger.Error("提交事务失败", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "提交事务失败"})
+		return
+	}
 	c.JSON(http.StatusOK, gin.H{"message": "贷款更新成功"})
 }
 
@@ -95,8 +133,8 @@ func (h *DBHandler) GetLoans(c *gin.Context) {
 			l.Description = &description.String
 		}
 
-		var totalRepaid float64
-		err := h.DB.QueryRow("SELECT COALESCE(SUM(amount), 0) FROM transactions WHERE user_id = ? AND type = 'repayment' AND related_loan_id = ?", userID, l.ID).Scan(&totalRepaid)
+		var totalRepaid Money
+		err := h.DB.QueryRow("SELECT COALESCE(SUM(amount), 0) FROM postings WHERE user_id = ? AND account_type = 'expense' AND loan_id = ?", userID, l.ID).Scan(&totalRepaid)
 		if err != nil {
 			logger.Error("计算已还款额失败", "error", err, "loanID", l.ID)
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "计算已还款额失败"})
@@ -140,7 +178,7 @@ func (h *DBHandler) SettleLoan(c *gin.Context) {
 	defer tx.Rollback()
 
 	// 1. 获取贷款信息并验证归属权
-	var principal float64
+	var principal Money
 	var loanDesc sql.NullString
 	err = tx.QueryRow("SELECT principal, description FROM loans WHERE id = ? AND user_id = ?", loanID, userID).Scan(&principal, &loanDesc)
 	if err != nil {
@@ -153,16 +191,16 @@ func (h *DBHandler) SettleLoan(c *gin.Context) {
 		return
 	}
 
-	var totalRepaid float64
-	tx.QueryRow("SELECT COALESCE(SUM(amount), 0) FROM transactions WHERE user_id = ? AND type = 'repayment' AND related_loan_id = ?", userID, loanID).Scan(&totalRepaid)
+	var totalRepaid Money
+	tx.QueryRow("SELECT COALESCE(SUM(amount), 0) FROM postings WHERE user_id = ? AND account_type = 'expense' AND loan_id = ?", userID, loanID).Scan(&totalRepaid)
 	outstandingBalance := principal - totalRepaid
 	if outstandingBalance <= 0 {
 		c.JSON(http.StatusConflict, gin.H{"error": "该贷款已还清或无需还款"})
 		return
 	}
 
-	// 2. 从指定账户扣款 (先验证账户归属和余额)
-	var fromAccountBalance float64
+	// 2. 验证扣款账户归属和余额
+	var fromAccountBalance Money
 	err = tx.QueryRow("SELECT balance FROM accounts WHERE id = ? AND user_id = ?", req.FromAccountID, userID).Scan(&fromAccountBalance)
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -174,34 +212,56 @@ func (h *DBHandler) SettleLoan(c *gin.Context) {
 		return
 	}
 
-	if fromAccountBalance < outstandingBalance {
-		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("扣款账户余额不足 (当前: %.2f, 需要: %.2f)", fromAccountBalance, outstandingBalance)})
+	if err := ensureAccountsActive(tx, &req.FromAccountID); err != nil {
+		writeLedgerError(c, logger, err)
+		return
+	}
+	if err := requireBaseCurrencyAccount(tx, userID.(int64), req.FromAccountID); err != nil {
+		writeLedgerError(c, logger, err)
 		return
 	}
 
-	_, err = tx.Exec("UPDATE accounts SET balance = balance - ? WHERE id = ?", outstandingBalance, req.FromAccountID)
-	if err != nil {
-		logger.Error("更新扣款账户余额失败", "error", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "更新扣款账户余额失败"})
+	if err := checkAvailableFunds(tx, req.FromAccountID, outstandingBalance, "扣款账户"); err != nil {
+		writeLedgerError(c, logger, err)
 		return
 	}
 
 	// 3. 创建还款流水
+	if err := ensureMonthOpen(tx, userID.(int64), req.RepaymentDate); err != nil {
+		writeLedgerError(c, logger, err)
+		return
+	}
 	description := req.Description
 	if description == "" {
 		description = fmt.Sprintf("还清贷款: %s", loanDesc.String)
 	}
 	createdAt := time.Now().Format(time.RFC3339)
 	loanRepaymentCategoryID := "loan_repayment"
-	_, err = tx.Exec(
-		"INSERT INTO transactions (user_id, type, amount, transaction_date, description, category_id, related_loan_id, from_account_id, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)",
-		userID, "repayment", outstandingBalance, req.RepaymentDate, description, loanRepaymentCategoryID, loanID, req.FromAccountID, createdAt,
+	res, err := tx.Exec(
+		"INSERT INTO transactions (user_id, type, amount, transaction_date, description, category_id, related_loan_id, from_account_id, currency, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, (SELECT currency FROM accounts WHERE id = ?), ?)",
+		userID, "repayment", outstandingBalance, req.RepaymentDate, description, loanRepaymentCategoryID, loanID, req.FromAccountID, req.FromAccountID, createdAt,
 	)
 	if err != nil {
 		logger.Error("创建还款流水失败", "error", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "创建还款流水失败"})
 		return
 	}
+	actor := auditActorFromContext(c)
+	repaymentID, _ := res.LastInsertId()
+	// 写入记账分录并扣减账户余额
+	if err := postTransaction(tx, repaymentID); err != nil {
+		writeLedgerError(c, logger, err)
+		return
+	}
+	if err := writeAudit(tx, actor, "transaction", "create", repaymentID, nil); err != nil {
+		writeLedgerError(c, logger, err)
+		return
+	}
+	loanBefore, err := auditSnapshot(tx, "loan", userID.(int64), loanID)
+	if err != nil {
+		writeLedgerError(c, logger, err)
+		return
+	}
 
 	// 4. 更新贷款状态
 	_, err = tx.Exec("UPDATE loans SET status = ?, repayment_date = ? WHERE id = ? AND user_id = ?", "paid", req.RepaymentDate, loanID, userID)
@@ -210,6 +270,10 @@ func (h *DBHandler) SettleLoan(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "更新贷款状态失败"})
 		return
 	}
+	if err := writeAudit(tx, actor, "loan", "update", loanID, loanBefore); err != nil {
+		writeLedgerError(c, logger, err)
+		return
+	}
 
 	if err := tx.Commit(); err != nil {
 		logger.Error("提交事务失败", "error", err)
@@ -232,8 +296,20 @@ func (h *DBHandler) UpdateLoanStatus(c *gin.Context) {
 		return
 	}
 
+	tx, err := h.DB.Begin()
+	if err != nil {
+		h.Logger.Error("开启事务失败", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "开启事务失败"})
+		return
+	}
+	defer tx.Rollback()
+	before, err := auditSnapshot(tx, "loan", userID.(int64), id)
+	if err != nil {
+		writeLedgerError(c, h.Logger, err)
+		return
+	}
 	query := "UPDATE loans SET status = ?, repayment_date = NULL WHERE id = ? AND user_id = ?"
-	res, err := h.DB.Exec(query, payload.Status, id, userID)
+	res, err := tx.Exec(query, payload.Status, id, userID)
 	if err != nil {
 		h.Logger.Error("恢复贷款状态失败", "error", err, "loanID", id, slog.Int64("userID", userID.(int64)))
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "恢复贷款状态失败"})
@@ -245,6 +321,15 @@ func (h *DBHandler) UpdateLoanStatus(c *gin.Context) {
 		c.JSON(http.StatusNotFound, gin.H{"error": "未找到指定ID的贷款"})
 		return
 	}
+	if err := writeAudit(tx, auditActorFromContext(c), "loan", "update", id, before); err != nil {
+		writeLedgerError(c, h.Logger, err)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		h.Logger.Error("提交事务失败", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "提交事务失败"})
+		return
+	}
 	c.JSON(http.StatusOK, gin.H{"message": "贷款状态已恢复为 'active'"})
 }
 
@@ -253,8 +338,16 @@ func (h *DBHandler) DeleteLoan(c *gin.Context) {
 	userID, _ := c.Get("userID")
 	id := c.Param("id")
 
+	tx, err := h.DB.Begin()
+	if err != nil {
+		h.Logger.Error("开启事务失败", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "开启事务失败"})
+		return
+	}
+	defer tx.Rollback()
+
 	var count int
-	err := h.DB.QueryRow("SELECT COUNT(*) FROM transactions WHERE related_loan_id = ? AND user_id = ?", id, userID).Scan(&count)
+	err = tx.QueryRow("SELECT COUNT(*) FROM transactions WHERE related_loan_id = ? AND user_id = ?", id, userID).Scan(&count)
 	if err != nil {
 		h.Logger.Error("检查贷款使用情况失败", "error", err, "loanID", id, slog.Int64("userID", userID.(int64)))
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "检查贷款使用情况失败"})
@@ -265,7 +358,12 @@ func (h *DBHandler) DeleteLoan(c *gin.Context) {
 		return
 	}
 
-	res, err := h.DB.Exec("DELETE FROM loans WHERE id = ? AND user_id = ?", id, userID)
+	before, err := auditSnapshot(tx, "loan", userID.(int64), id)
+	if err != nil {
+		writeLedgerError(c, h.Logger, err)
+		return
+	}
+	res, err := tx.Exec("DELETE FROM loans WHERE id = ? AND user_id = ?", id, userID)
 	if err != nil {
 		h.Logger.Error("删除贷款失败", "error", err, "loanID", id, slog.Int64("userID", userID.(int64)))
 		var sqliteErr sqlite3.Error
@@ -282,5 +380,14 @@ func (h *DBHandler) DeleteLoan(c *gin.Context) {
 		c.JSON(http.StatusNotFound, gin.H{"error": "未找到指定ID的贷款"})
 		return
 	}
+	if err := writeAudit(tx, auditActorFromContext(c), "loan", "delete", id, before); err != nil {
+		writeLedgerError(c, h.Logger, err)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		h.Logger.Error("提交事务失败", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "提交事务失败"})
+		return
+	}
 	c.JSON(http.StatusOK, gin.H{"message": "贷款删除成功"})
 }
@@ -2,6 +2,7 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"log/slog"
@@ -32,6 +33,11 @@ func initializeDB(logger *slog.Logger) (*sql.DB, error) {
 		return nil, fmt.Errorf("打开数据库失败: %w", err)
 	}
 
+	// === 0. 旧版本数据库：金额列由 REAL (元) 转换为 INTEGER (分)，需在其它结构变更之前、事务之外执行 ===
+	if err := migrateMoneyColumns(db, logger); err != nil {
+		return nil, err
+	}
+
 	// === 使用事务来确保所有表结构创建的原子性 ===
 	tx, err := db.Begin()
 	if err != nil {
@@ -94,7 +100,7 @@ func initializeDB(logger *slog.Logger) (*sql.DB, error) {
         "user_id" INTEGER NOT NULL,
         "name" TEXT NOT NULL,
         "type" TEXT NOT NULL,
-        "balance" REAL NOT NULL DEFAULT 0,
+        "balance" INTEGER NOT NULL DEFAULT 0, -- 金额均以分为单位存储
         "icon" TEXT,
         "is_primary" INTEGER NOT NULL DEFAULT 0,
         "created_at" TEXT NOT NULL,
@@ -114,7 +120,7 @@ func initializeDB(logger *slog.Logger) (*sql.DB, error) {
     CREATE TABLE IF NOT EXISTS loans (
         "id" INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
         "user_id" INTEGER NOT NULL,
-        "principal" REAL NOT NULL,
+        "principal" INTEGER NOT NULL,
         "interest_rate" REAL NOT NULL,
         "loan_date" TEXT NOT NULL,
         "repayment_date" TEXT,
@@ -132,7 +138,7 @@ func initializeDB(logger *slog.Logger) (*sql.DB, error) {
         "id" INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
         "user_id" INTEGER NOT NULL,
         "category_id" TEXT,
-        "amount" REAL NOT NULL,
+        "amount" INTEGER NOT NULL,
         "period" TEXT NOT NULL,
         "year" INTEGER,
         "month" INTEGER,
@@ -149,7 +155,7 @@ func initializeDB(logger *slog.Logger) (*sql.DB, error) {
         "id" INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
         "user_id" INTEGER NOT NULL,
         "type" TEXT NOT NULL,
-        "amount" REAL NOT NULL,
+        "amount" INTEGER NOT NULL,
         "transaction_date" TEXT NOT NULL,
         "description" TEXT,
         "created_at" TEXT NOT NULL,
@@ -193,12 +199,17 @@ func initializeDB(logger *slog.Logger) (*sql.DB, error) {
 		return nil, fmt.Errorf("创建 refresh_tokens 表失败: %w", err)
 	}
 
+	// === 2. 增量结构变更 (后续功能新增的表、列、索引等) ===
+	if err := migrateSchema(tx, logger); err != nil {
+		return nil, err
+	}
+
 	// 提交事务，完成所有表的创建
 	if err := tx.Commit(); err != nil {
 		return nil, fmt.Errorf("提交数据库结构创建事务失败: %w", err)
 	}
 
-	// === 2. 种子数据 (在表结构创建成功后执行) ===
+	// === 3. 种子数据 (在表结构创建成功后执行) ===
 	seedSharedCategories(db, logger)
 	seedAdminUser(db, logger)
 
@@ -206,6 +217,116 @@ func initializeDB(logger *slog.Logger) (*sql.DB, error) {
 	return db, nil
 }
 
+// migrateSchema 在基础表之上执行增量结构变更。所有语句都必须可重复执行，
+// 以便在新建数据库、旧版本数据库以及恢复的备份上都能安全运行。
+func migrateSchema(tx *sql.Tx, logger *slog.Logger) error {
+	// 多币种：币种列及汇率表 (transaction_lines 视图依赖这些列，需最先执行)
+	if err := setupCurrencies(tx); err != nil {
+		return err
+	}
+
+	// 退款与原支出的关联 (transaction_lines 视图依赖该列)
+	if err := setupRefunds(tx); err != nil {
+		return err
+	}
+
+	// 流水回收站 (transaction_lines 视图依赖 deleted_at 列)
+	if err := setupTrash(tx); err != nil {
+		return err
+	}
+
+	// 流水拆分明细
+	if err := setupTransactionSplits(tx); err != nil {
+		return err
+	}
+
+	// 周期记账规则
+	if err := setupRecurringRules(tx); err != nil {
+		return err
+	}
+
+	// 标签及流水-标签关联
+	if err := setupTags(tx); err != nil {
+		return err
+	}
+
+	// 流水附件
+	if err := setupAttachments(tx); err != nil {
+		return err
+	}
+
+	// 账单导入记录 (按外部交易号去重)
+	if err := setupImports(tx); err != nil {
+		return err
+	}
+
+	// 账户对账记录及流水清算标记
+	if err := setupReconciliations(tx); err != nil {
+		return err
+	}
+
+	// 月度结算快照
+	if err := setupSettlements(tx); err != nil {
+		return err
+	}
+
+	// 商户、商户别名及自动分类规则
+	if err := setupPayees(tx); err != nil {
+		return err
+	}
+
+	// 信用卡账户的额度、账单日和还款日
+	if err := setupCreditCards(tx); err != nil {
+		return err
+	}
+
+	// 账户归档
+	if err := setupAccountArchive(tx); err != nil {
+		return err
+	}
+
+	// 复式记账分录 (为已有流水补写分录) 及基于分录的 transaction_lines 视图
+	if err := setupPostings(tx); err != nil {
+		return err
+	}
+
+	// 账户期初余额，用于一致性检查 (由分录倒推，需在 setupPostings 之后执行)
+	if err := setupIntegrity(tx); err != nil {
+		return err
+	}
+
+	// 数据变更审计日志
+	if err := setupAudit(tx); err != nil {
+		return err
+	}
+
+	// 写操作的幂等键
+	if err := setupIdempotency(tx); err != nil {
+		return err
+	}
+
+	// 流水描述全文索引
+	setupTransactionFTS(tx, logger)
+
+	return nil
+}
+
+// addColumnIfMissing 为已有表补充新列 (SQLite 的 ALTER TABLE ADD COLUMN 不支持 IF NOT EXISTS)
+func addColumnIfMissing(tx *sql.Tx, table, column, definition string) error {
+	var count int
+	err := tx.QueryRow("SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?", table, column).Scan(&count)
+	if err != nil {
+		return fmt.Errorf("检查 %s.%s 列失败: %w", table, column, err)
+	}
+	if count > 0 {
+		return nil
+	}
+	if _, err := tx.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s", table, definition)); err != nil {
+		return fmt.Errorf("为 %s 表增加 %s 列失败: %w", table, column, err)
+	}
+	return nil
+}
+
 // ... (省略 hashPassword, seedSharedCategories, seedAdminUser, main 函数，它们不需要修改)
 
 func hashPassword(password string) (string, error) {
@@ -303,6 +424,15 @@ func main() {
 	handler := &DBHandler{DB: db, Logger: logger}
 	router := setupRouter(handler)
 
+	// 清理上次运行遗留的已删除附件文件
+	handler.purgeOrphanAttachments()
+
+	// 后台执行周期记账规则
+	go handler.StartRecurringScheduler(context.Background(), time.Hour)
+
+	// 后台清理超过保留期限的回收站流水
+	go handler.StartTrashPurger(context.Background(), time.Hour)
+
 	logger.Info("🚀 服务器启动于 http://localhost:8080")
 	if err := router.Run(":8080"); err != nil {
 		logger.Error("服务器启动失败", "error", err)
@@ -3,11 +3,14 @@ package main
 
 import (
 	"database/sql"
+	"fmt"
 	"io"
 	"log/slog"
+	"math"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"strings"
 	"testing"
 	"time"
 
@@ -16,8 +19,9 @@ import (
 
 // setupTestDB 创建一个用于测试的内存数据库
 func setupTestDB(t *testing.T) *sql.DB {
-	// 使用内存数据库
-	db, err := sql.Open("sqlite3", ":memory:?_foreign_keys=on")
+	// 使用内存数据库。以测试名命名并开启共享缓存，保证连接池中的多个连接 (例如遍历结果集时的嵌套查询) 访问同一个库
+	dsn := fmt.Sprintf("file:%s?mode=memory&cache=shared&_foreign_keys=on", strings.ReplaceAll(t.Name(), "/", "_"))
+	db, err := sql.Open("sqlite3", dsn)
 	if err != nil {
 		t.Fatalf("无法打开内存数据库: %v", err)
 	}
@@ -36,7 +40,7 @@ func setupTestDB(t *testing.T) *sql.DB {
 		`CREATE UNIQUE INDEX IF NOT EXISTS one_primary_account_per_user_idx ON accounts (user_id, is_primary) WHERE is_primary = 1;`,
 		`CREATE TABLE IF NOT EXISTS transactions ( "id" INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT, "user_id" INTEGER NOT NULL, "type" TEXT NOT NULL, "amount" REAL NOT NULL, "transaction_date" TEXT NOT NULL, "description" TEXT, "created_at" TEXT NOT NULL, "category_id" TEXT, "related_loan_id" INTEGER, "from_account_id" INTEGER, "to_account_id" INTEGER, "settlement_month" TEXT, FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE, FOREIGN KEY(related_loan_id) REFERENCES loans(id) ON DELETE SET NULL, FOREIGN KEY(from_account_id) REFERENCES accounts(id) ON DELETE SET NULL, FOREIGN KEY(to_account_id) REFERENCES accounts(id) ON DELETE SET NULL );`,
 		`CREATE UNIQUE INDEX IF NOT EXISTS one_settlement_per_month_per_user_idx ON transactions (user_id, settlement_month) WHERE settlement_month IS NOT NULL;`,
-		`CREATE TABLE IF NOT EXISTS budgets ( "id" INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT, "user_id" INTEGER NOT NULL, "category_id" TEXT, "amount" REAL NOT NULL, "period" TEXT NOT NULL, "created_at" TEXT NOT NULL, UNIQUE(user_id, period, category_id) );`,
+		`CREATE TABLE IF NOT EXISTS budgets ( "id" INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT, "user_id" INTEGER NOT NULL, "category_id" TEXT, "amount" REAL NOT NULL, "period" TEXT NOT NULL, "year" INTEGER, "month" INTEGER, "created_at" TEXT NOT NULL, FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE, UNIQUE(user_id, period, year, month, category_id) );`,
 		`CREATE TABLE IF NOT EXISTS refresh_tokens ( "id" INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT, "user_id" INTEGER NOT NULL, "token_hash" TEXT NOT NULL UNIQUE, "expires_at" TEXT NOT NULL, "created_at" TEXT NOT NULL, FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE );`,
 		`CREATE TABLE IF NOT EXISTS login_history ( "id" INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT, "user_id" INTEGER, "username_attempt" TEXT NOT NULL, "ip_address" TEXT, "user_agent" TEXT, "status" TEXT NOT NULL, "created_at" TEXT NOT NULL );`,
 	}
@@ -47,6 +51,26 @@ func setupTestDB(t *testing.T) *sql.DB {
 		}
 	}
 
+	// 以上为旧版本的表结构 (金额为 REAL)，与 initializeDB 一致先执行金额列迁移，再执行增量结构变更
+	if err := migrateMoneyColumns(db, logger); err != nil {
+		db.Close()
+		t.Fatalf("迁移金额列失败: %v", err)
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		db.Close()
+		t.Fatalf("开启测试数据库事务失败: %v", err)
+	}
+	if err := migrateSchema(tx, logger); err != nil {
+		tx.Rollback()
+		db.Close()
+		t.Fatalf("执行增量结构变更失败: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		db.Close()
+		t.Fatalf("提交增量结构变更失败: %v", err)
+	}
+
 	// 为测试数据库也创建 admin 用户和共享分类
 	seedSharedCategories(db, logger)
 	seedAdminUser(db, logger)
@@ -86,6 +110,11 @@ func getTestAuthToken(t *testing.T, userID int64, username string, isAdmin bool)
 	return token
 }
 
+// yuan 将以元为单位的金额转换为 Money，便于在测试中书写金额
+func yuan(v float64) Money {
+	return Money(math.Round(v * moneyScale))
+}
+
 // performRequest 执行一个HTTP测试请求
 func performRequest(r http.Handler, method, path string, body io.Reader, token ...string) *httptest.ResponseRecorder {
 	req, _ := http.NewRequest(method, path, body)
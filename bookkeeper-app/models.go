@@ -3,6 +3,7 @@ package main
 
 import (
 	"database/sql"
+	"encoding/json"
 	"log/slog"
 
 	"github.com/golang-jwt/jwt/v5"
@@ -84,7 +85,7 @@ type Transaction struct {
 	ID              int64   `json:"id"`
 	UserID          int64   `json:"-"`
 	Type            string  `json:"type"`
-	Amount          float64 `json:"amount"`
+	Amount          Money   `json:"amount"`
 	TransactionDate string  `json:"transaction_date"`
 	Description     string  `json:"description"`
 	RelatedLoanID   *int64  `json:"related_loan_id,omitempty"`
@@ -95,32 +96,282 @@ type Transaction struct {
 	FromAccountName *string `json:"from_account_name,omitempty"`
 	ToAccountID     *int64  `json:"to_account_id,omitempty"`
 	ToAccountName   *string `json:"to_account_name,omitempty"`
+	// Currency 金额所用币种；跨币种转账时 ToAmount 为转入账户实际入账的金额 (以转入账户币种计)
+	Currency string `json:"currency"`
+	ToAmount *Money `json:"to_amount,omitempty"`
+	// RefundOfID 退款流水对应的原支出；RefundOf 为其摘要，原支出一侧则通过 Refunds 列出全部退款
+	RefundOfID     *int64            `json:"refund_of_id,omitempty"`
+	RefundOf       *TransactionLink  `json:"refund_of,omitempty"`
+	Refunds        []TransactionLink `json:"refunds,omitempty"`
+	RefundedAmount Money             `json:"refunded_amount,omitempty"`
+	// Cleared 是否已与银行/支付平台账单核对；ReconciliationID 非空表示已在对账中锁定，不能再修改或删除
+	Cleared          bool   `json:"cleared"`
+	ReconciliationID *int64 `json:"reconciliation_id,omitempty"`
+	// SettlementMonth 流水所在月份已结算时为该月份 (YYYY-MM)，此时流水不能修改或删除
+	SettlementMonth *string `json:"settlement_month,omitempty"`
+	PayeeID         *int64  `json:"payee_id,omitempty"`
+	PayeeName       *string `json:"payee_name,omitempty"`
+	// DeletedAt 仅在回收站列表中出现：流水被删除的时间
+	DeletedAt *string `json:"deleted_at,omitempty"`
+
+	Splits []TransactionSplit `json:"splits,omitempty"`
+	Tags   []string           `json:"tags,omitempty"`
+}
+
+// TrashResponse 回收站列表，流水在删除 RetentionDays 天后被彻底清除
+type TrashResponse struct {
+	Transactions  []Transaction `json:"transactions"`
+	RetentionDays int           `json:"retention_days"`
+}
+
+// TransactionLink 关联流水的摘要 (用于展示退款链)
+type TransactionLink struct {
+	ID              int64  `json:"id"`
+	Amount          Money  `json:"amount"`
+	TransactionDate string `json:"transaction_date"`
+	Description     string `json:"description"`
+}
+
+// TransactionSplit 流水的拆分明细行，各行金额之和等于流水金额
+type TransactionSplit struct {
+	ID           int64   `json:"id"`
+	CategoryID   string  `json:"category_id"`
+	CategoryName *string `json:"category_name,omitempty"`
+	Amount       Money   `json:"amount"`
+	Note         string  `json:"note"`
+}
+type TransactionSplitRequest struct {
+	CategoryID string `json:"category_id" binding:"required"`
+	Amount     Money  `json:"amount" binding:"required,gt=0"`
+	Note       string `json:"note"`
 }
 type CreateTransactionRequest struct {
-	Type            string  `json:"type" binding:"required,oneof=income expense repayment transfer settlement"`
-	Amount          float64 `json:"amount" binding:"required,gt=0"`
+	Type            string  `json:"type" binding:"required,oneof=income expense repayment transfer settlement refund"`
+	Amount          Money   `json:"amount" binding:"required,gt=0"`
 	TransactionDate string  `json:"transaction_date" binding:"required"`
 	Description     string  `json:"description"`
 	CategoryID      *string `json:"category_id"`
 	RelatedLoanID   *int64  `json:"related_loan_id"`
 	FromAccountID   *int64  `json:"from_account_id"`
 	ToAccountID     *int64  `json:"to_account_id"`
+	// ToAmount 跨币种转账时必填：转入账户实际收到的金额 (以转入账户币种计)，同币种转账或其它类型流水忽略
+	ToAmount *Money `json:"to_amount" binding:"omitempty,gt=0"`
+	// RefundOfID 退款流水必填：被退款的原支出。退款默认退回原付款账户并沿用原分类
+	RefundOfID *int64 `json:"refund_of_id"`
+	// PayeeID 与 PayeeName 二选一：PayeeName 按商户名或别名匹配，不存在时自动创建商户
+	PayeeID   *int64 `json:"payee_id"`
+	PayeeName string `json:"payee_name" binding:"max=100"`
+
+	Splits []TransactionSplitRequest `json:"splits" binding:"omitempty,dive"`
+	// Tags 标签名列表，不存在的标签会自动创建。修改流水时不传 (null) 表示保留原有标签，传空数组表示清空
+	Tags []string `json:"tags" binding:"omitempty,max=20,dive,required,max=50"`
 }
 type GetTransactionsResponse struct {
 	Transactions []Transaction    `json:"transactions"`
 	Summary      FinancialSummary `json:"summary"`
+	NextCursor   *string          `json:"next_cursor,omitempty"`
+}
+
+// QuickEntryRequest 一句话记账，如 "午饭 35 微信 昨天"；commit 为 false 时只返回解析结果
+type QuickEntryRequest struct {
+	Text   string `json:"text" binding:"required,max=200"`
+	Commit bool   `json:"commit"`
+}
+
+// QuickEntryResponse 解析出的流水请求及匹配到的分类、账户名称，ID 仅在直接入账时返回
+type QuickEntryResponse struct {
+	Transaction     CreateTransactionRequest `json:"transaction"`
+	CategoryName    string                   `json:"category_name,omitempty"`
+	FromAccountName string                   `json:"from_account_name,omitempty"`
+	ToAccountName   string                   `json:"to_account_name,omitempty"`
+	ID              *int64                   `json:"id,omitempty"`
+}
+
+// BulkCreateTransactionsRequest 批量创建流水，dry_run 为 true 时只校验不提交
+type BulkCreateTransactionsRequest struct {
+	Items  []CreateTransactionRequest `json:"items" binding:"required,min=1"`
+	DryRun bool                       `json:"dry_run"`
+}
+
+// BulkDeleteTransactionsRequest 批量删除流水，dry_run 为 true 时只校验不提交
+type BulkDeleteTransactionsRequest struct {
+	IDs    []int64 `json:"ids" binding:"required,min=1"`
+	DryRun bool    `json:"dry_run"`
+}
+
+// BulkItemError 批量操作中单个条目的错误，Index 为条目在请求数组中的下标
+type BulkItemError struct {
+	Index  int    `json:"index"`
+	Status int    `json:"status"`
+	Error  string `json:"error"`
+}
+
+// TransactionSearchResult 搜索结果：流水本身加上高亮片段 (已做 HTML 转义的描述，命中处用 <mark> 包裹) 和相关度得分 (越大越相关)
+type TransactionSearchResult struct {
+	Transaction
+	Snippet string  `json:"snippet"`
+	Score   float64 `json:"score"`
 }
+
+// TransactionFilter GetTransactions 的筛选条件 (由查询参数解析而来)
+type TransactionFilter struct {
+	Year        string
+	Month       string
+	From        string // YYYY-MM-DD，包含
+	To          string // YYYY-MM-DD，包含
+	CategoryIDs []string
+	AccountIDs  []int64 // 匹配转出或转入账户
+	Types       []string
+	MinAmount   *Money
+	MaxAmount   *Money
+	Keyword     string  // 描述中包含的子串
+	TagIDs      []int64 // 带有任一指定标签
+	PayeeIDs    []int64
+}
+
+// transactionCursor 分页游标，对应排序键 (transaction_date, created_at, id)
+type transactionCursor struct {
+	Date      string `json:"d"`
+	CreatedAt string `json:"c"`
+	ID        int64  `json:"i"`
+}
+
+// FinancialSummary 金额均已按流水日期的汇率换算为用户本位币 (Currency)
 type FinancialSummary struct {
-	TotalIncome  float64 `json:"total_income"`
-	TotalExpense float64 `json:"total_expense"`
-	NetBalance   float64 `json:"net_balance"`
+	TotalIncome  Money  `json:"total_income"`
+	TotalExpense Money  `json:"total_expense"`
+	NetBalance   Money  `json:"net_balance"`
+	Currency     string `json:"currency"`
+}
+
+// Tag 跨分类的标签 (如 "日本旅行"、"可报销")，与流水为多对多关系
+type Tag struct {
+	ID               int64  `json:"id"`
+	Name             string `json:"name"`
+	TransactionCount int    `json:"transaction_count"`
+	CreatedAt        string `json:"created_at"`
+}
+type TagRequest struct {
+	Name string `json:"name" binding:"required,max=50"`
+}
+
+// Payee 商户/收款方。别名用于把账单中的不同写法 (如 "美团外卖"、"meituan") 归并到同一商户
+type Payee struct {
+	ID               int64    `json:"id"`
+	Name             string   `json:"name"`
+	Aliases          []string `json:"aliases"`
+	TransactionCount int      `json:"transaction_count"`
+	CreatedAt        string   `json:"created_at"`
+}
+type PayeeRequest struct {
+	Name    string   `json:"name" binding:"required,max=100"`
+	Aliases []string `json:"aliases" binding:"omitempty,max=50,dive,required,max=100"`
+}
+
+// PayeeRule 自动分类规则：新建或导入流水时，描述 (description) 或商户名/别名 (payee) 包含 Pattern 即命中，
+// 为流水补全未填写的商户、分类、标签和账户。按 Priority 从高到低只应用第一条命中的规则
+type PayeeRule struct {
+	ID         int64    `json:"id"`
+	MatchField string   `json:"match_field"`
+	Pattern    string   `json:"pattern"`
+	PayeeID    *int64   `json:"payee_id,omitempty"`
+	CategoryID *string  `json:"category_id,omitempty"`
+	Tags       []string `json:"tags,omitempty"`
+	AccountID  *int64   `json:"account_id,omitempty"`
+	Priority   int      `json:"priority"`
+	CreatedAt  string   `json:"created_at"`
+}
+type PayeeRuleRequest struct {
+	MatchField string   `json:"match_field" binding:"required,oneof=description payee"`
+	Pattern    string   `json:"pattern" binding:"required,max=100"`
+	PayeeID    *int64   `json:"payee_id"`
+	CategoryID *string  `json:"category_id"`
+	Tags       []string `json:"tags" binding:"omitempty,max=20,dive,required,max=50"`
+	AccountID  *int64   `json:"account_id"`
+	Priority   int      `json:"priority"`
+}
+
+// PayeeSpending 商户支出排行中的一项 (金额已换算为本位币，退款冲减原支出的商户)
+type PayeeSpending struct {
+	PayeeID          int64  `json:"payee_id"`
+	Name             string `json:"name"`
+	Total            Money  `json:"total"`
+	TransactionCount int    `json:"transaction_count"`
+}
+
+// Attachment 流水附件 (票据、发票等) 的元数据，文件本身存储在附件目录中
+type Attachment struct {
+	ID            int64  `json:"id"`
+	TransactionID int64  `json:"transaction_id"`
+	FileName      string `json:"file_name"`
+	MimeType      string `json:"mime_type"`
+	Size          int64  `json:"size"`
+	SHA256        string `json:"sha256"`
+	CreatedAt     string `json:"created_at"`
+}
+
+// CSVColumnMapping 通用 CSV 导入的列映射，值为 CSV 表头中的列名
+type CSVColumnMapping struct {
+	Date        string `json:"date" binding:"required"`
+	Amount      string `json:"amount" binding:"required"`
+	Direction   string `json:"direction"` // 收/支列，缺省时按金额正负判断 (负数为支出)
+	Description string `json:"description"`
+	ExternalID  string `json:"external_id"` // 外部交易号，用于去重
+	CategoryID  string `json:"category_id"` // 分类ID列
+}
+
+// CSVImportRow 账单导入的单行结果。Status 取值: new (待导入), imported (已导入), duplicate (重复), skipped (跳过), error (出错)
+type CSVImportRow struct {
+	Line          int                       `json:"line"`
+	ExternalID    string                    `json:"external_id,omitempty"`
+	Counterparty  string                    `json:"counterparty,omitempty"`
+	Status        string                    `json:"status"`
+	Reason        string                    `json:"reason,omitempty"`
+	Transaction   *CreateTransactionRequest `json:"transaction,omitempty"`
+	TransactionID *int64                    `json:"transaction_id,omitempty"`
+}
+
+// CSVImportResponse 账单导入结果，committed 为 false 时仅为预览
+type CSVImportResponse struct {
+	Source    string         `json:"source"`
+	Committed bool           `json:"committed"`
+	Rows      []CSVImportRow `json:"rows"`
+	Summary   map[string]int `json:"summary"`
+}
+
+// RecurringRule 周期记账规则：一个流水模板加上执行计划
+type RecurringRule struct {
+	ID              int64                    `json:"id"`
+	UserID          int64                    `json:"-"`
+	Template        CreateTransactionRequest `json:"template"`
+	Frequency       string                   `json:"frequency"`
+	DayOfMonth      *int                     `json:"day_of_month,omitempty"`
+	StartDate       string                   `json:"start_date"`
+	EndDate         *string                  `json:"end_date,omitempty"`
+	MaxOccurrences  *int                     `json:"max_occurrences,omitempty"`
+	OccurrenceCount int                      `json:"occurrence_count"`
+	NextRunDate     *string                  `json:"next_run_date,omitempty"` // 为空表示规则已执行完毕
+	IsActive        bool                     `json:"is_active"`
+	LastError       *string                  `json:"last_error,omitempty"`
+	CreatedAt       string                   `json:"created_at"`
+}
+
+// RecurringRuleRequest 创建/修改周期规则的请求体。模板中的 transaction_date 由计划决定，无需填写。
+type RecurringRuleRequest struct {
+	Template       CreateTransactionRequest `json:"template" binding:"-"`
+	Frequency      string                   `json:"frequency" binding:"required,oneof=daily weekly monthly yearly"`
+	DayOfMonth     *int                     `json:"day_of_month" binding:"omitempty,min=1,max=31"`
+	StartDate      string                   `json:"start_date" binding:"required"`
+	EndDate        *string                  `json:"end_date"`
+	MaxOccurrences *int                     `json:"max_occurrences" binding:"omitempty,gt=0"`
+	IsActive       *bool                    `json:"is_active"`
 }
 
 // Loan 相关模型，新增 UserID
 type Loan struct {
 	ID            int64   `json:"id"`
 	UserID        int64   `json:"-"`
-	Principal     float64 `json:"principal"`
+	Principal     Money   `json:"principal"`
 	InterestRate  float64 `json:"interest_rate"`
 	LoanDate      string  `json:"loan_date"`
 	RepaymentDate *string `json:"repayment_date,omitempty"`
@@ -129,7 +380,7 @@ type Loan struct {
 	CreatedAt     string  `json:"created_at"`
 }
 type UpdateLoanRequest struct {
-	Principal     float64  `json:"principal" binding:"required,gt=0"`
+	Principal     Money    `json:"principal" binding:"required,gt=0"`
 	InterestRate  *float64 `json:"interest_rate" binding:"required,gte=0"`
 	LoanDate      string   `json:"loan_date" binding:"required"`
 	RepaymentDate *string  `json:"repayment_date,omitempty"`
@@ -137,8 +388,8 @@ type UpdateLoanRequest struct {
 }
 type LoanResponse struct {
 	Loan
-	TotalRepaid        float64 `json:"total_repaid"`
-	OutstandingBalance float64 `json:"outstanding_balance"`
+	TotalRepaid        Money `json:"total_repaid"`
+	OutstandingBalance Money `json:"outstanding_balance"`
 }
 type SettleLoanRequest struct {
 	FromAccountID int64  `json:"from_account_id" binding:"required"`
@@ -151,11 +402,11 @@ type Budget struct {
 	ID           int64   `json:"id"`
 	UserID       int64   `json:"-"`
 	CategoryID   *string `json:"category_id"`
-	Amount       float64 `json:"amount"`
+	Amount       Money   `json:"amount"`
 	Period       string  `json:"period"`
 	CategoryName *string `json:"category_name,omitempty"`
-	Spent        float64 `json:"spent"`
-	Remaining    float64 `json:"remaining"`
+	Spent        Money   `json:"spent"`
+	Remaining    Money   `json:"remaining"`
 	Progress     float64 `json:"progress"`
 	Year         int     `json:"year"`
 	Month        int     `json:"month"`
@@ -164,7 +415,7 @@ type Budget struct {
 // 【修改】修正 CreateOrUpdateBudgetRequest 结构体
 type CreateOrUpdateBudgetRequest struct {
 	CategoryID *string `json:"category_id"`
-	Amount     float64 `json:"amount" binding:"required,gt=0"`
+	Amount     Money   `json:"amount" binding:"required,gt=0"`
 	Period     string  `json:"period" binding:"required,oneof=monthly yearly"`
 	Year       int     `json:"year"`  // 对于年度预算是必须的
 	Month      int     `json:"month"` // 对于月度预算是必须的
@@ -172,61 +423,251 @@ type CreateOrUpdateBudgetRequest struct {
 
 // Account 相关模型，新增 UserID
 type Account struct {
-	ID        int64   `json:"id"`
-	UserID    int64   `json:"-"`
-	Name      string  `json:"name"`
-	Type      string  `json:"type"`
-	Balance   float64 `json:"balance"`
-	Icon      string  `json:"icon"`
-	IsPrimary bool    `json:"is_primary"`
-	CreatedAt string  `json:"created_at"`
+	ID        int64  `json:"id"`
+	UserID    int64  `json:"-"`
+	Name      string `json:"name"`
+	Type      string `json:"type"`
+	Balance   Money  `json:"balance"`
+	Icon      string `json:"icon"`
+	IsPrimary bool   `json:"is_primary"`
+	Currency  string `json:"currency"`
+	CreatedAt string `json:"created_at"`
+	// 以下仅信用卡账户有：余额为负表示欠款，可用额度 = 信用额度 + 余额
+	CreditLimit     Money  `json:"credit_limit,omitempty"`
+	AvailableCredit *Money `json:"available_credit,omitempty"`
+	StatementDay    *int   `json:"statement_day,omitempty"`
+	PaymentDueDay   *int   `json:"payment_due_day,omitempty"`
+	// ArchivedAt 归档时间，未归档时为空
+	ArchivedAt *string `json:"archived_at,omitempty"`
 }
 type CreateAccountRequest struct {
-	Name    string  `json:"name" binding:"required"`
-	Type    string  `json:"type" binding:"required,oneof=wechat alipay card other"`
-	Balance float64 `json:"balance" binding:"gte=0"`
-	Icon    string  `json:"icon"`
+	Name string `json:"name" binding:"required"`
+	Type string `json:"type" binding:"required,oneof=wechat alipay card credit_card other"`
+	// Balance 初始余额；只有信用卡可以为负 (已有欠款)，且不能超过信用额度
+	Balance Money  `json:"balance"`
+	Icon    string `json:"icon"`
+	// Currency ISO 4217 币种代码，不填则使用用户本位币；创建后不可修改
+	Currency string `json:"currency" binding:"omitempty,iso4217"`
+	// 信用卡必填：信用额度、每月账单日和还款日 (1-31，当月没有这一天时取月末)
+	CreditLimit   Money `json:"credit_limit" binding:"gte=0"`
+	StatementDay  *int  `json:"statement_day" binding:"omitempty,min=1,max=31"`
+	PaymentDueDay *int  `json:"payment_due_day" binding:"omitempty,min=1,max=31"`
 }
 type UpdateAccountRequest struct {
 	Name string `json:"name" binding:"required"`
 	Icon string `json:"icon"`
+	// 仅信用卡账户可修改，不填则保持不变；额度不能低于当前欠款
+	CreditLimit   *Money `json:"credit_limit" binding:"omitempty,gt=0"`
+	StatementDay  *int   `json:"statement_day" binding:"omitempty,min=1,max=31"`
+	PaymentDueDay *int   `json:"payment_due_day" binding:"omitempty,min=1,max=31"`
+}
+
+// CreditCardStatement 信用卡的一期账单。余额为负表示欠款，AmountDue 为账单日的欠款金额，
+// PaidAmount 为账单日之后到还款日之间的还款；Status 为 open (未出账)、paid、unpaid 或 overdue
+type CreditCardStatement struct {
+	PeriodStart    string        `json:"period_start"`
+	PeriodEnd      string        `json:"period_end"`
+	DueDate        string        `json:"due_date"`
+	OpeningBalance Money         `json:"opening_balance"`
+	Charges        Money         `json:"charges"`
+	Credits        Money         `json:"credits"`
+	ClosingBalance Money         `json:"closing_balance"`
+	AmountDue      Money         `json:"amount_due"`
+	PaidAmount     Money         `json:"paid_amount"`
+	Status         string        `json:"status"`
+	Transactions   []Transaction `json:"transactions"`
 }
 type TransferRequest struct {
-	FromAccountID int64   `json:"from_account_id" binding:"required"`
-	ToAccountID   int64   `json:"to_account_id" binding:"required"`
-	Amount        float64 `json:"amount" binding:"required,gt=0"`
-	Date          string  `json:"date" binding:"required"`
-	Description   string  `json:"description"`
+	FromAccountID int64  `json:"from_account_id" binding:"required"`
+	ToAccountID   int64  `json:"to_account_id" binding:"required"`
+	Amount        Money  `json:"amount" binding:"required,gt=0"`
+	Date          string `json:"date" binding:"required"`
+	Description   string `json:"description"`
+}
+
+// Posting 一条记账分录：借方为正、贷方为负。AccountType 为 asset (账户)、income/expense (分类) 或 equity
+type Posting struct {
+	ID            int64   `json:"id"`
+	TransactionID int64   `json:"transaction_id"`
+	UserID        int64   `json:"-"`
+	AccountType   string  `json:"account_type"`
+	AccountID     *int64  `json:"account_id,omitempty"`
+	AccountName   *string `json:"account_name,omitempty"`
+	CategoryID    *string `json:"category_id,omitempty"`
+	LoanID        *int64  `json:"loan_id,omitempty"`
+	Amount        Money   `json:"amount"`
+	Currency      string  `json:"currency"`
+}
+
+// Reconciliation 一次已完成的账户对账记录
+type Reconciliation struct {
+	ID               int64  `json:"id"`
+	AccountID        int64  `json:"account_id"`
+	StatementDate    string `json:"statement_date"`
+	StatementBalance Money  `json:"statement_balance"`
+	// ClearedBalance 对账时截至账单日的已清算余额，Difference = StatementBalance - ClearedBalance
+	ClearedBalance          Money  `json:"cleared_balance"`
+	Difference              Money  `json:"difference"`
+	AdjustmentTransactionID *int64 `json:"adjustment_transaction_id,omitempty"`
+	TransactionCount        int    `json:"transaction_count"`
+	CreatedAt               string `json:"created_at"`
+}
+
+// ReconciliationPreview 对账进行中的状态：差额及截至账单日仍未清算的流水
+type ReconciliationPreview struct {
+	AccountID        int64         `json:"account_id"`
+	StatementDate    string        `json:"statement_date"`
+	StatementBalance Money         `json:"statement_balance"`
+	ClearedBalance   Money         `json:"cleared_balance"`
+	Difference       Money         `json:"difference"`
+	Uncleared        []Transaction `json:"uncleared"`
+}
+type ReconciliationRequest struct {
+	StatementDate    string `json:"statement_date" binding:"required"`
+	StatementBalance *Money `json:"statement_balance" binding:"required"`
+	// CreateAdjustment 存在差额时生成一笔调整流水补平，否则拒绝完成对账
+	CreateAdjustment bool `json:"create_adjustment"`
+}
+type TransactionClearedRequest struct {
+	Cleared *bool `json:"cleared" binding:"required"`
+}
+
+// Settlement 月度结算快照：结算时该月的收支合计 (本位币) 及各账户的期初、流入、流出和期末余额
+type Settlement struct {
+	ID               int64               `json:"id"`
+	Month            string              `json:"month"`
+	Currency         string              `json:"currency"`
+	TotalIncome      Money               `json:"total_income"`
+	TotalExpense     Money               `json:"total_expense"`
+	NetBalance       Money               `json:"net_balance"`
+	TransactionCount int                 `json:"transaction_count"`
+	Accounts         []SettlementAccount `json:"accounts,omitempty"`
+	CreatedAt        string              `json:"created_at"`
+}
+
+// SettlementAccount 账户在结算月内的余额变动 (以账户币种计，流入/流出包含转账)
+type SettlementAccount struct {
+	AccountID      *int64 `json:"account_id"`
+	AccountName    string `json:"account_name"`
+	Currency       string `json:"currency"`
+	OpeningBalance Money  `json:"opening_balance"`
+	Income         Money  `json:"income"`
+	Expense        Money  `json:"expense"`
+	ClosingBalance Money  `json:"closing_balance"`
+}
+
+// AccountBalance 账户在某日结束时的余额 (以账户币种计)
+type AccountBalance struct {
+	AccountID int64  `json:"account_id"`
+	Date      string `json:"date"`
+	Currency  string `json:"currency"`
+	Balance   Money  `json:"balance"`
+}
+
+// AccountBalanceHistory 账户在一段时间内按日/周/月汇总的余额变动
+type AccountBalanceHistory struct {
+	AccountID int64                 `json:"account_id"`
+	Currency  string                `json:"currency"`
+	From      string                `json:"from"`
+	To        string                `json:"to"`
+	Interval  string                `json:"interval"`
+	Points    []BalanceHistoryPoint `json:"points"`
+}
+
+// BalanceHistoryPoint 一个时间段的期初余额、流入、流出和期末余额；首尾时间段按 from/to 截断
+type BalanceHistoryPoint struct {
+	PeriodStart    string `json:"period_start"`
+	PeriodEnd      string `json:"period_end"`
+	OpeningBalance Money  `json:"opening_balance"`
+	Inflow         Money  `json:"inflow"`
+	Outflow        Money  `json:"outflow"`
+	ClosingBalance Money  `json:"closing_balance"`
+}
+
+// IntegrityReport 数据一致性检查结果：余额与流水推算值不一致的账户，以及流水中的孤立引用。
+// Repaired 为 true 表示已为余额偏差生成调整流水。
+type IntegrityReport struct {
+	CheckedAt       string               `json:"checked_at"`
+	AccountsChecked int                  `json:"accounts_checked"`
+	Discrepancies   []BalanceDiscrepancy `json:"discrepancies"`
+	Issues          []IntegrityIssue     `json:"issues"`
+	Repaired        bool                 `json:"repaired"`
+}
+
+// BalanceDiscrepancy 账户余额偏差：ExpectedBalance = OpeningBalance + TransactionTotal，Difference = ActualBalance - ExpectedBalance
+type BalanceDiscrepancy struct {
+	UserID           int64  `json:"user_id"`
+	AccountID        int64  `json:"account_id"`
+	AccountName      string `json:"account_name"`
+	Currency         string `json:"currency"`
+	OpeningBalance   Money  `json:"opening_balance"`
+	TransactionTotal Money  `json:"transaction_total"`
+	ExpectedBalance  Money  `json:"expected_balance"`
+	ActualBalance    Money  `json:"actual_balance"`
+	Difference       Money  `json:"difference"`
+	// 修复模式下：生成的调整流水ID，或无法修复的原因
+	AdjustmentTransactionID *int64 `json:"adjustment_transaction_id,omitempty"`
+	RepairError             string `json:"repair_error,omitempty"`
+}
+
+// IntegrityIssue 流水中的一处孤立引用，Code 如 missing_from_account、missing_loan
+type IntegrityIssue struct {
+	UserID        int64  `json:"user_id"`
+	TransactionID int64  `json:"transaction_id"`
+	Code          string `json:"code"`
+	Message       string `json:"message"`
+}
+
+// AuditLog 一条数据变更审计记录，Before/After 为变更前后整行数据的 JSON 快照
+type AuditLog struct {
+	ID        int64           `json:"id"`
+	UserID    int64           `json:"user_id"`
+	OwnerID   int64           `json:"owner_id"`
+	Entity    string          `json:"entity"`
+	EntityID  string          `json:"entity_id"`
+	Action    string          `json:"action"`
+	Before    json.RawMessage `json:"before,omitempty"`
+	After     json.RawMessage `json:"after,omitempty"`
+	IPAddress string          `json:"ip_address"`
+	UserAgent string          `json:"user_agent"`
+	CreatedAt string          `json:"created_at"`
+}
+
+// AuditLogResponse 审计记录分页结果，next_before_id 作为 before_id 传入以获取下一页
+type AuditLogResponse struct {
+	Entries      []AuditLog `json:"entries"`
+	NextBeforeID *int64     `json:"next_before_id,omitempty"`
 }
 
 // Dashboard & Analytics 相关模型 (这些是聚合数据，不需要 UserID)
 type DashboardCard struct {
-	Title     string  `json:"title"`
-	Value     float64 `json:"value"`
-	PrevValue float64 `json:"prev_value"`
-	Icon      string  `json:"icon"`
-	Meta      any     `json:"meta,omitempty"`
+	Title     string `json:"title"`
+	Value     Money  `json:"value"`
+	PrevValue Money  `json:"prev_value"`
+	Icon      string `json:"icon"`
+	Meta      any    `json:"meta,omitempty"`
 }
 type ChartDataPoint struct {
-	Name  string  `json:"name"`
-	Value float64 `json:"value"`
+	Name  string `json:"name"`
+	Value Money  `json:"value"`
 }
 type AnalyticsChartsResponse struct {
 	ExpenseTrend    []ChartDataPoint `json:"expense_trend"`
 	CategoryExpense []ChartDataPoint `json:"category_expense"`
+	TagExpense      []ChartDataPoint `json:"tag_expense"` // 带多个标签的流水会计入每个标签
 }
 type DashboardBudgetSummary struct {
 	Period   string  `json:"period"`
-	Amount   float64 `json:"amount"`
-	Spent    float64 `json:"spent"`
+	Amount   Money   `json:"amount"`
+	Spent    Money   `json:"spent"`
 	Progress float64 `json:"progress"`
 	IsSet    bool    `json:"is_set"`
 }
 type DashboardLoanInfo struct {
 	ID                      int64   `json:"id"`
 	Description             string  `json:"description"`
-	OutstandingBalance      float64 `json:"outstanding_balance"`
-	Principal               float64 `json:"principal"`
+	OutstandingBalance      Money   `json:"outstanding_balance"`
+	Principal               Money   `json:"principal"`
 	RepaymentAmountProgress float64 `json:"repayment_amount_progress"`
 	LoanDate                string  `json:"loan_date"`
 	RepaymentDate           *string `json:"repayment_date,omitempty"`
@@ -256,3 +697,22 @@ func getDefaultCategories() []Category {
 		{ID: "settlement", Name: "月度结算", Type: "internal", Icon: "BookCheck"},
 	}
 }
+
+// ExchangeRate 按日期生效的汇率：1 单位 FromCurrency = Rate 单位 ToCurrency
+type ExchangeRate struct {
+	ID           int64   `json:"id"`
+	FromCurrency string  `json:"from_currency"`
+	ToCurrency   string  `json:"to_currency"`
+	Rate         float64 `json:"rate"`
+	RateDate     string  `json:"rate_date"`
+	CreatedAt    string  `json:"created_at"`
+}
+type ExchangeRateRequest struct {
+	FromCurrency string  `json:"from_currency" binding:"required,iso4217"`
+	ToCurrency   string  `json:"to_currency" binding:"required,iso4217"`
+	Rate         float64 `json:"rate" binding:"required,gt=0"`
+	RateDate     string  `json:"rate_date" binding:"required"`
+}
+type BaseCurrencyRequest struct {
+	BaseCurrency string `json:"base_currency" binding:"required,iso4217"`
+}
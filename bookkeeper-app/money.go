@@ -0,0 +1,294 @@
+// bookkeeper-app/money.go
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Money 以最小货币单位 (分) 存储的金额。数据库中为 INTEGER，运算全部为整数运算，避免浮点累积误差；
+// JSON 中仍以两位小数的数字表示 (如 12.34)，与旧接口保持兼容。所有币种统一按两位小数计。
+type Money int64
+
+// moneyScale 每个货币单位包含的最小单位数
+const moneyScale = 100
+
+// maxMoneyDigits 整数部分允许的最大位数，保证换算为分后不会溢出 int64
+const maxMoneyDigits = 15
+
+// ParseMoney 精确解析十进制金额字符串 (如 "12.34"、"-5"、"0.1")，最多两位小数。
+// 不经过 float64，因此不会引入二进制浮点误差。
+func ParseMoney(s string) (Money, error) {
+	s = strings.TrimSpace(s)
+	negative := false
+	if strings.HasPrefix(s, "-") || strings.HasPrefix(s, "+") {
+		negative = s[0] == '-'
+		s = s[1:]
+	}
+	intPart, fracPart, _ := strings.Cut(s, ".")
+	// 允许超出两位的小数部分为 0，如 "1.500"
+	if len(fracPart) > 2 {
+		if strings.Trim(fracPart[2:], "0") != "" {
+			return 0, fmt.Errorf("金额最多两位小数: %s", s)
+		}
+		fracPart = fracPart[:2]
+	}
+	if intPart == "" && fracPart == "" {
+		return 0, fmt.Errorf("无效的金额: %q", s)
+	}
+	if intPart == "" {
+		intPart = "0"
+	}
+	if len(intPart) > maxMoneyDigits {
+		return 0, fmt.Errorf("金额过大: %s", s)
+	}
+	for _, r := range intPart + fracPart {
+		if r < '0' || r > '9' {
+			return 0, fmt.Errorf("无效的金额: %q", s)
+		}
+	}
+	units, _ := strconv.ParseInt(intPart, 10, 64)
+	cents, _ := strconv.ParseInt((fracPart + "00")[:2], 10, 64)
+	m := Money(units*moneyScale + cents)
+	if negative {
+		m = -m
+	}
+	return m, nil
+}
+
+// String 格式化为两位小数，如 "-12.05"
+func (m Money) String() string {
+	sign := ""
+	v := int64(m)
+	if v < 0 {
+		sign, v = "-", -v
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, v/moneyScale, v%moneyScale)
+}
+
+// Float64 返回以元为单位的浮点值，只用于计算比例 (如预算进度)，不得参与金额累加
+func (m Money) Float64() float64 {
+	return float64(m) / moneyScale
+}
+
+func (m Money) MarshalJSON() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+// UnmarshalJSON 同时接受 JSON 数字 (12.34) 和字符串 ("12.34")
+func (m *Money) UnmarshalJSON(data []byte) error {
+	s := string(data)
+	if s == "null" {
+		return nil
+	}
+	if unquoted, err := strconv.Unquote(s); err == nil {
+		s = unquoted
+	}
+	if strings.ContainsAny(s, "eE") {
+		return fmt.Errorf("金额不支持科学计数法: %s", s)
+	}
+	v, err := ParseMoney(s)
+	if err != nil {
+		return err
+	}
+	*m = v
+	return nil
+}
+
+// Scan 实现 sql.Scanner。新数据为 INTEGER (分)；REAL 仅可能来自未迁移的旧数据或 ROUND 等函数的结果，按分四舍五入。
+func (m *Money) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case nil:
+		*m = 0
+	case int64:
+		*m = Money(v)
+	case float64:
+		*m = Money(math.Round(v))
+	case []byte:
+		return m.scanString(string(v))
+	case string:
+		return m.scanString(v)
+	default:
+		return errors.New("无法将数据库值转换为金额")
+	}
+	return nil
+}
+
+func (m *Money) scanString(s string) error {
+	v, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return fmt.Errorf("无法将数据库值 %q 转换为金额", s)
+	}
+	*m = Money(v)
+	return nil
+}
+
+// Value 实现 driver.Valuer，以分为单位写入数据库
+func (m Money) Value() (driver.Value, error) {
+	return int64(m), nil
+}
+
+// moneyColumns 以分存储的金额列 (按表名)。汇率、利率等比例值不是金额，仍为 REAL。
+var moneyColumns = []struct {
+	table   string
+	columns []string
+}{
+	{"accounts", []string{"balance"}},
+	{"loans", []string{"principal"}},
+	{"budgets", []string{"amount"}},
+	{"transactions", []string{"amount", "to_amount"}},
+	{"transaction_splits", []string{"amount"}},
+}
+
+// migrateMoneyColumns 将旧版本中以 REAL (元) 存储的金额列无损转换为 INTEGER (分)。
+// SQLite 不支持修改列类型，只能重建表；重建会 DROP 旧表，为避免触发 ON DELETE CASCADE，
+// 必须在关闭外键约束的独立连接上执行 (PRAGMA foreign_keys 在事务内无效)，因此不能放进 migrateSchema。
+// 已是 INTEGER 的表 (新建数据库或已迁移过) 会被跳过。
+func migrateMoneyColumns(db *sql.DB, logger *slog.Logger) error {
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("获取数据库连接失败: %w", err)
+	}
+	defer conn.Close()
+
+	type pending struct {
+		table   string
+		columns []string
+	}
+	var todo []pending
+	for _, mc := range moneyColumns {
+		var realColumns []string
+		for _, col := range mc.columns {
+			var count int
+			err := conn.QueryRowContext(ctx, "SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ? AND upper(type) = 'REAL'", mc.table, col).Scan(&count)
+			if err != nil {
+				return fmt.Errorf("检查 %s.%s 列类型失败: %w", mc.table, col, err)
+			}
+			if count > 0 {
+				realColumns = append(realColumns, col)
+			}
+		}
+		if len(realColumns) > 0 {
+			todo = append(todo, pending{mc.table, realColumns})
+		}
+	}
+	if len(todo) == 0 {
+		return nil
+	}
+
+	if _, err := conn.ExecContext(ctx, "PRAGMA foreign_keys = OFF"); err != nil {
+		return fmt.Errorf("关闭外键约束失败: %w", err)
+	}
+	defer conn.ExecContext(ctx, "PRAGMA foreign_keys = ON")
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("开启金额迁移事务失败: %w", err)
+	}
+	defer tx.Rollback()
+
+	// 视图引用了待重建的表，重命名时会校验失败；migrateSchema 会重新创建它
+	if _, err := tx.Exec("DROP VIEW IF EXISTS transaction_lines"); err != nil {
+		return fmt.Errorf("删除 transaction_lines 视图失败: %w", err)
+	}
+	for _, p := range todo {
+		if err := rebuildWithMoneyColumns(tx, p.table, p.columns); err != nil {
+			return err
+		}
+		logger.Info("金额列已转换为以分存储", "table", p.table, "columns", p.columns)
+	}
+
+	// 重建过程中外键约束处于关闭状态，提交前确认没有破坏引用完整性
+	rows, err := tx.Query("PRAGMA foreign_key_check")
+	if err != nil {
+		return fmt.Errorf("外键检查失败: %w", err)
+	}
+	violated := rows.Next()
+	rows.Close()
+	if violated {
+		return errors.New("金额迁移后外键检查未通过")
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("提交金额迁移事务失败: %w", err)
+	}
+	return nil
+}
+
+// rebuildWithMoneyColumns 按 SQLite 推荐的方式重建表：以新列类型建临时表、复制数据、删除旧表、改名，
+// 最后恢复原表上的索引和触发器。金额按 ROUND(元 × 100) 转换为分。
+func rebuildWithMoneyColumns(tx *sql.Tx, table string, columns []string) error {
+	var createSQL string
+	if err := tx.QueryRow("SELECT sql FROM sqlite_master WHERE type = 'table' AND name = ?", table).Scan(&createSQL); err != nil {
+		return fmt.Errorf("读取 %s 表结构失败: %w", table, err)
+	}
+
+	var extras []string
+	rows, err := tx.Query("SELECT sql FROM sqlite_master WHERE tbl_name = ? AND type IN ('index', 'trigger') AND sql IS NOT NULL", table)
+	if err != nil {
+		return fmt.Errorf("读取 %s 的索引和触发器失败: %w", table, err)
+	}
+	for rows.Next() {
+		var stmt string
+		if err := rows.Scan(&stmt); err != nil {
+			rows.Close()
+			return err
+		}
+		extras = append(extras, stmt)
+	}
+	rows.Close()
+
+	var allColumns []string
+	rows, err = tx.Query("SELECT name FROM pragma_table_info(?) ORDER BY cid", table)
+	if err != nil {
+		return fmt.Errorf("读取 %s 的列失败: %w", table, err)
+	}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return err
+		}
+		allColumns = append(allColumns, name)
+	}
+	rows.Close()
+
+	tmpTable := table + "__money_new"
+	newSQL := regexp.MustCompile(`^CREATE TABLE\s+"?`+table+`"?`).ReplaceAllString(createSQL, `CREATE TABLE "`+tmpTable+`"`)
+	isMoney := make(map[string]bool, len(columns))
+	for _, col := range columns {
+		isMoney[col] = true
+		newSQL = regexp.MustCompile(`(?i)([\s,(]"?`+col+`"?\s+)REAL\b`).ReplaceAllString(newSQL, "${1}INTEGER")
+	}
+
+	quoted := make([]string, len(allColumns))
+	selects := make([]string, len(allColumns))
+	for i, col := range allColumns {
+		quoted[i] = `"` + col + `"`
+		selects[i] = quoted[i]
+		if isMoney[col] {
+			selects[i] = fmt.Sprintf(`CAST(ROUND("%s" * %d) AS INTEGER)`, col, moneyScale)
+		}
+	}
+
+	stmts := []string{
+		newSQL,
+		fmt.Sprintf(`INSERT INTO "%s" (%s) SELECT %s FROM "%s"`, tmpTable, strings.Join(quoted, ", "), strings.Join(selects, ", "), table),
+		fmt.Sprintf(`DROP TABLE "%s"`, table),
+		fmt.Sprintf(`ALTER TABLE "%s" RENAME TO "%s"`, tmpTable, table),
+	}
+	for _, stmt := range append(stmts, extras...) {
+		if _, err := tx.Exec(stmt); err != nil {
+			return fmt.Errorf("重建 %s 表失败: %w", table, err)
+		}
+	}
+	return nil
+}
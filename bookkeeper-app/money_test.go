@@ -0,0 +1,92 @@
+// bookkeeper-app/money_test.go
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// 测试金额的精确解析、格式化及 JSON 编解码
+func TestMoney(t *testing.T) {
+	for input, want := range map[string]Money{"12.34": 1234, "-5": -500, "0.1": 10, ".5": 50, "1.500": 150, "+3.07": 307} {
+		got, err := ParseMoney(input)
+		assert.NoError(t, err, input)
+		assert.Equal(t, want, got, input)
+	}
+	for _, input := range []string{"", "abc", "1.234", "1,000", "1234567890123456"} {
+		_, err := ParseMoney(input)
+		assert.Error(t, err, input)
+	}
+
+	assert.Equal(t, "-12.05", Money(-1205).String())
+	assert.Equal(t, "0.30", (Money(10) + Money(20)).String())
+
+	var req struct {
+		A Money  `json:"a"`
+		B Money  `json:"b"`
+		C *Money `json:"c"`
+	}
+	assert.NoError(t, json.Unmarshal([]byte(`{"a":0.1,"b":"19.99","c":null}`), &req))
+	assert.Equal(t, Money(10), req.A)
+	assert.Equal(t, Money(1999), req.B)
+	assert.Nil(t, req.C)
+	assert.Error(t, json.Unmarshal([]byte(`{"a":1e3}`), &req))
+
+	out, _ := json.Marshal(map[string]Money{"v": 1999})
+	assert.JSONEq(t, `{"v":19.99}`, string(out))
+}
+
+// 测试旧版 REAL 金额列迁移为分：数值无误差，且重建表不会级联删除关联数据
+func TestMigrateMoneyColumns(t *testing.T) {
+	db, err := sql.Open("sqlite3", "file:TestMigrateMoneyColumns?mode=memory&cache=shared&_foreign_keys=on")
+	if err != nil {
+		t.Fatalf("无法打开内存数据库: %v", err)
+	}
+	defer db.Close()
+	logger := slog.New(slog.NewJSONHandler(io.Discard, nil))
+
+	for _, stmt := range []string{
+		`CREATE TABLE accounts ( "id" INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT, "balance" REAL NOT NULL DEFAULT 0 );`,
+		`CREATE TABLE transactions ( "id" INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT, "amount" REAL NOT NULL, "to_amount" REAL, "from_account_id" INTEGER, FOREIGN KEY(from_account_id) REFERENCES accounts(id) ON DELETE CASCADE );`,
+		`CREATE INDEX idx_transactions_account ON transactions (from_account_id);`,
+		`INSERT INTO accounts (id, balance) VALUES (1, 0.1 + 0.2), (2, -1234.56);`,
+		`INSERT INTO transactions (amount, to_amount, from_account_id) VALUES (19.99, NULL, 1), (0.07, 1.01, 2);`,
+	} {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatalf("准备旧版数据失败: %v", err)
+		}
+	}
+
+	assert.NoError(t, migrateMoneyColumns(db, logger))
+	// 再次执行应被跳过
+	assert.NoError(t, migrateMoneyColumns(db, logger))
+
+	var balances []Money
+	rows, _ := db.Query("SELECT balance FROM accounts ORDER BY id")
+	for rows.Next() {
+		var m Money
+		rows.Scan(&m)
+		balances = append(balances, m)
+	}
+	rows.Close()
+	assert.Equal(t, []Money{30, -123456}, balances)
+
+	var count int
+	db.QueryRow("SELECT COUNT(*) FROM transactions WHERE typeof(amount) = 'integer'").Scan(&count)
+	assert.Equal(t, 2, count, "重建 accounts 表不应级联删除流水")
+	var amount, toAmount Money
+	db.QueryRow("SELECT amount, to_amount FROM transactions WHERE from_account_id = 2").Scan(&amount, &toAmount)
+	assert.Equal(t, Money(7), amount)
+	assert.Equal(t, Money(101), toAmount)
+
+	var columnType string
+	db.QueryRow("SELECT type FROM pragma_table_info('transactions') WHERE name = 'to_amount'").Scan(&columnType)
+	assert.Equal(t, "INTEGER", columnType)
+	db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE name = 'idx_transactions_account'").Scan(&count)
+	assert.Equal(t, 1, count, "索引应在重建后恢复")
+}
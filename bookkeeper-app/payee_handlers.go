@@ -0,0 +1,685 @@
+// bookkeeper-app/payee_handlers.go
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultPayeeReportLimit = 10
+	maxPayeeReportLimit     = 100
+)
+
+// setupPayees 创建商户、商户别名及自动分类规则表，并为流水增加 payee_id 列。
+// 商户名和别名按用户唯一且不区分大小写；删除商户时流水和规则上的引用置空。
+func setupPayees(tx *sql.Tx) error {
+	if _, err := tx.Exec(`
+    CREATE TABLE IF NOT EXISTS payees (
+        "id" INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+        "user_id" INTEGER NOT NULL,
+        "name" TEXT NOT NULL COLLATE NOCASE,
+        "created_at" TEXT NOT NULL,
+        FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE,
+        UNIQUE(user_id, name)
+    );`); err != nil {
+		return fmt.Errorf("创建 payees 表失败: %w", err)
+	}
+	if _, err := tx.Exec(`
+    CREATE TABLE IF NOT EXISTS payee_aliases (
+        "id" INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+        "user_id" INTEGER NOT NULL,
+        "payee_id" INTEGER NOT NULL,
+        "alias" TEXT NOT NULL COLLATE NOCASE,
+        FOREIGN KEY(payee_id) REFERENCES payees(id) ON DELETE CASCADE,
+        UNIQUE(user_id, alias)
+    );`); err != nil {
+		return fmt.Errorf("创建 payee_aliases 表失败: %w", err)
+	}
+	if _, err := tx.Exec(`CREATE INDEX IF NOT EXISTS idx_payee_aliases_payee ON payee_aliases (payee_id);`); err != nil {
+		return fmt.Errorf("为 payee_aliases 创建索引失败: %w", err)
+	}
+	if _, err := tx.Exec(`
+    CREATE TABLE IF NOT EXISTS payee_rules (
+        "id" INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+        "user_id" INTEGER NOT NULL,
+        "match_field" TEXT NOT NULL,
+        "pattern" TEXT NOT NULL,
+        "payee_id" INTEGER,
+        "category_id" TEXT,
+        "tags" TEXT,
+        "account_id" INTEGER,
+        "priority" INTEGER NOT NULL DEFAULT 0,
+        "created_at" TEXT NOT NULL,
+        FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE,
+        FOREIGN KEY(payee_id) REFERENCES payees(id) ON DELETE SET NULL,
+        FOREIGN KEY(account_id) REFERENCES accounts(id) ON DELETE SET NULL
+    );`); err != nil {
+		return fmt.Errorf("创建 payee_rules 表失败: %w", err)
+	}
+	if err := addColumnIfMissing(tx, "transactions", "payee_id", `"payee_id" INTEGER REFERENCES payees(id) ON DELETE SET NULL`); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`CREATE INDEX IF NOT EXISTS idx_transactions_payee ON transactions (payee_id) WHERE payee_id IS NOT NULL;`); err != nil {
+		return fmt.Errorf("为 transactions.payee_id 创建索引失败: %w", err)
+	}
+	return nil
+}
+
+// normalizePayeeAliases 去除首尾空白并去重 (不区分大小写)，与商户名相同的别名忽略
+func normalizePayeeAliases(name string, aliases []string) ([]string, error) {
+	seen := map[string]bool{strings.ToLower(name): true}
+	result := make([]string, 0, len(aliases))
+	for _, alias := range aliases {
+		alias = strings.TrimSpace(alias)
+		if alias == "" {
+			return nil, &ledgerError{Status: http.StatusBadRequest, Message: "商户别名不能为空"}
+		}
+		key := strings.ToLower(alias)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		result = append(result, alias)
+	}
+	return result, nil
+}
+
+// savePayeeAliases 用给定的别名整体替换商户现有的别名
+func savePayeeAliases(tx *sql.Tx, userID, payeeID int64, name string, aliases []string) error {
+	aliases, err := normalizePayeeAliases(name, aliases)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM payee_aliases WHERE payee_id = ?", payeeID); err != nil {
+		return &ledgerError{Status: http.StatusInternalServerError, Message: "清除旧别名失败", Err: err}
+	}
+	for _, alias := range aliases {
+		if _, err := tx.Exec("INSERT INTO payee_aliases (user_id, payee_id, alias) VALUES (?, ?, ?)", userID, payeeID, alias); err != nil {
+			if isUniqueViolation(err) {
+				return &ledgerError{Status: http.StatusConflict, Message: fmt.Sprintf("别名「%s」已被其他商户使用", alias)}
+			}
+			return &ledgerError{Status: http.StatusInternalServerError, Message: "保存商户别名失败", Err: err}
+		}
+	}
+	return nil
+}
+
+// findPayee 按商户名或别名 (不区分大小写) 查找商户，商户名优先
+func findPayee(tx *sql.Tx, userID int64, text string) (int64, bool, error) {
+	var id int64
+	err := tx.QueryRow(`
+        SELECT id FROM (
+            SELECT id, 0 AS rank FROM payees WHERE user_id = ? AND name = ?
+            UNION ALL
+            SELECT payee_id, 1 FROM payee_aliases WHERE user_id = ? AND alias = ?
+        ) ORDER BY rank LIMIT 1`,
+		userID, text, userID, text).Scan(&id)
+	if err == sql.ErrNoRows {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	return id, true, nil
+}
+
+// resolvePayee 确定流水的商户：校验 payee_id 的归属权，或按 payee_name 匹配商户名/别名，不存在时自动创建
+func resolvePayee(tx *sql.Tx, userID int64, req *CreateTransactionRequest) error {
+	if req.PayeeID != nil {
+		if !isOwner(tx, userID, "payees", *req.PayeeID) {
+			return &ledgerError{Status: http.StatusForbidden, Message: "无权使用该商户"}
+		}
+		return nil
+	}
+	name := strings.TrimSpace(req.PayeeName)
+	if name == "" {
+		return nil
+	}
+	id, ok, err := findPayee(tx, userID, name)
+	if err != nil {
+		return &ledgerError{Status: http.StatusInternalServerError, Message: "查询商户失败", Err: err}
+	}
+	if !ok {
+		res, err := tx.Exec("INSERT INTO payees (user_id, name, created_at) VALUES (?, ?, ?)", userID, name, time.Now().Format(time.RFC3339))
+		if err != nil {
+			return &ledgerError{Status: http.StatusInternalServerError, Message: "创建商户失败", Err: err}
+		}
+		id, _ = res.LastInsertId()
+	}
+	req.PayeeID = &id
+	return nil
+}
+
+// payeeRuleColumns 与 scanPayeeRule 对应的查询列
+const payeeRuleColumns = "id, match_field, pattern, payee_id, category_id, tags, account_id, priority, created_at"
+
+func scanPayeeRule(row interface{ Scan(...interface{}) error }) (PayeeRule, error) {
+	var rule PayeeRule
+	var payeeID, accountID sql.NullInt64
+	var categoryID, tags sql.NullString
+	if err := row.Scan(&rule.ID, &rule.MatchField, &rule.Pattern, &payeeID, &categoryID, &tags, &accountID, &rule.Priority, &rule.CreatedAt); err != nil {
+		return rule, err
+	}
+	if payeeID.Valid {
+		rule.PayeeID = &payeeID.Int64
+	}
+	if categoryID.Valid {
+		rule.CategoryID = &categoryID.String
+	}
+	if accountID.Valid {
+		rule.AccountID = &accountID.Int64
+	}
+	if tags.Valid {
+		if err := json.Unmarshal([]byte(tags.String), &rule.Tags); err != nil {
+			return rule, fmt.Errorf("解析规则 %d 的标签失败: %w", rule.ID, err)
+		}
+	}
+	return rule, nil
+}
+
+// applyPayeeRules 按优先级找到第一条命中的规则，为流水补全用户未填写的商户、分类、标签和账户
+func applyPayeeRules(tx *sql.Tx, userID int64, req *CreateTransactionRequest) error {
+	rows, err := tx.Query("SELECT "+payeeRuleColumns+" FROM payee_rules WHERE user_id = ? ORDER BY priority DESC, id ASC", userID)
+	if err != nil {
+		return &ledgerError{Status: http.StatusInternalServerError, Message: "查询自动分类规则失败", Err: err}
+	}
+	var rules []PayeeRule
+	for rows.Next() {
+		rule, err := scanPayeeRule(rows)
+		if err != nil {
+			rows.Close()
+			return &ledgerError{Status: http.StatusInternalServerError, Message: "读取自动分类规则失败", Err: err}
+		}
+		rules = append(rules, rule)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return &ledgerError{Status: http.StatusInternalServerError, Message: "读取自动分类规则失败", Err: err}
+	}
+	if len(rules) == 0 {
+		return nil
+	}
+
+	// 商户规则匹配商户名及其全部别名
+	var payeeNames []string
+	if req.PayeeID != nil {
+		rows, err := tx.Query(`
+            SELECT name FROM payees WHERE id = ?
+            UNION ALL
+            SELECT alias FROM payee_aliases WHERE payee_id = ?`, *req.PayeeID, *req.PayeeID)
+		if err != nil {
+			return &ledgerError{Status: http.StatusInternalServerError, Message: "查询商户别名失败", Err: err}
+		}
+		for rows.Next() {
+			var name string
+			if err := rows.Scan(&name); err != nil {
+				rows.Close()
+				return &ledgerError{Status: http.StatusInternalServerError, Message: "查询商户别名失败", Err: err}
+			}
+			payeeNames = append(payeeNames, strings.ToLower(name))
+		}
+		rows.Close()
+	}
+
+	description := strings.ToLower(req.Description)
+	for _, rule := range rules {
+		pattern := strings.ToLower(rule.Pattern)
+		matched := false
+		switch rule.MatchField {
+		case "description":
+			matched = strings.Contains(description, pattern)
+		case "payee":
+			for _, name := range payeeNames {
+				if strings.Contains(name, pattern) {
+					matched = true
+					break
+				}
+			}
+		}
+		if !matched {
+			continue
+		}
+
+		if req.PayeeID == nil {
+			req.PayeeID = rule.PayeeID
+		}
+		// 拆分流水的分类由各明细决定，退款沿用原支出的分类
+		if req.CategoryID == nil && len(req.Splits) == 0 && (req.Type == "income" || req.Type == "expense") {
+			req.CategoryID = rule.CategoryID
+		}
+		if len(req.Tags) == 0 && len(rule.Tags) > 0 {
+			req.Tags = rule.Tags
+		}
+		if rule.AccountID != nil {
+			switch req.Type {
+			case "expense", "repayment":
+				if req.FromAccountID == nil {
+					req.FromAccountID = rule.AccountID
+				}
+			case "income":
+				if req.ToAccountID == nil {
+					req.ToAccountID = rule.AccountID
+				}
+			}
+		}
+		return nil
+	}
+	return nil
+}
+
+// GetPayees 获取当前用户的全部商户、别名及各自关联的流水数量
+func (h *DBHandler) GetPayees(c *gin.Context) {
+	userID, _ := c.Get("userID")
+	logger := h.Logger.With(slog.Int64("userID", userID.(int64)))
+
+	rows, err := h.DB.Query(`
+        SELECT p.id, p.name, p.created_at, COUNT(t.id)
+        FROM payees p
+        LEFT JOIN transactions t ON t.payee_id = p.id AND t.deleted_at IS NULL
+        WHERE p.user_id = ?
+        GROUP BY p.id
+        ORDER BY p.name`, userID)
+	if err != nil {
+		logger.Error("查询商户失败", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "获取商户失败"})
+		return
+	}
+	defer rows.Close()
+
+	payees := []Payee{}
+	index := map[int64]int{}
+	for rows.Next() {
+		payee := Payee{Aliases: []string{}}
+		if err := rows.Scan(&payee.ID, &payee.Name, &payee.CreatedAt, &payee.TransactionCount); err != nil {
+			logger.Error("扫描商户数据失败", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "获取商户失败"})
+			return
+		}
+		index[payee.ID] = len(payees)
+		payees = append(payees, payee)
+	}
+	rows.Close()
+
+	aliasRows, err := h.DB.Query("SELECT payee_id, alias FROM payee_aliases WHERE user_id = ? ORDER BY alias", userID)
+	if err != nil {
+		logger.Error("查询商户别名失败", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "获取商户失败"})
+		return
+	}
+	defer aliasRows.Close()
+	for aliasRows.Next() {
+		var payeeID int64
+		var alias string
+		if err := aliasRows.Scan(&payeeID, &alias); err != nil {
+			logger.Error("扫描商户别名失败", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "获取商户失败"})
+			return
+		}
+		if i, ok := index[payeeID]; ok {
+			payees[i].Aliases = append(payees[i].Aliases, alias)
+		}
+	}
+	c.JSON(http.StatusOK, payees)
+}
+
+// CreatePayee 创建商户及其别名 (也可以在记账时直接填写新商户名自动创建)
+func (h *DBHandler) CreatePayee(c *gin.Context) {
+	userID, _ := c.Get("userID")
+	logger := h.Logger.With(slog.Int64("userID", userID.(int64)))
+
+	var req PayeeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的请求数据: " + err.Error()})
+		return
+	}
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "商户名不能为空"})
+		return
+	}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		logger.Error("开启事务失败", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "开启事务失败"})
+		return
+	}
+	defer tx.Rollback()
+
+	createdAt := time.Now().Format(time.RFC3339)
+	res, err := tx.Exec("INSERT INTO payees (user_id, name, created_at) VALUES (?, ?, ?)", userID, name, createdAt)
+	if err != nil {
+		if isUniqueViolation(err) {
+			c.JSON(http.StatusConflict, gin.H{"error": "商户已存在"})
+			return
+		}
+		logger.Error("创建商户失败", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "创建商户失败"})
+		return
+	}
+	id, _ := res.LastInsertId()
+	if err := savePayeeAliases(tx, userID.(int64), id, name, req.Aliases); err != nil {
+		writeLedgerError(c, logger, err)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		logger.Error("提交事务失败", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "提交事务失败"})
+		return
+	}
+	aliases, _ := normalizePayeeAliases(name, req.Aliases)
+	c.JSON(http.StatusCreated, Payee{ID: id, Name: name, Aliases: aliases, CreatedAt: createdAt})
+}
+
+// UpdatePayee 重命名商户，未传 aliases (null) 时保留原有别名，传空数组表示清空
+func (h *DBHandler) UpdatePayee(c *gin.Context) {
+	userID, _ := c.Get("userID")
+	id, _ := strconv.ParseInt(c.Param("id"), 10, 64)
+	logger := h.Logger.With(slog.Int64("userID", userID.(int64)), slog.Int64("payeeID", id))
+
+	var req PayeeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的请求数据: " + err.Error()})
+		return
+	}
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "商户名不能为空"})
+		return
+	}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		logger.Error("开启事务失败", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "开启事务失败"})
+		return
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec("UPDATE payees SET name = ? WHERE id = ? AND user_id = ?", name, id, userID)
+	if err != nil {
+		if isUniqueViolation(err) {
+			c.JSON(http.StatusConflict, gin.H{"error": "该名称已被其他商户使用"})
+			return
+		}
+		logger.Error("更新商户失败", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "更新商户失败"})
+		return
+	}
+	rowsAffected, _ := res.RowsAffected()
+	if rowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "未找到指定ID的商户"})
+		return
+	}
+	if req.Aliases != nil {
+		if err := savePayeeAliases(tx, userID.(int64), id, name, req.Aliases); err != nil {
+			writeLedgerError(c, logger, err)
+			return
+		}
+	} else {
+		// 保留原有别名，但新名称与某个别名相同时该别名不再需要
+		if _, err := tx.Exec("DELETE FROM payee_aliases WHERE payee_id = ? AND alias = ?", id, name); err != nil {
+			logger.Error("清理商户别名失败", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "更新商户失败"})
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		logger.Error("提交事务失败", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "提交事务失败"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "商户更新成功"})
+}
+
+// DeletePayee 删除商户及其别名，相关流水和规则仅移除对该商户的引用
+func (h *DBHandler) DeletePayee(c *gin.Context) {
+	userID, _ := c.Get("userID")
+	id := c.Param("id")
+	res, err := h.DB.Exec("DELETE FROM payees WHERE id = ? AND user_id = ?", id, userID)
+	if err != nil {
+		h.Logger.Error("删除商户失败", "error", err, "payeeID", id, slog.Int64("userID", userID.(int64)))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "删除商户失败"})
+		return
+	}
+	rowsAffected, _ := res.RowsAffected()
+	if rowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "未找到指定ID的商户"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "商户删除成功，相关流水已移除该商户"})
+}
+
+// validatePayeeRule 校验规则引用的商户和账户归属，并规范化标签
+func validatePayeeRule(tx *sql.Tx, userID int64, req *PayeeRuleRequest) error {
+	req.Pattern = strings.TrimSpace(req.Pattern)
+	if req.Pattern == "" {
+		return &ledgerError{Status: http.StatusBadRequest, Message: "匹配关键字不能为空"}
+	}
+	if req.PayeeID == nil && req.CategoryID == nil && len(req.Tags) == 0 && req.AccountID == nil {
+		return &ledgerError{Status: http.StatusBadRequest, Message: "规则至少需要设置商户、分类、标签或账户中的一项"}
+	}
+	if req.PayeeID != nil && !isOwner(tx, userID, "payees", *req.PayeeID) {
+		return &ledgerError{Status: http.StatusForbidden, Message: "无权使用该商户"}
+	}
+	if req.AccountID != nil && !isOwner(tx, userID, "accounts", *req.AccountID) {
+		return &ledgerError{Status: http.StatusForbidden, Message: "无权使用该账户"}
+	}
+	tags, err := normalizeTagNames(req.Tags)
+	if err != nil {
+		return err
+	}
+	req.Tags = tags
+	return nil
+}
+
+// encodeRuleTags 标签以 JSON 数组存储，没有标签时存 NULL
+func encodeRuleTags(tags []string) interface{} {
+	if len(tags) == 0 {
+		return nil
+	}
+	raw, _ := json.Marshal(tags)
+	return string(raw)
+}
+
+// GetPayeeRules 获取当前用户的自动分类规则，按生效顺序排列
+func (h *DBHandler) GetPayeeRules(c *gin.Context) {
+	userID, _ := c.Get("userID")
+	logger := h.Logger.With(slog.Int64("userID", userID.(int64)))
+
+	rows, err := h.DB.Query("SELECT "+payeeRuleColumns+" FROM payee_rules WHERE user_id = ? ORDER BY priority DESC, id ASC", userID)
+	if err != nil {
+		logger.Error("查询自动分类规则失败", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "获取自动分类规则失败"})
+		return
+	}
+	defer rows.Close()
+
+	rules := []PayeeRule{}
+	for rows.Next() {
+		rule, err := scanPayeeRule(rows)
+		if err != nil {
+			logger.Error("扫描自动分类规则失败", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "获取自动分类规则失败"})
+			return
+		}
+		rules = append(rules, rule)
+	}
+	c.JSON(http.StatusOK, rules)
+}
+
+// CreatePayeeRule 创建自动分类规则
+func (h *DBHandler) CreatePayeeRule(c *gin.Context) {
+	userID, _ := c.Get("userID")
+	logger := h.Logger.With(slog.Int64("userID", userID.(int64)))
+
+	var req PayeeRuleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的请求数据: " + err.Error()})
+		return
+	}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		logger.Error("开启事务失败", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "开启事务失败"})
+		return
+	}
+	defer tx.Rollback()
+
+	if err := validatePayeeRule(tx, userID.(int64), &req); err != nil {
+		writeLedgerError(c, logger, err)
+		return
+	}
+	createdAt := time.Now().Format(time.RFC3339)
+	res, err := tx.Exec(
+		"INSERT INTO payee_rules (user_id, match_field, pattern, payee_id, category_id, tags, account_id, priority, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		userID, req.MatchField, req.Pattern, req.PayeeID, req.CategoryID, encodeRuleTags(req.Tags), req.AccountID, req.Priority, createdAt,
+	)
+	if err != nil {
+		logger.Error("创建自动分类规则失败", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "创建自动分类规则失败"})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		logger.Error("提交事务失败", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "提交事务失败"})
+		return
+	}
+	id, _ := res.LastInsertId()
+	c.JSON(http.StatusCreated, PayeeRule{
+		ID: id, MatchField: req.MatchField, Pattern: req.Pattern, PayeeID: req.PayeeID, CategoryID: req.CategoryID,
+		Tags: req.Tags, AccountID: req.AccountID, Priority: req.Priority, CreatedAt: createdAt,
+	})
+}
+
+// UpdatePayeeRule 整体替换一条自动分类规则，已入账的流水不受影响
+func (h *DBHandler) UpdatePayeeRule(c *gin.Context) {
+	userID, _ := c.Get("userID")
+	id := c.Param("id")
+	logger := h.Logger.With(slog.Int64("userID", userID.(int64)), "ruleID", id)
+
+	var req PayeeRuleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的请求数据: " + err.Error()})
+		return
+	}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		logger.Error("开启事务失败", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "开启事务失败"})
+		return
+	}
+	defer tx.Rollback()
+
+	if err := validatePayeeRule(tx, userID.(int64), &req); err != nil {
+		writeLedgerError(c, logger, err)
+		return
+	}
+	res, err := tx.Exec(
+		"UPDATE payee_rules SET match_field = ?, pattern = ?, payee_id = ?, category_id = ?, tags = ?, account_id = ?, priority = ? WHERE id = ? AND user_id = ?",
+		req.MatchField, req.Pattern, req.PayeeID, req.CategoryID, encodeRuleTags(req.Tags), req.AccountID, req.Priority, id, userID,
+	)
+	if err != nil {
+		logger.Error("更新自动分类规则失败", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "更新自动分类规则失败"})
+		return
+	}
+	rowsAffected, _ := res.RowsAffected()
+	if rowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "未找到指定ID的规则"})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		logger.Error("提交事务失败", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "提交事务失败"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "自动分类规则更新成功"})
+}
+
+// DeletePayeeRule 删除自动分类规则
+func (h *DBHandler) DeletePayeeRule(c *gin.Context) {
+	userID, _ := c.Get("userID")
+	id := c.Param("id")
+	res, err := h.DB.Exec("DELETE FROM payee_rules WHERE id = ? AND user_id = ?", id, userID)
+	if err != nil {
+		h.Logger.Error("删除自动分类规则失败", "error", err, "ruleID", id, slog.Int64("userID", userID.(int64)))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "删除自动分类规则失败"})
+		return
+	}
+	rowsAffected, _ := res.RowsAffected()
+	if rowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "未找到指定ID的规则"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "自动分类规则删除成功"})
+}
+
+// GetPayeeAnalytics 商户支出排行。与 GetAnalyticsCharts 一致：金额按流水日期的汇率换算为本位币，
+// 支出按 transaction_lines 统计，退款冲减原支出所属的商户
+func (h *DBHandler) GetPayeeAnalytics(c *gin.Context) {
+	userID, _ := c.Get("userID")
+	logger := h.Logger.With(slog.Int64("userID", userID.(int64)))
+
+	year := c.Query("year")
+	month := c.Query("month")
+	limit := defaultPayeeReportLimit
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "无效的 limit 参数"})
+			return
+		}
+		limit = min(n, maxPayeeReportLimit)
+	}
+
+	var queryBuilder strings.Builder
+	queryBuilder.WriteString(`
+        SELECT p.id, p.name, COALESCE(SUM(t.base_amount), 0) AS total, COUNT(DISTINCT t.origin_id)
+        FROM transaction_lines t
+        JOIN transactions o ON o.id = t.origin_id
+        JOIN payees p ON p.id = o.payee_id
+        WHERE t.user_id = ? AND t.type IN ('expense', 'repayment')
+    `)
+	args := []interface{}{userID}
+	if year != "" {
+		queryBuilder.WriteString(" AND strftime('%Y', t.transaction_date) = ?")
+		args = append(args, year)
+	}
+	if month != "" {
+		queryBuilder.WriteString(" AND strftime('%m', t.transaction_date) = ?")
+		args = append(args, fmt.Sprintf("%02s", month))
+	}
+	queryBuilder.WriteString(" GROUP BY p.id HAVING total > 0 ORDER BY total DESC LIMIT ?")
+	args = append(args, limit)
+
+	rows, err := h.DB.Query(queryBuilder.String(), args...)
+	if err != nil {
+		logger.Error("查询商户支出失败", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "获取商户支出数据失败"})
+		return
+	}
+	defer rows.Close()
+
+	result := []PayeeSpending{}
+	for rows.Next() {
+		var item PayeeSpending
+		if err := rows.Scan(&item.PayeeID, &item.Name, &item.Total, &item.TransactionCount); err != nil {
+			logger.Warn("扫描商户支出数据失败", "error", err)
+			continue
+		}
+		result = append(result, item)
+	}
+	c.JSON(http.StatusOK, result)
+}
@@ -0,0 +1,124 @@
+// bookkeeper-app/payee_handlers_test.go
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// 测试商户与自动分类规则：别名归并、按描述/商户补全未填写的字段、导入时关联已有商户，以及商户支出排行
+func TestPayeesAndRules(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	handler := &DBHandler{DB: db, Logger: slog.New(slog.NewJSONHandler(io.Discard, nil))}
+	router := setupRouter(handler)
+
+	userID := createTestUser(t, db, "testuser", "password")
+	token := getTestAuthToken(t, userID, "testuser", false)
+	cardID := createTestAccount(t, db, userID, "银行卡", 1000.0)
+	walletID := createTestAccount(t, db, userID, "钱包", 1000.0)
+
+	w := performRequest(router, "POST", "/api/v1/payees", bytes.NewBufferString(`{"name":"美团","aliases":["美团外卖","meituan","美团"]}`), token)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	var meituan Payee
+	json.Unmarshal(w.Body.Bytes(), &meituan)
+	assert.Equal(t, []string{"美团外卖", "meituan"}, meituan.Aliases)
+	w = performRequest(router, "POST", "/api/v1/payees", bytes.NewBufferString(`{"name":"咖啡店","aliases":["MeiTuan"]}`), token)
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	// 商户规则：美团的流水默认记为餐饮并从钱包付款；描述规则：含 "打车" 的记为交通
+	for _, body := range []string{
+		fmt.Sprintf(`{"match_field":"payee","pattern":"美团","category_id":"food","tags":["外卖"],"account_id":%d}`, walletID),
+		`{"match_field":"description","pattern":"打车","category_id":"transport","priority":5}`,
+	} {
+		w = performRequest(router, "POST", "/api/v1/payee_rules", bytes.NewBufferString(body), token)
+		assert.Equal(t, http.StatusCreated, w.Code)
+	}
+	w = performRequest(router, "POST", "/api/v1/payee_rules", bytes.NewBufferString(`{"match_field":"description","pattern":"咖啡","payee_id":999}`), token)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	w = performRequest(router, "POST", "/api/v1/payee_rules", bytes.NewBufferString(`{"match_field":"description","pattern":"咖啡"}`), token)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	create := func(req CreateTransactionRequest) int64 {
+		body, _ := json.Marshal(req)
+		w := performRequest(router, "POST", "/api/v1/transactions", bytes.NewBuffer(body), token)
+		assert.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+		var resp struct {
+			ID int64 `json:"id"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		return resp.ID
+	}
+	// 按别名匹配到美团，规则补全分类、标签和付款账户
+	create(CreateTransactionRequest{Type: "expense", Amount: yuan(40), TransactionDate: "2024-05-01", Description: "午饭", PayeeName: "MEITUAN"})
+	// 用户填写的分类和账户优先于规则，未填写的标签仍由规则补全
+	other := "other"
+	create(CreateTransactionRequest{Type: "expense", Amount: yuan(25), TransactionDate: "2024-05-02", PayeeID: &meituan.ID, CategoryID: &other, FromAccountID: &cardID})
+	// 描述规则，且新商户名自动创建
+	create(CreateTransactionRequest{Type: "expense", Amount: yuan(60), TransactionDate: "2024-05-03", Description: "滴滴打车", PayeeName: "滴滴", FromAccountID: &cardID})
+
+	var walletBalance Money
+	db.QueryRow("SELECT balance FROM accounts WHERE id = ?", walletID).Scan(&walletBalance)
+	assert.Equal(t, yuan(960), walletBalance)
+
+	w = performRequest(router, "GET", "/api/v1/transactions?year=2024&order=asc", nil, token)
+	var list GetTransactionsResponse
+	json.Unmarshal(w.Body.Bytes(), &list)
+	if assert.Len(t, list.Transactions, 3) {
+		first := list.Transactions[0]
+		assert.Equal(t, meituan.ID, *first.PayeeID)
+		assert.Equal(t, "美团", *first.PayeeName)
+		assert.Equal(t, "food", *first.CategoryID)
+		assert.Equal(t, []string{"外卖"}, first.Tags)
+		assert.Equal(t, "other", *list.Transactions[1].CategoryID)
+		assert.Equal(t, []string{"外卖"}, list.Transactions[1].Tags)
+		assert.Equal(t, "transport", *list.Transactions[2].CategoryID)
+		assert.Equal(t, "滴滴", *list.Transactions[2].PayeeName)
+	}
+	w = performRequest(router, "GET", "/api/v1/transactions?payee_id="+strconv.FormatInt(meituan.ID, 10), nil, token)
+	json.Unmarshal(w.Body.Bytes(), &list)
+	assert.Len(t, list.Transactions, 2)
+
+	// 导入时交易对方按别名关联已有商户，未知的交易对方不创建商户
+	performRequest(router, "PUT", fmt.Sprintf("/api/v1/payees/%d", meituan.ID), bytes.NewBufferString(`{"name":"美团","aliases":["美团外卖","meituan","咖啡店"]}`), token)
+	w = importTestCSV(router, map[string]string{"source": "wechat", "account_id": strconv.FormatInt(cardID, 10), "commit": "true"}, []byte(wechatSample), token)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	var imported CSVImportResponse
+	json.Unmarshal(w.Body.Bytes(), &imported)
+	if assert.Len(t, imported.Rows, 3) {
+		assert.Equal(t, "咖啡店", imported.Rows[0].Counterparty)
+		assert.Equal(t, meituan.ID, *imported.Rows[0].Transaction.PayeeID)
+		assert.Equal(t, "food", *imported.Rows[0].Transaction.CategoryID)
+		assert.Nil(t, imported.Rows[2].Transaction.PayeeID)
+	}
+
+	w = performRequest(router, "GET", "/api/v1/payees", nil, token)
+	var payees []Payee
+	json.Unmarshal(w.Body.Bytes(), &payees)
+	assert.Len(t, payees, 2)
+
+	// 商户支出排行
+	w = performRequest(router, "GET", "/api/v1/analytics/payees?year=2024", nil, token)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var report []PayeeSpending
+	json.Unmarshal(w.Body.Bytes(), &report)
+	assert.Equal(t, []PayeeSpending{
+		{PayeeID: meituan.ID, Name: "美团", Total: yuan(93), TransactionCount: 3},
+		{PayeeID: payees[0].ID, Name: "滴滴", Total: yuan(60), TransactionCount: 1},
+	}, report)
+
+	// 删除商户后流水保留，仅移除商户引用
+	w = performRequest(router, "DELETE", fmt.Sprintf("/api/v1/payees/%d", meituan.ID), nil, token)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var linked int
+	db.QueryRow("SELECT COUNT(*) FROM transactions WHERE payee_id IS NOT NULL").Scan(&linked)
+	assert.Equal(t, 1, linked)
+}
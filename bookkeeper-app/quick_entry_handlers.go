@@ -0,0 +1,396 @@
+// bookkeeper-app/quick_entry_handlers.go
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// quickCategoryAliases 常用说法到默认分类 ID 的映射 (键为小写)。分类名和分类 ID 本身总是可以直接使用。
+var quickCategoryAliases = map[string]string{
+	"早饭": "food_dining", "午饭": "food_dining", "晚饭": "food_dining", "早餐": "food_dining", "午餐": "food_dining",
+	"晚餐": "food_dining", "夜宵": "food_dining", "外卖": "food_dining", "吃饭": "food_dining", "咖啡": "food_dining",
+	"breakfast": "food_dining", "lunch": "food_dining", "dinner": "food_dining", "food": "food_dining", "coffee": "food_dining",
+	"打车": "transportation", "地铁": "transportation", "公交": "transportation", "加油": "transportation", "停车": "transportation",
+	"taxi": "transportation", "bus": "transportation", "metro": "transportation",
+	"房租": "rent_mortgage", "房贷": "rent_mortgage", "rent": "rent_mortgage",
+	"水费": "utilities", "电费": "utilities", "燃气费": "utilities", "话费": "utilities", "网费": "utilities",
+	"电影": "entertainment", "游戏": "entertainment", "movie": "entertainment",
+	"看病": "health_wellness", "买药": "health_wellness", "医院": "health_wellness",
+	"工资": "salary", "薪水": "salary", "奖金": "salary",
+	"理财": "investments", "分红": "investments",
+}
+
+// quickAccountTypeAliases 账户类型的常用说法，未按账户名匹配到时按类型匹配
+var quickAccountTypeAliases = map[string]string{
+	"微信": "wechat", "wechat": "wechat", "wx": "wechat",
+	"支付宝": "alipay", "alipay": "alipay", "zfb": "alipay",
+	"卡": "card", "银行卡": "card", "储蓄卡": "card", "card": "card",
+	"信用卡": "credit_card", "credit": "credit_card",
+}
+
+// quickTypeKeywords 显式指定流水类型的关键词
+var quickTypeKeywords = map[string]string{
+	"收入": "income", "income": "income",
+	"支出": "expense", "expense": "expense",
+	"转账": "transfer", "transfer": "transfer",
+}
+
+// quickDirectionKeywords 标记下一个账户是转入方还是转出方
+var quickDirectionKeywords = map[string]string{
+	"to": "to", "到": "to", "转到": "to", "转入": "to", "存入": "to",
+	"from": "from", "从": "from",
+}
+
+var quickRelativeDays = map[string]int{
+	"今天": 0, "today": 0,
+	"昨天": -1, "yesterday": -1,
+	"前天": -2,
+	"明天": 1, "tomorrow": 1,
+}
+
+var quickWeekdays = map[string]time.Weekday{
+	"一": time.Monday, "二": time.Tuesday, "三": time.Wednesday, "四": time.Thursday,
+	"五": time.Friday, "六": time.Saturday, "日": time.Sunday, "天": time.Sunday,
+}
+
+var (
+	quickDaysAgoPattern = regexp.MustCompile(`^(\d{1,3})天前$`)
+	// 带年份的日期原样使用；不带年份的日期取今年，若晚于今天则视为去年
+	quickDateLayouts         = []string{"2006-01-02", "2006/1/2", "2006年1月2日", "2006年1月2号", "2006.1.2"}
+	quickDateLayoutsNoYear   = []string{"1/2", "1-2", "1月2日", "1月2号"}
+	quickAmountCurrencyTrims = []string{"¥", "￥"}
+	quickAmountUnitTrims     = []string{"块钱", "元", "块", "rmb"}
+)
+
+// quickEntryData 解析快速记账所需的用户数据
+type quickEntryData struct {
+	Today      time.Time
+	Categories []Category // 共享分类和私有分类 (仅收入/支出类)
+	Accounts   []Account  // 按主账户优先、创建时间先后排序
+}
+
+// quickAccountRef 解析出的账户及其方向标记 ("", "to", "from")
+type quickAccountRef struct {
+	Account   Account
+	Direction string
+}
+
+// parseQuickDate 识别相对日期 (今天/昨天/N天前/周五) 和绝对日期，返回 YYYY-MM-DD
+func parseQuickDate(word string, today time.Time) (string, bool) {
+	if offset, ok := quickRelativeDays[word]; ok {
+		return today.AddDate(0, 0, offset).Format(dateLayout), true
+	}
+	if m := quickDaysAgoPattern.FindStringSubmatch(word); m != nil {
+		n, _ := strconv.Atoi(m[1])
+		return today.AddDate(0, 0, -n).Format(dateLayout), true
+	}
+	// 周X/星期X 表示最近一个 (含今天) 的该星期几
+	for _, prefix := range []string{"周", "星期", "礼拜"} {
+		if day, ok := quickWeekdays[strings.TrimPrefix(word, prefix)]; ok && strings.HasPrefix(word, prefix) {
+			back := (int(today.Weekday()) - int(day) + 7) % 7
+			return today.AddDate(0, 0, -back).Format(dateLayout), true
+		}
+	}
+	for _, layout := range quickDateLayouts {
+		if t, err := time.Parse(layout, word); err == nil {
+			return t.Format(dateLayout), true
+		}
+	}
+	for _, layout := range quickDateLayoutsNoYear {
+		if t, err := time.Parse(layout, word); err == nil {
+			date := time.Date(today.Year(), t.Month(), t.Day(), 0, 0, 0, 0, today.Location())
+			if date.After(today) {
+				date = date.AddDate(-1, 0, 0)
+			}
+			return date.Format(dateLayout), true
+		}
+	}
+	return "", false
+}
+
+// parseQuickAmount 识别金额，允许货币符号前缀和 元/块 等单位后缀
+func parseQuickAmount(word string) (Money, bool) {
+	s := strings.ToLower(word)
+	for _, prefix := range quickAmountCurrencyTrims {
+		s = strings.TrimPrefix(s, prefix)
+	}
+	for _, suffix := range quickAmountUnitTrims {
+		s = strings.TrimSuffix(s, suffix)
+	}
+	// ParseMoney 允许正负号，快速记账中金额总是正数
+	if s == "" || s[0] < '0' || s[0] > '9' {
+		return 0, false
+	}
+	amount, err := ParseMoney(s)
+	if err != nil || amount <= 0 {
+		return 0, false
+	}
+	return amount, true
+}
+
+// matchQuickCategory 按分类名、分类 ID、常用说法依次匹配
+func matchQuickCategory(word string, categories []Category) (Category, bool) {
+	lower := strings.ToLower(word)
+	for _, cat := range categories {
+		if strings.EqualFold(cat.Name, word) || strings.EqualFold(cat.ID, word) {
+			return cat, true
+		}
+	}
+	if id, ok := quickCategoryAliases[lower]; ok {
+		for _, cat := range categories {
+			if cat.ID == id {
+				return cat, true
+			}
+		}
+	}
+	return Category{}, false
+}
+
+// matchQuickAccount 先按账户名匹配，再按账户类型匹配 (同类型有多个账户时取排在最前的)
+func matchQuickAccount(word string, accounts []Account) (Account, bool) {
+	for _, acc := range accounts {
+		if strings.EqualFold(acc.Name, word) {
+			return acc, true
+		}
+	}
+	if accountType, ok := quickAccountTypeAliases[strings.ToLower(word)]; ok {
+		for _, acc := range accounts {
+			if acc.Type == accountType {
+				return acc, true
+			}
+		}
+	}
+	return Account{}, false
+}
+
+// parseQuickEntry 基于规则解析一句话记账，如 "午饭 35 微信 昨天"、"salary 12000 to card 6/15"。
+// 各词按空白分隔、顺序无关：依次尝试识别为日期、金额、类型关键词、方向关键词、账户和分类，
+// 每一项只取第一个匹配，其余的词组成描述。解析结果只依赖输入和用户数据，不调用外部服务。
+func parseQuickEntry(text string, data quickEntryData) (*QuickEntryResponse, error) {
+	badRequest := func(format string, args ...interface{}) error {
+		return &ledgerError{Status: http.StatusBadRequest, Message: fmt.Sprintf(format, args...)}
+	}
+	words := strings.Fields(strings.NewReplacer("，", " ", "；", " ", "、", " ").Replace(text))
+
+	var (
+		req                  CreateTransactionRequest
+		explicitType         string
+		category             *Category
+		categoryWord         string
+		accounts             []quickAccountRef
+		pendingDirection     string
+		description          []string
+		haveAmount, haveDate bool
+	)
+	for _, word := range words {
+		lower := strings.ToLower(word)
+		if !haveDate {
+			if date, ok := parseQuickDate(lower, data.Today); ok {
+				req.TransactionDate, haveDate = date, true
+				continue
+			}
+		}
+		if !haveAmount {
+			if amount, ok := parseQuickAmount(word); ok {
+				req.Amount, haveAmount = amount, true
+				continue
+			}
+		}
+		if t, ok := quickTypeKeywords[lower]; ok && explicitType == "" {
+			explicitType = t
+			continue
+		}
+		if d, ok := quickDirectionKeywords[lower]; ok {
+			pendingDirection = d
+			continue
+		}
+		if acc, ok := matchQuickAccount(word, data.Accounts); ok && len(accounts) < 2 {
+			accounts = append(accounts, quickAccountRef{Account: acc, Direction: pendingDirection})
+			pendingDirection = ""
+			continue
+		}
+		if category == nil {
+			if cat, ok := matchQuickCategory(word, data.Categories); ok {
+				category, categoryWord = &cat, word
+				continue
+			}
+		}
+		description = append(description, word)
+	}
+
+	if !haveAmount {
+		return nil, badRequest("未能识别金额")
+	}
+	if !haveDate {
+		req.TransactionDate = data.Today.Format(dateLayout)
+	}
+
+	// 类型：显式关键词优先，其次由分类决定；只指定了转入账户时视为收入，否则默认为支出
+	req.Type = explicitType
+	switch {
+	case req.Type != "":
+	case category != nil:
+		req.Type = category.Type
+	case len(accounts) == 1 && accounts[0].Direction == "to":
+		req.Type = "income"
+	default:
+		req.Type = "expense"
+	}
+	if category != nil {
+		if req.Type == "transfer" {
+			return nil, badRequest("转账不能指定分类")
+		}
+		if category.Type != req.Type {
+			return nil, badRequest("分类「%s」不能用于%s", category.Name, map[string]string{"income": "收入", "expense": "支出"}[req.Type])
+		}
+		req.CategoryID = &category.ID
+	}
+
+	response := &QuickEntryResponse{}
+	if category != nil {
+		response.CategoryName = category.Name
+	}
+	setFrom := func(acc Account) { req.FromAccountID, response.FromAccountName = &acc.ID, acc.Name }
+	setTo := func(acc Account) { req.ToAccountID, response.ToAccountName = &acc.ID, acc.Name }
+
+	if req.Type == "transfer" {
+		if len(accounts) != 2 {
+			return nil, badRequest("转账需要指定转出和转入两个账户")
+		}
+		// 有方向标记的账户按标记归位，否则按出现顺序 (先转出后转入)
+		from, to := accounts[0], accounts[1]
+		if from.Direction == "to" || to.Direction == "from" {
+			from, to = to, from
+		}
+		setFrom(from.Account)
+		setTo(to.Account)
+	} else {
+		if len(accounts) > 1 {
+			return nil, badRequest("收入或支出只能指定一个账户")
+		}
+		var acc Account
+		if len(accounts) == 1 {
+			acc = accounts[0].Account
+		} else if len(data.Accounts) > 0 {
+			acc = data.Accounts[0] // 未指定账户时使用主账户
+		} else {
+			return nil, badRequest("请先创建账户")
+		}
+		if req.Type == "income" {
+			setTo(acc)
+		} else {
+			setFrom(acc)
+		}
+	}
+
+	req.Description = strings.Join(description, " ")
+	// 没有其它描述时，用识别出分类的那个词作为描述 (如 "午饭")，与分类名相同时不重复
+	if req.Description == "" && category != nil && !strings.EqualFold(categoryWord, category.Name) && !strings.EqualFold(categoryWord, category.ID) {
+		req.Description = categoryWord
+	}
+	response.Transaction = req
+	return response, nil
+}
+
+// loadQuickEntryData 读取用户可用的收支分类和账户
+func (h *DBHandler) loadQuickEntryData(userID int64) (quickEntryData, error) {
+	data := quickEntryData{}
+	now := time.Now()
+	data.Today = time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+
+	rows, err := h.DB.Query(`
+        SELECT id, name, type FROM shared_categories WHERE type IN ('income', 'expense')
+        UNION ALL
+        SELECT id, name, type FROM categories WHERE user_id = ? AND type IN ('income', 'expense')`, userID)
+	if err != nil {
+		return data, err
+	}
+	for rows.Next() {
+		var cat Category
+		if err := rows.Scan(&cat.ID, &cat.Name, &cat.Type); err != nil {
+			rows.Close()
+			return data, err
+		}
+		data.Categories = append(data.Categories, cat)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return data, err
+	}
+
+	rows, err = h.DB.Query("SELECT id, name, type, is_primary FROM accounts WHERE user_id = ? AND archived_at IS NULL ORDER BY is_primary DESC, created_at ASC, id ASC", userID)
+	if err != nil {
+		return data, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var acc Account
+		if err := rows.Scan(&acc.ID, &acc.Name, &acc.Type, &acc.IsPrimary); err != nil {
+			return data, err
+		}
+		data.Accounts = append(data.Accounts, acc)
+	}
+	return data, rows.Err()
+}
+
+// QuickEntry 一句话记账：默认只返回解析结果供确认，commit 为 true 时直接按正常流程入账
+// POST /transactions/quick
+func (h *DBHandler) QuickEntry(c *gin.Context) {
+	userID, _ := c.Get("userID")
+	logger := h.Logger.With(slog.Int64("userID", userID.(int64)))
+
+	var req QuickEntryRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的请求数据: " + err.Error()})
+		return
+	}
+
+	data, err := h.loadQuickEntryData(userID.(int64))
+	if err != nil {
+		logger.Error("读取分类和账户失败", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "服务器内部错误"})
+		return
+	}
+	response, err := parseQuickEntry(req.Text, data)
+	if err != nil {
+		writeLedgerError(c, logger, err)
+		return
+	}
+	if !req.Commit {
+		c.JSON(http.StatusOK, response)
+		return
+	}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		logger.Error("开启事务失败", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "开启事务失败"})
+		return
+	}
+	defer tx.Rollback()
+
+	id, err := createTransactionInTx(tx, userID.(int64), &response.Transaction)
+	if err != nil {
+		writeLedgerError(c, logger, err)
+		return
+	}
+	if err := writeAudit(tx, auditActorFromContext(c), "transaction", "create", id, nil); err != nil {
+		writeLedgerError(c, logger, err)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		logger.Error("提交事务失败", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "提交事务失败"})
+		return
+	}
+	response.ID = &id
+	c.JSON(http.StatusCreated, response)
+}
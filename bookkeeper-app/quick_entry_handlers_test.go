@@ -0,0 +1,107 @@
+// bookkeeper-app/quick_entry_handlers_test.go
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// 测试一句话记账的解析规则：金额、相对/绝对日期、账户名或类型、分类名/ID/常用说法
+func TestParseQuickEntry(t *testing.T) {
+	data := quickEntryData{
+		Today: time.Date(2024, 6, 20, 0, 0, 0, 0, time.Local), // 周四
+		Categories: []Category{
+			{ID: "salary", Name: "工资", Type: "income"},
+			{ID: "food_dining", Name: "餐饮", Type: "expense"},
+			{ID: "pets", Name: "宠物", Type: "expense"},
+		},
+		Accounts: []Account{
+			{ID: 1, Name: "招行", Type: "card", IsPrimary: true},
+			{ID: 2, Name: "零钱", Type: "wechat"},
+			{ID: 3, Name: "工资卡", Type: "card"},
+		},
+	}
+	id := func(v int64) *int64 { return &v }
+	str := func(v string) *string { return &v }
+
+	tests := []struct {
+		text string
+		want CreateTransactionRequest
+	}{
+		{"午饭 35 微信 昨天", CreateTransactionRequest{Type: "expense", Amount: yuan(35), TransactionDate: "2024-06-19", Description: "午饭", CategoryID: str("food_dining"), FromAccountID: id(2)}},
+		{"salary 12000 to 工资卡 6/15", CreateTransactionRequest{Type: "income", Amount: yuan(12000), TransactionDate: "2024-06-15", CategoryID: str("salary"), ToAccountID: id(3)}},
+		// 按类型匹配时取主账户优先；不带年份且晚于今天的日期视为去年
+		{"工资 ¥8000.50 card 12月31日", CreateTransactionRequest{Type: "income", Amount: yuan(8000.5), TransactionDate: "2023-12-31", CategoryID: str("salary"), ToAccountID: id(1)}},
+		// 未识别的词作为描述，未指定账户和日期时使用主账户和今天
+		{"猫粮 宠物 99元", CreateTransactionRequest{Type: "expense", Amount: yuan(99), TransactionDate: "2024-06-20", Description: "猫粮", CategoryID: str("pets"), FromAccountID: id(1)}},
+		{"转账 500 零钱 to 招行 3天前", CreateTransactionRequest{Type: "transfer", Amount: yuan(500), TransactionDate: "2024-06-17", FromAccountID: id(2), ToAccountID: id(1)}},
+		{"收入 200 周一 红包", CreateTransactionRequest{Type: "income", Amount: yuan(200), TransactionDate: "2024-06-17", Description: "红包", ToAccountID: id(1)}},
+	}
+	for _, tt := range tests {
+		resp, err := parseQuickEntry(tt.text, data)
+		if assert.NoError(t, err, tt.text) {
+			assert.Equal(t, tt.want, resp.Transaction, tt.text)
+		}
+	}
+
+	// 解析失败的情况
+	for _, text := range []string{"午饭 微信", "转账 100 零钱", "收入 100 餐饮", "午饭 30 零钱 招行"} {
+		_, err := parseQuickEntry(text, data)
+		assert.Error(t, err, text)
+	}
+}
+
+// 测试快速记账接口：默认只预览不入账，commit 时按正常流程入账
+func TestQuickEntry(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	handler := &DBHandler{DB: db, Logger: slog.New(slog.NewJSONHandler(io.Discard, nil))}
+	router := setupRouter(handler)
+
+	userID := createTestUser(t, db, "testuser", "password")
+	token := getTestAuthToken(t, userID, "testuser", false)
+	accountID := createTestAccount(t, db, userID, "Test Account", 100.0)
+
+	send := func(req QuickEntryRequest) (int, QuickEntryResponse) {
+		body, _ := json.Marshal(req)
+		w := performRequest(router, "POST", "/api/v1/transactions/quick", bytes.NewBuffer(body), token)
+		var resp QuickEntryResponse
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		return w.Code, resp
+	}
+	balance := func() Money {
+		var b Money
+		db.QueryRow("SELECT balance FROM accounts WHERE id = ?", accountID).Scan(&b)
+		return b
+	}
+
+	code, resp := send(QuickEntryRequest{Text: "午饭 35 card 2024-05-03"})
+	assert.Equal(t, http.StatusOK, code)
+	assert.Nil(t, resp.ID)
+	assert.Equal(t, "餐饮", resp.CategoryName)
+	assert.Equal(t, "Test Account", resp.FromAccountName)
+	assert.Equal(t, yuan(100), balance())
+
+	code, resp = send(QuickEntryRequest{Text: "午饭 35 card 2024-05-03", Commit: true})
+	assert.Equal(t, http.StatusCreated, code)
+	if assert.NotNil(t, resp.ID) {
+		var description, categoryID string
+		db.QueryRow("SELECT description, category_id FROM transactions WHERE id = ?", *resp.ID).Scan(&description, &categoryID)
+		assert.Equal(t, "午饭", description)
+		assert.Equal(t, "food_dining", categoryID)
+	}
+	assert.Equal(t, yuan(65), balance())
+
+	// 入账时同样检查余额；无法识别金额时返回 400
+	code, _ = send(QuickEntryRequest{Text: "房租 3000 2024-05-04", Commit: true})
+	assert.Equal(t, http.StatusConflict, code)
+	code, _ = send(QuickEntryRequest{Text: "午饭 card"})
+	assert.Equal(t, http.StatusBadRequest, code)
+}
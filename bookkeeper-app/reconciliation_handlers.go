@@ -0,0 +1,374 @@
+// bookkeeper-app/reconciliation_handlers.go
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// setupReconciliations 创建对账记录表，并为流水增加清算标记和对账锁定列
+func setupReconciliations(tx *sql.Tx) error {
+	if _, err := tx.Exec(`
+    CREATE TABLE IF NOT EXISTS reconciliations (
+        "id" INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+        "user_id" INTEGER NOT NULL,
+        "account_id" INTEGER NOT NULL,
+        "statement_date" TEXT NOT NULL,
+        "statement_balance" INTEGER NOT NULL,
+        "cleared_balance" INTEGER NOT NULL,
+        "difference" INTEGER NOT NULL,
+        "adjustment_transaction_id" INTEGER,
+        "transaction_count" INTEGER NOT NULL DEFAULT 0,
+        "created_at" TEXT NOT NULL,
+        FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE,
+        FOREIGN KEY(account_id) REFERENCES accounts(id) ON DELETE CASCADE,
+        FOREIGN KEY(adjustment_transaction_id) REFERENCES transactions(id) ON DELETE SET NULL
+    );`); err != nil {
+		return fmt.Errorf("创建 reconciliations 表失败: %w", err)
+	}
+	if _, err := tx.Exec(`CREATE INDEX IF NOT EXISTS idx_reconciliations_account ON reconciliations (account_id, statement_date);`); err != nil {
+		return fmt.Errorf("为 reconciliations 创建索引失败: %w", err)
+	}
+	columns := []struct{ name, definition string }{
+		{"cleared", `"cleared" INTEGER NOT NULL DEFAULT 0`},
+		{"reconciliation_id", `"reconciliation_id" INTEGER REFERENCES reconciliations(id) ON DELETE SET NULL`},
+	}
+	for _, col := range columns {
+		if err := addColumnIfMissing(tx, "transactions", col.name, col.definition); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// accountInflowSQL / accountOutflowSQL 流水 (别名 t) 使账户 accountExpr 增加 / 减少的金额，
+// 由该流水在账户上的记账分录得出。accountExpr 可以是占位符 "?" 或外层查询的列。
+func accountInflowSQL(accountExpr string) string {
+	return "(SELECT COALESCE(SUM(p.amount), 0) FROM postings p WHERE p.transaction_id = t.id AND p.account_type = 'asset' AND p.account_id = " + accountExpr + " AND p.amount > 0)"
+}
+
+func accountOutflowSQL(accountExpr string) string {
+	return "(SELECT COALESCE(-SUM(p.amount), 0) FROM postings p WHERE p.transaction_id = t.id AND p.account_type = 'asset' AND p.account_id = " + accountExpr + " AND p.amount < 0)"
+}
+
+// accountEffectSQL 流水 (别名 t) 对账户余额的影响 (带符号)
+func accountEffectSQL(accountExpr string) string {
+	return "(" + accountInflowSQL(accountExpr) + " - " + accountOutflowSQL(accountExpr) + ")"
+}
+
+// ensureNotReconciled 已对账的流水被锁定，不能再修改或删除
+func ensureNotReconciled(tx *sql.Tx, id int64) error {
+	var reconciliationID sql.NullInt64
+	if err := tx.QueryRow("SELECT reconciliation_id FROM transactions WHERE id = ?", id).Scan(&reconciliationID); err != nil {
+		return &ledgerError{Status: http.StatusInternalServerError, Message: "查询流水对账状态失败", Err: err}
+	}
+	if reconciliationID.Valid {
+		return &ledgerError{Status: http.StatusConflict, Message: "该流水已对账锁定，不能修改或删除"}
+	}
+	return nil
+}
+
+// buildReconciliationPreview 计算截至账单日的已清算余额和差额，并列出截至账单日仍未清算的流水。
+// 已清算余额 = 当前余额 - 账单日之后流水的影响 - 账单日及之前未清算流水的影响，
+// 因此无需记录账户的期初余额。
+func buildReconciliationPreview(tx *sql.Tx, userID, accountID int64, statementDate string, statementBalance Money) (ReconciliationPreview, error) {
+	preview := ReconciliationPreview{AccountID: accountID, StatementDate: statementDate, StatementBalance: statementBalance, Uncleared: []Transaction{}}
+
+	var balance, pending Money
+	if err := tx.QueryRow("SELECT balance FROM accounts WHERE id = ? AND user_id = ?", accountID, userID).Scan(&balance); err != nil {
+		if err == sql.ErrNoRows {
+			return preview, &ledgerError{Status: http.StatusNotFound, Message: "未找到指定ID的账户"}
+		}
+		return preview, &ledgerError{Status: http.StatusInternalServerError, Message: "查询账户余额失败", Err: err}
+	}
+	err := tx.QueryRow(`
+        SELECT COALESCE(SUM(`+accountEffectSQL("?")+`), 0)
+        FROM transactions t
+        WHERE t.user_id = ? AND (t.from_account_id = ? OR t.to_account_id = ?) AND t.deleted_at IS NULL
+          AND (date(t.transaction_date) > ? OR t.cleared = 0)`,
+		accountID, accountID, userID, accountID, accountID, statementDate,
+	).Scan(&pending)
+	if err != nil {
+		return preview, &ledgerError{Status: http.StatusInternalServerError, Message: "计算已清算余额失败", Err: err}
+	}
+	preview.ClearedBalance = balance - pending
+	preview.Difference = statementBalance - preview.ClearedBalance
+
+	rows, err := tx.Query(userCategoriesCTE+" SELECT "+transactionColumns+" FROM transactions t "+transactionJoins+`
+        WHERE t.user_id = ? AND (t.from_account_id = ? OR t.to_account_id = ?) AND t.deleted_at IS NULL
+          AND t.cleared = 0 AND date(t.transaction_date) <= ?
+        ORDER BY t.transaction_date, t.id`,
+		userID, userID, accountID, accountID, statementDate,
+	)
+	if err != nil {
+		return preview, &ledgerError{Status: http.StatusInternalServerError, Message: "查询未清算流水失败", Err: err}
+	}
+	defer rows.Close()
+	for rows.Next() {
+		t, err := scanTransaction(rows)
+		if err != nil {
+			return preview, &ledgerError{Status: http.StatusInternalServerError, Message: "扫描未清算流水失败", Err: err}
+		}
+		preview.Uncleared = append(preview.Uncleared, t)
+	}
+	if err := rows.Err(); err != nil {
+		return preview, &ledgerError{Status: http.StatusInternalServerError, Message: "查询未清算流水失败", Err: err}
+	}
+	return preview, nil
+}
+
+// PreviewReconciliation 根据账单日期和账单余额返回差额及未清算流水，不做任何修改
+// GET /accounts/:id/reconciliation?statement_date=2024-05-31&statement_balance=1234.56
+func (h *DBHandler) PreviewReconciliation(c *gin.Context) {
+	userID, _ := c.Get("userID")
+	logger := h.Logger.With(slog.Int64("userID", userID.(int64)), "accountID", c.Param("id"))
+
+	accountID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的账户ID"})
+		return
+	}
+	statementDate := c.Query("statement_date")
+	if _, err := time.Parse(dateLayout, statementDate); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "statement_date 格式应为 YYYY-MM-DD"})
+		return
+	}
+	statementBalance, err := ParseMoney(c.Query("statement_balance"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的账单余额 (statement_balance)"})
+		return
+	}
+
+	// 只读事务，保证余额与流水来自同一快照
+	tx, err := h.DB.Begin()
+	if err != nil {
+		logger.Error("开启事务失败", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "开启事务失败"})
+		return
+	}
+	defer tx.Rollback()
+
+	preview, err := buildReconciliationPreview(tx, userID.(int64), accountID, statementDate, statementBalance)
+	if err != nil {
+		writeLedgerError(c, logger, err)
+		return
+	}
+	c.JSON(http.StatusOK, preview)
+}
+
+// CreateReconciliation 完成对账：差额为零 (或选择生成调整流水) 时，锁定截至账单日的已清算流水并记录对账历史
+func (h *DBHandler) CreateReconciliation(c *gin.Context) {
+	userID, _ := c.Get("userID")
+	logger := h.Logger.With(slog.Int64("userID", userID.(int64)), "accountID", c.Param("id"))
+
+	accountID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的账户ID"})
+		return
+	}
+	var req ReconciliationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的请求数据: " + err.Error()})
+		return
+	}
+	if _, err := time.Parse(dateLayout, req.StatementDate); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "statement_date 格式应为 YYYY-MM-DD"})
+		return
+	}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		logger.Error("开启事务失败", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "开启事务失败"})
+		return
+	}
+	defer tx.Rollback()
+
+	preview, err := buildReconciliationPreview(tx, userID.(int64), accountID, req.StatementDate, *req.StatementBalance)
+	if err != nil {
+		writeLedgerError(c, logger, err)
+		return
+	}
+
+	// 账单日不能早于上一次对账，否则已锁定的流水会落在本次账单日之后
+	var lastDate sql.NullString
+	if err := tx.QueryRow("SELECT MAX(statement_date) FROM reconciliations WHERE account_id = ?", accountID).Scan(&lastDate); err != nil {
+		logger.Error("查询上次对账日期失败", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询对账记录失败"})
+		return
+	}
+	if lastDate.Valid && req.StatementDate < lastDate.String {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("账单日期不能早于上次对账日期 (%s)", lastDate.String)})
+		return
+	}
+
+	rec := Reconciliation{
+		AccountID:        accountID,
+		StatementDate:    req.StatementDate,
+		StatementBalance: *req.StatementBalance,
+		ClearedBalance:   preview.ClearedBalance,
+		Difference:       preview.Difference,
+		CreatedAt:        time.Now().Format(time.RFC3339),
+	}
+	if rec.Difference != 0 {
+		if !req.CreateAdjustment {
+			c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("对账差额为 %s，请核对未清算流水，或选择生成调整流水", rec.Difference), "difference": rec.Difference})
+			return
+		}
+		// 以账单日生成一笔余额调整 (账户对权益，不计入收支统计) 补平差额，并直接标记为已清算
+		id, err := insertAdjustment(tx, userID.(int64), accountID, rec.Difference, req.StatementDate, "对账调整")
+		if err != nil {
+			writeLedgerError(c, logger, err)
+			return
+		}
+		if err := postTransaction(tx, id); err != nil {
+			writeLedgerError(c, logger, err)
+			return
+		}
+		if _, err := tx.Exec("UPDATE transactions SET cleared = 1 WHERE id = ?", id); err != nil {
+			logger.Error("标记调整流水失败", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "生成调整流水失败"})
+			return
+		}
+		if err := writeAudit(tx, auditActorFromContext(c), "transaction", "create", id, nil); err != nil {
+			writeLedgerError(c, logger, err)
+			return
+		}
+		rec.AdjustmentTransactionID = &id
+	}
+
+	res, err := tx.Exec(
+		"INSERT INTO reconciliations (user_id, account_id, statement_date, statement_balance, cleared_balance, difference, adjustment_transaction_id, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+		userID, accountID, rec.StatementDate, rec.StatementBalance, rec.ClearedBalance, rec.Difference, rec.AdjustmentTransactionID, rec.CreatedAt,
+	)
+	if err != nil {
+		logger.Error("创建对账记录失败", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "创建对账记录失败"})
+		return
+	}
+	rec.ID, _ = res.LastInsertId()
+
+	// 锁定截至账单日的已清算流水
+	res, err = tx.Exec(`
+        UPDATE transactions SET reconciliation_id = ?
+        WHERE user_id = ? AND (from_account_id = ? OR to_account_id = ?)
+          AND cleared = 1 AND reconciliation_id IS NULL AND deleted_at IS NULL AND date(transaction_date) <= ?`,
+		rec.ID, userID, accountID, accountID, rec.StatementDate,
+	)
+	if err != nil {
+		logger.Error("锁定已对账流水失败", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "锁定已对账流水失败"})
+		return
+	}
+	count, _ := res.RowsAffected()
+	rec.TransactionCount = int(count)
+	if _, err := tx.Exec("UPDATE reconciliations SET transaction_count = ? WHERE id = ?", rec.TransactionCount, rec.ID); err != nil {
+		logger.Error("更新对账记录失败", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "创建对账记录失败"})
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		logger.Error("提交事务失败", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "提交事务失败"})
+		return
+	}
+	c.JSON(http.StatusCreated, rec)
+}
+
+// GetReconciliations 返回账户的对账历史，最近的在前
+func (h *DBHandler) GetReconciliations(c *gin.Context) {
+	userID, _ := c.Get("userID")
+	logger := h.Logger.With(slog.Int64("userID", userID.(int64)), "accountID", c.Param("id"))
+
+	rows, err := h.DB.Query(`
+        SELECT id, account_id, statement_date, statement_balance, cleared_balance, difference, adjustment_transaction_id, transaction_count, created_at
+        FROM reconciliations WHERE account_id = ? AND user_id = ?
+        ORDER BY statement_date DESC, id DESC`, c.Param("id"), userID)
+	if err != nil {
+		logger.Error("查询对账记录失败", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询对账记录失败"})
+		return
+	}
+	defer rows.Close()
+
+	reconciliations := []Reconciliation{}
+	for rows.Next() {
+		var r Reconciliation
+		var adjustmentID sql.NullInt64
+		if err := rows.Scan(&r.ID, &r.AccountID, &r.StatementDate, &r.StatementBalance, &r.ClearedBalance, &r.Difference, &adjustmentID, &r.TransactionCount, &r.CreatedAt); err != nil {
+			logger.Error("扫描对账记录失败", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "查询对账记录失败"})
+			return
+		}
+		if adjustmentID.Valid {
+			r.AdjustmentTransactionID = &adjustmentID.Int64
+		}
+		reconciliations = append(reconciliations, r)
+	}
+	c.JSON(http.StatusOK, reconciliations)
+}
+
+// SetTransactionCleared 标记或取消标记流水为已清算；已对账锁定的流水不能修改
+func (h *DBHandler) SetTransactionCleared(c *gin.Context) {
+	userID, _ := c.Get("userID")
+	id := c.Param("id")
+	logger := h.Logger.With(slog.Int64("userID", userID.(int64)), "transactionID", id)
+
+	var req TransactionClearedRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的请求数据: " + err.Error()})
+		return
+	}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		logger.Error("开启事务失败", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "开启事务失败"})
+		return
+	}
+	defer tx.Rollback()
+
+	var reconciliationID sql.NullInt64
+	err = tx.QueryRow("SELECT reconciliation_id FROM transactions WHERE id = ? AND user_id = ? AND deleted_at IS NULL", id, userID).Scan(&reconciliationID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "未找到指定ID的流水"})
+			return
+		}
+		logger.Error("查询流水失败", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "服务器内部错误"})
+		return
+	}
+	if reconciliationID.Valid {
+		c.JSON(http.StatusConflict, gin.H{"error": "该流水已对账锁定，不能修改清算状态"})
+		return
+	}
+
+	before, err := auditSnapshot(tx, "transaction", userID.(int64), id)
+	if err != nil {
+		writeLedgerError(c, logger, err)
+		return
+	}
+	if _, err := tx.Exec("UPDATE transactions SET cleared = ? WHERE id = ? AND user_id = ? AND reconciliation_id IS NULL", *req.Cleared, id, userID); err != nil {
+		logger.Error("更新清算状态失败", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "更新清算状态失败"})
+		return
+	}
+	if err := writeAudit(tx, auditActorFromContext(c), "transaction", "update", id, before); err != nil {
+		writeLedgerError(c, logger, err)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		logger.Error("提交事务失败", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "提交事务失败"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "清算状态已更新"})
+}
@@ -0,0 +1,116 @@
+// bookkeeper-app/reconciliation_handlers_test.go
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// 测试对账流程：计算差额与未清算流水、差额不为零时拒绝或生成调整流水、完成后锁定流水并记录历史
+func TestReconciliation(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	handler := &DBHandler{DB: db, Logger: slog.New(slog.NewJSONHandler(io.Discard, nil))}
+	router := setupRouter(handler)
+
+	userID := createTestUser(t, db, "testuser", "password")
+	token := getTestAuthToken(t, userID, "testuser", false)
+	cardID := createTestAccount(t, db, userID, "银行卡", 1000.0)
+	walletID := createTestAccount(t, db, userID, "钱包", 0)
+
+	var ids []int64
+	for _, req := range []CreateTransactionRequest{
+		{Type: "expense", Amount: yuan(100), TransactionDate: "2024-05-03", FromAccountID: &cardID},
+		{Type: "transfer", Amount: yuan(50), TransactionDate: "2024-05-10", FromAccountID: &cardID, ToAccountID: &walletID},
+		{Type: "expense", Amount: yuan(30), TransactionDate: "2024-05-20", FromAccountID: &cardID},
+		{Type: "income", Amount: yuan(200), TransactionDate: "2024-06-02", ToAccountID: &cardID},
+	} {
+		body, _ := json.Marshal(req)
+		w := performRequest(router, "POST", "/api/v1/transactions", bytes.NewBuffer(body), token)
+		assert.Equal(t, http.StatusCreated, w.Code)
+		var resp struct {
+			ID int64 `json:"id"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		ids = append(ids, resp.ID)
+	}
+	setCleared := func(id int64, cleared bool) int {
+		body, _ := json.Marshal(map[string]bool{"cleared": cleared})
+		return performRequest(router, "PUT", fmt.Sprintf("/api/v1/transactions/%d/cleared", id), bytes.NewBuffer(body), token).Code
+	}
+	assert.Equal(t, http.StatusOK, setCleared(ids[0], true))
+	assert.Equal(t, http.StatusOK, setCleared(ids[1], true))
+	assert.Equal(t, http.StatusOK, setCleared(ids[3], true)) // 账单日之后的流水不影响本次对账
+
+	// 当前余额 1020，已清算余额 = 1020 - 200 (6 月) - (-30) (未清算) = 850
+	previewURL := fmt.Sprintf("/api/v1/accounts/%d/reconciliation?statement_date=2024-05-31&statement_balance=840", cardID)
+	w := performRequest(router, "GET", previewURL, nil, token)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var preview ReconciliationPreview
+	json.Unmarshal(w.Body.Bytes(), &preview)
+	assert.Equal(t, yuan(850), preview.ClearedBalance)
+	assert.Equal(t, yuan(-10), preview.Difference)
+	if assert.Len(t, preview.Uncleared, 1) {
+		assert.Equal(t, ids[2], preview.Uncleared[0].ID)
+	}
+
+	// 有差额且未要求调整时拒绝完成
+	reconcileURL := fmt.Sprintf("/api/v1/accounts/%d/reconciliations", cardID)
+	w = performRequest(router, "POST", reconcileURL, bytes.NewBufferString(`{"statement_date":"2024-05-31","statement_balance":840}`), token)
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	// 生成调减 10 元的余额调整后完成对账，调整不计入支出统计
+	w = performRequest(router, "POST", reconcileURL, bytes.NewBufferString(`{"statement_date":"2024-05-31","statement_balance":840,"create_adjustment":true}`), token)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	var rec Reconciliation
+	json.Unmarshal(w.Body.Bytes(), &rec)
+	assert.Equal(t, yuan(-10), rec.Difference)
+	assert.Equal(t, 3, rec.TransactionCount) // 两笔已清算流水 + 调整流水
+	if assert.NotNil(t, rec.AdjustmentTransactionID) {
+		var txType string
+		var amount Money
+		db.QueryRow("SELECT type, amount FROM transactions WHERE id = ?", *rec.AdjustmentTransactionID).Scan(&txType, &amount)
+		assert.Equal(t, "adjustment", txType)
+		assert.Equal(t, yuan(10), amount)
+		var lines int
+		db.QueryRow("SELECT COUNT(*) FROM transaction_lines WHERE transaction_id = ?", *rec.AdjustmentTransactionID).Scan(&lines)
+		assert.Zero(t, lines)
+	}
+	var balance Money
+	db.QueryRow("SELECT balance FROM accounts WHERE id = ?", cardID).Scan(&balance)
+	assert.Equal(t, yuan(1010), balance)
+
+	// 已锁定的流水不能修改、删除或取消清算；转账对另一账户同样锁定
+	assert.Equal(t, http.StatusConflict, setCleared(ids[0], false))
+	w = performRequest(router, "DELETE", fmt.Sprintf("/api/v1/transactions/%d", ids[1]), nil, token)
+	assert.Equal(t, http.StatusConflict, w.Code)
+	body, _ := json.Marshal(CreateTransactionRequest{Type: "expense", Amount: yuan(1), TransactionDate: "2024-05-03", FromAccountID: &cardID})
+	w = performRequest(router, "PUT", fmt.Sprintf("/api/v1/transactions/%d", ids[0]), bytes.NewBuffer(body), token)
+	assert.Equal(t, http.StatusConflict, w.Code)
+	w = performRequest(router, "DELETE", fmt.Sprintf("/api/v1/transactions/%d", ids[2]), nil, token)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// 账单日期不能早于上次对账
+	w = performRequest(router, "POST", reconcileURL, bytes.NewBufferString(`{"statement_date":"2024-05-01","statement_balance":0}`), token)
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	w = performRequest(router, "GET", reconcileURL, nil, token)
+	var history []Reconciliation
+	json.Unmarshal(w.Body.Bytes(), &history)
+	assert.Len(t, history, 1)
+
+	w = performRequest(router, "GET", "/api/v1/transactions?year=2024&month=5", nil, token)
+	var list GetTransactionsResponse
+	json.Unmarshal(w.Body.Bytes(), &list)
+	for _, tr := range list.Transactions {
+		assert.True(t, tr.Cleared)
+		assert.NotNil(t, tr.ReconciliationID)
+	}
+}
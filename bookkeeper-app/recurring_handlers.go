@@ -0,0 +1,545 @@
+// bookkeeper-app/recurring_handlers.go
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+)
+
+const dateLayout = "2006-01-02"
+
+// rowScanner 兼容 *sql.Row 和 *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// setupRecurringRules 创建周期规则表以及已执行期次表。
+// recurring_occurrences 的唯一约束保证同一规则的同一期最多入账一次，即使调度器重启也不会重复记账；
+// 无法入账而被跳过的期次 transaction_id 为空，skip_reason 记录跳过原因。
+func setupRecurringRules(tx *sql.Tx) error {
+	if _, err := tx.Exec(`
+    CREATE TABLE IF NOT EXISTS recurring_rules (
+        "id" INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+        "user_id" INTEGER NOT NULL,
+        "template" TEXT NOT NULL,
+        "frequency" TEXT NOT NULL,
+        "day_of_month" INTEGER,
+        "start_date" TEXT NOT NULL,
+        "end_date" TEXT,
+        "max_occurrences" INTEGER,
+        "occurrence_count" INTEGER NOT NULL DEFAULT 0,
+        "next_run_date" TEXT,
+        "is_active" INTEGER NOT NULL DEFAULT 1,
+        "last_error" TEXT,
+        "created_at" TEXT NOT NULL,
+        FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+    );`); err != nil {
+		return fmt.Errorf("创建 recurring_rules 表失败: %w", err)
+	}
+	if _, err := tx.Exec(`
+    CREATE TABLE IF NOT EXISTS recurring_occurrences (
+        "id" INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+        "rule_id" INTEGER NOT NULL,
+        "occurrence_date" TEXT NOT NULL,
+        "transaction_id" INTEGER,
+        "created_at" TEXT NOT NULL,
+        FOREIGN KEY(rule_id) REFERENCES recurring_rules(id) ON DELETE CASCADE,
+        FOREIGN KEY(transaction_id) REFERENCES transactions(id) ON DELETE SET NULL,
+        UNIQUE(rule_id, occurrence_date)
+    );`); err != nil {
+		return fmt.Errorf("创建 recurring_occurrences 表失败: %w", err)
+	}
+	if err := addColumnIfMissing(tx, "recurring_occurrences", "skip_reason", `"skip_reason" TEXT`); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`CREATE INDEX IF NOT EXISTS idx_recurring_rules_due ON recurring_rules (is_active, next_run_date);`); err != nil {
+		return fmt.Errorf("为 recurring_rules 创建索引失败: %w", err)
+	}
+	return nil
+}
+
+// recurringSchedule 规则的执行计划，用于推算各期日期
+type recurringSchedule struct {
+	Frequency  string
+	DayOfMonth int // 仅 monthly 使用，0 表示沿用开始日期中的日
+	Start      time.Time
+}
+
+// clampDate 构造日期，日超出当月天数时取当月最后一天 (如 31 号在 2 月取 28/29 号)
+func clampDate(year int, month time.Month, day int) time.Time {
+	first := time.Date(year, month, 1, 0, 0, 0, 0, time.UTC)
+	if last := first.AddDate(0, 1, -1).Day(); day > last {
+		day = last
+	}
+	return time.Date(first.Year(), first.Month(), day, 0, 0, 0, 0, time.UTC)
+}
+
+func (s recurringSchedule) monthDay() int {
+	if s.DayOfMonth > 0 {
+		return s.DayOfMonth
+	}
+	return s.Start.Day()
+}
+
+// first 返回第一期的日期 (不早于开始日期)
+func (s recurringSchedule) first() time.Time {
+	if s.Frequency == "monthly" {
+		d := clampDate(s.Start.Year(), s.Start.Month(), s.monthDay())
+		if d.Before(s.Start) {
+			d = clampDate(s.Start.Year(), s.Start.Month()+1, s.monthDay())
+		}
+		return d
+	}
+	return s.Start
+}
+
+// next 返回 d 之后的下一期日期
+func (s recurringSchedule) next(d time.Time) time.Time {
+	switch s.Frequency {
+	case "daily":
+		return d.AddDate(0, 0, 1)
+	case "weekly":
+		return d.AddDate(0, 0, 7)
+	case "monthly":
+		return clampDate(d.Year(), d.Month()+1, s.monthDay())
+	default: // yearly
+		return clampDate(d.Year()+1, s.Start.Month(), s.Start.Day())
+	}
+}
+
+// onOrAfter 返回不早于 date 的第一期日期
+func (s recurringSchedule) onOrAfter(date time.Time) time.Time {
+	d := s.first()
+	for d.Before(date) {
+		d = s.next(d)
+	}
+	return d
+}
+
+func (r *RecurringRule) schedule() recurringSchedule {
+	start, _ := time.Parse(dateLayout, r.StartDate)
+	s := recurringSchedule{Frequency: r.Frequency, Start: start}
+	if r.DayOfMonth != nil {
+		s.DayOfMonth = *r.DayOfMonth
+	}
+	return s
+}
+
+// finishedAt 判断在已执行 count 期的情况下，日期为 date 的一期是否已超出规则的结束条件
+func (r *RecurringRule) finishedAt(date string, count int) bool {
+	return (r.EndDate != nil && date > *r.EndDate) || (r.MaxOccurrences != nil && count >= *r.MaxOccurrences)
+}
+
+const recurringRuleColumns = `id, user_id, template, frequency, day_of_month, start_date, end_date, max_occurrences,
+        occurrence_count, next_run_date, is_active, last_error, created_at`
+
+func scanRecurringRule(row rowScanner) (RecurringRule, error) {
+	var r RecurringRule
+	var template string
+	var dayOfMonth, maxOccurrences sql.NullInt64
+	var endDate, nextRunDate, lastError sql.NullString
+	var isActive int
+	if err := row.Scan(&r.ID, &r.UserID, &template, &r.Frequency, &dayOfMonth, &r.StartDate, &endDate, &maxOccurrences,
+		&r.OccurrenceCount, &nextRunDate, &isActive, &lastError, &r.CreatedAt); err != nil {
+		return r, err
+	}
+	if err := json.Unmarshal([]byte(template), &r.Template); err != nil {
+		return r, fmt.Errorf("解析周期规则模板失败: %w", err)
+	}
+	if dayOfMonth.Valid {
+		v := int(dayOfMonth.Int64)
+		r.DayOfMonth = &v
+	}
+	if maxOccurrences.Valid {
+		v := int(maxOccurrences.Int64)
+		r.MaxOccurrences = &v
+	}
+	if endDate.Valid {
+		r.EndDate = &endDate.String
+	}
+	if nextRunDate.Valid {
+		r.NextRunDate = &nextRunDate.String
+	}
+	if lastError.Valid {
+		r.LastError = &lastError.String
+	}
+	r.IsActive = isActive == 1
+	return r, nil
+}
+
+// validateRecurringRequest 校验计划字段和流水模板，模板的归属权检查与 CreateTransaction 一致 (余额在每期入账时检查)
+func validateRecurringRequest(tx *sql.Tx, userID int64, req *RecurringRuleRequest) error {
+	if _, err := time.Parse(dateLayout, req.StartDate); err != nil {
+		return &ledgerError{Status: http.StatusBadRequest, Message: "start_date 格式应为 YYYY-MM-DD"}
+	}
+	if req.EndDate != nil {
+		if _, err := time.Parse(dateLayout, *req.EndDate); err != nil {
+			return &ledgerError{Status: http.StatusBadRequest, Message: "end_date 格式应为 YYYY-MM-DD"}
+		}
+		if *req.EndDate < req.StartDate {
+			return &ledgerError{Status: http.StatusBadRequest, Message: "end_date 不能早于 start_date"}
+		}
+	}
+
+	tpl := &req.Template
+	tpl.TransactionDate = req.StartDate
+	if err := binding.Validator.ValidateStruct(tpl); err != nil {
+		return &ledgerError{Status: http.StatusBadRequest, Message: "无效的流水模板: " + err.Error()}
+	}
+	if tpl.Type == "settlement" {
+		return &ledgerError{Status: http.StatusBadRequest, Message: "月度结算流水不能设置为周期规则"}
+	}
+	if err := validateSplits(tpl); err != nil {
+		return err
+	}
+	if tpl.FromAccountID != nil && !isOwner(tx, userID, "accounts", *tpl.FromAccountID) {
+		return &ledgerError{Status: http.StatusForbidden, Message: "无权操作付款账户"}
+	}
+	if tpl.ToAccountID != nil && !isOwner(tx, userID, "accounts", *tpl.ToAccountID) {
+		return &ledgerError{Status: http.StatusForbidden, Message: "无权操作收款账户"}
+	}
+	if tpl.RelatedLoanID != nil && !isOwner(tx, userID, "loans", *tpl.RelatedLoanID) {
+		return &ledgerError{Status: http.StatusForbidden, Message: "无权操作关联贷款"}
+	}
+	return nil
+}
+
+// GetRecurringRules 获取当前用户的全部周期规则
+func (h *DBHandler) GetRecurringRules(c *gin.Context) {
+	userID, _ := c.Get("userID")
+	logger := h.Logger.With(slog.Int64("userID", userID.(int64)))
+
+	rows, err := h.DB.Query("SELECT "+recurringRuleColumns+" FROM recurring_rules WHERE user_id = ? ORDER BY is_active DESC, next_run_date ASC", userID)
+	if err != nil {
+		logger.Error("查询周期规则失败", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询周期规则失败"})
+		return
+	}
+	defer rows.Close()
+
+	rules := []RecurringRule{}
+	for rows.Next() {
+		r, err := scanRecurringRule(rows)
+		if err != nil {
+			logger.Error("扫描周期规则失败", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "扫描周期规则失败"})
+			return
+		}
+		rules = append(rules, r)
+	}
+	c.JSON(http.StatusOK, rules)
+}
+
+// CreateRecurringRule 创建周期规则，下一次执行日期为计划中的第一期
+func (h *DBHandler) CreateRecurringRule(c *gin.Context) {
+	userID, _ := c.Get("userID")
+	logger := h.Logger.With(slog.Int64("userID", userID.(int64)))
+
+	var req RecurringRuleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的请求数据: " + err.Error()})
+		return
+	}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		logger.Error("开启事务失败", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "开启事务失败"})
+		return
+	}
+	defer tx.Rollback()
+
+	if err := validateRecurringRequest(tx, userID.(int64), &req); err != nil {
+		writeLedgerError(c, logger, err)
+		return
+	}
+
+	rule := RecurringRule{Frequency: req.Frequency, DayOfMonth: req.DayOfMonth, StartDate: req.StartDate}
+	nextRunDate := rule.schedule().first().Format(dateLayout)
+	isActive := req.IsActive == nil || *req.IsActive
+	template, _ := json.Marshal(req.Template)
+	createdAt := time.Now().Format(time.RFC3339)
+
+	res, err := tx.Exec(
+		"INSERT INTO recurring_rules (user_id, template, frequency, day_of_month, start_date, end_date, max_occurrences, next_run_date, is_active, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		userID, string(template), req.Frequency, req.DayOfMonth, req.StartDate, req.EndDate, req.MaxOccurrences, nextRunDate, isActive, createdAt,
+	)
+	if err != nil {
+		logger.Error("创建周期规则失败", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "创建周期规则失败"})
+		return
+	}
+	id, _ := res.LastInsertId()
+
+	if err := tx.Commit(); err != nil {
+		logger.Error("提交事务失败", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "提交事务失败"})
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"message": "周期规则创建成功", "id": id, "next_run_date": nextRunDate})
+}
+
+// UpdateRecurringRule 修改周期规则。已入账的期次不会重复入账，下一次执行日期从最后一次入账之后重新推算。
+func (h *DBHandler) UpdateRecurringRule(c *gin.Context) {
+	userID, _ := c.Get("userID")
+	id := c.Param("id")
+	logger := h.Logger.With(slog.Int64("userID", userID.(int64)), "ruleID", id)
+
+	var req RecurringRuleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的请求数据: " + err.Error()})
+		return
+	}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		logger.Error("开启事务失败", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "开启事务失败"})
+		return
+	}
+	defer tx.Rollback()
+
+	existing, err := scanRecurringRule(tx.QueryRow("SELECT "+recurringRuleColumns+" FROM recurring_rules WHERE id = ? AND user_id = ?", id, userID))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "未找到指定ID的周期规则"})
+		} else {
+			logger.Error("查询周期规则失败", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "服务器内部错误"})
+		}
+		return
+	}
+	if err := validateRecurringRequest(tx, userID.(int64), &req); err != nil {
+		writeLedgerError(c, logger, err)
+		return
+	}
+
+	rule := RecurringRule{
+		Frequency: req.Frequency, DayOfMonth: req.DayOfMonth, StartDate: req.StartDate,
+		EndDate: req.EndDate, MaxOccurrences: req.MaxOccurrences,
+	}
+	from, _ := time.Parse(dateLayout, req.StartDate)
+	var lastPosted sql.NullString
+	if err := tx.QueryRow("SELECT MAX(occurrence_date) FROM recurring_occurrences WHERE rule_id = ?", id).Scan(&lastPosted); err != nil {
+		logger.Error("查询已入账期次失败", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询已入账期次失败"})
+		return
+	}
+	if lastPosted.Valid {
+		if d, err := time.Parse(dateLayout, lastPosted.String); err == nil && !d.Before(from) {
+			from = d.AddDate(0, 0, 1)
+		}
+	}
+	var nextRunDate *string
+	if next := rule.schedule().onOrAfter(from).Format(dateLayout); !rule.finishedAt(next, existing.OccurrenceCount) {
+		nextRunDate = &next
+	}
+	isActive := existing.IsActive
+	if req.IsActive != nil {
+		isActive = *req.IsActive
+	}
+	template, _ := json.Marshal(req.Template)
+
+	_, err = tx.Exec(
+		"UPDATE recurring_rules SET template = ?, frequency = ?, day_of_month = ?, start_date = ?, end_date = ?, max_occurrences = ?, next_run_date = ?, is_active = ?, last_error = NULL WHERE id = ? AND user_id = ?",
+		string(template), req.Frequency, req.DayOfMonth, req.StartDate, req.EndDate, req.MaxOccurrences, nextRunDate, isActive && nextRunDate != nil, id, userID,
+	)
+	if err != nil {
+		logger.Error("更新周期规则失败", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "更新周期规则失败"})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		logger.Error("提交事务失败", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "提交事务失败"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "周期规则更新成功", "next_run_date": nextRunDate})
+}
+
+// DeleteRecurringRule 删除周期规则，已生成的流水保留
+func (h *DBHandler) DeleteRecurringRule(c *gin.Context) {
+	userID, _ := c.Get("userID")
+	id := c.Param("id")
+	res, err := h.DB.Exec("DELETE FROM recurring_rules WHERE id = ? AND user_id = ?", id, userID)
+	if err != nil {
+		h.Logger.Error("删除周期规则失败", "error", err, "ruleID", id, slog.Int64("userID", userID.(int64)))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "删除周期规则失败"})
+		return
+	}
+	rowsAffected, _ := res.RowsAffected()
+	if rowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "未找到指定ID的周期规则"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "周期规则删除成功，已生成的流水不受影响"})
+}
+
+// StartRecurringScheduler 后台调度器：启动时立即执行一次 (补记停机期间错过的期次)，之后每隔 interval 执行一次
+func (h *DBHandler) StartRecurringScheduler(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if posted := h.runDueRecurringRules(time.Now()); posted > 0 {
+			h.Logger.Info("周期规则已自动入账", "count", posted)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// runDueRecurringRules 执行所有截至 now 已到期的期次，返回成功入账的笔数
+func (h *DBHandler) runDueRecurringRules(now time.Time) int {
+	today := now.Format(dateLayout)
+	rows, err := h.DB.Query("SELECT id FROM recurring_rules WHERE is_active = 1 AND next_run_date IS NOT NULL AND next_run_date <= ?", today)
+	if err != nil {
+		h.Logger.Error("查询到期周期规则失败", "error", err)
+		return 0
+	}
+	var ruleIDs []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err == nil {
+			ruleIDs = append(ruleIDs, id)
+		}
+	}
+	rows.Close()
+
+	posted := 0
+	for _, ruleID := range ruleIDs {
+		for {
+			occurrence, done, err := h.postNextOccurrence(ruleID, today)
+			if err != nil {
+				h.Logger.Warn("周期规则入账失败", "ruleID", ruleID, "occurrence", occurrence, "error", err)
+				// 重试也无法成功的期次 (如所在月份已结算、账户已归档) 登记为已跳过，不再阻塞之后的期次
+				var le *ledgerError
+				if errors.As(err, &le) && le.Status < http.StatusInternalServerError && !le.Retryable {
+					if err := h.skipOccurrence(ruleID, occurrence, le.Message); err != nil {
+						h.Logger.Error("跳过周期规则期次失败", "ruleID", ruleID, "occurrence", occurrence, "error", err)
+						break
+					}
+					continue
+				}
+				// 其余失败 (如余额不足) 停在这一期，记录原因，下次调度时重试
+				message := err.Error()
+				if le != nil {
+					message = le.Message
+				}
+				h.DB.Exec("UPDATE recurring_rules SET last_error = ? WHERE id = ?", message, ruleID)
+				break
+			}
+			if done {
+				break
+			}
+			posted++
+		}
+	}
+	return posted
+}
+
+// postNextOccurrence 在一个事务中入账规则的下一期并推进计划，返回这一期的日期。没有到期期次时返回 done=true。
+func (h *DBHandler) postNextOccurrence(ruleID int64, today string) (string, bool, error) {
+	tx, err := h.DB.Begin()
+	if err != nil {
+		return "", false, err
+	}
+	defer tx.Rollback()
+
+	rule, err := scanRecurringRule(tx.QueryRow("SELECT "+recurringRuleColumns+" FROM recurring_rules WHERE id = ?", ruleID))
+	if err != nil {
+		return "", false, err
+	}
+	if !rule.IsActive || rule.NextRunDate == nil || *rule.NextRunDate > today {
+		return "", true, nil
+	}
+	occurrence := *rule.NextRunDate
+
+	// 结束条件可能在规则修改后才满足，此时直接结束规则
+	if rule.finishedAt(occurrence, rule.OccurrenceCount) {
+		if _, err := tx.Exec("UPDATE recurring_rules SET next_run_date = NULL, is_active = 0 WHERE id = ?", ruleID); err != nil {
+			return occurrence, false, err
+		}
+		return occurrence, true, tx.Commit()
+	}
+
+	// 先登记期次：如果这一期已经入账过 (唯一约束冲突)，只推进计划而不重复记账
+	res, err := tx.Exec("INSERT OR IGNORE INTO recurring_occurrences (rule_id, occurrence_date, created_at) VALUES (?, ?, ?)",
+		ruleID, occurrence, time.Now().Format(time.RFC3339))
+	if err != nil {
+		return occurrence, false, err
+	}
+	count := rule.OccurrenceCount
+	if inserted, _ := res.RowsAffected(); inserted == 1 {
+		req := rule.Template
+		req.TransactionDate = occurrence
+		transactionID, err := createTransactionInTx(tx, rule.UserID, &req)
+		if err != nil {
+			return occurrence, false, err
+		}
+		// 由调度器代规则所有者入账，没有请求来源
+		if err := writeAudit(tx, auditActor{UserID: rule.UserID}, "transaction", "create", transactionID, nil); err != nil {
+			return occurrence, false, err
+		}
+		if _, err := tx.Exec("UPDATE recurring_occurrences SET transaction_id = ? WHERE rule_id = ? AND occurrence_date = ?", transactionID, ruleID, occurrence); err != nil {
+			return occurrence, false, err
+		}
+		count++
+	}
+
+	if err := advanceRecurringRule(tx, &rule, occurrence, count, nil); err != nil {
+		return occurrence, false, err
+	}
+	return occurrence, false, tx.Commit()
+}
+
+// skipOccurrence 登记无法入账的一期 (不生成流水) 并推进计划，跳过原因同时记入 skip_reason 和规则的 last_error。
+// 跳过的期次不计入已执行次数。
+func (h *DBHandler) skipOccurrence(ruleID int64, occurrence, reason string) error {
+	tx, err := h.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	rule, err := scanRecurringRule(tx.QueryRow("SELECT "+recurringRuleColumns+" FROM recurring_rules WHERE id = ?", ruleID))
+	if err != nil {
+		return err
+	}
+	// 规则在此期间被修改过，交给下一轮调度按新计划处理
+	if rule.NextRunDate == nil || *rule.NextRunDate != occurrence {
+		return nil
+	}
+	if _, err := tx.Exec("INSERT OR IGNORE INTO recurring_occurrences (rule_id, occurrence_date, skip_reason, created_at) VALUES (?, ?, ?, ?)",
+		ruleID, occurrence, reason, time.Now().Format(time.RFC3339)); err != nil {
+		return err
+	}
+	message := fmt.Sprintf("%s 这一期未能入账，已跳过：%s", occurrence, reason)
+	if err := advanceRecurringRule(tx, &rule, occurrence, rule.OccurrenceCount, &message); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// advanceRecurringRule 把规则的下一次执行日期推进到 occurrence 之后的一期，没有下一期时结束规则
+func advanceRecurringRule(tx *sql.Tx, rule *RecurringRule, occurrence string, count int, lastError *string) error {
+	current, _ := time.Parse(dateLayout, occurrence)
+	var nextRunDate *string
+	if next := rule.schedule().next(current).Format(dateLayout); !rule.finishedAt(next, count) {
+		nextRunDate = &next
+	}
+	_, err := tx.Exec("UPDATE recurring_rules SET next_run_date = ?, occurrence_count = ?, is_active = ?, last_error = ? WHERE id = ?",
+		nextRunDate, count, nextRunDate != nil, lastError, rule.ID)
+	return err
+}
@@ -0,0 +1,161 @@
+// bookkeeper-app/recurring_handlers_test.go
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// 测试月末规则的日期推算 (31 号在短月份取月末)
+func TestRecurringSchedule_MonthEndClamp(t *testing.T) {
+	start, _ := time.Parse(dateLayout, "2024-01-31")
+	s := recurringSchedule{Frequency: "monthly", DayOfMonth: 31, Start: start}
+
+	var dates []string
+	d := s.first()
+	for i := 0; i < 4; i++ {
+		dates = append(dates, d.Format(dateLayout))
+		d = s.next(d)
+	}
+	assert.Equal(t, []string{"2024-01-31", "2024-02-29", "2024-03-31", "2024-04-30"}, dates)
+}
+
+// 测试调度器补记错过的期次、遵守次数上限，并且重复执行不会重复入账
+func TestRunDueRecurringRules_CatchUpAndIdempotent(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	handler := &DBHandler{DB: db, Logger: slog.New(slog.NewJSONHandler(io.Discard, nil))}
+	router := setupRouter(handler)
+
+	userID := createTestUser(t, db, "testuser", "password")
+	token := getTestAuthToken(t, userID, "testuser", false)
+	accountID := createTestAccount(t, db, userID, "Test Account", 1000.0)
+
+	category := "rent_mortgage"
+	dayOfMonth := 31
+	maxOccurrences := 3
+	ruleReq := RecurringRuleRequest{
+		Template: CreateTransactionRequest{
+			Type:          "expense",
+			Amount:        yuan(100.0),
+			Description:   "房租",
+			CategoryID:    &category,
+			FromAccountID: &accountID,
+		},
+		Frequency:      "monthly",
+		DayOfMonth:     &dayOfMonth,
+		StartDate:      "2024-01-15",
+		MaxOccurrences: &maxOccurrences,
+	}
+	body, _ := json.Marshal(ruleReq)
+	w := performRequest(router, "POST", "/api/v1/recurring", bytes.NewBuffer(body), token)
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	now, _ := time.Parse(dateLayout, "2024-12-01")
+	assert.Equal(t, 3, handler.runDueRecurringRules(now))
+	// 模拟重启后再次执行，不应重复入账
+	assert.Equal(t, 0, handler.runDueRecurringRules(now))
+
+	rows, _ := db.Query("SELECT transaction_date FROM transactions WHERE user_id = ? ORDER BY transaction_date", userID)
+	var dates []string
+	for rows.Next() {
+		var d string
+		rows.Scan(&d)
+		dates = append(dates, d)
+	}
+	rows.Close()
+	assert.Equal(t, []string{"2024-01-31", "2024-02-29", "2024-03-31"}, dates)
+
+	var balance Money
+	db.QueryRow("SELECT balance FROM accounts WHERE id = ?", accountID).Scan(&balance)
+	assert.Equal(t, yuan(700), balance)
+
+	w = performRequest(router, "GET", "/api/v1/recurring", nil, token)
+	var rules []RecurringRule
+	json.Unmarshal(w.Body.Bytes(), &rules)
+	if assert.Len(t, rules, 1) {
+		assert.False(t, rules[0].IsActive)
+		assert.Nil(t, rules[0].NextRunDate)
+		assert.Equal(t, 3, rules[0].OccurrenceCount)
+	}
+}
+
+// 测试余额不足时停在当期并记录原因，余额补足后继续补记
+func TestRunDueRecurringRules_RetriesAfterFailure(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	handler := &DBHandler{DB: db, Logger: slog.New(slog.NewJSONHandler(io.Discard, nil))}
+	router := setupRouter(handler)
+
+	userID := createTestUser(t, db, "testuser", "password")
+	token := getTestAuthToken(t, userID, "testuser", false)
+	accountID := createTestAccount(t, db, userID, "Test Account", 15.0)
+
+	ruleReq := RecurringRuleRequest{
+		Template:  CreateTransactionRequest{Type: "expense", Amount: yuan(10.0), FromAccountID: &accountID},
+		Frequency: "weekly",
+		StartDate: "2024-01-01",
+	}
+	body, _ := json.Marshal(ruleReq)
+	w := performRequest(router, "POST", "/api/v1/recurring", bytes.NewBuffer(body), token)
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	now, _ := time.Parse(dateLayout, "2024-01-16")
+	assert.Equal(t, 1, handler.runDueRecurringRules(now))
+
+	var lastError, nextRunDate string
+	db.QueryRow("SELECT last_error, next_run_date FROM recurring_rules").Scan(&lastError, &nextRunDate)
+	assert.Contains(t, lastError, "账户余额不足")
+	assert.Equal(t, "2024-01-08", nextRunDate)
+
+	db.Exec("UPDATE accounts SET balance = ? WHERE id = ?", yuan(100), accountID)
+	assert.Equal(t, 2, handler.runDueRecurringRules(now))
+}
+
+// 测试所在月份已结算的期次被跳过并记录原因，不会阻塞之后的期次
+func TestRunDueRecurringRules_SkipsSettledMonth(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	handler := &DBHandler{DB: db, Logger: slog.New(slog.NewJSONHandler(io.Discard, nil))}
+	router := setupRouter(handler)
+
+	userID := createTestUser(t, db, "testuser", "password")
+	token := getTestAuthToken(t, userID, "testuser", false)
+	accountID := createTestAccount(t, db, userID, "Test Account", 100.0)
+
+	ruleReq := RecurringRuleRequest{
+		Template:  CreateTransactionRequest{Type: "expense", Amount: yuan(10.0), FromAccountID: &accountID},
+		Frequency: "weekly",
+		StartDate: "2024-01-22",
+	}
+	body, _ := json.Marshal(ruleReq)
+	w := performRequest(router, "POST", "/api/v1/recurring", bytes.NewBuffer(body), token)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	w = performRequest(router, "POST", "/api/v1/settlements/2024-01", nil, token)
+	assert.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+
+	now, _ := time.Parse(dateLayout, "2024-02-10")
+	assert.Equal(t, 1, handler.runDueRecurringRules(now))
+
+	var skipped int
+	db.QueryRow("SELECT COUNT(*) FROM recurring_occurrences WHERE transaction_id IS NULL AND skip_reason LIKE '%已完成月度结算%'").Scan(&skipped)
+	assert.Equal(t, 2, skipped) // 2024-01-22、2024-01-29
+	var nextRunDate string
+	var count int
+	db.QueryRow("SELECT next_run_date, occurrence_count FROM recurring_rules").Scan(&nextRunDate, &count)
+	assert.Equal(t, "2024-02-12", nextRunDate)
+	assert.Equal(t, 1, count)
+
+	// 修改规则后不会重新尝试已跳过的期次
+	body, _ = json.Marshal(ruleReq)
+	w = performRequest(router, "PUT", "/api/v1/recurring/1", bytes.NewBuffer(body), token)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, 0, handler.runDueRecurringRules(now))
+}
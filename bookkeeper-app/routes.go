@@ -46,8 +46,8 @@ func setupRouter(handler *DBHandler) *gin.Engine {
 			return isOriginAllowed(origin, subnet, allowedStaticOrigins) || origin == ""
 		},
 		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowHeaders:     []string{"Origin", "Content-Length", "Content-Type", "Authorization"},
-		ExposeHeaders:    []string{"Content-Length"},
+		AllowHeaders:     []string{"Origin", "Content-Length", "Content-Type", "Authorization", "Idempotency-Key"},
+		ExposeHeaders:    []string{"Content-Length", "Idempotent-Replayed"},
 		AllowCredentials: true,
 		MaxAge:           12 * time.Hour,
 	}
@@ -62,7 +62,7 @@ func setupRouter(handler *DBHandler) *gin.Engine {
 		}
 
 		protected := base.Group("/")
-		protected.Use(AuthMiddleware())
+		protected.Use(AuthMiddleware(), handler.IdempotencyMiddleware())
 		{
 			protected.PUT("/auth/update_password", handler.UpdatePassword)
 
@@ -73,7 +73,61 @@ func setupRouter(handler *DBHandler) *gin.Engine {
 
 			protected.POST("/transactions", handler.CreateTransaction)
 			protected.GET("/transactions", handler.GetTransactions)
+			protected.GET("/transactions/search", handler.SearchTransactions)
+			protected.GET("/transactions/export", handler.ExportTransactions)
+			protected.POST("/transactions/bulk", handler.BulkCreateTransactions)
+			protected.POST("/transactions/quick", handler.QuickEntry)
+			protected.DELETE("/transactions/bulk", handler.BulkDeleteTransactions)
+			protected.PUT("/transactions/:id", handler.UpdateTransaction)
 			protected.DELETE("/transactions/:id", handler.DeleteTransaction)
+			protected.PUT("/transactions/:id/cleared", handler.SetTransactionCleared)
+			protected.GET("/transactions/:id/postings", handler.GetTransactionPostings)
+			protected.POST("/transactions/:id/attachments", handler.UploadAttachment)
+			protected.GET("/transactions/:id/attachments", handler.GetAttachments)
+			protected.GET("/attachments/:id", handler.DownloadAttachment)
+			protected.DELETE("/attachments/:id", handler.DeleteAttachment)
+
+			trash := protected.Group("/trash")
+			{
+				trash.GET("", handler.GetTrash)
+				trash.POST("/:id/restore", handler.RestoreTransaction)
+			}
+
+			protected.GET("/audit", handler.GetAuditLog)
+			protected.GET("/integrity", handler.GetIntegrity)
+			protected.POST("/integrity/repair", handler.RepairIntegrity)
+
+			tags := protected.Group("/tags")
+			{
+				tags.GET("", handler.GetTags)
+				tags.POST("", handler.CreateTag)
+				tags.PUT("/:id", handler.UpdateTag)
+				tags.DELETE("/:id", handler.DeleteTag)
+			}
+
+			payees := protected.Group("/payees")
+			{
+				payees.GET("", handler.GetPayees)
+				payees.POST("", handler.CreatePayee)
+				payees.PUT("/:id", handler.UpdatePayee)
+				payees.DELETE("/:id", handler.DeletePayee)
+			}
+
+			payeeRules := protected.Group("/payee_rules")
+			{
+				payeeRules.GET("", handler.GetPayeeRules)
+				payeeRules.POST("", handler.CreatePayeeRule)
+				payeeRules.PUT("/:id", handler.UpdatePayeeRule)
+				payeeRules.DELETE("/:id", handler.DeletePayeeRule)
+			}
+
+			recurring := protected.Group("/recurring")
+			{
+				recurring.GET("", handler.GetRecurringRules)
+				recurring.POST("", handler.CreateRecurringRule)
+				recurring.PUT("/:id", handler.UpdateRecurringRule)
+				recurring.DELETE("/:id", handler.DeleteRecurringRule)
+			}
 
 			protected.POST("/loans", handler.CreateLoan)
 			protected.GET("/loans", handler.GetLoans)
@@ -93,23 +147,55 @@ func setupRouter(handler *DBHandler) *gin.Engine {
 				accounts.PUT("/:id", handler.UpdateAccount)
 				accounts.DELETE("/:id", handler.DeleteAccount)
 				accounts.POST("/:id/set_primary", handler.SetPrimaryAccount)
+				accounts.POST("/:id/archive", handler.ArchiveAccount)
+				accounts.POST("/:id/unarchive", handler.UnarchiveAccount)
+				accounts.GET("/:id/reconciliation", handler.PreviewReconciliation)
+				accounts.GET("/:id/reconciliations", handler.GetReconciliations)
+				accounts.POST("/:id/reconciliations", handler.CreateReconciliation)
+				accounts.GET("/:id/statements", handler.GetCreditCardStatements)
+				accounts.GET("/:id/balance", handler.GetAccountBalanceAt)
+				accounts.GET("/:id/history", handler.GetAccountBalanceHistory)
+			}
+
+			rates := protected.Group("/exchange_rates")
+			{
+				rates.GET("", handler.GetExchangeRates)
+				rates.POST("", handler.CreateExchangeRate)
+				rates.POST("/upload", handler.UploadExchangeRates)
+				rates.DELETE("/:id", handler.DeleteExchangeRate)
+			}
+			protected.GET("/settings/base_currency", handler.GetBaseCurrency)
+			protected.PUT("/settings/base_currency", handler.UpdateBaseCurrency)
+
+			settlements := protected.Group("/settlements")
+			{
+				settlements.GET("", handler.GetSettlements)
+				settlements.GET("/:month", handler.GetSettlement)
+				settlements.POST("/:month", handler.CreateSettlement)
+				settlements.DELETE("/:month", handler.DeleteSettlement)
 			}
 
 			protected.GET("/dashboard/cards", handler.GetDashboardCards)
 			protected.GET("/analytics/charts", handler.GetAnalyticsCharts)
+			protected.GET("/analytics/payees", handler.GetPayeeAnalytics)
 			protected.GET("/dashboard/widgets", handler.GetDashboardWidgets)
 
+			protected.POST("/import/csv", handler.ImportCSV)
+
 			protected.GET("/data/export", handler.ExportData)
 			protected.POST("/data/import", handler.ImportData)
 		}
 
 		admin := base.Group("/admin")
-		admin.Use(AdminMiddleware())
+		admin.Use(AdminMiddleware(), handler.IdempotencyMiddleware())
 		{
 			admin.POST("/users/register", handler.Register)
 			admin.GET("/users", handler.GetUsers)
 			admin.DELETE("/users/:id", handler.DeleteUser)
 			admin.GET("/stats", handler.GetSystemStats)
+			admin.GET("/audit", handler.GetAllAuditLog)
+			admin.GET("/integrity", handler.GetAllIntegrity)
+			admin.POST("/integrity/repair", handler.RepairAllIntegrity)
 		}
 	}
 
@@ -0,0 +1,235 @@
+// bookkeeper-app/search_handlers.go
+package main
+
+import (
+	"database/sql"
+	"html"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultSearchLimit = 20
+	maxSearchLimit     = 100
+	// trigram 分词器要求每个检索词至少 3 个字符，更短的词走 LIKE 回退路径
+	minFTSTermLength = 3
+
+	highlightStart = "<mark>"
+	highlightEnd   = "</mark>"
+	// 生成片段时先用控制字符标记命中位置，HTML 转义描述文本后再替换为 <mark> 标签
+	matchStart = "\x02"
+	matchEnd   = "\x03"
+)
+
+// fts5Available 检查当前链接的 SQLite 是否编译了 FTS5 (需要 -tags sqlite_fts5 构建)
+func fts5Available(q interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}) bool {
+	var enabled int
+	err := q.QueryRow("SELECT sqlite_compileoption_used('ENABLE_FTS5')").Scan(&enabled)
+	return err == nil && enabled == 1
+}
+
+// setupTransactionFTS 创建流水描述的 FTS5 外部内容索引及同步触发器。
+// 如果 SQLite 未编译 FTS5，则移除可能残留的触发器 (否则写入流水会失败)，搜索接口退化为 LIKE 匹配。
+func setupTransactionFTS(tx *sql.Tx, logger *slog.Logger) {
+	triggers := map[string]string{
+		"transactions_fts_ai": `
+        CREATE TRIGGER transactions_fts_ai AFTER INSERT ON transactions BEGIN
+            INSERT INTO transactions_fts(rowid, description) VALUES (new.id, new.description);
+        END;`,
+		"transactions_fts_ad": `
+        CREATE TRIGGER transactions_fts_ad AFTER DELETE ON transactions BEGIN
+            INSERT INTO transactions_fts(transactions_fts, rowid, description) VALUES ('delete', old.id, old.description);
+        END;`,
+		"transactions_fts_au": `
+        CREATE TRIGGER transactions_fts_au AFTER UPDATE OF description ON transactions BEGIN
+            INSERT INTO transactions_fts(transactions_fts, rowid, description) VALUES ('delete', old.id, old.description);
+            INSERT INTO transactions_fts(rowid, description) VALUES (new.id, new.description);
+        END;`,
+	}
+
+	if !fts5Available(tx) {
+		logger.Warn("当前 SQLite 未启用 FTS5 (构建时需加 -tags sqlite_fts5)，流水搜索将使用 LIKE 匹配")
+		for name := range triggers {
+			if _, err := tx.Exec("DROP TRIGGER IF EXISTS " + name); err != nil {
+				logger.Warn("移除全文索引触发器失败", "trigger", name, "error", err)
+			}
+		}
+		return
+	}
+
+	if _, err := tx.Exec(`
+    CREATE VIRTUAL TABLE IF NOT EXISTS transactions_fts USING fts5(
+        description,
+        content='transactions',
+        content_rowid='id',
+        tokenize='trigram'
+    );`); err != nil {
+		logger.Warn("创建流水全文索引失败，流水搜索将使用 LIKE 匹配", "error", err)
+		return
+	}
+
+	// 任何一个触发器缺失 (新建索引，或之前由未启用 FTS5 的版本运行过) 都说明索引可能已不同步，需要重建
+	needRebuild := false
+	for name, ddl := range triggers {
+		var count int
+		tx.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'trigger' AND name = ?", name).Scan(&count)
+		if count > 0 {
+			continue
+		}
+		needRebuild = true
+		if _, err := tx.Exec(ddl); err != nil {
+			logger.Warn("创建全文索引触发器失败", "trigger", name, "error", err)
+			return
+		}
+	}
+	if needRebuild {
+		if _, err := tx.Exec("INSERT INTO transactions_fts(transactions_fts) VALUES ('rebuild')"); err != nil {
+			logger.Warn("回填流水全文索引失败", "error", err)
+			return
+		}
+		logger.Info("✅ 流水全文索引已重建")
+	}
+}
+
+// ftsReady 判断全文索引是否可用于当前查询
+func ftsReady(db *sql.DB) bool {
+	if !fts5Available(db) {
+		return false
+	}
+	var count int
+	db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE name = 'transactions_fts'").Scan(&count)
+	return count > 0
+}
+
+// buildFTSQuery 将用户输入转换为安全的 FTS5 查询：每个词都作为短语加引号，词之间为 AND 关系
+func buildFTSQuery(terms []string) string {
+	quoted := make([]string, len(terms))
+	for i, term := range terms {
+		quoted[i] = `"` + strings.ReplaceAll(term, `"`, `""`) + `"`
+	}
+	return strings.Join(quoted, " ")
+}
+
+// highlightSnippet 将带命中标记的片段转为 HTML：描述是用户输入，必须先转义，只有高亮标签是原样输出的
+func highlightSnippet(marked string) string {
+	escaped := html.EscapeString(marked)
+	return strings.NewReplacer(matchStart, highlightStart, matchEnd, highlightEnd).Replace(escaped)
+}
+
+// markTerms 在 LIKE 回退路径中手动标记命中的检索词，标记方式同 FTS5 的 snippet
+func markTerms(text string, terms []string) string {
+	var b strings.Builder
+	for i := 0; i < len(text); {
+		matched := 0
+		for _, term := range terms {
+			if n := len(term); n > matched && i+n <= len(text) && strings.EqualFold(text[i:i+n], term) {
+				matched = n
+			}
+		}
+		if matched > 0 {
+			b.WriteString(matchStart + text[i:i+matched] + matchEnd)
+			i += matched
+			continue
+		}
+		_, size := utf8.DecodeRuneInString(text[i:])
+		b.WriteString(text[i : i+size])
+		i += size
+	}
+	return b.String()
+}
+
+// SearchTransactions 在当前用户的流水描述中进行全文搜索，返回按相关度排序的结果和高亮片段
+func (h *DBHandler) SearchTransactions(c *gin.Context) {
+	userID, _ := c.Get("userID")
+	logger := h.Logger.With(slog.Int64("userID", userID.(int64)))
+
+	terms := strings.Fields(c.Query("q"))
+	if len(terms) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "请提供搜索关键词 (q)"})
+		return
+	}
+	limit := defaultSearchLimit
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "无效的 limit 参数"})
+			return
+		}
+		limit = min(n, maxSearchLimit)
+	}
+
+	useFTS := ftsReady(h.DB)
+	for _, term := range terms {
+		if utf8.RuneCountInString(term) < minFTSTermLength {
+			useFTS = false
+		}
+	}
+
+	var rows *sql.Rows
+	var err error
+	if useFTS {
+		rows, err = h.DB.Query(userCategoriesCTE+`
+        SELECT `+transactionColumns+`,
+            snippet(transactions_fts, 0, ?, ?, '…', 32), -bm25(transactions_fts)
+        FROM transactions_fts
+        JOIN transactions t ON t.id = transactions_fts.rowid
+        `+transactionJoins+`
+        WHERE transactions_fts MATCH ? AND t.user_id = ? AND t.deleted_at IS NULL
+        ORDER BY bm25(transactions_fts), t.transaction_date DESC
+        LIMIT ?`,
+			userID, matchStart, matchEnd, buildFTSQuery(terms), userID, limit)
+	} else {
+		conditions := []string{"t.user_id = ?", "t.deleted_at IS NULL"}
+		args := []interface{}{userID, userID}
+		for _, term := range terms {
+			escaped := strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(term)
+			conditions = append(conditions, `t.description LIKE ? ESCAPE '\'`)
+			args = append(args, "%"+escaped+"%")
+		}
+		args = append(args, limit)
+		rows, err = h.DB.Query(userCategoriesCTE+`
+        SELECT `+transactionColumns+`, t.description, 0
+        FROM transactions t
+        `+transactionJoins+`
+        WHERE `+strings.Join(conditions, " AND ")+`
+        ORDER BY t.transaction_date DESC, t.created_at DESC
+        LIMIT ?`, args...)
+	}
+	if err != nil {
+		logger.Error("搜索流水失败", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "搜索流水失败"})
+		return
+	}
+	defer rows.Close()
+
+	results := []TransactionSearchResult{}
+	for rows.Next() {
+		var snippet sql.NullString
+		var score float64
+		t, err := scanTransaction(rows, &snippet, &score)
+		if err != nil {
+			logger.Error("扫描搜索结果失败", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "扫描搜索结果失败"})
+			return
+		}
+		marked := snippet.String
+		if !useFTS {
+			marked = markTerms(marked, terms)
+		}
+		results = append(results, TransactionSearchResult{Transaction: t, Snippet: highlightSnippet(marked), Score: score})
+	}
+	if err := rows.Err(); err != nil {
+		logger.Error("遍历搜索结果时出错", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "搜索流水失败"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"results": results, "full_text": useFTS})
+}
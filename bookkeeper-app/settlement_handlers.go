@@ -0,0 +1,313 @@
+// bookkeeper-app/settlement_handlers.go
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const monthLayout = "2006-01"
+
+// setupSettlements 创建月度结算快照表。
+// 旧版本在 transactions.settlement_month 上建有唯一索引 (每月只允许一条结算流水)，
+// 现在结算会为当月所有流水打上标记，因此改为普通索引。
+func setupSettlements(tx *sql.Tx) error {
+	if _, err := tx.Exec(`
+    CREATE TABLE IF NOT EXISTS settlements (
+        "id" INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+        "user_id" INTEGER NOT NULL,
+        "month" TEXT NOT NULL,
+        "currency" TEXT NOT NULL,
+        "total_income" INTEGER NOT NULL,
+        "total_expense" INTEGER NOT NULL,
+        "transaction_count" INTEGER NOT NULL DEFAULT 0,
+        "created_at" TEXT NOT NULL,
+        FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE,
+        UNIQUE(user_id, month)
+    );`); err != nil {
+		return fmt.Errorf("创建 settlements 表失败: %w", err)
+	}
+	if _, err := tx.Exec(`
+    CREATE TABLE IF NOT EXISTS settlement_accounts (
+        "id" INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+        "settlement_id" INTEGER NOT NULL,
+        "account_id" INTEGER,
+        "account_name" TEXT NOT NULL,
+        "currency" TEXT NOT NULL,
+        "opening_balance" INTEGER NOT NULL,
+        "income" INTEGER NOT NULL,
+        "expense" INTEGER NOT NULL,
+        "closing_balance" INTEGER NOT NULL,
+        FOREIGN KEY(settlement_id) REFERENCES settlements(id) ON DELETE CASCADE,
+        FOREIGN KEY(account_id) REFERENCES accounts(id) ON DELETE SET NULL
+    );`); err != nil {
+		return fmt.Errorf("创建 settlement_accounts 表失败: %w", err)
+	}
+	if _, err := tx.Exec(`CREATE INDEX IF NOT EXISTS idx_settlement_accounts_settlement ON settlement_accounts (settlement_id);`); err != nil {
+		return fmt.Errorf("为 settlement_accounts 创建索引失败: %w", err)
+	}
+	if _, err := tx.Exec(`DROP INDEX IF EXISTS one_settlement_per_month_per_user_idx;`); err != nil {
+		return fmt.Errorf("删除旧的结算月份唯一索引失败: %w", err)
+	}
+	if _, err := tx.Exec(`CREATE INDEX IF NOT EXISTS idx_transactions_settlement_month ON transactions (user_id, settlement_month) WHERE settlement_month IS NOT NULL;`); err != nil {
+		return fmt.Errorf("为 transactions.settlement_month 创建索引失败: %w", err)
+	}
+	return nil
+}
+
+// ensureMonthOpen 已结算月份的流水不能新增、修改或删除，需先撤销结算
+func ensureMonthOpen(tx *sql.Tx, userID int64, date string) error {
+	var month sql.NullString
+	err := tx.QueryRow("SELECT month FROM settlements WHERE user_id = ? AND month = strftime('%Y-%m', ?)", userID, date).Scan(&month)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return &ledgerError{Status: http.StatusInternalServerError, Message: "查询月度结算状态失败", Err: err}
+	}
+	return &ledgerError{Status: http.StatusConflict, Message: fmt.Sprintf("%s 已完成月度结算，请先撤销结算再修改该月流水", month.String)}
+}
+
+// CreateSettlement 结算指定月份：统计收支及各账户期末余额并保存快照，为该月流水打上结算标记
+// POST /settlements/:month (month 格式为 YYYY-MM)
+func (h *DBHandler) CreateSettlement(c *gin.Context) {
+	userID, _ := c.Get("userID")
+	month := c.Param("month")
+	logger := h.Logger.With(slog.Int64("userID", userID.(int64)), "month", month)
+
+	start, err := time.Parse(monthLayout, month)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "月份格式应为 YYYY-MM"})
+		return
+	}
+	if !start.AddDate(0, 1, 0).Before(time.Now()) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "只能结算已经结束的月份"})
+		return
+	}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		logger.Error("开启事务失败", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "开启事务失败"})
+		return
+	}
+	defer tx.Rollback()
+
+	settlement := Settlement{Month: month, CreatedAt: time.Now().Format(time.RFC3339), Accounts: []SettlementAccount{}}
+	if settlement.Currency, err = userBaseCurrency(tx, userID.(int64)); err != nil {
+		logger.Error("查询本位币失败", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "月度结算失败"})
+		return
+	}
+	settlement.TotalIncome, settlement.TotalExpense, err = getTotalsForPeriod(tx, userID.(int64), start.Format("2006"), start.Format("01"))
+	if err != nil {
+		logger.Error("统计月度收支失败", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "统计月度收支失败"})
+		return
+	}
+	settlement.NetBalance = settlement.TotalIncome - settlement.TotalExpense
+
+	res, err := tx.Exec(
+		"INSERT INTO settlements (user_id, month, currency, total_income, total_expense, created_at) VALUES (?, ?, ?, ?, ?, ?)",
+		userID, month, settlement.Currency, settlement.TotalIncome, settlement.TotalExpense, settlement.CreatedAt,
+	)
+	if err != nil {
+		if isUniqueViolation(err) {
+			c.JSON(http.StatusConflict, gin.H{"error": "该月份已经结算"})
+			return
+		}
+		logger.Error("保存月度结算失败", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "保存月度结算失败"})
+		return
+	}
+	settlement.ID, _ = res.LastInsertId()
+
+	// 期末余额 = 当前余额 - 结算月之后流水的影响；期初余额 = 期末余额 - 当月流入 + 当月流出
+	rows, err := tx.Query(`
+        SELECT a.id, a.name, a.currency, a.balance,
+               COALESCE((SELECT SUM(`+accountEffectSQL("a.id")+`) FROM transactions t
+                         WHERE t.user_id = a.user_id AND t.deleted_at IS NULL AND strftime('%Y-%m', t.transaction_date) > ?), 0),
+               COALESCE((SELECT SUM(`+accountInflowSQL("a.id")+`) FROM transactions t
+                         WHERE t.user_id = a.user_id AND t.deleted_at IS NULL AND strftime('%Y-%m', t.transaction_date) = ?), 0),
+               COALESCE((SELECT SUM(`+accountOutflowSQL("a.id")+`) FROM transactions t
+                         WHERE t.user_id = a.user_id AND t.deleted_at IS NULL AND strftime('%Y-%m', t.transaction_date) = ?), 0)
+        FROM accounts a WHERE a.user_id = ?
+        ORDER BY a.is_primary DESC, a.created_at ASC, a.id ASC`, month, month, month, userID)
+	if err != nil {
+		logger.Error("统计账户余额失败", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "统计账户余额失败"})
+		return
+	}
+	for rows.Next() {
+		var a SettlementAccount
+		var accountID int64
+		var balance, later Money
+		if err := rows.Scan(&accountID, &a.AccountName, &a.Currency, &balance, &later, &a.Income, &a.Expense); err != nil {
+			rows.Close()
+			logger.Error("扫描账户余额失败", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "统计账户余额失败"})
+			return
+		}
+		a.AccountID = &accountID
+		a.ClosingBalance = balance - later
+		a.OpeningBalance = a.ClosingBalance - a.Income + a.Expense
+		settlement.Accounts = append(settlement.Accounts, a)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		logger.Error("遍历账户余额失败", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "统计账户余额失败"})
+		return
+	}
+	for _, a := range settlement.Accounts {
+		_, err := tx.Exec(
+			"INSERT INTO settlement_accounts (settlement_id, account_id, account_name, currency, opening_balance, income, expense, closing_balance) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+			settlement.ID, a.AccountID, a.AccountName, a.Currency, a.OpeningBalance, a.Income, a.Expense, a.ClosingBalance,
+		)
+		if err != nil {
+			logger.Error("保存账户结算快照失败", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "保存月度结算失败"})
+			return
+		}
+	}
+
+	// 为当月流水打上结算标记
+	res, err = tx.Exec("UPDATE transactions SET settlement_month = ? WHERE user_id = ? AND deleted_at IS NULL AND strftime('%Y-%m', transaction_date) = ?", month, userID, month)
+	if err != nil {
+		logger.Error("标记结算流水失败", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "标记结算流水失败"})
+		return
+	}
+	count, _ := res.RowsAffected()
+	settlement.TransactionCount = int(count)
+	if _, err := tx.Exec("UPDATE settlements SET transaction_count = ? WHERE id = ?", settlement.TransactionCount, settlement.ID); err != nil {
+		logger.Error("更新月度结算失败", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "保存月度结算失败"})
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		logger.Error("提交事务失败", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "提交事务失败"})
+		return
+	}
+	c.JSON(http.StatusCreated, settlement)
+}
+
+// GetSettlements 返回所有已结算月份 (不含账户明细)，最近的在前
+func (h *DBHandler) GetSettlements(c *gin.Context) {
+	userID, _ := c.Get("userID")
+	rows, err := h.DB.Query(`
+        SELECT id, month, currency, total_income, total_expense, transaction_count, created_at
+        FROM settlements WHERE user_id = ? ORDER BY month DESC`, userID)
+	if err != nil {
+		h.Logger.Error("查询月度结算失败", "error", err, slog.Int64("userID", userID.(int64)))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询月度结算失败"})
+		return
+	}
+	defer rows.Close()
+
+	settlements := []Settlement{}
+	for rows.Next() {
+		var s Settlement
+		if err := rows.Scan(&s.ID, &s.Month, &s.Currency, &s.TotalIncome, &s.TotalExpense, &s.TransactionCount, &s.CreatedAt); err != nil {
+			h.Logger.Error("扫描月度结算失败", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "查询月度结算失败"})
+			return
+		}
+		s.NetBalance = s.TotalIncome - s.TotalExpense
+		settlements = append(settlements, s)
+	}
+	c.JSON(http.StatusOK, settlements)
+}
+
+// GetSettlement 返回指定月份的结算快照及各账户明细
+func (h *DBHandler) GetSettlement(c *gin.Context) {
+	userID, _ := c.Get("userID")
+	month := c.Param("month")
+	logger := h.Logger.With(slog.Int64("userID", userID.(int64)), "month", month)
+
+	var s Settlement
+	err := h.DB.QueryRow(`
+        SELECT id, month, currency, total_income, total_expense, transaction_count, created_at
+        FROM settlements WHERE user_id = ? AND month = ?`, userID, month,
+	).Scan(&s.ID, &s.Month, &s.Currency, &s.TotalIncome, &s.TotalExpense, &s.TransactionCount, &s.CreatedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "该月份尚未结算"})
+			return
+		}
+		logger.Error("查询月度结算失败", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询月度结算失败"})
+		return
+	}
+	s.NetBalance = s.TotalIncome - s.TotalExpense
+
+	rows, err := h.DB.Query(`
+        SELECT account_id, account_name, currency, opening_balance, income, expense, closing_balance
+        FROM settlement_accounts WHERE settlement_id = ? ORDER BY id`, s.ID)
+	if err != nil {
+		logger.Error("查询账户结算快照失败", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询月度结算失败"})
+		return
+	}
+	defer rows.Close()
+	s.Accounts = []SettlementAccount{}
+	for rows.Next() {
+		var a SettlementAccount
+		var accountID sql.NullInt64
+		if err := rows.Scan(&accountID, &a.AccountName, &a.Currency, &a.OpeningBalance, &a.Income, &a.Expense, &a.ClosingBalance); err != nil {
+			logger.Error("扫描账户结算快照失败", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "查询月度结算失败"})
+			return
+		}
+		if accountID.Valid {
+			a.AccountID = &accountID.Int64
+		}
+		s.Accounts = append(s.Accounts, a)
+	}
+	c.JSON(http.StatusOK, s)
+}
+
+// DeleteSettlement 撤销 (重新打开) 指定月份的结算：删除快照并清除该月流水的结算标记
+func (h *DBHandler) DeleteSettlement(c *gin.Context) {
+	userID, _ := c.Get("userID")
+	month := c.Param("month")
+	logger := h.Logger.With(slog.Int64("userID", userID.(int64)), "month", month)
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		logger.Error("开启事务失败", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "开启事务失败"})
+		return
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec("DELETE FROM settlements WHERE user_id = ? AND month = ?", userID, month)
+	if err != nil {
+		logger.Error("撤销月度结算失败", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "撤销月度结算失败"})
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "该月份尚未结算"})
+		return
+	}
+	if _, err := tx.Exec("UPDATE transactions SET settlement_month = NULL WHERE user_id = ? AND settlement_month = ?", userID, month); err != nil {
+		logger.Error("清除结算标记失败", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "撤销月度结算失败"})
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		logger.Error("提交事务失败", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "提交事务失败"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "月度结算已撤销，该月流水可以重新修改"})
+}
@@ -0,0 +1,106 @@
+// bookkeeper-app/settlement_handlers_test.go
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// 测试月度结算：生成收支及账户余额快照、标记当月流水、结算后拒绝修改，撤销后恢复
+func TestMonthlySettlement(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	handler := &DBHandler{DB: db, Logger: slog.New(slog.NewJSONHandler(io.Discard, nil))}
+	router := setupRouter(handler)
+
+	userID := createTestUser(t, db, "testuser", "password")
+	token := getTestAuthToken(t, userID, "testuser", false)
+	cardID := createTestAccount(t, db, userID, "银行卡", 1000.0)
+	walletID := createTestAccount(t, db, userID, "钱包", 0)
+
+	create := func(req CreateTransactionRequest) (int, int64) {
+		body, _ := json.Marshal(req)
+		w := performRequest(router, "POST", "/api/v1/transactions", bytes.NewBuffer(body), token)
+		var resp struct {
+			ID int64 `json:"id"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		return w.Code, resp.ID
+	}
+	_, expenseID := create(CreateTransactionRequest{Type: "expense", Amount: yuan(120), TransactionDate: "2024-05-03", FromAccountID: &cardID})
+	create(CreateTransactionRequest{Type: "income", Amount: yuan(500), TransactionDate: "2024-05-15", ToAccountID: &cardID})
+	create(CreateTransactionRequest{Type: "transfer", Amount: yuan(80), TransactionDate: "2024-05-20", FromAccountID: &cardID, ToAccountID: &walletID})
+	create(CreateTransactionRequest{Type: "expense", Amount: yuan(30), TransactionDate: "2024-06-01", FromAccountID: &cardID})
+
+	// 不能手动创建结算流水，也不能结算尚未结束的月份
+	code, _ := create(CreateTransactionRequest{Type: "settlement", Amount: yuan(1), TransactionDate: "2024-05-31"})
+	assert.Equal(t, http.StatusBadRequest, code)
+	w := performRequest(router, "POST", "/api/v1/settlements/2999-01", nil, token)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = performRequest(router, "POST", "/api/v1/settlements/2024-05", nil, token)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	var settlement Settlement
+	json.Unmarshal(w.Body.Bytes(), &settlement)
+	assert.Equal(t, yuan(500), settlement.TotalIncome)
+	assert.Equal(t, yuan(120), settlement.TotalExpense)
+	assert.Equal(t, yuan(380), settlement.NetBalance)
+	assert.Equal(t, 3, settlement.TransactionCount)
+	if assert.Len(t, settlement.Accounts, 2) {
+		card := settlement.Accounts[0]
+		assert.Equal(t, "银行卡", card.AccountName)
+		assert.Equal(t, yuan(1000), card.OpeningBalance)
+		assert.Equal(t, yuan(500), card.Income)
+		assert.Equal(t, yuan(200), card.Expense)
+		assert.Equal(t, yuan(1300), card.ClosingBalance)
+		assert.Equal(t, yuan(80), settlement.Accounts[1].ClosingBalance)
+	}
+
+	w = performRequest(router, "POST", "/api/v1/settlements/2024-05", nil, token)
+	assert.Equal(t, http.StatusConflict, w.Code)
+	w = performRequest(router, "GET", "/api/v1/settlements/2024-05", nil, token)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var stored Settlement
+	json.Unmarshal(w.Body.Bytes(), &stored)
+	assert.Equal(t, settlement.Accounts, stored.Accounts)
+
+	// 已结算月份内的新增、修改、删除以及移入该月都被拒绝；其它月份不受影响
+	code, _ = create(CreateTransactionRequest{Type: "expense", Amount: yuan(1), TransactionDate: "2024-05-31", FromAccountID: &cardID})
+	assert.Equal(t, http.StatusConflict, code)
+	w = performRequest(router, "DELETE", fmt.Sprintf("/api/v1/transactions/%d", expenseID), nil, token)
+	assert.Equal(t, http.StatusConflict, w.Code)
+	code, juneID := create(CreateTransactionRequest{Type: "expense", Amount: yuan(5), TransactionDate: "2024-06-05", FromAccountID: &cardID})
+	assert.Equal(t, http.StatusCreated, code)
+	body, _ := json.Marshal(CreateTransactionRequest{Type: "expense", Amount: yuan(5), TransactionDate: "2024-05-30", FromAccountID: &cardID})
+	w = performRequest(router, "PUT", fmt.Sprintf("/api/v1/transactions/%d", juneID), bytes.NewBuffer(body), token)
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	w = performRequest(router, "GET", "/api/v1/transactions?year=2024&month=5", nil, token)
+	var list GetTransactionsResponse
+	json.Unmarshal(w.Body.Bytes(), &list)
+	for _, tr := range list.Transactions {
+		if assert.NotNil(t, tr.SettlementMonth) {
+			assert.Equal(t, "2024-05", *tr.SettlementMonth)
+		}
+	}
+
+	// 撤销结算后恢复可编辑
+	w = performRequest(router, "DELETE", "/api/v1/settlements/2024-05", nil, token)
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = performRequest(router, "DELETE", fmt.Sprintf("/api/v1/transactions/%d", expenseID), nil, token)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var stamped int
+	db.QueryRow("SELECT COUNT(*) FROM transactions WHERE settlement_month IS NOT NULL").Scan(&stamped)
+	assert.Equal(t, 0, stamped)
+	w = performRequest(router, "GET", "/api/v1/settlements", nil, token)
+	var all []Settlement
+	json.Unmarshal(w.Body.Bytes(), &all)
+	assert.Empty(t, all)
+}
@@ -0,0 +1,251 @@
+// bookkeeper-app/tag_handlers.go
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mattn/go-sqlite3"
+)
+
+// setupTags 创建标签表及流水-标签关联表。标签名按用户唯一且不区分大小写。
+func setupTags(tx *sql.Tx) error {
+	if _, err := tx.Exec(`
+    CREATE TABLE IF NOT EXISTS tags (
+        "id" INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+        "user_id" INTEGER NOT NULL,
+        "name" TEXT NOT NULL COLLATE NOCASE,
+        "created_at" TEXT NOT NULL,
+        FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE,
+        UNIQUE(user_id, name)
+    );`); err != nil {
+		return fmt.Errorf("创建 tags 表失败: %w", err)
+	}
+	if _, err := tx.Exec(`
+    CREATE TABLE IF NOT EXISTS transaction_tags (
+        "transaction_id" INTEGER NOT NULL,
+        "tag_id" INTEGER NOT NULL,
+        PRIMARY KEY("transaction_id", "tag_id"),
+        FOREIGN KEY(transaction_id) REFERENCES transactions(id) ON DELETE CASCADE,
+        FOREIGN KEY(tag_id) REFERENCES tags(id) ON DELETE CASCADE
+    );`); err != nil {
+		return fmt.Errorf("创建 transaction_tags 表失败: %w", err)
+	}
+	if _, err := tx.Exec(`CREATE INDEX IF NOT EXISTS idx_transaction_tags_tag ON transaction_tags (tag_id);`); err != nil {
+		return fmt.Errorf("为 transaction_tags 创建索引失败: %w", err)
+	}
+	return nil
+}
+
+// normalizeTagNames 去除首尾空白并去重 (不区分大小写)，保留首次出现的写法
+func normalizeTagNames(names []string) ([]string, error) {
+	seen := make(map[string]bool, len(names))
+	result := make([]string, 0, len(names))
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			return nil, &ledgerError{Status: http.StatusBadRequest, Message: "标签名不能为空"}
+		}
+		key := strings.ToLower(name)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		result = append(result, name)
+	}
+	return result, nil
+}
+
+// saveTransactionTags 用给定的标签整体替换流水现有的标签，不存在的标签自动创建
+func saveTransactionTags(tx *sql.Tx, userID, transactionID int64, names []string) error {
+	names, err := normalizeTagNames(names)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM transaction_tags WHERE transaction_id = ?", transactionID); err != nil {
+		return &ledgerError{Status: http.StatusInternalServerError, Message: "清除旧标签失败", Err: err}
+	}
+	createdAt := time.Now().Format(time.RFC3339)
+	for _, name := range names {
+		if _, err := tx.Exec("INSERT OR IGNORE INTO tags (user_id, name, created_at) VALUES (?, ?, ?)", userID, name, createdAt); err != nil {
+			return &ledgerError{Status: http.StatusInternalServerError, Message: "创建标签失败", Err: err}
+		}
+		_, err := tx.Exec(`
+            INSERT INTO transaction_tags (transaction_id, tag_id)
+            SELECT ?, id FROM tags WHERE user_id = ? AND name = ?`,
+			transactionID, userID, name)
+		if err != nil {
+			return &ledgerError{Status: http.StatusInternalServerError, Message: "保存流水标签失败", Err: err}
+		}
+	}
+	return nil
+}
+
+// loadTransactionTags 为一组流水批量加载标签名 (分批查询，避免超出 SQLite 参数个数限制)
+func loadTransactionTags(db *sql.DB, userID int64, transactions []Transaction) error {
+	const batchSize = 500
+	index := make(map[int64]int, len(transactions))
+	for i, t := range transactions {
+		index[t.ID] = i
+	}
+
+	for start := 0; start < len(transactions); start += batchSize {
+		end := min(start+batchSize, len(transactions))
+		args := []interface{}{userID}
+		for _, t := range transactions[start:end] {
+			args = append(args, t.ID)
+		}
+		rows, err := db.Query(`
+        SELECT tt.transaction_id, g.name
+        FROM transaction_tags tt
+        JOIN tags g ON g.id = tt.tag_id
+        WHERE g.user_id = ? AND tt.transaction_id IN (`+placeholders(end-start)+`)
+        ORDER BY g.name`, args...)
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			var transactionID int64
+			var name string
+			if err := rows.Scan(&transactionID, &name); err != nil {
+				rows.Close()
+				return err
+			}
+			i := index[transactionID]
+			transactions[i].Tags = append(transactions[i].Tags, name)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// isUniqueViolation 判断是否为 SQLite 唯一约束冲突
+func isUniqueViolation(err error) bool {
+	var sqliteErr sqlite3.Error
+	return errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique
+}
+
+// GetTags 获取当前用户的全部标签及各自关联的流水数量
+func (h *DBHandler) GetTags(c *gin.Context) {
+	userID, _ := c.Get("userID")
+	logger := h.Logger.With(slog.Int64("userID", userID.(int64)))
+
+	rows, err := h.DB.Query(`
+        SELECT g.id, g.name, g.created_at, COUNT(tt.transaction_id)
+        FROM tags g
+        LEFT JOIN transaction_tags tt ON tt.tag_id = g.id
+        WHERE g.user_id = ?
+        GROUP BY g.id
+        ORDER BY g.name`, userID)
+	if err != nil {
+		logger.Error("查询标签失败", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "获取标签失败"})
+		return
+	}
+	defer rows.Close()
+
+	tags := []Tag{}
+	for rows.Next() {
+		var tag Tag
+		if err := rows.Scan(&tag.ID, &tag.Name, &tag.CreatedAt, &tag.TransactionCount); err != nil {
+			logger.Error("扫描标签数据失败", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "获取标签失败"})
+			return
+		}
+		tags = append(tags, tag)
+	}
+	c.JSON(http.StatusOK, tags)
+}
+
+// CreateTag 创建标签 (也可以在记账时直接填写新标签名自动创建)
+func (h *DBHandler) CreateTag(c *gin.Context) {
+	userID, _ := c.Get("userID")
+	logger := h.Logger.With(slog.Int64("userID", userID.(int64)))
+
+	var req TagRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的请求数据: " + err.Error()})
+		return
+	}
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "标签名不能为空"})
+		return
+	}
+
+	createdAt := time.Now().Format(time.RFC3339)
+	res, err := h.DB.Exec("INSERT INTO tags (user_id, name, created_at) VALUES (?, ?, ?)", userID, name, createdAt)
+	if err != nil {
+		if isUniqueViolation(err) {
+			c.JSON(http.StatusConflict, gin.H{"error": "标签已存在"})
+			return
+		}
+		logger.Error("创建标签失败", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "创建标签失败"})
+		return
+	}
+	id, _ := res.LastInsertId()
+	c.JSON(http.StatusCreated, Tag{ID: id, Name: name, CreatedAt: createdAt})
+}
+
+// UpdateTag 重命名标签，所有关联流水随之生效
+func (h *DBHandler) UpdateTag(c *gin.Context) {
+	userID, _ := c.Get("userID")
+	id := c.Param("id")
+	logger := h.Logger.With(slog.Int64("userID", userID.(int64)), "tagID", id)
+
+	var req TagRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的请求数据: " + err.Error()})
+		return
+	}
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "标签名不能为空"})
+		return
+	}
+
+	res, err := h.DB.Exec("UPDATE tags SET name = ? WHERE id = ? AND user_id = ?", name, id, userID)
+	if err != nil {
+		if isUniqueViolation(err) {
+			c.JSON(http.StatusConflict, gin.H{"error": "该名称已被其他标签使用"})
+			return
+		}
+		logger.Error("重命名标签失败", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "重命名标签失败"})
+		return
+	}
+	rowsAffected, _ := res.RowsAffected()
+	if rowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "未找到指定ID的标签"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "标签重命名成功"})
+}
+
+// DeleteTag 删除标签，仅移除它与流水的关联，流水本身不受影响
+func (h *DBHandler) DeleteTag(c *gin.Context) {
+	userID, _ := c.Get("userID")
+	id := c.Param("id")
+	res, err := h.DB.Exec("DELETE FROM tags WHERE id = ? AND user_id = ?", id, userID)
+	if err != nil {
+		h.Logger.Error("删除标签失败", "error", err, "tagID", id, slog.Int64("userID", userID.(int64)))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "删除标签失败"})
+		return
+	}
+	rowsAffected, _ := res.RowsAffected()
+	if rowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "未找到指定ID的标签"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "标签删除成功，相关流水已移除该标签"})
+}
@@ -0,0 +1,79 @@
+// bookkeeper-app/tag_handlers_test.go
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// 测试记账时自动创建标签、按标签筛选、标签支出统计以及重命名
+func TestTransactionTags(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	handler := &DBHandler{DB: db, Logger: slog.New(slog.NewJSONHandler(io.Discard, nil))}
+	router := setupRouter(handler)
+
+	userID := createTestUser(t, db, "testuser", "password")
+	token := getTestAuthToken(t, userID, "testuser", false)
+	accountID := createTestAccount(t, db, userID, "Test Account", 1000.0)
+
+	create := func(amount Money, tags []string) int64 {
+		req := CreateTransactionRequest{Type: "expense", Amount: amount, TransactionDate: "2024-05-01", FromAccountID: &accountID, Tags: tags}
+		body, _ := json.Marshal(req)
+		w := performRequest(router, "POST", "/api/v1/transactions", bytes.NewBuffer(body), token)
+		assert.Equal(t, http.StatusCreated, w.Code)
+		var resp struct {
+			ID int64 `json:"id"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		return resp.ID
+	}
+	create(yuan(100), []string{"trip-japan", "reimbursable", " Trip-Japan "})
+	create(yuan(50), []string{"trip-japan"})
+	create(yuan(30), nil)
+
+	w := performRequest(router, "GET", "/api/v1/tags", nil, token)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var tags []Tag
+	json.Unmarshal(w.Body.Bytes(), &tags)
+	if !assert.Len(t, tags, 2) {
+		return
+	}
+	assert.Equal(t, "reimbursable", tags[0].Name)
+	assert.Equal(t, 1, tags[0].TransactionCount)
+	assert.Equal(t, "trip-japan", tags[1].Name)
+	assert.Equal(t, 2, tags[1].TransactionCount)
+	tripID := tags[1].ID
+
+	w = performRequest(router, "GET", fmt.Sprintf("/api/v1/transactions?tag_id=%d", tripID), nil, token)
+	var resp GetTransactionsResponse
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	assert.Len(t, resp.Transactions, 2)
+	assert.Equal(t, yuan(150), resp.Summary.TotalExpense)
+
+	w = performRequest(router, "GET", "/api/v1/analytics/charts?year=2024", nil, token)
+	var charts AnalyticsChartsResponse
+	json.Unmarshal(w.Body.Bytes(), &charts)
+	assert.Equal(t, []ChartDataPoint{{Name: "trip-japan", Value: yuan(150)}, {Name: "reimbursable", Value: yuan(100)}}, charts.TagExpense)
+
+	// 重命名与已有标签冲突 (不区分大小写)
+	body, _ := json.Marshal(TagRequest{Name: "Reimbursable"})
+	w = performRequest(router, "PUT", fmt.Sprintf("/api/v1/tags/%d", tripID), bytes.NewBuffer(body), token)
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	body, _ = json.Marshal(TagRequest{Name: "日本旅行"})
+	w = performRequest(router, "PUT", fmt.Sprintf("/api/v1/tags/%d", tripID), bytes.NewBuffer(body), token)
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = performRequest(router, "GET", fmt.Sprintf("/api/v1/transactions?tag_id=%d", tripID), nil, token)
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if assert.Len(t, resp.Transactions, 2) {
+		assert.Contains(t, resp.Transactions[0].Tags, "日本旅行")
+	}
+}
@@ -3,9 +3,13 @@ package main
 
 import (
 	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
@@ -31,191 +35,579 @@ func (h *DBHandler) CreateTransaction(c *gin.Context) {
 	}
 	defer tx.Rollback() // 确保在出错时回滚
 
-	// --- 核心逻辑：根据流水类型处理账户余额 ---
+	// --- 核心逻辑：处理账户余额并写入流水 ---
+	id, err := createTransactionInTx(tx, userID.(int64), &req)
+	if err != nil {
+		writeLedgerError(c, logger, err)
+		return
+	}
+	if err := writeAudit(tx, auditActorFromContext(c), "transaction", "create", id, nil); err != nil {
+		writeLedgerError(c, logger, err)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		logger.Error("提交事务失败", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "提交事务失败"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"message": "流水记录创建成功", "id": id})
+}
+
+// createTransactionInTx 在事务中完成一条流水的全部写入：校验、流水记录、拆分明细和记账分录 (同时更新余额)，返回新流水ID
+func createTransactionInTx(tx *sql.Tx, userID int64, req *CreateTransactionRequest) (int64, error) {
+	if err := ensureMonthOpen(tx, userID, req.TransactionDate); err != nil {
+		return 0, err
+	}
+	if err := resolvePayee(tx, userID, req); err != nil {
+		return 0, err
+	}
+	// 自动分类规则只补全未填写的字段，因此在其余校验之前执行
+	if err := applyPayeeRules(tx, userID, req); err != nil {
+		return 0, err
+	}
+	if err := validateSplits(req); err != nil {
+		return 0, err
+	}
+	if err := prepareRefund(tx, userID, req, 0); err != nil {
+		return 0, err
+	}
+	if err := validateTransactionAccounts(tx, userID, req); err != nil {
+		return 0, err
+	}
+	if err := ensureAccountsActive(tx, req.FromAccountID, req.ToAccountID); err != nil {
+		return 0, err
+	}
+	currency, err := transactionCurrency(tx, userID, req)
+	if err != nil {
+		return 0, err
+	}
+
+	createdAt := time.Now().Format(time.RFC3339)
+	res, err := tx.Exec(
+		"INSERT INTO transactions(user_id, type, amount, transaction_date, description, category_id, related_loan_id, from_account_id, to_account_id, currency, to_amount, refund_of_id, payee_id, created_at) VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		userID, req.Type, req.Amount, req.TransactionDate, req.Description, req.CategoryID, req.RelatedLoanID, req.FromAccountID, req.ToAccountID, currency, req.ToAmount, req.RefundOfID, req.PayeeID, createdAt,
+	)
+	if err != nil {
+		return 0, &ledgerError{Status: http.StatusInternalServerError, Message: "创建流水记录失败", Err: err}
+	}
+	id, _ := res.LastInsertId()
+
+	if err := saveTransactionSplits(tx, id, req.Splits); err != nil {
+		return 0, err
+	}
+	if err := saveTransactionTags(tx, userID, id, req.Tags); err != nil {
+		return 0, err
+	}
+	if err := postTransaction(tx, id); err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+// deleteTransactionInTx 在事务中把一条流水移入回收站，并冲回其记账分录以恢复账户余额。
+// 拆分明细、标签和附件原样保留，以便恢复 (恢复时重新生成分录)；超过保留期限后由 purgeExpiredTrash 彻底删除。
+func deleteTransactionInTx(tx *sql.Tx, userID int64, id int64) error {
+	// 1. 获取要删除的流水信息
+	var t Transaction
+	err := tx.QueryRow(
+		"SELECT type, amount, to_amount, from_account_id, to_account_id, transaction_date FROM transactions WHERE id = ? AND user_id = ? AND deleted_at IS NULL",
+		id, userID,
+	).Scan(&t.Type, &t.Amount, &t.ToAmount, &t.FromAccountID, &t.ToAccountID, &t.TransactionDate)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return &ledgerError{Status: http.StatusNotFound, Message: "未找到指定ID的流水"}
+		}
+		return &ledgerError{Status: http.StatusInternalServerError, Message: "查询待删除流水失败", Err: err}
+	}
+	if err := ensureNotReconciled(tx, id); err != nil {
+		return err
+	}
+	if err := ensureMonthOpen(tx, userID, t.TransactionDate); err != nil {
+		return err
+	}
+	var refundCount int
+	if err := tx.QueryRow("SELECT COUNT(*) FROM transactions WHERE refund_of_id = ? AND deleted_at IS NULL", id).Scan(&refundCount); err != nil {
+		return &ledgerError{Status: http.StatusInternalServerError, Message: "查询退款记录失败", Err: err}
+	}
+	if refundCount > 0 {
+		return &ledgerError{Status: http.StatusConflict, Message: "该支出已有退款记录，请先删除相关退款"}
+	}
+
+	// 2. 冲回记账分录，恢复账户余额
+	if err := unpostTransaction(tx, id); err != nil {
+		return err
+	}
+
+	// 3. 标记为已删除
+	if _, err := tx.Exec("UPDATE transactions SET deleted_at = ? WHERE id = ?", time.Now().Format(time.RFC3339), id); err != nil {
+		return &ledgerError{Status: http.StatusInternalServerError, Message: "删除流水记录失败", Err: err}
+	}
+	return nil
+}
+
+// isOwner 是一个辅助函数，用于检查某个资源是否属于当前用户
+func isOwner(tx *sql.Tx, userID int64, tableName string, resourceID int64) bool {
+	var count int
+	// 使用 Sprintf 时要极其小心SQL注入，这里 tableName 是由我们硬编码控制的，所以是安全的。
+	query := fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE id = ? AND user_id = ?", tableName)
+	err := tx.QueryRow(query, resourceID, userID).Scan(&count)
+	return err == nil && count > 0
+}
+
+// ledgerError 表示余额处理过程中的错误，携带应返回给客户端的状态码和提示信息
+type ledgerError struct {
+	Status    int
+	Message   string
+	Err       error // 底层错误，仅用于记录日志
+	Retryable bool  // 条件变化后可能自行消失的错误 (如余额不足)，周期规则入账失败时据此决定重试还是跳过这一期
+}
+
+func (e *ledgerError) Error() string {
+	if e.Err != nil {
+		return e.Message + ": " + e.Err.Error()
+	}
+	return e.Message
+}
+
+// writeLedgerError 将 ledgerError 转换为 JSON 错误响应，其它错误统一按 500 处理
+func writeLedgerError(c *gin.Context, logger *slog.Logger, err error) {
+	var le *ledgerError
+	if errors.As(err, &le) {
+		if le.Err != nil {
+			logger.Error(le.Message, "error", le.Err)
+		}
+		c.JSON(le.Status, gin.H{"error": le.Message})
+		return
+	}
+	logger.Error("处理流水失败", "error", err)
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "服务器内部错误"})
+}
+
+// validateTransactionAccounts 校验流水涉及的账户/贷款归属，并检查付款账户余额是否充足。
+// 必须在事务中调用，CreateTransaction 和 UpdateTransaction 共用这一逻辑；余额的变动由之后的 postTransaction 完成。
+// 只有跨币种转账会保留 req.ToAmount，其余情况将其清空，保证流水记录与分录一致。
+func validateTransactionAccounts(tx *sql.Tx, userID int64, req *CreateTransactionRequest) error {
+	if req.Type != "transfer" {
+		req.ToAmount = nil
+	}
 	switch req.Type {
-	case "income":
+	case "income", "refund":
 		if req.ToAccountID == nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "收入流水必须指定收款账户 (to_account_id)"})
-			return
+			return &ledgerError{Status: http.StatusBadRequest, Message: "收入或退款流水必须指定收款账户 (to_account_id)"}
 		}
-		if !isOwner(tx, userID.(int64), "accounts", *req.ToAccountID) {
-			c.JSON(http.StatusForbidden, gin.H{"error": "无权操作收款账户"})
-			return
-		}
-		// 增加收款账户余额
-		_, err = tx.Exec("UPDATE accounts SET balance = balance + ? WHERE id = ?", req.Amount, *req.ToAccountID)
-		if err != nil {
-			logger.Error("更新收款账户余额失败", "error", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "更新收款账户余额失败"})
-			return
+		if !isOwner(tx, userID, "accounts", *req.ToAccountID) {
+			return &ledgerError{Status: http.StatusForbidden, Message: "无权操作收款账户"}
 		}
 
 	case "expense", "repayment":
 		if req.FromAccountID == nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "支出或还款流水必须指定付款账户 (from_account_id)"})
-			return
-		}
-		if !isOwner(tx, userID.(int64), "accounts", *req.FromAccountID) {
-			c.JSON(http.StatusForbidden, gin.H{"error": "无权操作付款账户"})
-			return
-		}
-		// 检查余额是否充足
-		var balance float64
-		err := tx.QueryRow("SELECT balance FROM accounts WHERE id = ?", *req.FromAccountID).Scan(&balance)
-		if err != nil {
-			logger.Error("查询付款账户余额失败", "error", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "查询付款账户余额失败"})
-			return
+			return &ledgerError{Status: http.StatusBadRequest, Message: "支出或还款流水必须指定付款账户 (from_account_id)"}
 		}
-		if balance < req.Amount {
-			c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("账户余额不足 (当前: %.2f, 需要: %.2f)", balance, req.Amount)})
-			return
+		if !isOwner(tx, userID, "accounts", *req.FromAccountID) {
+			return &ledgerError{Status: http.StatusForbidden, Message: "无权操作付款账户"}
 		}
-		// 扣减付款账户余额
-		_, err = tx.Exec("UPDATE accounts SET balance = balance - ? WHERE id = ?", req.Amount, *req.FromAccountID)
-		if err != nil {
-			logger.Error("更新付款账户余额失败", "error", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "更新付款账户余额失败"})
-			return
+		// 检查余额 (信用卡为可用额度) 是否充足
+		if err := checkAvailableFunds(tx, *req.FromAccountID, req.Amount, "付款账户"); err != nil {
+			return err
 		}
 		// 如果是还款，需要额外验证关联贷款的归属权
 		if req.Type == "repayment" {
 			if req.RelatedLoanID == nil {
-				c.JSON(http.StatusBadRequest, gin.H{"error": "还款流水必须指定关联贷款 (related_loan_id)"})
-				return
+				return &ledgerError{Status: http.StatusBadRequest, Message: "还款流水必须指定关联贷款 (related_loan_id)"}
 			}
-			if !isOwner(tx, userID.(int64), "loans", *req.RelatedLoanID) {
-				c.JSON(http.StatusForbidden, gin.H{"error": "无权操作关联贷款"})
-				return
+			if !isOwner(tx, userID, "loans", *req.RelatedLoanID) {
+				return &ledgerError{Status: http.StatusForbidden, Message: "无权操作关联贷款"}
+			}
+			// 贷款以本位币记账，还款金额直接抵扣本金，因此只能从本位币账户还款
+			if err := requireBaseCurrencyAccount(tx, userID, *req.FromAccountID); err != nil {
+				return err
 			}
 		}
 
 	case "transfer":
 		if req.FromAccountID == nil || req.ToAccountID == nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "转账流水必须同时指定转出和转入账户"})
-			return
+			return &ledgerError{Status: http.StatusBadRequest, Message: "转账流水必须同时指定转出和转入账户"}
 		}
 		// 验证两个账户都属于当前用户
 		var count int
-		err = tx.QueryRow("SELECT COUNT(*) FROM accounts WHERE id IN (?, ?) AND user_id = ?", req.FromAccountID, req.ToAccountID, userID).Scan(&count)
+		err := tx.QueryRow("SELECT COUNT(*) FROM accounts WHERE id IN (?, ?) AND user_id = ?", *req.FromAccountID, *req.ToAccountID, userID).Scan(&count)
 		if err != nil || count != 2 {
-			c.JSON(http.StatusForbidden, gin.H{"error": "账户不存在或无权操作"})
-			return
-		}
-		// 检查转出账户余额
-		var fromBalance float64
-		err = tx.QueryRow("SELECT balance FROM accounts WHERE id = ?", *req.FromAccountID).Scan(&fromBalance)
-		if err != nil {
-			logger.Error("查询转出账户余额失败", "error", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "查询转出账户余额失败"})
-			return
+			return &ledgerError{Status: http.StatusForbidden, Message: "账户不存在或无权操作"}
 		}
-		if fromBalance < req.Amount {
-			c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("转出账户余额不足 (当前: %.2f, 需要: %.2f)", fromBalance, req.Amount)})
-			return
+		// 检查转出账户余额 (信用卡为可用额度)
+		if err := checkAvailableFunds(tx, *req.FromAccountID, req.Amount, "转出账户"); err != nil {
+			return err
 		}
-		// 更新账户余额
-		_, err = tx.Exec("UPDATE accounts SET balance = balance - ? WHERE id = ?", req.Amount, *req.FromAccountID)
+		// 跨币种转账必须给出转入金额；同币种转账两边金额相同
+		fromCurrency, err := accountCurrency(tx, *req.FromAccountID)
 		if err != nil {
-			logger.Error("更新转出账户余额失败", "error", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "更新转出账户余额失败"})
-			return
+			return &ledgerError{Status: http.StatusInternalServerError, Message: "查询转出账户币种失败", Err: err}
 		}
-		_, err = tx.Exec("UPDATE accounts SET balance = balance + ? WHERE id = ?", req.Amount, *req.ToAccountID)
+		toCurrency, err := accountCurrency(tx, *req.ToAccountID)
 		if err != nil {
-			logger.Error("更新转入账户余额失败", "error", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "更新转入账户余额失败"})
-			return
+			return &ledgerError{Status: http.StatusInternalServerError, Message: "查询转入账户币种失败", Err: err}
+		}
+		if fromCurrency == toCurrency {
+			req.ToAmount = nil
+		} else if req.ToAmount == nil {
+			return &ledgerError{Status: http.StatusBadRequest, Message: fmt.Sprintf("跨币种转账 (%s → %s) 必须指定转入金额 (to_amount)", fromCurrency, toCurrency)}
 		}
 
-	// settlement 类型不直接处理账户，它由月度结算功能独立处理
+	// 余额调整由对账和一致性修复生成，只校验账户归属，不检查余额 (从回收站恢复时经过这里)
+	case "adjustment":
+		accountID := req.ToAccountID
+		if accountID == nil {
+			accountID = req.FromAccountID
+		}
+		if accountID == nil || !isOwner(tx, userID, "accounts", *accountID) {
+			return &ledgerError{Status: http.StatusForbidden, Message: "账户不存在或无权操作"}
+		}
+
+	// 月度结算不再以流水形式记录，而是由 POST /settlements/:month 生成结算快照
 	case "settlement":
-		// no account action needed here
-		break
+		return &ledgerError{Status: http.StatusBadRequest, Message: "月度结算请使用 POST /settlements/:month，不能手动创建结算流水"}
 	default:
-		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的流水类型"})
-		return
+		return &ledgerError{Status: http.StatusBadRequest, Message: "无效的流水类型"}
 	}
+	return nil
+}
 
-	createdAt := time.Now().Format(time.RFC3339)
-	_, err = tx.Exec(
-		"INSERT INTO transactions(user_id, type, amount, transaction_date, description, category_id, related_loan_id, from_account_id, to_account_id, created_at) VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
-		userID, req.Type, req.Amount, req.TransactionDate, req.Description, req.CategoryID, req.RelatedLoanID, req.FromAccountID, req.ToAccountID, createdAt,
-	)
-	if err != nil {
-		logger.Error("创建流水记录失败", "error", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "创建流水记录失败"})
-		return
-	}
+const (
+	defaultTransactionPageLimit = 50
+	maxTransactionPageLimit     = 500
+)
 
-	if err := tx.Commit(); err != nil {
-		logger.Error("提交事务失败", "error", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "提交事务失败"})
-		return
+var validTransactionTypes = map[string]bool{
+	"income": true, "expense": true, "repayment": true, "transfer": true, "settlement": true, "refund": true,
+	"adjustment": true,
+}
+
+// splitQueryList 同时支持重复参数 (?a=1&a=2) 和逗号分隔 (?a=1,2) 两种写法
+func splitQueryList(c *gin.Context, key string) []string {
+	var values []string
+	for _, raw := range c.QueryArray(key) {
+		for _, v := range strings.Split(raw, ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				values = append(values, v)
+			}
+		}
 	}
+	return values
+}
 
-	c.JSON(http.StatusCreated, gin.H{"message": "流水记录创建成功"})
+// parseTransactionFilter 从查询参数中解析流水筛选条件
+func parseTransactionFilter(c *gin.Context) (TransactionFilter, error) {
+	f := TransactionFilter{
+		Year:        c.Query("year"),
+		Month:       c.Query("month"),
+		From:        c.Query("from"),
+		To:          c.Query("to"),
+		CategoryIDs: splitQueryList(c, "category_id"),
+		Types:       splitQueryList(c, "type"),
+		Keyword:     strings.TrimSpace(c.Query("q")),
+	}
+	for _, d := range []string{f.From, f.To} {
+		if d == "" {
+			continue
+		}
+		if _, err := time.Parse("2006-01-02", d); err != nil {
+			return f, fmt.Errorf("日期格式应为 YYYY-MM-DD: %s", d)
+		}
+	}
+	for _, typ := range f.Types {
+		if !validTransactionTypes[typ] {
+			return f, fmt.Errorf("无效的流水类型: %s", typ)
+		}
+	}
+	for _, v := range splitQueryList(c, "account_id") {
+		id, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return f, fmt.Errorf("无效的账户ID: %s", v)
+		}
+		f.AccountIDs = append(f.AccountIDs, id)
+	}
+	for _, v := range splitQueryList(c, "tag_id") {
+		id, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return f, fmt.Errorf("无效的标签ID: %s", v)
+		}
+		f.TagIDs = append(f.TagIDs, id)
+	}
+	for _, v := range splitQueryList(c, "payee_id") {
+		id, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return f, fmt.Errorf("无效的商户ID: %s", v)
+		}
+		f.PayeeIDs = append(f.PayeeIDs, id)
+	}
+	for key, dest := range map[string]**Money{"min_amount": &f.MinAmount, "max_amount": &f.MaxAmount} {
+		if v := c.Query(key); v != "" {
+			amount, err := ParseMoney(v)
+			if err != nil {
+				return f, fmt.Errorf("无效的金额参数 %s: %s", key, v)
+			}
+			*dest = &amount
+		}
+	}
+	return f, nil
 }
 
-// isOwner 是一个辅助函数，用于检查某个资源是否属于当前用户
-func isOwner(tx *sql.Tx, userID int64, tableName string, resourceID int64) bool {
-	var count int
-	// 使用 Sprintf 时要极其小心SQL注入，这里 tableName 是由我们硬编码控制的，所以是安全的。
-	query := fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE id = ? AND user_id = ?", tableName)
-	err := tx.QueryRow(query, resourceID, userID).Scan(&count)
-	return err == nil && count > 0
+// placeholders 生成 n 个以逗号分隔的 SQL 占位符
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?,", n), ",")
 }
 
-// GetTransactions (已修复查询逻辑)
-func (h *DBHandler) GetTransactions(c *gin.Context) {
-	userID, _ := c.Get("userID")
-	logger := h.Logger.With(slog.Int64("userID", userID.(int64)))
+// conditions 生成针对 transactions 表 (别名 t) 的 WHERE 条件及参数
+func (f *TransactionFilter) conditions(userID int64) ([]string, []interface{}) {
+	conditions := []string{"t.user_id = ?", "t.deleted_at IS NULL"}
+	args := []interface{}{userID}
 
-	year := c.Query("year")
-	month := c.Query("month")
+	if f.Year != "" {
+		conditions = append(conditions, "strftime('%Y', t.transaction_date) = ?")
+		args = append(args, f.Year)
+	}
+	if f.Month != "" {
+		conditions = append(conditions, "strftime('%m', t.transaction_date) = ?")
+		args = append(args, fmt.Sprintf("%02s", f.Month))
+	}
+	if f.From != "" {
+		conditions = append(conditions, "date(t.transaction_date) >= ?")
+		args = append(args, f.From)
+	}
+	if f.To != "" {
+		conditions = append(conditions, "date(t.transaction_date) <= ?")
+		args = append(args, f.To)
+	}
+	if len(f.CategoryIDs) > 0 {
+		// 流水本身的分类或任一拆分明细的分类匹配即可
+		ph := placeholders(len(f.CategoryIDs))
+		conditions = append(conditions, "(t.category_id IN ("+ph+") OR EXISTS (SELECT 1 FROM transaction_splits s WHERE s.transaction_id = t.id AND s.category_id IN ("+ph+")))")
+		for i := 0; i < 2; i++ {
+			for _, id := range f.CategoryIDs {
+				args = append(args, id)
+			}
+		}
+	}
+	if len(f.AccountIDs) > 0 {
+		ph := placeholders(len(f.AccountIDs))
+		conditions = append(conditions, "(t.from_account_id IN ("+ph+") OR t.to_account_id IN ("+ph+"))")
+		for i := 0; i < 2; i++ {
+			for _, id := range f.AccountIDs {
+				args = append(args, id)
+			}
+		}
+	}
+	if len(f.Types) > 0 {
+		conditions = append(conditions, "t.type IN ("+placeholders(len(f.Types))+")")
+		for _, typ := range f.Types {
+			args = append(args, typ)
+		}
+	}
+	if len(f.TagIDs) > 0 {
+		conditions = append(conditions, "EXISTS (SELECT 1 FROM transaction_tags tt WHERE tt.transaction_id = t.id AND tt.tag_id IN ("+placeholders(len(f.TagIDs))+"))")
+		for _, id := range f.TagIDs {
+			args = append(args, id)
+		}
+	}
+	if len(f.PayeeIDs) > 0 {
+		conditions = append(conditions, "t.payee_id IN ("+placeholders(len(f.PayeeIDs))+")")
+		for _, id := range f.PayeeIDs {
+			args = append(args, id)
+		}
+	}
+	if f.MinAmount != nil {
+		conditions = append(conditions, "t.amount >= ?")
+		args = append(args, *f.MinAmount)
+	}
+	if f.MaxAmount != nil {
+		conditions = append(conditions, "t.amount <= ?")
+		args = append(args, *f.MaxAmount)
+	}
+	if f.Keyword != "" {
+		escaped := strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(f.Keyword)
+		conditions = append(conditions, `t.description LIKE ? ESCAPE '\'`)
+		args = append(args, "%"+escaped+"%")
+	}
+	return conditions, args
+}
 
-	var queryBuilder strings.Builder
-	// 【修复问题三】使用子查询和 COALESCE 统一获取分类名
-	queryBuilder.WriteString(`
+// 【修复问题三】使用子查询和 COALESCE 统一获取分类名，参数为当前用户ID
+const userCategoriesCTE = `
         WITH UserCategories AS (
             SELECT id, name FROM shared_categories
             UNION ALL
             SELECT id, name FROM categories WHERE user_id = ?
-        )
-        SELECT 
+        )`
+
+// transactionColumns 与 scanTransaction 对应的查询列，需配合 transactionJoins 使用
+const transactionColumns = `
             t.id, t.type, t.amount, t.transaction_date, t.description, 
             t.related_loan_id, t.category_id, uc.name as category_name, t.created_at,
             t.from_account_id, fa.name as from_account_name,
-            t.to_account_id, ta.name as to_account_name
-        FROM transactions t
+            t.to_account_id, ta.name as to_account_name,
+            t.currency, t.to_amount, t.refund_of_id, t.cleared, t.reconciliation_id, t.settlement_month,
+            t.payee_id, p.name as payee_name`
+
+const transactionJoins = `
         LEFT JOIN UserCategories uc ON t.category_id = uc.id
         LEFT JOIN accounts fa ON t.from_account_id = fa.id
         LEFT JOIN accounts ta ON t.to_account_id = ta.id
-    `)
+        LEFT JOIN payees p ON t.payee_id = p.id`
+
+// scanTransaction 扫描一行 transactionColumns，extra 用于接收查询中追加在其后的列
+func scanTransaction(rows *sql.Rows, extra ...interface{}) (Transaction, error) {
+	var t Transaction
+	var description, categoryID, categoryName, fromAccountName, toAccountName, settlementMonth, payeeName sql.NullString
+	var relatedLoanID, fromAccountID, toAccountID, refundOfID, reconciliationID, payeeID sql.NullInt64
+	dest := []interface{}{
+		&t.ID, &t.Type, &t.Amount, &t.TransactionDate, &description,
+		&relatedLoanID, &categoryID, &categoryName, &t.CreatedAt,
+		&fromAccountID, &fromAccountName, &toAccountID, &toAccountName,
+		&t.Currency, &t.ToAmount, &refundOfID, &t.Cleared, &reconciliationID, &settlementMonth,
+		&payeeID, &payeeName,
+	}
+	if err := rows.Scan(append(dest, extra...)...); err != nil {
+		return t, err
+	}
+	t.Description = description.String
+	if relatedLoanID.Valid {
+		t.RelatedLoanID = &relatedLoanID.Int64
+	}
+	if categoryID.Valid {
+		t.CategoryID = &categoryID.String
+	}
+	if categoryName.Valid {
+		t.CategoryName = &categoryName.String
+	}
+	if fromAccountID.Valid {
+		t.FromAccountID = &fromAccountID.Int64
+	}
+	if fromAccountName.Valid {
+		t.FromAccountName = &fromAccountName.String
+	}
+	if toAccountID.Valid {
+		t.ToAccountID = &toAccountID.Int64
+	}
+	if toAccountName.Valid {
+		t.ToAccountName = &toAccountName.String
+	}
+	if refundOfID.Valid {
+		t.RefundOfID = &refundOfID.Int64
+	}
+	if reconciliationID.Valid {
+		t.ReconciliationID = &reconciliationID.Int64
+	}
+	if settlementMonth.Valid {
+		t.SettlementMonth = &settlementMonth.String
+	}
+	if payeeID.Valid {
+		t.PayeeID = &payeeID.Int64
+	}
+	if payeeName.Valid {
+		t.PayeeName = &payeeName.String
+	}
+	return t, nil
+}
 
-	var conditions []string
-	var args []interface{}
-	// 子查询的 userID
-	args = append(args, userID)
-	// 主查询的 userID
-	conditions = append(conditions, "t.user_id = ?")
-	args = append(args, userID)
+func encodeTransactionCursor(cur transactionCursor) string {
+	raw, _ := json.Marshal(cur)
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
 
-	if year != "" {
-		conditions = append(conditions, "strftime('%Y', t.transaction_date) = ?")
-		args = append(args, year)
+func decodeTransactionCursor(s string) (transactionCursor, error) {
+	var cur transactionCursor
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return cur, err
 	}
-	if month != "" {
-		monthFormatted := fmt.Sprintf("%02s", month)
-		conditions = append(conditions, "strftime('%m', t.transaction_date) = ?")
-		args = append(args, monthFormatted)
+	err = json.Unmarshal(raw, &cur)
+	return cur, err
+}
+
+// GetTransactions 支持多条件筛选、按 (日期, 创建时间, ID) 排序和游标分页。
+// 未提供 limit 时返回全部匹配记录，以兼容旧前端；汇总信息始终覆盖整个筛选结果集。
+func (h *DBHandler) GetTransactions(c *gin.Context) {
+	userID, _ := c.Get("userID")
+	logger := h.Logger.With(slog.Int64("userID", userID.(int64)))
+
+	filter, err := parseTransactionFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	conditions, filterArgs := filter.conditions(userID.(int64))
+
+	order := strings.ToLower(c.DefaultQuery("order", "desc"))
+	if order != "asc" && order != "desc" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "order 只能为 asc 或 desc"})
+		return
+	}
+
+	limit := 0
+	if v := c.Query("limit"); v != "" {
+		limit, err = strconv.Atoi(v)
+		if err != nil || limit <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "无效的 limit 参数"})
+			return
+		}
+		if limit > maxTransactionPageLimit {
+			limit = maxTransactionPageLimit
+		}
+	} else if c.Query("cursor") != "" {
+		limit = defaultTransactionPageLimit
+	}
+
+	// 1. 汇总信息基于完整的筛选结果，不受分页影响，按流水日期的汇率换算为本位币；结果集中的退款冲减支出
+	var summary FinancialSummary
+	if summary.Currency, err = userBaseCurrency(h.DB, userID.(int64)); err != nil {
+		logger.Error("查询本位币失败", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "汇总流水失败"})
+		return
+	}
+	summaryQuery := `
+        SELECT
+            COALESCE(SUM(CASE WHEN t.type = 'income' THEN ` + transactionBaseAmount + ` ELSE 0 END), 0),
+            COALESCE(SUM(CASE WHEN t.type IN ('expense', 'repayment') THEN ` + transactionBaseAmount + `
+                              WHEN t.type = 'refund' THEN -` + transactionBaseAmount + ` ELSE 0 END), 0)
+        FROM transactions t WHERE ` + strings.Join(conditions, " AND ")
+	if err := h.DB.QueryRow(summaryQuery, filterArgs...).Scan(&summary.TotalIncome, &summary.TotalExpense); err != nil {
+		logger.Error("汇总流水失败", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "汇总流水失败"})
+		return
+	}
+	summary.NetBalance = summary.TotalIncome - summary.TotalExpense
+
+	// 2. 查询当前页
+	pageConditions := append([]string{}, conditions...)
+	pageArgs := append([]interface{}{}, filterArgs...)
+	if cursorStr := c.Query("cursor"); cursorStr != "" {
+		cur, err := decodeTransactionCursor(cursorStr)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "无效的分页游标"})
+			return
+		}
+		op := "<"
+		if order == "asc" {
+			op = ">"
+		}
+		pageConditions = append(pageConditions, "(t.transaction_date, t.created_at, t.id) "+op+" (?, ?, ?)")
+		pageArgs = append(pageArgs, cur.Date, cur.CreatedAt, cur.ID)
 	}
 
-	if len(conditions) > 0 {
-		queryBuilder.WriteString(" WHERE ")
-		queryBuilder.WriteString(strings.Join(conditions, " AND "))
+	var queryBuilder strings.Builder
+	queryBuilder.WriteString(userCategoriesCTE + " SELECT " + transactionColumns + " FROM transactions t " + transactionJoins)
+	queryBuilder.WriteString(" WHERE ")
+	queryBuilder.WriteString(strings.Join(pageConditions, " AND "))
+	queryBuilder.WriteString(fmt.Sprintf(" ORDER BY t.transaction_date %[1]s, t.created_at %[1]s, t.id %[1]s", strings.ToUpper(order)))
+	// 子查询的 userID 在最前面
+	args := append([]interface{}{userID}, pageArgs...)
+	if limit > 0 {
+		// 多取一条用于判断是否还有下一页
+		queryBuilder.WriteString(" LIMIT ?")
+		args = append(args, limit+1)
 	}
-	queryBuilder.WriteString(" ORDER BY t.transaction_date DESC, t.created_at DESC")
 
 	rows, err := h.DB.Query(queryBuilder.String(), args...)
 	if err != nil {
@@ -226,68 +618,61 @@ func (h *DBHandler) GetTransactions(c *gin.Context) {
 	defer rows.Close()
 
 	transactions := []Transaction{}
-	var totalIncome, totalExpense float64
 	for rows.Next() {
-		var t Transaction
-		var description, categoryID, categoryName, fromAccountName, toAccountName sql.NullString
-		var relatedLoanID, fromAccountID, toAccountID sql.NullInt64
-		if err := rows.Scan(
-			&t.ID, &t.Type, &t.Amount, &t.TransactionDate, &description,
-			&relatedLoanID, &categoryID, &categoryName, &t.CreatedAt,
-			&fromAccountID, &fromAccountName, &toAccountID, &toAccountName,
-		); err != nil {
+		t, err := scanTransaction(rows)
+		if err != nil {
 			logger.Error("扫描流水数据失败", "error", err)
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "扫描流水数据失败"})
 			return
 		}
-		t.Description = description.String
-		if relatedLoanID.Valid {
-			t.RelatedLoanID = &relatedLoanID.Int64
-		}
-		if categoryID.Valid {
-			t.CategoryID = &categoryID.String
-		}
-		if categoryName.Valid {
-			t.CategoryName = &categoryName.String
-		}
-		if fromAccountID.Valid {
-			t.FromAccountID = &fromAccountID.Int64
-		}
-		if fromAccountName.Valid {
-			t.FromAccountName = &fromAccountName.String
-		}
-		if toAccountID.Valid {
-			t.ToAccountID = &toAccountID.Int64
-		}
-		if toAccountName.Valid {
-			t.ToAccountName = &toAccountName.String
-		}
-
-		if t.Type == "income" {
-			totalIncome += t.Amount
-		} else if t.Type == "expense" || t.Type == "repayment" { // 【修复问题二】将还款计入总支出
-			totalExpense += t.Amount
-		}
 		transactions = append(transactions, t)
 	}
+	if err := rows.Err(); err != nil {
+		logger.Error("遍历流水结果集时出错", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询流水失败"})
+		return
+	}
 
 	response := GetTransactionsResponse{
 		Transactions: transactions,
-		Summary: FinancialSummary{
-			TotalIncome:  totalIncome,
-			TotalExpense: totalExpense,
-			NetBalance:   totalIncome - totalExpense,
-		},
+		Summary:      summary,
+	}
+	if limit > 0 && len(transactions) > limit {
+		response.Transactions = transactions[:limit]
+		last := response.Transactions[limit-1]
+		next := encodeTransactionCursor(transactionCursor{Date: last.TransactionDate, CreatedAt: last.CreatedAt, ID: last.ID})
+		response.NextCursor = &next
+	}
+	if err := loadTransactionSplits(h.DB, userID.(int64), response.Transactions); err != nil {
+		logger.Error("查询拆分明细失败", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询拆分明细失败"})
+		return
+	}
+	if err := loadTransactionTags(h.DB, userID.(int64), response.Transactions); err != nil {
+		logger.Error("查询流水标签失败", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询流水标签失败"})
+		return
+	}
+	if err := loadTransactionRefunds(h.DB, userID.(int64), response.Transactions); err != nil {
+		logger.Error("查询退款关系失败", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询退款关系失败"})
+		return
 	}
 	c.JSON(http.StatusOK, response)
 }
 
-// DeleteTransaction (已重构)
-func (h *DBHandler) DeleteTransaction(c *gin.Context) {
+// UpdateTransaction 修改一条流水：先撤销旧流水对账户余额的影响，再按新数据重新入账，全部在同一事务中完成
+func (h *DBHandler) UpdateTransaction(c *gin.Context) {
 	userID, _ := c.Get("userID")
 	id := c.Param("id")
 	logger := h.Logger.With(slog.Int64("userID", userID.(int64)), "transactionID", id)
 
+	var req CreateTransactionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的请求数据: " + err.Error()})
+		return
+	}
+
 	tx, err := h.DB.Begin()
 	if err != nil {
 		logger.Error("开启事务失败", "error", err)
@@ -296,59 +681,164 @@ func (h *DBHandler) DeleteTransaction(c *gin.Context) {
 	}
 	defer tx.Rollback()
 
-	// 1. 获取要删除的流水信息
-	var t Transaction
+	// 1. 获取原流水信息 (同时校验归属权)
+	var old Transaction
 	err = tx.QueryRow(
-		"SELECT type, amount, from_account_id, to_account_id FROM transactions WHERE id = ? AND user_id = ?",
+		"SELECT type, amount, to_amount, from_account_id, to_account_id, transaction_date FROM transactions WHERE id = ? AND user_id = ? AND deleted_at IS NULL",
 		id, userID,
-	).Scan(&t.Type, &t.Amount, &t.FromAccountID, &t.ToAccountID)
-
+	).Scan(&old.Type, &old.Amount, &old.ToAmount, &old.FromAccountID, &old.ToAccountID, &old.TransactionDate)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			c.JSON(http.StatusNotFound, gin.H{"error": "未找到指定ID的流水"})
 		} else {
-			logger.Error("查询待删除流水失败", "error", err)
+			logger.Error("查询待修改流水失败", "error", err)
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "服务器内部错误"})
 		}
 		return
 	}
+	// 余额调整流水由对账和一致性修复生成，改成普通收支会混入收支统计
+	if old.Type == "adjustment" {
+		c.JSON(http.StatusConflict, gin.H{"error": "余额调整流水不能修改，如需撤销请删除"})
+		return
+	}
 
-	// 2. 执行反向操作，恢复账户余额
-	switch t.Type {
-	case "income":
-		if t.ToAccountID != nil {
-			_, err = tx.Exec("UPDATE accounts SET balance = balance - ? WHERE id = ?", t.Amount, t.ToAccountID)
-		}
-	case "expense", "repayment":
-		if t.FromAccountID != nil {
-			_, err = tx.Exec("UPDATE accounts SET balance = balance + ? WHERE id = ?", t.Amount, t.FromAccountID)
+	if err := validateSplits(&req); err != nil {
+		writeLedgerError(c, logger, err)
+		return
+	}
+	transactionID, _ := strconv.ParseInt(id, 10, 64)
+	before, err := auditSnapshot(tx, "transaction", userID.(int64), transactionID)
+	if err != nil {
+		writeLedgerError(c, logger, err)
+		return
+	}
+	if err := ensureNotReconciled(tx, transactionID); err != nil {
+		writeLedgerError(c, logger, err)
+		return
+	}
+	// 原日期和新日期所在月份都不能已结算
+	for _, date := range []string{old.TransactionDate, req.TransactionDate} {
+		if err := ensureMonthOpen(tx, userID.(int64), date); err != nil {
+			writeLedgerError(c, logger, err)
+			return
 		}
-	case "transfer":
-		if t.FromAccountID != nil && t.ToAccountID != nil {
-			_, err = tx.Exec("UPDATE accounts SET balance = balance + ? WHERE id = ?", t.Amount, t.FromAccountID)
-			if err == nil {
-				_, err = tx.Exec("UPDATE accounts SET balance = balance - ? WHERE id = ?", t.Amount, t.ToAccountID)
-			}
+	}
+	if req.RefundOfID != nil && *req.RefundOfID == transactionID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "流水不能作为自身的退款"})
+		return
+	}
+	if err := prepareRefund(tx, userID.(int64), &req, transactionID); err != nil {
+		writeLedgerError(c, logger, err)
+		return
+	}
+	if err := resolvePayee(tx, userID.(int64), &req); err != nil {
+		writeLedgerError(c, logger, err)
+		return
+	}
+
+	// 2. 冲回原流水的记账分录
+	if err := unpostTransaction(tx, transactionID); err != nil {
+		writeLedgerError(c, logger, err)
+		return
+	}
+
+	// 3. 按新数据校验归属权和余额
+	if err := validateTransactionAccounts(tx, userID.(int64), &req); err != nil {
+		writeLedgerError(c, logger, err)
+		return
+	}
+	// 原流水已使用的账户即使已归档也允许保留，只有新换上的账户必须未归档
+	var newAccounts []*int64
+	for _, id := range []*int64{req.FromAccountID, req.ToAccountID} {
+		if id != nil && !sameAccount(id, old.FromAccountID) && !sameAccount(id, old.ToAccountID) {
+			newAccounts = append(newAccounts, id)
 		}
 	}
+	if err := ensureAccountsActive(tx, newAccounts...); err != nil {
+		writeLedgerError(c, logger, err)
+		return
+	}
+
+	// 4. 更新流水记录 (币种随账户重新确定)
+	currency, err := transactionCurrency(tx, userID.(int64), &req)
 	if err != nil {
-		logger.Error("恢复账户余额失败", "error", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "删除流水时恢复账户余额失败"})
+		writeLedgerError(c, logger, err)
+		return
+	}
+	// 已有退款的支出修改后仍需能承载这些退款
+	if err := checkRefundedExpense(tx, transactionID, &req, currency); err != nil {
+		writeLedgerError(c, logger, err)
+		return
+	}
+	_, err = tx.Exec(
+		"UPDATE transactions SET type = ?, amount = ?, transaction_date = ?, description = ?, category_id = ?, related_loan_id = ?, from_account_id = ?, to_account_id = ?, currency = ?, to_amount = ?, refund_of_id = ?, payee_id = ? WHERE id = ? AND user_id = ?",
+		req.Type, req.Amount, req.TransactionDate, req.Description, req.CategoryID, req.RelatedLoanID, req.FromAccountID, req.ToAccountID, currency, req.ToAmount, req.RefundOfID, req.PayeeID, id, userID,
+	)
+	if err != nil {
+		logger.Error("更新流水记录失败", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "更新流水记录失败"})
+		return
+	}
+
+	// 5. 以新的拆分明细整体替换旧明细
+	if err := saveTransactionSplits(tx, transactionID, req.Splits); err != nil {
+		writeLedgerError(c, logger, err)
+		return
+	}
+
+	// 6. 未传 tags 时保留原有标签
+	if req.Tags != nil {
+		if err := saveTransactionTags(tx, userID.(int64), transactionID, req.Tags); err != nil {
+			writeLedgerError(c, logger, err)
+			return
+		}
+	}
+
+	// 7. 按新数据写入记账分录，更新账户余额
+	if err := postTransaction(tx, transactionID); err != nil {
+		writeLedgerError(c, logger, err)
+		return
+	}
+	if err := writeAudit(tx, auditActorFromContext(c), "transaction", "update", transactionID, before); err != nil {
+		writeLedgerError(c, logger, err)
 		return
 	}
 
-	// 3. 删除流水记录
-	res, err := tx.Exec("DELETE FROM transactions WHERE id = ?", id)
+	if err := tx.Commit(); err != nil {
+		logger.Error("提交事务失败", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "提交事务失败"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "流水更新成功，相关账户余额已重新计算"})
+}
+
+// DeleteTransaction 删除流水 (移入回收站，可通过 POST /trash/:id/restore 恢复)
+func (h *DBHandler) DeleteTransaction(c *gin.Context) {
+	userID, _ := c.Get("userID")
+	id := c.Param("id")
+	logger := h.Logger.With(slog.Int64("userID", userID.(int64)), "transactionID", id)
+
+	tx, err := h.DB.Begin()
 	if err != nil {
-		logger.Error("删除流水记录失败", "error", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "删除流水记录失败"})
+		logger.Error("开启事务失败", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "开启事务失败"})
 		return
 	}
+	defer tx.Rollback()
 
-	rowsAffected, _ := res.RowsAffected()
-	if rowsAffected == 0 {
-		// 理论上不会发生，因为前面已经查询过了
-		c.JSON(http.StatusNotFound, gin.H{"error": "未找到指定ID的流水"})
+	transactionID, _ := strconv.ParseInt(id, 10, 64)
+	before, err := auditSnapshot(tx, "transaction", userID.(int64), transactionID)
+	if err != nil {
+		writeLedgerError(c, logger, err)
+		return
+	}
+	if err := deleteTransactionInTx(tx, userID.(int64), transactionID); err != nil {
+		writeLedgerError(c, logger, err)
+		return
+	}
+	if err := writeAudit(tx, auditActorFromContext(c), "transaction", "delete", transactionID, before); err != nil {
+		writeLedgerError(c, logger, err)
 		return
 	}
 
@@ -358,5 +848,5 @@ func (h *DBHandler) DeleteTransaction(c *gin.Context) {
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "流水删除成功，相关账户余额已恢复"})
+	c.JSON(http.StatusOK, gin.H{"message": "流水已移入回收站，相关账户余额已恢复"})
 }
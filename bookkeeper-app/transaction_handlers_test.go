@@ -0,0 +1,279 @@
+// bookkeeper-app/transaction_handlers_test.go
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// 测试修改流水时账户余额被重新计算 (金额和账户都发生变化)
+func TestUpdateTransaction_RepostsBalance(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	handler := &DBHandler{DB: db, Logger: slog.New(slog.NewJSONHandler(io.Discard, nil))}
+	router := setupRouter(handler)
+
+	userID := createTestUser(t, db, "testuser", "password")
+	token := getTestAuthToken(t, userID, "testuser", false)
+	accountA := createTestAccount(t, db, userID, "A", 1000.0)
+	accountB := createTestAccount(t, db, userID, "B", 500.0)
+
+	category := "food_dining"
+	createReq := CreateTransactionRequest{
+		Type:            "expense",
+		Amount:          yuan(50.0),
+		TransactionDate: time.Now().Format("2006-01-02"),
+		CategoryID:      &category,
+		FromAccountID:   &accountA,
+	}
+	body, _ := json.Marshal(createReq)
+	w := performRequest(router, "POST", "/api/v1/transactions", bytes.NewBuffer(body), token)
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	var transactionID int64
+	db.QueryRow("SELECT id FROM transactions WHERE user_id = ? ORDER BY id DESC LIMIT 1", userID).Scan(&transactionID)
+
+	// 把金额改为 80，付款账户改为 B
+	updateReq := createReq
+	updateReq.Amount = yuan(80.0)
+	updateReq.FromAccountID = &accountB
+	body, _ = json.Marshal(updateReq)
+	w = performRequest(router, "PUT", fmt.Sprintf("/api/v1/transactions/%d", transactionID), bytes.NewBuffer(body), token)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var balanceA, balanceB, amount Money
+	db.QueryRow("SELECT balance FROM accounts WHERE id = ?", accountA).Scan(&balanceA)
+	db.QueryRow("SELECT balance FROM accounts WHERE id = ?", accountB).Scan(&balanceB)
+	db.QueryRow("SELECT amount FROM transactions WHERE id = ?", transactionID).Scan(&amount)
+	assert.Equal(t, yuan(1000), balanceA, "原付款账户的扣款应被撤销")
+	assert.Equal(t, yuan(420), balanceB, "新付款账户应按新金额扣款")
+	assert.Equal(t, yuan(80), amount)
+}
+
+// 测试修改后余额不足时整个修改被回滚
+func TestUpdateTransaction_InsufficientBalanceRollsBack(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	handler := &DBHandler{DB: db, Logger: slog.New(slog.NewJSONHandler(io.Discard, nil))}
+	router := setupRouter(handler)
+
+	userID := createTestUser(t, db, "testuser", "password")
+	token := getTestAuthToken(t, userID, "testuser", false)
+	accountID := createTestAccount(t, db, userID, "Test Account", 100.0)
+
+	createReq := CreateTransactionRequest{
+		Type:            "expense",
+		Amount:          yuan(60.0),
+		TransactionDate: time.Now().Format("2006-01-02"),
+		FromAccountID:   &accountID,
+	}
+	body, _ := json.Marshal(createReq)
+	w := performRequest(router, "POST", "/api/v1/transactions", bytes.NewBuffer(body), token)
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	var transactionID int64
+	db.QueryRow("SELECT id FROM transactions WHERE user_id = ? ORDER BY id DESC LIMIT 1", userID).Scan(&transactionID)
+
+	// 撤销 60 后余额为 100，修改为 100.01 应失败
+	updateReq := createReq
+	updateReq.Amount = yuan(100.01)
+	body, _ = json.Marshal(updateReq)
+	w = performRequest(router, "PUT", fmt.Sprintf("/api/v1/transactions/%d", transactionID), bytes.NewBuffer(body), token)
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	var balance, amount Money
+	db.QueryRow("SELECT balance FROM accounts WHERE id = ?", accountID).Scan(&balance)
+	db.QueryRow("SELECT amount FROM transactions WHERE id = ?", transactionID).Scan(&amount)
+	assert.Equal(t, yuan(40), balance, "修改失败时余额应保持不变")
+	assert.Equal(t, yuan(60), amount)
+}
+
+// 测试不能修改其他用户的流水
+func TestUpdateTransaction_NotOwner(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	handler := &DBHandler{DB: db, Logger: slog.New(slog.NewJSONHandler(io.Discard, nil))}
+	router := setupRouter(handler)
+
+	user1ID := createTestUser(t, db, "user1", "password")
+	user2ID := createTestUser(t, db, "user2", "password")
+	accountID := createTestAccount(t, db, user2ID, "User2-Account", 100.0)
+	db.Exec("INSERT INTO transactions (user_id, type, amount, transaction_date, from_account_id, created_at) VALUES (?, 'expense', ?, '2024-01-01', ?, ?)", user2ID, yuan(10), accountID, time.Now().Format(time.RFC3339))
+	var transactionID int64
+	db.QueryRow("SELECT id FROM transactions WHERE user_id = ?", user2ID).Scan(&transactionID)
+
+	user1Token := getTestAuthToken(t, user1ID, "user1", false)
+	updateReq := CreateTransactionRequest{Type: "expense", Amount: yuan(20), TransactionDate: "2024-01-01", FromAccountID: &accountID}
+	body, _ := json.Marshal(updateReq)
+	w := performRequest(router, "PUT", fmt.Sprintf("/api/v1/transactions/%d", transactionID), bytes.NewBuffer(body), user1Token)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+// 测试筛选条件、游标分页，以及汇总覆盖整个筛选结果
+func TestGetTransactions_FilterAndCursorPagination(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	handler := &DBHandler{DB: db, Logger: slog.New(slog.NewJSONHandler(io.Discard, nil))}
+	router := setupRouter(handler)
+
+	userID := createTestUser(t, db, "testuser", "password")
+	token := getTestAuthToken(t, userID, "testuser", false)
+	accountID := createTestAccount(t, db, userID, "Test Account", 1000.0)
+
+	createdAt := time.Now().Format(time.RFC3339)
+	insert := "INSERT INTO transactions (user_id, type, amount, transaction_date, description, category_id, from_account_id, to_account_id, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)"
+	for day := 1; day <= 5; day++ {
+		db.Exec(insert, userID, "expense", yuan(float64(day*10)), fmt.Sprintf("2024-03-%02d", day), fmt.Sprintf("team dinner %d", day), "food_dining", accountID, nil, createdAt)
+	}
+	db.Exec(insert, userID, "expense", yuan(999), "2024-03-03", "taxi", "transportation", accountID, nil, createdAt)
+	db.Exec(insert, userID, "income", yuan(500), "2024-03-02", "salary", "salary", nil, accountID, createdAt)
+	db.Exec(insert, userID, "expense", yuan(70), "2024-04-01", "team dinner april", "food_dining", accountID, nil, createdAt)
+
+	query := "/api/v1/transactions?from=2024-03-01&to=2024-03-31&category_id=food_dining,salary&min_amount=20&q=dinner&limit=2"
+	var seen []string
+	cursor := ""
+	for page := 0; page < 5; page++ {
+		url := query
+		if cursor != "" {
+			url += "&cursor=" + cursor
+		}
+		w := performRequest(router, "GET", url, nil, token)
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		var resp GetTransactionsResponse
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		// 汇总覆盖全部匹配的 4 条 (20+30+40+50)，而不仅是当前页
+		assert.Equal(t, yuan(140), resp.Summary.TotalExpense)
+		for _, tr := range resp.Transactions {
+			seen = append(seen, tr.TransactionDate)
+		}
+		if resp.NextCursor == nil {
+			break
+		}
+		cursor = *resp.NextCursor
+	}
+	assert.Equal(t, []string{"2024-03-05", "2024-03-04", "2024-03-03", "2024-03-02"}, seen)
+
+	w := performRequest(router, "GET", "/api/v1/transactions?type=bogus", nil, token)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+// 测试搜索结果仅包含当前用户的流水，并返回高亮片段
+func TestSearchTransactions_ScopedToUser(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	handler := &DBHandler{DB: db, Logger: slog.New(slog.NewJSONHandler(io.Discard, nil))}
+	router := setupRouter(handler)
+
+	user1ID := createTestUser(t, db, "user1", "password")
+	user2ID := createTestUser(t, db, "user2", "password")
+	createdAt := time.Now().Format(time.RFC3339)
+	insert := "INSERT INTO transactions (user_id, type, amount, transaction_date, description, created_at) VALUES (?, 'expense', ?, ?, ?, ?)"
+	db.Exec(insert, user1ID, yuan(300), "2024-03-15", "和团队一起的晚餐 dinner with the team", createdAt)
+	db.Exec(insert, user1ID, yuan(20), "2024-03-16", "午饭", createdAt)
+	db.Exec(insert, user2ID, yuan(99), "2024-03-15", "dinner with the team", createdAt)
+
+	token := getTestAuthToken(t, user1ID, "user1", false)
+	w := performRequest(router, "GET", "/api/v1/transactions/search?q=team+dinner", nil, token)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var resp struct {
+		Results []TransactionSearchResult `json:"results"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	if assert.Len(t, resp.Results, 1) {
+		assert.Equal(t, yuan(300), resp.Results[0].Amount)
+		assert.Contains(t, resp.Results[0].Snippet, "<mark>")
+	}
+
+	// 短于 3 个字符的中文关键词同样可以搜索到
+	w = performRequest(router, "GET", "/api/v1/transactions/search?q=晚餐", nil, token)
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Len(t, resp.Results, 1)
+
+	// 描述中的 HTML 被转义，只有高亮标签原样输出
+	db.Exec(insert, user1ID, yuan(5), "2024-03-17", `<img src=x onerror="alert(1)"> lunch`, createdAt)
+	for _, q := range []string{"lunch", "onerror"} {
+		w = performRequest(router, "GET", "/api/v1/transactions/search?q="+q, nil, token)
+		resp.Results = nil
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		if assert.Len(t, resp.Results, 1) {
+			assert.NotContains(t, resp.Results[0].Snippet, "<img")
+			assert.Contains(t, resp.Results[0].Snippet, "&lt;img")
+			assert.Contains(t, resp.Results[0].Snippet, "<mark>")
+		}
+	}
+}
+
+// 测试拆分流水：余额只按流水本身扣减，分类统计和预算按拆分行计算
+func TestCreateTransaction_WithSplits(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	handler := &DBHandler{DB: db, Logger: slog.New(slog.NewJSONHandler(io.Discard, nil))}
+	router := setupRouter(handler)
+
+	userID := createTestUser(t, db, "testuser", "password")
+	token := getTestAuthToken(t, userID, "testuser", false)
+	accountID := createTestAccount(t, db, userID, "Test Account", 1000.0)
+
+	createReq := CreateTransactionRequest{
+		Type:            "expense",
+		Amount:          yuan(100.0),
+		TransactionDate: "2024-05-10",
+		Description:     "超市小票",
+		FromAccountID:   &accountID,
+		Splits: []TransactionSplitRequest{
+			{CategoryID: "food_dining", Amount: yuan(60.0), Note: "食品"},
+			{CategoryID: "shopping", Amount: yuan(40.0), Note: "日用品"},
+		},
+	}
+
+	// 拆分合计与流水金额不一致时拒绝
+	badReq := createReq
+	badReq.Amount = yuan(90.0)
+	body, _ := json.Marshal(badReq)
+	w := performRequest(router, "POST", "/api/v1/transactions", bytes.NewBuffer(body), token)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	body, _ = json.Marshal(createReq)
+	w = performRequest(router, "POST", "/api/v1/transactions", bytes.NewBuffer(body), token)
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	var balance Money
+	db.QueryRow("SELECT balance FROM accounts WHERE id = ?", accountID).Scan(&balance)
+	assert.Equal(t, yuan(900), balance)
+
+	// 列表中带出拆分明细
+	w = performRequest(router, "GET", "/api/v1/transactions?year=2024&month=5", nil, token)
+	var listResp GetTransactionsResponse
+	json.Unmarshal(w.Body.Bytes(), &listResp)
+	if assert.Len(t, listResp.Transactions, 1) {
+		assert.Len(t, listResp.Transactions[0].Splits, 2)
+	}
+
+	// 分类支出按拆分行归属
+	w = performRequest(router, "GET", "/api/v1/analytics/charts?year=2024&month=5", nil, token)
+	var charts AnalyticsChartsResponse
+	json.Unmarshal(w.Body.Bytes(), &charts)
+	assert.ElementsMatch(t, []ChartDataPoint{{Name: "餐饮", Value: yuan(60.0)}, {Name: "购物", Value: yuan(40.0)}}, charts.CategoryExpense)
+
+	// 分类预算的已用金额只统计该分类的拆分行
+	category := "food_dining"
+	budgetBody, _ := json.Marshal(CreateOrUpdateBudgetRequest{CategoryID: &category, Amount: yuan(500), Period: "monthly", Year: 2024, Month: 5})
+	w = performRequest(router, "POST", "/api/v1/budgets", bytes.NewBuffer(budgetBody), token)
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = performRequest(router, "GET", "/api/v1/budgets?year=2024&month=5", nil, token)
+	var budgets []Budget
+	json.Unmarshal(w.Body.Bytes(), &budgets)
+	if assert.Len(t, budgets, 1) {
+		assert.Equal(t, yuan(60), budgets[0].Spent)
+	}
+}
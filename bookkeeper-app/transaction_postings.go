@@ -0,0 +1,315 @@
+// bookkeeper-app/transaction_postings.go
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 复式记账分录：每条流水写入一组借贷平衡的分录 (借方为正、贷方为负，同一币种的分录合计为零)。
+// 分录的科目类型：
+//   - asset   账户 (account_id)，账户余额等于期初余额加上其所有分录
+//   - income  收入分类 (category_id)
+//   - expense 支出分类 (category_id)；还款记为 loan_repayment 等分类下的支出并带上 loan_id (贷款本金不入账)
+//   - equity  权益，用于跨币种转账两边金额的差额，以及余额调整 (adjustment) 流水的对方科目
+//
+// 流水的增删改都通过 postTransaction / unpostTransaction 完成，账户余额只在这里变动；
+// 已删除 (在回收站中) 的流水没有分录。
+const (
+	postingAsset   = "asset"
+	postingIncome  = "income"
+	postingExpense = "expense"
+	postingEquity  = "equity"
+)
+
+// setupPostings 创建分录表，为还没有分录的流水补写分录 (只补分录，不改动账户余额)，
+// 并基于分录重建 transaction_lines 分类统计视图
+func setupPostings(tx *sql.Tx) error {
+	if _, err := tx.Exec(`
+    CREATE TABLE IF NOT EXISTS postings (
+        "id" INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+        "transaction_id" INTEGER NOT NULL,
+        "user_id" INTEGER NOT NULL,
+        "account_type" TEXT NOT NULL,
+        "account_id" INTEGER,
+        "category_id" TEXT,
+        "loan_id" INTEGER,
+        "amount" INTEGER NOT NULL,
+        "currency" TEXT NOT NULL,
+        FOREIGN KEY(transaction_id) REFERENCES transactions(id) ON DELETE CASCADE,
+        FOREIGN KEY(account_id) REFERENCES accounts(id) ON DELETE SET NULL,
+        FOREIGN KEY(loan_id) REFERENCES loans(id) ON DELETE SET NULL
+    );`); err != nil {
+		return fmt.Errorf("创建 postings 表失败: %w", err)
+	}
+	if _, err := tx.Exec(`CREATE INDEX IF NOT EXISTS idx_postings_transaction ON postings (transaction_id);`); err != nil {
+		return fmt.Errorf("为 postings 创建索引失败: %w", err)
+	}
+	if _, err := tx.Exec(`CREATE INDEX IF NOT EXISTS idx_postings_account ON postings (account_id) WHERE account_id IS NOT NULL;`); err != nil {
+		return fmt.Errorf("为 postings 创建索引失败: %w", err)
+	}
+
+	rows, err := tx.Query(`
+        SELECT t.id FROM transactions t
+        WHERE t.deleted_at IS NULL AND t.type <> 'settlement'
+          AND NOT EXISTS (SELECT 1 FROM postings p WHERE p.transaction_id = t.id)`)
+	if err != nil {
+		return fmt.Errorf("查询待补写分录的流水失败: %w", err)
+	}
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return fmt.Errorf("扫描待补写分录的流水失败: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	for _, id := range ids {
+		postings, err := buildPostings(tx, id)
+		if err != nil {
+			return fmt.Errorf("为流水 %d 生成分录失败: %w", id, err)
+		}
+		if err := insertPostings(tx, postings); err != nil {
+			return fmt.Errorf("为流水 %d 写入分录失败: %w", id, err)
+		}
+	}
+
+	if _, err := tx.Exec(`DROP VIEW IF EXISTS transaction_lines;`); err != nil {
+		return fmt.Errorf("删除 transaction_lines 视图失败: %w", err)
+	}
+	if _, err := tx.Exec(transactionLinesView); err != nil {
+		return fmt.Errorf("创建 transaction_lines 视图失败: %w", err)
+	}
+	return nil
+}
+
+// buildPostings 按流水当前的数据 (含拆分明细) 生成分录，这是 REST 流水与分录之间唯一的转换规则
+func buildPostings(tx *sql.Tx, transactionID int64) ([]Posting, error) {
+	var t Transaction
+	var toCurrency sql.NullString
+	err := tx.QueryRow(`
+        SELECT t.user_id, t.type, t.amount, t.to_amount, t.category_id, t.related_loan_id, t.from_account_id, t.to_account_id, t.currency, ta.currency
+        FROM transactions t LEFT JOIN accounts ta ON ta.id = t.to_account_id
+        WHERE t.id = ?`, transactionID,
+	).Scan(&t.UserID, &t.Type, &t.Amount, &t.ToAmount, &t.CategoryID, &t.RelatedLoanID, &t.FromAccountID, &t.ToAccountID, &t.Currency, &toCurrency)
+	if err != nil {
+		return nil, err
+	}
+
+	// 有拆分明细时收支按拆分行分别记入各分类
+	type categoryLine struct {
+		categoryID *string
+		amount     Money
+	}
+	lines := []categoryLine{{t.CategoryID, t.Amount}}
+	splitRows, err := tx.Query("SELECT category_id, amount FROM transaction_splits WHERE transaction_id = ? ORDER BY id", transactionID)
+	if err != nil {
+		return nil, err
+	}
+	var splits []categoryLine
+	for splitRows.Next() {
+		var line categoryLine
+		if err := splitRows.Scan(&line.categoryID, &line.amount); err != nil {
+			splitRows.Close()
+			return nil, err
+		}
+		splits = append(splits, line)
+	}
+	splitRows.Close()
+	if len(splits) > 0 {
+		lines = splits
+	}
+
+	var postings []Posting
+	add := func(accountType string, accountID *int64, categoryID *string, amount Money, currency string) {
+		postings = append(postings, Posting{
+			TransactionID: transactionID, AccountType: accountType, AccountID: accountID, CategoryID: categoryID,
+			Amount: amount, Currency: currency, UserID: t.UserID,
+		})
+	}
+	addCategories := func(accountType string, sign Money) {
+		for _, line := range lines {
+			add(accountType, nil, line.categoryID, sign*line.amount, t.Currency)
+		}
+	}
+
+	switch t.Type {
+	case "income":
+		add(postingAsset, t.ToAccountID, nil, t.Amount, t.Currency)
+		addCategories(postingIncome, -1)
+	case "refund":
+		// 退款冲减原支出分类
+		add(postingAsset, t.ToAccountID, nil, t.Amount, t.Currency)
+		addCategories(postingExpense, -1)
+	case "expense":
+		addCategories(postingExpense, 1)
+		add(postingAsset, t.FromAccountID, nil, -t.Amount, t.Currency)
+	case "repayment":
+		add(postingExpense, nil, t.CategoryID, t.Amount, t.Currency)
+		postings[len(postings)-1].LoanID = t.RelatedLoanID
+		add(postingAsset, t.FromAccountID, nil, -t.Amount, t.Currency)
+	case "transfer":
+		toAmount, currency := t.Amount, t.Currency
+		if t.ToAmount != nil {
+			toAmount = *t.ToAmount
+		}
+		if toCurrency.Valid {
+			currency = toCurrency.String
+		}
+		add(postingAsset, t.FromAccountID, nil, -t.Amount, t.Currency)
+		add(postingAsset, t.ToAccountID, nil, toAmount, currency)
+		if currency != t.Currency {
+			add(postingEquity, nil, nil, t.Amount, t.Currency)
+			add(postingEquity, nil, nil, -toAmount, currency)
+		}
+	case "adjustment":
+		// 余额调整只在账户和权益之间记账，不涉及收支分类
+		if t.ToAccountID != nil {
+			add(postingAsset, t.ToAccountID, nil, t.Amount, t.Currency)
+			add(postingEquity, nil, nil, -t.Amount, t.Currency)
+		} else {
+			add(postingEquity, nil, nil, t.Amount, t.Currency)
+			add(postingAsset, t.FromAccountID, nil, -t.Amount, t.Currency)
+		}
+	}
+	return postings, nil
+}
+
+func insertPostings(tx *sql.Tx, postings []Posting) error {
+	for _, p := range postings {
+		_, err := tx.Exec(
+			"INSERT INTO postings (transaction_id, user_id, account_type, account_id, category_id, loan_id, amount, currency) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+			p.TransactionID, p.UserID, p.AccountType, p.AccountID, p.CategoryID, p.LoanID, p.Amount, p.Currency,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// recordPostings 为流水写入分录但不改动账户余额，用于余额中已经包含该金额的情况 (如修复余额偏差)
+func recordPostings(tx *sql.Tx, transactionID int64) ([]Posting, error) {
+	postings, err := buildPostings(tx, transactionID)
+	if err != nil {
+		return nil, &ledgerError{Status: http.StatusInternalServerError, Message: "生成记账分录失败", Err: err}
+	}
+	if err := insertPostings(tx, postings); err != nil {
+		return nil, &ledgerError{Status: http.StatusInternalServerError, Message: "写入记账分录失败", Err: err}
+	}
+	return postings, nil
+}
+
+// postTransaction 为流水写入分录，并把账户分录计入账户余额。调用前流水不应有分录。
+func postTransaction(tx *sql.Tx, transactionID int64) error {
+	postings, err := recordPostings(tx, transactionID)
+	if err != nil {
+		return err
+	}
+	for _, p := range postings {
+		if p.AccountType != postingAsset || p.AccountID == nil {
+			continue
+		}
+		if _, err := tx.Exec("UPDATE accounts SET balance = balance + ? WHERE id = ?", p.Amount, *p.AccountID); err != nil {
+			return &ledgerError{Status: http.StatusInternalServerError, Message: "更新账户余额失败", Err: err}
+		}
+	}
+	return nil
+}
+
+// insertAdjustment 写入一条余额调整流水 (不含分录)：amount 为正时调增账户余额 (记入 to_account_id)，为负时调减 (记入 from_account_id)。
+// 调整流水以权益为对方科目，不计入收支统计和预算；它调整的就是余额本身，因此不套用收款方规则、不检查余额，
+// 账户已归档时也可以调整。调用前账户归属权必须已经校验过。
+func insertAdjustment(tx *sql.Tx, userID, accountID int64, amount Money, date, description string) (int64, error) {
+	if err := ensureMonthOpen(tx, userID, date); err != nil {
+		return 0, err
+	}
+	currency, err := accountCurrency(tx, accountID)
+	if err != nil {
+		return 0, &ledgerError{Status: http.StatusInternalServerError, Message: "查询账户币种失败", Err: err}
+	}
+	var fromAccountID, toAccountID *int64
+	if amount >= 0 {
+		toAccountID = &accountID
+	} else {
+		fromAccountID, amount = &accountID, -amount
+	}
+	res, err := tx.Exec(
+		"INSERT INTO transactions(user_id, type, amount, transaction_date, description, from_account_id, to_account_id, currency, created_at) VALUES(?, 'adjustment', ?, ?, ?, ?, ?, ?, ?)",
+		userID, amount, date, description, fromAccountID, toAccountID, currency, time.Now().Format(time.RFC3339),
+	)
+	if err != nil {
+		return 0, &ledgerError{Status: http.StatusInternalServerError, Message: "创建调整流水失败", Err: err}
+	}
+	id, _ := res.LastInsertId()
+	return id, nil
+}
+
+// unpostTransaction 按流水现有的分录冲回账户余额并删除分录 (删除、修改流水时使用)
+func unpostTransaction(tx *sql.Tx, transactionID int64) error {
+	_, err := tx.Exec(`
+        UPDATE accounts SET balance = balance - (
+            SELECT SUM(p.amount) FROM postings p
+            WHERE p.transaction_id = ? AND p.account_type = 'asset' AND p.account_id = accounts.id)
+        WHERE id IN (SELECT account_id FROM postings WHERE transaction_id = ? AND account_type = 'asset')`,
+		transactionID, transactionID)
+	if err != nil {
+		return &ledgerError{Status: http.StatusInternalServerError, Message: "恢复账户余额失败", Err: err}
+	}
+	if _, err := tx.Exec("DELETE FROM postings WHERE transaction_id = ?", transactionID); err != nil {
+		return &ledgerError{Status: http.StatusInternalServerError, Message: "删除记账分录失败", Err: err}
+	}
+	return nil
+}
+
+// GetTransactionPostings 查看流水对应的记账分录
+// GET /transactions/:id/postings
+func (h *DBHandler) GetTransactionPostings(c *gin.Context) {
+	userID, _ := c.Get("userID")
+	logger := h.Logger.With(slog.Int64("userID", userID.(int64)), "transactionID", c.Param("id"))
+
+	transactionID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的流水ID"})
+		return
+	}
+	var count int
+	if err := h.DB.QueryRow("SELECT COUNT(*) FROM transactions WHERE id = ? AND user_id = ? AND deleted_at IS NULL", transactionID, userID).Scan(&count); err != nil {
+		logger.Error("查询流水失败", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询记账分录失败"})
+		return
+	}
+	if count == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "未找到指定ID的流水"})
+		return
+	}
+	rows, err := h.DB.Query(`
+        SELECT p.id, p.transaction_id, p.account_type, p.account_id, a.name, p.category_id, p.loan_id, p.amount, p.currency
+        FROM postings p LEFT JOIN accounts a ON a.id = p.account_id
+        WHERE p.transaction_id = ? ORDER BY p.id`, transactionID)
+	if err != nil {
+		logger.Error("查询记账分录失败", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询记账分录失败"})
+		return
+	}
+	defer rows.Close()
+	postings := []Posting{}
+	for rows.Next() {
+		var p Posting
+		if err := rows.Scan(&p.ID, &p.TransactionID, &p.AccountType, &p.AccountID, &p.AccountName, &p.CategoryID, &p.LoanID, &p.Amount, &p.Currency); err != nil {
+			logger.Error("扫描记账分录失败", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "查询记账分录失败"})
+			return
+		}
+		postings = append(postings, p)
+	}
+	c.JSON(http.StatusOK, postings)
+}
@@ -0,0 +1,157 @@
+// bookkeeper-app/transaction_postings_test.go
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// 测试流水的增删改和恢复都通过分录变动余额，且每条流水的分录按币种借贷平衡
+func TestTransactionPostings(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	handler := &DBHandler{DB: db, Logger: slog.New(slog.NewJSONHandler(io.Discard, nil))}
+	router := setupRouter(handler)
+
+	userID := createTestUser(t, db, "testuser", "password")
+	token := getTestAuthToken(t, userID, "testuser", false)
+	accountA := createTestAccount(t, db, userID, "Account A", 1000.0)
+	accountB := createTestAccount(t, db, userID, "Account B", 0)
+	usdAccount := createTestAccount(t, db, userID, "USD Account", 0)
+	db.Exec("UPDATE accounts SET currency = 'USD' WHERE id = ?", usdAccount)
+
+	create := func(req CreateTransactionRequest) int64 {
+		body, _ := json.Marshal(req)
+		w := performRequest(router, "POST", "/api/v1/transactions", bytes.NewBuffer(body), token)
+		assert.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+		var resp struct {
+			ID int64 `json:"id"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		return resp.ID
+	}
+	postings := func(id int64) []Posting {
+		w := performRequest(router, "GET", fmt.Sprintf("/api/v1/transactions/%d/postings", id), nil, token)
+		assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		var resp []Posting
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		return resp
+	}
+	balance := func(accountID int64) Money {
+		var b Money
+		db.QueryRow("SELECT balance FROM accounts WHERE id = ?", accountID).Scan(&b)
+		return b
+	}
+	assertBalanced := func(ps []Posting) {
+		totals := map[string]Money{}
+		for _, p := range ps {
+			totals[p.Currency] += p.Amount
+		}
+		for currency, total := range totals {
+			assert.Zero(t, total, "币种 %s 的分录不平衡", currency)
+		}
+	}
+
+	food, transport := "food_dining", "transportation"
+	expenseID := create(CreateTransactionRequest{Type: "expense", Amount: yuan(100), TransactionDate: "2024-05-01", FromAccountID: &accountA, CategoryID: &food})
+	ps := postings(expenseID)
+	if assert.Len(t, ps, 2) {
+		assert.Equal(t, postingExpense, ps[0].AccountType)
+		assert.Equal(t, yuan(100), ps[0].Amount)
+		assert.Equal(t, postingAsset, ps[1].AccountType)
+		assert.Equal(t, yuan(-100), ps[1].Amount)
+		if assert.NotNil(t, ps[1].AccountName) {
+			assert.Equal(t, "Account A", *ps[1].AccountName)
+		}
+	}
+	assertBalanced(ps)
+	assert.Equal(t, yuan(900), balance(accountA))
+
+	// 拆分流水按拆分行分别记入各分类
+	splitID := create(CreateTransactionRequest{Type: "expense", Amount: yuan(60), TransactionDate: "2024-05-02", FromAccountID: &accountA, Splits: []TransactionSplitRequest{
+		{CategoryID: food, Amount: yuan(40)},
+		{CategoryID: transport, Amount: yuan(20)},
+	}})
+	ps = postings(splitID)
+	assert.Len(t, ps, 3)
+	assertBalanced(ps)
+
+	transferID := create(CreateTransactionRequest{Type: "transfer", Amount: yuan(200), TransactionDate: "2024-05-03", FromAccountID: &accountA, ToAccountID: &accountB})
+	ps = postings(transferID)
+	assert.Len(t, ps, 2)
+	assertBalanced(ps)
+	assert.Equal(t, yuan(640), balance(accountA))
+	assert.Equal(t, yuan(200), balance(accountB))
+
+	// 跨币种转账两边金额的差额记入权益
+	toAmount := yuan(14)
+	fxID := create(CreateTransactionRequest{Type: "transfer", Amount: yuan(100), ToAmount: &toAmount, TransactionDate: "2024-05-04", FromAccountID: &accountA, ToAccountID: &usdAccount})
+	ps = postings(fxID)
+	assert.Len(t, ps, 4)
+	assertBalanced(ps)
+	assert.Equal(t, yuan(14), balance(usdAccount))
+
+	// 修改流水：冲回旧分录后按新数据重新记账
+	body, _ := json.Marshal(CreateTransactionRequest{Type: "expense", Amount: yuan(150), TransactionDate: "2024-05-01", FromAccountID: &accountB, CategoryID: &food})
+	w := performRequest(router, "PUT", fmt.Sprintf("/api/v1/transactions/%d", expenseID), bytes.NewBuffer(body), token)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, yuan(640), balance(accountA))
+	assert.Equal(t, yuan(50), balance(accountB))
+	ps = postings(expenseID)
+	if assert.Len(t, ps, 2) {
+		assert.Equal(t, accountB, *ps[1].AccountID)
+		assert.Equal(t, yuan(-150), ps[1].Amount)
+	}
+
+	// 删除后分录被移除，恢复后重新记账
+	w = performRequest(router, "DELETE", fmt.Sprintf("/api/v1/transactions/%d", transferID), nil, token)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, yuan(840), balance(accountA))
+	assert.Equal(t, yuan(-150), balance(accountB))
+	var count int
+	db.QueryRow("SELECT COUNT(*) FROM postings WHERE transaction_id = ?", transferID).Scan(&count)
+	assert.Zero(t, count)
+	w = performRequest(router, "GET", fmt.Sprintf("/api/v1/transactions/%d/postings", transferID), nil, token)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w = performRequest(router, "POST", fmt.Sprintf("/api/v1/trash/%d/restore", transferID), nil, token)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, yuan(640), balance(accountA))
+	assert.Equal(t, yuan(50), balance(accountB))
+	assert.Len(t, postings(transferID), 2)
+
+	// 迁移为没有分录的旧流水补写分录，且不改动余额
+	var before int
+	db.QueryRow("SELECT COUNT(*) FROM postings").Scan(&before)
+	db.Exec("DELETE FROM postings")
+	tx, err := db.Begin()
+	assert.NoError(t, err)
+	assert.NoError(t, setupPostings(tx))
+	assert.NoError(t, tx.Commit())
+	db.QueryRow("SELECT COUNT(*) FROM postings").Scan(&count)
+	assert.Equal(t, before, count)
+	assert.Equal(t, yuan(640), balance(accountA))
+
+	code, report := func() (int, IntegrityReport) {
+		w := performRequest(router, "GET", "/api/v1/integrity", nil, token)
+		var report IntegrityReport
+		json.Unmarshal(w.Body.Bytes(), &report)
+		return w.Code, report
+	}()
+	assert.Equal(t, http.StatusOK, code)
+	assert.Empty(t, report.Discrepancies)
+	assert.Empty(t, report.Issues)
+
+	// 其他用户看不到分录
+	otherID := createTestUser(t, db, "other", "password")
+	otherToken := getTestAuthToken(t, otherID, "other", false)
+	w = performRequest(router, "GET", fmt.Sprintf("/api/v1/transactions/%d/postings", expenseID), nil, otherToken)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
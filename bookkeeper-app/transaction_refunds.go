@@ -0,0 +1,178 @@
+// bookkeeper-app/transaction_refunds.go
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+)
+
+// setupRefunds 为流水增加 refund_of_id 列：退款流水指向被退款的原支出。
+// 必须在 setupPostings 之前执行，因为分录补写和 transaction_lines 视图依赖该列。
+func setupRefunds(tx *sql.Tx) error {
+	if err := addColumnIfMissing(tx, "transactions", "refund_of_id", `"refund_of_id" INTEGER REFERENCES transactions(id)`); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`CREATE INDEX IF NOT EXISTS idx_transactions_refund_of ON transactions (refund_of_id) WHERE refund_of_id IS NOT NULL;`); err != nil {
+		return fmt.Errorf("为 transactions.refund_of_id 创建索引失败: %w", err)
+	}
+	return nil
+}
+
+// refundedAmount 返回原支出已被退款的合计金额，excludeID 为修改中的退款流水自身 (新建时传 0)
+func refundedAmount(tx *sql.Tx, originalID, excludeID int64) (Money, error) {
+	var total Money
+	err := tx.QueryRow("SELECT COALESCE(SUM(amount), 0) FROM transactions WHERE refund_of_id = ? AND type = 'refund' AND id != ? AND deleted_at IS NULL", originalID, excludeID).Scan(&total)
+	return total, err
+}
+
+// prepareRefund 校验退款流水并补全默认值，selfID 为修改中的退款流水ID (新建时传 0)：
+// 原流水必须是当前用户的支出，退款金额不得超过其未退款余额；未指定时默认退回原付款账户、沿用原分类。
+// 退款必须退回与原支出同币种的账户，以便按原支出的日期和汇率冲减。非退款流水会清空 refund_of_id。
+func prepareRefund(tx *sql.Tx, userID int64, req *CreateTransactionRequest, selfID int64) error {
+	if req.Type != "refund" {
+		req.RefundOfID = nil
+		return nil
+	}
+	if req.RefundOfID == nil {
+		return &ledgerError{Status: http.StatusBadRequest, Message: "退款流水必须指定原支出 (refund_of_id)"}
+	}
+
+	var original Transaction
+	var splitCount int
+	err := tx.QueryRow(`
+        SELECT type, amount, transaction_date, category_id, from_account_id, currency,
+               (SELECT COUNT(*) FROM transaction_splits s WHERE s.transaction_id = t.id)
+        FROM transactions t WHERE id = ? AND user_id = ? AND deleted_at IS NULL`, *req.RefundOfID, userID,
+	).Scan(&original.Type, &original.Amount, &original.TransactionDate, &original.CategoryID, &original.FromAccountID, &original.Currency, &splitCount)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return &ledgerError{Status: http.StatusNotFound, Message: "找不到要退款的原支出或无权操作"}
+		}
+		return &ledgerError{Status: http.StatusInternalServerError, Message: "查询原支出失败", Err: err}
+	}
+	if original.Type != "expense" {
+		return &ledgerError{Status: http.StatusBadRequest, Message: "只能对支出流水退款"}
+	}
+	if req.TransactionDate < original.TransactionDate {
+		return &ledgerError{Status: http.StatusBadRequest, Message: "退款日期不能早于原支出日期"}
+	}
+
+	refunded, err := refundedAmount(tx, *req.RefundOfID, selfID)
+	if err != nil {
+		return &ledgerError{Status: http.StatusInternalServerError, Message: "查询已退款金额失败", Err: err}
+	}
+	if remaining := original.Amount - refunded; req.Amount > remaining {
+		return &ledgerError{Status: http.StatusConflict, Message: fmt.Sprintf("退款金额超过原支出未退款余额 (可退: %s, 本次: %s)", remaining, req.Amount)}
+	}
+
+	if req.ToAccountID == nil {
+		if original.FromAccountID == nil {
+			return &ledgerError{Status: http.StatusBadRequest, Message: "原付款账户已不存在，请指定退款账户 (to_account_id)"}
+		}
+		req.ToAccountID = original.FromAccountID
+	}
+	if !isOwner(tx, userID, "accounts", *req.ToAccountID) {
+		return &ledgerError{Status: http.StatusForbidden, Message: "无权操作退款账户"}
+	}
+	currency, err := accountCurrency(tx, *req.ToAccountID)
+	if err != nil {
+		return &ledgerError{Status: http.StatusInternalServerError, Message: "查询退款账户币种失败", Err: err}
+	}
+	if currency != original.Currency {
+		return &ledgerError{Status: http.StatusBadRequest, Message: fmt.Sprintf("退款账户币种 (%s) 与原支出币种 (%s) 不一致", currency, original.Currency)}
+	}
+
+	if req.CategoryID == nil {
+		if splitCount > 0 {
+			return &ledgerError{Status: http.StatusBadRequest, Message: "原支出已拆分到多个分类，退款需指定分类 (category_id)"}
+		}
+		req.CategoryID = original.CategoryID
+	}
+	req.FromAccountID = nil
+	req.RelatedLoanID = nil
+	return nil
+}
+
+// checkRefundedExpense 校验修改后的流水仍能承载其已有的退款：必须仍为同币种支出，且金额不低于已退款合计
+func checkRefundedExpense(tx *sql.Tx, id int64, req *CreateTransactionRequest, currency string) error {
+	var count int
+	var refunded Money
+	err := tx.QueryRow("SELECT COUNT(*), COALESCE(SUM(amount), 0) FROM transactions WHERE refund_of_id = ? AND type = 'refund' AND deleted_at IS NULL", id).Scan(&count, &refunded)
+	if err != nil {
+		return &ledgerError{Status: http.StatusInternalServerError, Message: "查询已退款金额失败", Err: err}
+	}
+	if count == 0 {
+		return nil
+	}
+	if req.Type != "expense" {
+		return &ledgerError{Status: http.StatusConflict, Message: "该支出已有退款记录，不能修改为其它类型"}
+	}
+	if req.Amount < refunded {
+		return &ledgerError{Status: http.StatusConflict, Message: fmt.Sprintf("支出金额不能低于已退款金额 (%s)", refunded)}
+	}
+	var refundCurrency string
+	err = tx.QueryRow("SELECT currency FROM transactions WHERE refund_of_id = ? AND type = 'refund' AND deleted_at IS NULL LIMIT 1", id).Scan(&refundCurrency)
+	if err != nil {
+		return &ledgerError{Status: http.StatusInternalServerError, Message: "查询退款币种失败", Err: err}
+	}
+	if refundCurrency != currency {
+		return &ledgerError{Status: http.StatusConflict, Message: "该支出已有退款记录，不能改为其它币种的账户"}
+	}
+	return nil
+}
+
+// loadTransactionRefunds 为一组流水批量加载退款关系：原支出附带其全部退款，退款流水附带其原支出
+func loadTransactionRefunds(db *sql.DB, userID int64, transactions []Transaction) error {
+	const batchSize = 400 // 每条流水占用两个参数
+	index := make(map[int64]int, len(transactions))
+	for i, t := range transactions {
+		index[t.ID] = i
+	}
+
+	for start := 0; start < len(transactions); start += batchSize {
+		end := min(start+batchSize, len(transactions))
+		var ids []interface{}
+		for _, t := range transactions[start:end] {
+			ids = append(ids, t.ID)
+		}
+		args := append([]interface{}{userID}, ids...)
+		args = append(args, ids...)
+		ph := placeholders(end - start)
+		rows, err := db.Query(`
+        SELECT r.id, r.amount, r.transaction_date, r.description,
+               o.id, o.amount, o.transaction_date, o.description, r.deleted_at IS NOT NULL
+        FROM transactions r
+        JOIN transactions o ON o.id = r.refund_of_id
+        WHERE r.user_id = ? AND r.type = 'refund' AND (r.id IN (`+ph+`) OR o.id IN (`+ph+`))
+        ORDER BY r.transaction_date, r.id`, args...)
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			var refund, original TransactionLink
+			var refundDesc, originalDesc sql.NullString
+			var refundDeleted bool
+			if err := rows.Scan(&refund.ID, &refund.Amount, &refund.TransactionDate, &refundDesc,
+				&original.ID, &original.Amount, &original.TransactionDate, &originalDesc, &refundDeleted); err != nil {
+				rows.Close()
+				return err
+			}
+			refund.Description, original.Description = refundDesc.String, originalDesc.String
+			// 两端可能分属不同批次，只处理本批次内的流水，避免重复追加
+			if i, ok := index[refund.ID]; ok && i >= start && i < end {
+				transactions[i].RefundOf = &original
+			}
+			// 回收站中的退款不再计入原支出
+			if i, ok := index[original.ID]; ok && i >= start && i < end && !refundDeleted {
+				transactions[i].Refunds = append(transactions[i].Refunds, refund)
+				transactions[i].RefundedAmount += refund.Amount
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,125 @@
+// bookkeeper-app/transaction_refunds_test.go
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// 测试退款：退回原账户、不超过未退款余额、在原分类和原期间冲减支出，且列表两侧都能看到退款链
+func TestRefundTransactions(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	handler := &DBHandler{DB: db, Logger: slog.New(slog.NewJSONHandler(io.Discard, nil))}
+	router := setupRouter(handler)
+
+	userID := createTestUser(t, db, "testuser", "password")
+	token := getTestAuthToken(t, userID, "testuser", false)
+	accountID := createTestAccount(t, db, userID, "Test Account", 1000.0)
+
+	create := func(req CreateTransactionRequest) (int, int64) {
+		body, _ := json.Marshal(req)
+		w := performRequest(router, "POST", "/api/v1/transactions", bytes.NewBuffer(body), token)
+		var resp struct {
+			ID int64 `json:"id"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		return w.Code, resp.ID
+	}
+
+	category := "shopping"
+	code, expenseID := create(CreateTransactionRequest{Type: "expense", Amount: yuan(300), TransactionDate: "2024-05-20", Description: "外套", CategoryID: &category, FromAccountID: &accountID})
+	assert.Equal(t, http.StatusCreated, code)
+	_, incomeID := create(CreateTransactionRequest{Type: "income", Amount: yuan(50), TransactionDate: "2024-05-21", ToAccountID: &accountID})
+
+	// 必须引用一笔支出
+	code, _ = create(CreateTransactionRequest{Type: "refund", Amount: yuan(10), TransactionDate: "2024-06-01"})
+	assert.Equal(t, http.StatusBadRequest, code)
+	code, _ = create(CreateTransactionRequest{Type: "refund", Amount: yuan(10), TransactionDate: "2024-06-01", RefundOfID: &incomeID})
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	// 跨月部分退款，默认退回原付款账户并沿用原分类
+	code, refundID := create(CreateTransactionRequest{Type: "refund", Amount: yuan(120), TransactionDate: "2024-06-02", Description: "退货", RefundOfID: &expenseID})
+	assert.Equal(t, http.StatusCreated, code)
+	var balance Money
+	db.QueryRow("SELECT balance FROM accounts WHERE id = ?", accountID).Scan(&balance)
+	assert.Equal(t, yuan(870), balance)
+
+	// 超出未退款余额 (180) 时拒绝
+	code, _ = create(CreateTransactionRequest{Type: "refund", Amount: yuan(181), TransactionDate: "2024-06-03", RefundOfID: &expenseID})
+	assert.Equal(t, http.StatusConflict, code)
+
+	// 预算和分类统计在原分类、原期间冲减
+	budgetBody, _ := json.Marshal(CreateOrUpdateBudgetRequest{CategoryID: &category, Amount: yuan(500), Period: "monthly", Year: 2024, Month: 5})
+	performRequest(router, "POST", "/api/v1/budgets", bytes.NewBuffer(budgetBody), token)
+	w := performRequest(router, "GET", "/api/v1/budgets?year=2024&month=5", nil, token)
+	var budgets []Budget
+	json.Unmarshal(w.Body.Bytes(), &budgets)
+	if assert.Len(t, budgets, 1) {
+		assert.Equal(t, yuan(180), budgets[0].Spent)
+	}
+	w = performRequest(router, "GET", "/api/v1/analytics/charts?year=2024", nil, token)
+	var charts AnalyticsChartsResponse
+	json.Unmarshal(w.Body.Bytes(), &charts)
+	assert.Equal(t, []ChartDataPoint{{Name: "购物", Value: yuan(180)}}, charts.CategoryExpense)
+	assert.Equal(t, []ChartDataPoint{{Name: "2024-05", Value: yuan(180)}}, charts.ExpenseTrend)
+
+	// 收入不受退款影响
+	w = performRequest(router, "GET", "/api/v1/dashboard/cards?year=2024&month=6", nil, token)
+	var cards []DashboardCard
+	json.Unmarshal(w.Body.Bytes(), &cards)
+	if assert.Len(t, cards, 4) {
+		assert.Equal(t, yuan(0), cards[0].Value)
+		assert.Equal(t, yuan(0), cards[1].Value)
+		assert.Equal(t, yuan(50), cards[0].PrevValue)
+		assert.Equal(t, yuan(180), cards[1].PrevValue)
+	}
+
+	// 列表两侧展示退款链
+	w = performRequest(router, "GET", "/api/v1/transactions?year=2024", nil, token)
+	var list GetTransactionsResponse
+	json.Unmarshal(w.Body.Bytes(), &list)
+	byID := map[int64]Transaction{}
+	for _, tr := range list.Transactions {
+		byID[tr.ID] = tr
+	}
+	if assert.Len(t, byID[expenseID].Refunds, 1) {
+		assert.Equal(t, refundID, byID[expenseID].Refunds[0].ID)
+		assert.Equal(t, yuan(120), byID[expenseID].RefundedAmount)
+	}
+	if assert.NotNil(t, byID[refundID].RefundOf) {
+		assert.Equal(t, expenseID, byID[refundID].RefundOf.ID)
+		assert.Equal(t, "外套", byID[refundID].RefundOf.Description)
+		assert.Equal(t, "shopping", *byID[refundID].CategoryID)
+	}
+	assert.Equal(t, yuan(180), list.Summary.TotalExpense)
+
+	// 已有退款的支出不能删除，也不能改成低于已退金额
+	w = performRequest(router, "DELETE", fmt.Sprintf("/api/v1/transactions/%d", expenseID), nil, token)
+	assert.Equal(t, http.StatusConflict, w.Code)
+	body, _ := json.Marshal(CreateTransactionRequest{Type: "expense", Amount: yuan(100), TransactionDate: "2024-05-20", CategoryID: &category, FromAccountID: &accountID})
+	w = performRequest(router, "PUT", fmt.Sprintf("/api/v1/transactions/%d", expenseID), bytes.NewBuffer(body), token)
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	// 修改退款金额时不计入自身的旧金额
+	body, _ = json.Marshal(CreateTransactionRequest{Type: "refund", Amount: yuan(300), TransactionDate: "2024-06-02", RefundOfID: &expenseID})
+	w = performRequest(router, "PUT", fmt.Sprintf("/api/v1/transactions/%d", refundID), bytes.NewBuffer(body), token)
+	assert.Equal(t, http.StatusOK, w.Code)
+	db.QueryRow("SELECT balance FROM accounts WHERE id = ?", accountID).Scan(&balance)
+	assert.Equal(t, yuan(1050), balance)
+
+	// 删除退款后即可删除原支出
+	w = performRequest(router, "DELETE", fmt.Sprintf("/api/v1/transactions/%d", refundID), nil, token)
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = performRequest(router, "DELETE", fmt.Sprintf("/api/v1/transactions/%d", expenseID), nil, token)
+	assert.Equal(t, http.StatusOK, w.Code)
+	db.QueryRow("SELECT balance FROM accounts WHERE id = ?", accountID).Scan(&balance)
+	assert.Equal(t, yuan(1050), balance)
+}
@@ -0,0 +1,129 @@
+// bookkeeper-app/transaction_splits.go
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// transactionLinesView 将流水展开为按分类归属的明细行，数据来自收入/支出科目的记账分录
+// (有拆分的流水每个拆分行一条分录)。amount 为正数表示收入或支出，base_amount 为按流水日期汇率换算后的本位币金额。
+// 退款分录冲减原支出分类，因此以负数计为原支出的一行 (type 为 expense，日期、汇率取原支出的)，从而在原分类、原期间内冲减支出；
+// origin_id 为明细行归属的流水 (退款为原支出，其余为流水本身)，用于关联原支出的标签。
+// 仅用于分类维度的统计 (分析图表、预算、看板)。视图依赖 postings 表，由 setupPostings 创建。
+var transactionLinesView = `
+    CREATE VIEW transaction_lines AS
+        SELECT t.id AS transaction_id, COALESCE(o.id, t.id) AS origin_id, t.user_id,
+               CASE WHEN t.type = 'refund' THEN 'expense' ELSE t.type END AS type,
+               COALESCE(o.transaction_date, t.transaction_date) AS transaction_date, p.category_id,
+               CASE WHEN p.account_type = 'income' THEN -p.amount ELSE p.amount END AS amount, p.currency,
+               ` + baseAmountSQL("(CASE WHEN p.account_type = 'income' THEN -p.amount ELSE p.amount END)", "p.currency", "COALESCE(o.transaction_date, t.transaction_date)", "t.user_id") + ` AS base_amount
+        FROM postings p
+        JOIN transactions t ON t.id = p.transaction_id
+        LEFT JOIN transactions o ON o.id = t.refund_of_id AND t.type = 'refund'
+        WHERE p.account_type IN ('income', 'expense') AND t.deleted_at IS NULL;`
+
+// setupTransactionSplits 创建拆分明细表
+func setupTransactionSplits(tx *sql.Tx) error {
+	if _, err := tx.Exec(`
+    CREATE TABLE IF NOT EXISTS transaction_splits (
+        "id" INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+        "transaction_id" INTEGER NOT NULL,
+        "category_id" TEXT NOT NULL,
+        "amount" INTEGER NOT NULL,
+        "note" TEXT,
+        "created_at" TEXT NOT NULL,
+        FOREIGN KEY(transaction_id) REFERENCES transactions(id) ON DELETE CASCADE
+    );`); err != nil {
+		return fmt.Errorf("创建 transaction_splits 表失败: %w", err)
+	}
+	if _, err := tx.Exec(`CREATE INDEX IF NOT EXISTS idx_transaction_splits_transaction ON transaction_splits (transaction_id);`); err != nil {
+		return fmt.Errorf("为 transaction_splits 创建索引失败: %w", err)
+	}
+	return nil
+}
+
+// validateSplits 校验拆分明细：只允许收入和支出类流水拆分，且各行金额之和必须等于流水金额
+func validateSplits(req *CreateTransactionRequest) error {
+	if len(req.Splits) == 0 {
+		return nil
+	}
+	if req.Type != "income" && req.Type != "expense" {
+		return &ledgerError{Status: http.StatusBadRequest, Message: "只有收入或支出流水可以拆分到多个分类"}
+	}
+	var total Money
+	for _, split := range req.Splits {
+		total += split.Amount
+	}
+	if total != req.Amount {
+		return &ledgerError{Status: http.StatusBadRequest, Message: fmt.Sprintf("拆分金额合计 (%s) 必须等于流水金额 (%s)", total, req.Amount)}
+	}
+	return nil
+}
+
+// saveTransactionSplits 用给定的拆分明细整体替换流水现有的明细
+func saveTransactionSplits(tx *sql.Tx, transactionID int64, splits []TransactionSplitRequest) error {
+	if _, err := tx.Exec("DELETE FROM transaction_splits WHERE transaction_id = ?", transactionID); err != nil {
+		return &ledgerError{Status: http.StatusInternalServerError, Message: "清除旧拆分明细失败", Err: err}
+	}
+	createdAt := time.Now().Format(time.RFC3339)
+	for _, split := range splits {
+		_, err := tx.Exec(
+			"INSERT INTO transaction_splits (transaction_id, category_id, amount, note, created_at) VALUES (?, ?, ?, ?, ?)",
+			transactionID, split.CategoryID, split.Amount, split.Note, createdAt,
+		)
+		if err != nil {
+			return &ledgerError{Status: http.StatusInternalServerError, Message: "保存拆分明细失败", Err: err}
+		}
+	}
+	return nil
+}
+
+// loadTransactionSplits 为一组流水批量加载拆分明细 (分批查询，避免超出 SQLite 参数个数限制)
+func loadTransactionSplits(db *sql.DB, userID int64, transactions []Transaction) error {
+	const batchSize = 500
+	index := make(map[int64]int, len(transactions))
+	for i, t := range transactions {
+		index[t.ID] = i
+	}
+
+	for start := 0; start < len(transactions); start += batchSize {
+		end := min(start+batchSize, len(transactions))
+		args := []interface{}{userID, userID}
+		for _, t := range transactions[start:end] {
+			args = append(args, t.ID)
+		}
+		rows, err := db.Query(userCategoriesCTE+`
+        SELECT s.transaction_id, s.id, s.category_id, uc.name, s.amount, s.note
+        FROM transaction_splits s
+        JOIN transactions t ON t.id = s.transaction_id
+        LEFT JOIN UserCategories uc ON s.category_id = uc.id
+        WHERE t.user_id = ? AND s.transaction_id IN (`+placeholders(end-start)+`)
+        ORDER BY s.id`, args...)
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			var transactionID int64
+			var split TransactionSplit
+			var categoryName, note sql.NullString
+			if err := rows.Scan(&transactionID, &split.ID, &split.CategoryID, &categoryName, &split.Amount, &note); err != nil {
+				rows.Close()
+				return err
+			}
+			if categoryName.Valid {
+				split.CategoryName = &categoryName.String
+			}
+			split.Note = note.String
+			i := index[transactionID]
+			transactions[i].Splits = append(transactions[i].Splits, split)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
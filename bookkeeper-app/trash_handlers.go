@@ -0,0 +1,181 @@
+// bookkeeper-app/trash_handlers.go
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// trashRetentionDays 流水在回收站中保留的天数，超过后被彻底删除
+const trashRetentionDays = 30
+
+// setupTrash 为流水增加 deleted_at 列：非空表示已移入回收站，所有列表和统计都会排除这些流水。
+// 必须在 setupPostings 之前执行，因为分录补写和 transaction_lines 视图依赖该列。
+func setupTrash(tx *sql.Tx) error {
+	if err := addColumnIfMissing(tx, "transactions", "deleted_at", `"deleted_at" TEXT`); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`CREATE INDEX IF NOT EXISTS idx_transactions_deleted ON transactions (user_id, deleted_at) WHERE deleted_at IS NOT NULL;`); err != nil {
+		return fmt.Errorf("为 transactions.deleted_at 创建索引失败: %w", err)
+	}
+	return nil
+}
+
+// GetTrash 列出回收站中的流水，最近删除的在前
+func (h *DBHandler) GetTrash(c *gin.Context) {
+	userID, _ := c.Get("userID")
+	logger := h.Logger.With(slog.Int64("userID", userID.(int64)))
+
+	rows, err := h.DB.Query(userCategoriesCTE+" SELECT "+transactionColumns+", t.deleted_at FROM transactions t "+transactionJoins+`
+        WHERE t.user_id = ? AND t.deleted_at IS NOT NULL
+        ORDER BY t.deleted_at DESC, t.id DESC`, userID, userID)
+	if err != nil {
+		logger.Error("查询回收站失败", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询回收站失败"})
+		return
+	}
+	defer rows.Close()
+
+	response := TrashResponse{Transactions: []Transaction{}, RetentionDays: trashRetentionDays}
+	for rows.Next() {
+		var deletedAt string
+		t, err := scanTransaction(rows, &deletedAt)
+		if err != nil {
+			logger.Error("扫描回收站数据失败", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "查询回收站失败"})
+			return
+		}
+		t.DeletedAt = &deletedAt
+		response.Transactions = append(response.Transactions, t)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		logger.Error("遍历回收站结果集时出错", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询回收站失败"})
+		return
+	}
+	if err := loadTransactionSplits(h.DB, userID.(int64), response.Transactions); err != nil {
+		logger.Error("查询拆分明细失败", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询拆分明细失败"})
+		return
+	}
+	if err := loadTransactionTags(h.DB, userID.(int64), response.Transactions); err != nil {
+		logger.Error("查询流水标签失败", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询流水标签失败"})
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// RestoreTransaction 从回收站恢复流水：按正常记账规则重新入账 (包括余额检查、月度结算和退款校验)
+// POST /trash/:id/restore
+func (h *DBHandler) RestoreTransaction(c *gin.Context) {
+	userID, _ := c.Get("userID")
+	id, _ := strconv.ParseInt(c.Param("id"), 10, 64)
+	logger := h.Logger.With(slog.Int64("userID", userID.(int64)), slog.Int64("transactionID", id))
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		logger.Error("开启事务失败", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "开启事务失败"})
+		return
+	}
+	defer tx.Rollback()
+
+	var req CreateTransactionRequest
+	err = tx.QueryRow(`
+        SELECT type, amount, to_amount, transaction_date, category_id, related_loan_id, from_account_id, to_account_id, refund_of_id
+        FROM transactions WHERE id = ? AND user_id = ? AND deleted_at IS NOT NULL`, id, userID,
+	).Scan(&req.Type, &req.Amount, &req.ToAmount, &req.TransactionDate, &req.CategoryID, &req.RelatedLoanID, &req.FromAccountID, &req.ToAccountID, &req.RefundOfID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "回收站中未找到指定ID的流水"})
+		} else {
+			logger.Error("查询待恢复流水失败", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "服务器内部错误"})
+		}
+		return
+	}
+
+	if err := ensureMonthOpen(tx, userID.(int64), req.TransactionDate); err != nil {
+		writeLedgerError(c, logger, err)
+		return
+	}
+	// 退款的原支出必须仍然存在 (未被删除)，且退款金额不超过其未退款余额
+	if err := prepareRefund(tx, userID.(int64), &req, id); err != nil {
+		writeLedgerError(c, logger, err)
+		return
+	}
+	if err := validateTransactionAccounts(tx, userID.(int64), &req); err != nil {
+		writeLedgerError(c, logger, err)
+		return
+	}
+	if err := ensureAccountsActive(tx, req.FromAccountID, req.ToAccountID); err != nil {
+		writeLedgerError(c, logger, err)
+		return
+	}
+	before, err := auditSnapshot(tx, "transaction", userID.(int64), id)
+	if err != nil {
+		writeLedgerError(c, logger, err)
+		return
+	}
+	if _, err := tx.Exec("UPDATE transactions SET deleted_at = NULL WHERE id = ?", id); err != nil {
+		logger.Error("恢复流水失败", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "恢复流水失败"})
+		return
+	}
+	if err := postTransaction(tx, id); err != nil {
+		writeLedgerError(c, logger, err)
+		return
+	}
+	if err := writeAudit(tx, auditActorFromContext(c), "transaction", "restore", id, before); err != nil {
+		writeLedgerError(c, logger, err)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		logger.Error("提交事务失败", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "提交事务失败"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "流水已恢复，相关账户余额已重新计算", "id": id})
+}
+
+// purgeExpiredTrash 彻底删除在回收站中超过保留期限的流水 (拆分明细、标签、附件随外键级联删除)，返回删除的条数
+func (h *DBHandler) purgeExpiredTrash(now time.Time) (int64, error) {
+	cutoff := now.AddDate(0, 0, -trashRetentionDays).Format(time.RFC3339)
+	res, err := h.DB.Exec("DELETE FROM transactions WHERE deleted_at IS NOT NULL AND deleted_at < ?", cutoff)
+	if err != nil {
+		return 0, err
+	}
+	purged, _ := res.RowsAffected()
+	if purged > 0 {
+		h.purgeOrphanAttachments()
+	}
+	return purged, nil
+}
+
+// StartTrashPurger 后台清理回收站：启动时立即执行一次，之后每隔 interval 执行一次
+func (h *DBHandler) StartTrashPurger(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if purged, err := h.purgeExpiredTrash(time.Now()); err != nil {
+			h.Logger.Error("清理回收站失败", "error", err)
+		} else if purged > 0 {
+			h.Logger.Info("已清理过期的回收站流水", "count", purged)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,119 @@
+// bookkeeper-app/trash_handlers_test.go
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// 测试回收站：删除后从列表和统计中排除，恢复时重新入账并检查余额和退款关系，超过保留期限后彻底删除
+func TestTrash_SoftDeleteRestoreAndPurge(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	handler := &DBHandler{DB: db, Logger: slog.New(slog.NewJSONHandler(io.Discard, nil))}
+	router := setupRouter(handler)
+
+	userID := createTestUser(t, db, "testuser", "password")
+	token := getTestAuthToken(t, userID, "testuser", false)
+	accountID := createTestAccount(t, db, userID, "Test Account", 1000.0)
+
+	create := func(req CreateTransactionRequest) int64 {
+		body, _ := json.Marshal(req)
+		w := performRequest(router, "POST", "/api/v1/transactions", bytes.NewBuffer(body), token)
+		assert.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+		var resp struct {
+			ID int64 `json:"id"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		return resp.ID
+	}
+	remove := func(id int64) int {
+		return performRequest(router, "DELETE", fmt.Sprintf("/api/v1/transactions/%d", id), nil, token).Code
+	}
+	restore := func(id int64) int {
+		return performRequest(router, "POST", fmt.Sprintf("/api/v1/trash/%d/restore", id), nil, token).Code
+	}
+	balance := func() Money {
+		var b Money
+		db.QueryRow("SELECT balance FROM accounts WHERE id = ?", accountID).Scan(&b)
+		return b
+	}
+
+	expenseID := create(CreateTransactionRequest{Type: "expense", Amount: yuan(100), TransactionDate: "2024-05-03", FromAccountID: &accountID})
+	incomeID := create(CreateTransactionRequest{Type: "income", Amount: yuan(50), TransactionDate: "2024-05-04", ToAccountID: &accountID})
+	refundID := create(CreateTransactionRequest{Type: "refund", Amount: yuan(30), TransactionDate: "2024-05-05", RefundOfID: &expenseID})
+
+	// 有退款的支出仍需先删除退款
+	assert.Equal(t, http.StatusConflict, remove(expenseID))
+	assert.Equal(t, http.StatusOK, remove(refundID))
+	assert.Equal(t, http.StatusOK, remove(expenseID))
+	assert.Equal(t, http.StatusNotFound, remove(expenseID))
+	assert.Equal(t, yuan(1050), balance())
+
+	// 已删除的流水不出现在列表和统计中
+	w := performRequest(router, "GET", "/api/v1/transactions?year=2024", nil, token)
+	var list GetTransactionsResponse
+	json.Unmarshal(w.Body.Bytes(), &list)
+	if assert.Len(t, list.Transactions, 1) {
+		assert.Equal(t, incomeID, list.Transactions[0].ID)
+	}
+	assert.Equal(t, yuan(0), list.Summary.TotalExpense)
+	w = performRequest(router, "GET", "/api/v1/dashboard/cards?year=2024&month=5", nil, token)
+	var cards []DashboardCard
+	json.Unmarshal(w.Body.Bytes(), &cards)
+	if assert.Len(t, cards, 4) {
+		assert.Equal(t, yuan(50), cards[0].Value)
+		assert.Equal(t, yuan(0), cards[1].Value)
+	}
+
+	w = performRequest(router, "GET", "/api/v1/trash", nil, token)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var trash TrashResponse
+	json.Unmarshal(w.Body.Bytes(), &trash)
+	assert.Equal(t, trashRetentionDays, trash.RetentionDays)
+	if assert.Len(t, trash.Transactions, 2) {
+		for _, tr := range trash.Transactions {
+			assert.Contains(t, []int64{expenseID, refundID}, tr.ID)
+			assert.NotNil(t, tr.DeletedAt)
+		}
+	}
+
+	// 原支出仍在回收站中时不能恢复退款
+	assert.Equal(t, http.StatusNotFound, restore(refundID))
+
+	// 恢复同样执行余额检查
+	bigID := create(CreateTransactionRequest{Type: "expense", Amount: yuan(1000), TransactionDate: "2024-05-06", FromAccountID: &accountID})
+	assert.Equal(t, http.StatusConflict, restore(expenseID))
+	assert.Equal(t, http.StatusOK, remove(bigID))
+	assert.Equal(t, http.StatusOK, restore(expenseID))
+	assert.Equal(t, http.StatusOK, restore(refundID))
+	assert.Equal(t, http.StatusNotFound, restore(refundID))
+	assert.Equal(t, yuan(980), balance())
+
+	w = performRequest(router, "GET", "/api/v1/transactions?year=2024", nil, token)
+	json.Unmarshal(w.Body.Bytes(), &list)
+	assert.Len(t, list.Transactions, 3)
+	assert.Equal(t, yuan(70), list.Summary.TotalExpense)
+
+	// 超过保留期限的流水被彻底删除
+	assert.Equal(t, http.StatusOK, remove(incomeID))
+	old := time.Now().AddDate(0, 0, -trashRetentionDays-1).Format(time.RFC3339)
+	db.Exec("UPDATE transactions SET deleted_at = ? WHERE id = ?", old, incomeID)
+	purged, err := handler.purgeExpiredTrash(time.Now())
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), purged)
+	w = performRequest(router, "GET", "/api/v1/trash", nil, token)
+	json.Unmarshal(w.Body.Bytes(), &trash)
+	if assert.Len(t, trash.Transactions, 1) {
+		assert.Equal(t, bigID, trash.Transactions[0].ID)
+	}
+	assert.Equal(t, http.StatusNotFound, restore(incomeID))
+}
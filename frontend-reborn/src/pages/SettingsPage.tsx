@@ -34,8 +34,8 @@ const SettingsPage: React.FC = () => {
 
     // 文件选择后的处理，打开确认模态框
     const beforeUpload = (file: File) => {
-        if (!file.name.endsWith('.db')) {
-            message.error('请选择 .db 格式的数据库备份文件！');
+        if (!file.name.endsWith('.zip') && !file.name.endsWith('.db')) {
+            message.error('请选择 .zip 格式的备份文件（或旧版的 .db 数据库文件）！');
             return Upload.LIST_IGNORE;
         }
         setFileToUpload(file);
@@ -81,7 +81,7 @@ const SettingsPage: React.FC = () => {
             
             <Card title="全量数据备份">
                 <Paragraph>
-                    将您的**所有数据**（包括账户、流水、预算、借贷、分类等）完整备份为一个 `.db` 数据库文件。
+                    将您的**所有数据**（包括账户、流水、预算、借贷、分类等）完整备份为一个 `.zip` 压缩包，其中包含数据库文件和所有流水附件。
                     这是最可靠的数据备份方式。请妥善保管好您的备份文件。
                 </Paragraph>
                 <Button type="primary" icon={<DownloadOutlined />} onClick={handleExport} loading={exporting}>
@@ -97,7 +97,7 @@ const SettingsPage: React.FC = () => {
                     showIcon
                     style={{ marginBottom: 16 }}
                 />
-                 <Upload beforeUpload={beforeUpload} showUploadList={false} accept=".db">
+                 <Upload beforeUpload={beforeUpload} showUploadList={false} accept=".zip,.db">
                     <Button icon={<UploadOutlined />} loading={uploading} danger>
                         选择备份文件以恢复
                     </Button>